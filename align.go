@@ -0,0 +1,58 @@
+package diff
+
+// Segment is a region of the alignment of two inputs. A copy segment covers equal ranges
+// of both inputs, a delete segment a range of old only and an insert segment a range of
+// new only, so its range of the other input is empty.
+type Segment struct {
+	Type     string // OpCopy, OpInsert or OpDelete
+	OldStart int64
+	OldEnd   int64
+	NewStart int64
+	NewEnd   int64
+}
+
+// Align returns the alignment of old and new derived from the matches of the handler,
+// independently of the chunk format. The segments tile both inputs in order, without
+// gaps or overlaps, and a replaced region is reported as a delete followed by an insert.
+func (h *GenericBinaryHandler) Align(old, new []byte) []Segment {
+	new = h.maskIgnored(old, new)
+
+	if !h.autoTuned {
+		h.OptimizeBinaryDiff(new)
+	}
+
+	segments := make([]Segment, 0)
+	var oldPos, newPos int64
+
+	add := func(segType string, oldEnd, newEnd int64) {
+		if oldEnd == oldPos && newEnd == newPos {
+			return
+		}
+
+		// Consecutive segments of the same type are reported as one
+		if n := len(segments); n > 0 && segments[n-1].Type == segType {
+			segments[n-1].OldEnd, segments[n-1].NewEnd = oldEnd, newEnd
+		} else {
+			segments = append(segments, Segment{
+				Type:     segType,
+				OldStart: oldPos,
+				OldEnd:   oldEnd,
+				NewStart: newPos,
+				NewEnd:   newEnd,
+			})
+		}
+
+		oldPos, newPos = oldEnd, newEnd
+	}
+
+	for _, match := range h.monotonicMatches(h.scanMatches(old, new)) {
+		add(OpDelete, match.OldOffset, newPos)
+		add(OpInsert, oldPos, match.NewOffset)
+		add(OpCopy, match.OldOffset+match.Length, match.NewOffset+match.Length)
+	}
+
+	add(OpDelete, int64(len(old)), newPos)
+	add(OpInsert, oldPos, int64(len(new)))
+
+	return segments
+}
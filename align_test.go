@@ -0,0 +1,77 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	base := make([]byte, 4096)
+	rng.Read(base)
+
+	edited := append([]byte(nil), base[:1000]...)
+	edited = append(edited, []byte("inserted bytes")...)
+	edited = append(edited, base[1000:2500]...)
+	edited = append(edited, base[2600:]...)
+	edited[3000] ^= 0xff
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+	}{
+		{name: "Both empty", old: nil, new: nil},
+		{name: "Old empty", old: nil, new: []byte("new content")},
+		{name: "New empty", old: []byte("old content"), new: nil},
+		{name: "Identical", old: base, new: base},
+		{name: "Completely different", old: []byte("abcdef"), new: []byte("uvwxyz")},
+		{name: "Insert, delete and replace", old: base, new: edited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := NewGenericBinaryHandler().Align(tt.old, tt.new)
+
+			var oldPos, newPos int64
+			for i, seg := range segments {
+				if seg.OldStart != oldPos || seg.NewStart != newPos {
+					t.Fatalf("segment %d starts at %d/%d, expected %d/%d", i, seg.OldStart, seg.NewStart, oldPos, newPos)
+				}
+
+				oldLen, newLen := seg.OldEnd-seg.OldStart, seg.NewEnd-seg.NewStart
+
+				switch seg.Type {
+				case OpCopy:
+					if oldLen != newLen || oldLen == 0 {
+						t.Errorf("segment %d: expected equal non-empty ranges, got %d and %d bytes", i, oldLen, newLen)
+					} else if !bytes.Equal(tt.old[seg.OldStart:seg.OldEnd], tt.new[seg.NewStart:seg.NewEnd]) {
+						t.Errorf("segment %d: copied ranges differ", i)
+					}
+				case OpDelete:
+					if oldLen <= 0 || newLen != 0 {
+						t.Errorf("segment %d: expected a non-empty old range only, got %d and %d bytes", i, oldLen, newLen)
+					}
+				case OpInsert:
+					if newLen <= 0 || oldLen != 0 {
+						t.Errorf("segment %d: expected a non-empty new range only, got %d and %d bytes", i, oldLen, newLen)
+					}
+				default:
+					t.Fatalf("unexpected segment type %q", seg.Type)
+				}
+
+				oldPos, newPos = seg.OldEnd, seg.NewEnd
+			}
+
+			if oldPos != int64(len(tt.old)) || newPos != int64(len(tt.new)) {
+				t.Errorf("expected the segments to end at %d/%d, got %d/%d", len(tt.old), len(tt.new), oldPos, newPos)
+			}
+		})
+	}
+
+	if segments := NewGenericBinaryHandler().Align(base, edited); segments[0].Type != OpCopy || segments[0].OldEnd != 1000 {
+		t.Errorf("expected the alignment to start with a copy of 1000 bytes, got %s of %d", segments[0].Type, segments[0].OldEnd)
+	}
+}
@@ -0,0 +1,282 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ApplyPatchReport applies the chunks it can to original and reports which were applied
+// and which were skipped, by index. A chunk is skipped when its range is out of bounds
+// or overlaps a previous chunk, when its old data no longer matches the original, or
+// when its data is corrupt. The original bytes of a skipped chunk's region are kept.
+func ApplyPatchReport(original []byte, chunks []DiffChunk) (result []byte, applied []int, skipped []int, err error) {
+	result = make([]byte, 0, len(original))
+	applied = make([]int, 0, len(chunks))
+	skipped = make([]int, 0)
+
+	lastOffset := int64(0)
+
+	for i, chunk := range chunks {
+		end := chunk.Offset + chunk.oldSpan()
+
+		if chunk.Offset < lastOffset || end > int64(len(original)) ||
+			(chunk.OldData != nil && chunk.Op != OpInsert && !bytes.Equal(original[chunk.Offset:end], chunk.OldData)) {
+			skipped = append(skipped, i)
+			continue
+		}
+
+		data, err := chunkDataIn(chunk, original)
+		if err != nil {
+			skipped = append(skipped, i)
+			continue
+		}
+
+		result = append(result, original[lastOffset:chunk.Offset]...)
+		result = append(result, data...)
+		lastOffset = end
+
+		applied = append(applied, i)
+	}
+
+	result = append(result, original[lastOffset:]...)
+
+	return result, applied, skipped, nil
+}
+
+// ApplyChunks applies only the chunks whose indices are in selected to original, leaving
+// the regions of the other chunks unchanged. Chunk offsets refer to the original, so the
+// output position of each applied chunk accounts for the size changes of the applied
+// chunks before it only. Selected chunks must not overlap.
+func ApplyChunks(original []byte, chunks []DiffChunk, selected []int) ([]byte, error) {
+	indices := append([]int(nil), selected...)
+	sort.Ints(indices)
+
+	result := make([]byte, 0, len(original))
+	lastOffset := int64(0)
+
+	for n, i := range indices {
+		if i < 0 || i >= len(chunks) {
+			return nil, fmt.Errorf("selected chunk %d out of range of %d chunks", i, len(chunks))
+		}
+
+		if n > 0 && indices[n-1] == i {
+			continue
+		}
+
+		chunk := chunks[i]
+		end := chunk.Offset + chunk.oldSpan()
+
+		if chunk.Offset < lastOffset || end > int64(len(original)) {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		data, err := chunkDataIn(chunk, original)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		result = append(result, original[lastOffset:chunk.Offset]...)
+		result = append(result, data...)
+		lastOffset = end
+	}
+
+	result = append(result, original[lastOffset:]...)
+
+	return result, nil
+}
+
+// chunkDataIn is chunkData reading the data of an OpCopy chunk from original.
+func chunkDataIn(chunk DiffChunk, original []byte) ([]byte, error) {
+	if chunk.Op == OpCopy {
+		return chunk.replacementIn(original)
+	}
+
+	return chunkData(chunk)
+}
+
+// chunkData returns the replacement data of a chunk, decompressed and verified.
+func chunkData(chunk DiffChunk) ([]byte, error) {
+	data := chunk.replacement()
+
+	if chunk.Compressed && len(data) > 0 {
+		decompressed, err := decompressData(data)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk.NewData = decompressed
+		data = decompressed
+	}
+
+	if err := chunk.verify(); err != nil && chunk.Op != OpDelete {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ReverseChunks returns the chunks which patch the new version back into the original,
+// from chunks which patch the original into the new version. Chunk offsets must be
+// monotonic. It returns ErrOldDataOmitted when a chunk replaces original bytes which
+// were not stored, as with Configuration.OmitOldData set.
+func ReverseChunks(chunks []DiffChunk) ([]DiffChunk, error) {
+	reversed := make([]DiffChunk, 0, len(chunks))
+
+	// shift is the size change of the new version before the current chunk
+	var shift int64
+
+	for i, chunk := range chunks {
+		if chunk.Op != OpInsert && chunk.OldData == nil && chunk.OldLength > 0 {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrOldDataOmitted)
+		}
+
+		// The copied bytes are in the original, which is not available
+		if chunk.Op == OpCopy {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w: reversing a copy", i, chunk.Offset, ErrNotSupported)
+		}
+
+		data, err := chunkData(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		var oldData []byte
+		if chunk.Op != OpInsert {
+			oldData = chunk.OldData
+		}
+
+		reversed = append(reversed, DiffChunk{
+			Offset:    chunk.Offset + shift,
+			OldData:   data,
+			OldLength: int64(len(data)),
+			NewData:   oldData,
+			ChunkType: chunk.ChunkType,
+			Op:        chunkOp(data, oldData),
+			Page:      chunk.Page,
+			Record:    chunk.Record,
+		})
+
+		shift += int64(len(data)) - chunk.oldSpan()
+	}
+
+	return reversed, nil
+}
+
+// patchAtOffsets applies chunks at their exact offsets, which must be monotonic, as for
+// handlers whose chunks are aligned to fixed-size pages or records.
+func patchAtOffsets(original []byte, chunks []DiffChunk) ([]byte, error) {
+	result := make([]byte, 0, len(original))
+	lastOffset := int64(0)
+
+	for i, chunk := range chunks {
+		if err := chunk.verify(); err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		end := chunk.Offset + chunk.oldSpan()
+		if chunk.Offset < lastOffset || end > int64(len(original)) {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		data, err := chunk.replacementIn(original)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		result = append(result, original[lastOffset:chunk.Offset]...)
+		result = append(result, data...)
+
+		lastOffset = end
+	}
+
+	return append(result, original[lastOffset:]...), nil
+}
+
+// ApplyCanonical applies chunks produced by any handler to original, from the canonical
+// fields of DiffChunk alone, so that the chunks of one handler can be applied where
+// another is used. Compressed chunks are decompressed, and the OldData of chunks which
+// stored it must match the original. Offsets are exact: the context of the chunks is
+// not used to relocate them, and RsyncDelta deltas, which follow the order of the new
+// file, are applied with ApplyRsyncDelta instead.
+func ApplyCanonical(original []byte, chunks []DiffChunk) ([]byte, error) {
+	result := make([]byte, 0, len(original))
+	lastOffset := int64(0)
+
+	for i, chunk := range chunks {
+		switch chunk.Op {
+		case OpInsert, OpDelete, OpReplace, OpCopy, OpZero:
+		default:
+			return nil, fmt.Errorf("chunk %d at offset %d: unknown operation %q", i, chunk.Offset, chunk.Op)
+		}
+
+		end := chunk.Offset + chunk.oldSpan()
+		if chunk.Offset < lastOffset || end > int64(len(original)) {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		if chunk.OldData != nil && chunk.Op != OpInsert && !bytes.Equal(original[chunk.Offset:end], chunk.OldData) {
+			return nil, fmt.Errorf("chunk %d at offset %d: old data does not match the original", i, chunk.Offset)
+		}
+
+		data, err := chunkDataIn(chunk, original)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		result = append(result, original[lastOffset:chunk.Offset]...)
+		result = append(result, data...)
+		lastOffset = end
+	}
+
+	return append(result, original[lastOffset:]...), nil
+}
+
+// ApplyLinks recreates in dir the empty directories and hard links recorded in the results
+// with Configuration.PreserveLinks, after the content of the other files was applied.
+// A file replaced by a link is removed first, so it shares the content of its target
+// instead of duplicating it. Paths and link targets outside dir are rejected with
+// ErrInvalidPatchFile.
+func ApplyLinks(dir string, results []DiffResult) error {
+	for _, result := range results {
+		path := filepath.Join(dir, result.LocalPath())
+
+		switch {
+		case result.IsDir && result.Operation == "added":
+			if !filepath.IsLocal(result.LocalPath()) {
+				return fmt.Errorf("%s: %w: path outside the tree", result.RelPath, ErrInvalidPatchFile)
+			}
+
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+
+			if result.Permissions != 0 {
+				if err := os.Chmod(path, result.Permissions); err != nil {
+					return err
+				}
+			}
+		case result.Operation == "linked":
+			target := filepath.FromSlash(result.LinkTo)
+			if !filepath.IsLocal(result.LocalPath()) || !filepath.IsLocal(target) {
+				return fmt.Errorf("%s: %w: path outside the tree", result.RelPath, ErrInvalidPatchFile)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			if err := os.Link(filepath.Join(dir, target), path); err != nil {
+				return fmt.Errorf("%s: %w", result.RelPath, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyPatchReport(t *testing.T) {
+	original := []byte("hello brave new world")
+
+	chunks := []DiffChunk{
+		{Offset: 6, OldData: []byte("brave"), NewData: []byte("bold"), Op: OpReplace},
+		{Offset: 100, OldData: []byte("gone"), NewData: []byte("here"), Op: OpReplace},
+		{Offset: 12, OldData: []byte("old"), NewData: []byte("NEW"), Op: OpReplace},
+		{Offset: 21, NewData: []byte("!"), Op: OpInsert},
+	}
+
+	result, applied, skipped, err := ApplyPatchReport(original, chunks)
+	if err != nil {
+		t.Fatalf("ApplyPatchReport returned an error: %v", err)
+	}
+
+	if want := []byte("hello bold new world!"); !bytes.Equal(result, want) {
+		t.Errorf("result = %q, want %q", result, want)
+	}
+
+	if diff := cmp.Diff([]int{0, 3}, applied); diff != "" {
+		t.Errorf("unexpected applied chunks (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]int{1, 2}, skipped); diff != "" {
+		t.Errorf("unexpected skipped chunks (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyChunks(t *testing.T) {
+	original := []byte("one\ntwo\nthree\nfour\nfive")
+	modified := []byte("one\nTWO\nthree\n4\nfive\nsix")
+
+	chunks, err := (&TextFileHandler{}).Compare(original, modified)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+
+	tests := []struct {
+		name     string
+		selected []int
+		want     string
+		wantErr  bool
+	}{
+		{name: "None", selected: nil, want: "one\ntwo\nthree\nfour\nfive"},
+		{name: "All", selected: []int{0, 1, 2}, want: "one\nTWO\nthree\n4\nfive\nsix"},
+		{name: "First", selected: []int{0}, want: "one\nTWO\nthree\nfour\nfive"},
+		{name: "Second", selected: []int{1}, want: "one\ntwo\nthree\n4\nfive"},
+		{name: "Non-contiguous", selected: []int{0, 2}, want: "one\nTWO\nthree\nfour\nfive\nsix"},
+		{name: "Unordered with duplicates", selected: []int{2, 1, 2}, want: "one\ntwo\nthree\n4\nfive\nsix"},
+		{name: "Out of range index", selected: []int{3}, wantErr: true},
+		{name: "Negative index", selected: []int{-1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyChunks(original, chunks, tt.selected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyChunks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("ApplyChunks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseChunks(t *testing.T) {
+	original := []byte("one\ntwo\nthree\nfour\nfive")
+	modified := []byte("one\nTWO!\nthree\n4\nfive\nsix\nseven")
+
+	handler := &TextFileHandler{}
+
+	chunks, err := handler.Compare(original, modified)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	reversed, err := ReverseChunks(chunks)
+	if err != nil {
+		t.Fatalf("ReverseChunks returned an error: %v", err)
+	}
+
+	got, err := handler.Patch(modified, reversed)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(got, original) {
+		t.Errorf("reverse patch = %q, want %q", got, original)
+	}
+
+	omitOldData(chunks)
+
+	if _, err := ReverseChunks(chunks); !errors.Is(err, ErrOldDataOmitted) {
+		t.Errorf("expected ErrOldDataOmitted, got %v", err)
+	}
+}
+
+func TestApplyCanonical(t *testing.T) {
+	oldText := []byte("alpha\nbeta\ngamma\ndelta\n")
+	newText := []byte("alpha\nBETA\ngamma\ndelta\nepsilon\n")
+
+	block := bytes.Repeat([]byte("0123456789abcdef"), 8)
+	oldBinary := append(append(bytes.Repeat([]byte{1}, 256), block...), bytes.Repeat([]byte{2}, 256)...)
+	newBinary := append(append(append(bytes.Repeat([]byte{1}, 200), bytes.Repeat([]byte{2}, 256)...), block...), 9, 9, 9)
+
+	tests := []struct {
+		name     string
+		handler  FileHandler
+		old, new []byte
+	}{
+		{name: "Text", handler: &TextFileHandler{}, old: oldText, new: newText},
+		{name: "Text with gaps", handler: &TextFileHandler{MaxGapLines: 2}, old: oldText, new: newText},
+		{name: "Binary", handler: NewGenericBinaryHandler(), old: oldBinary, new: newBinary},
+		{name: "Binary with moves", handler: &GenericBinaryHandler{MinMatchLength: 32, DetectMoves: true}, old: oldBinary, new: newBinary},
+		{name: "Record", handler: &RecordBinaryHandler{RecordSize: 16}, old: testRecords(10, 20, 30), new: testRecords(10, 21, 30, 40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := tt.handler.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			patched, err := ApplyCanonical(tt.old, chunks)
+			if err != nil {
+				t.Fatalf("ApplyCanonical returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("ApplyCanonical() = %q, want %q", patched, tt.new)
+			}
+
+			// The chunks of each handler are applied by the other handlers' Patch too
+			for _, other := range []FileHandler{&TextFileHandler{}, NewGenericBinaryHandler()} {
+				patched, err := other.Patch(tt.old, chunks)
+				if err != nil {
+					t.Fatalf("%s Patch returned an error: %v", other.GetFileType(), err)
+				}
+
+				if !bytes.Equal(patched, tt.new) {
+					t.Errorf("%s Patch() = %q, want %q", other.GetFileType(), patched, tt.new)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyCanonical_Errors(t *testing.T) {
+	original := []byte("hello world")
+
+	tests := []struct {
+		name   string
+		chunks []DiffChunk
+		want   error
+	}{
+		{name: "Out of range", chunks: []DiffChunk{{Offset: 8, OldLength: 5, NewData: []byte("x"), Op: OpReplace}}, want: ErrPatchOutOfRange},
+		{name: "Overlapping", chunks: []DiffChunk{{Offset: 4, OldLength: 3, Op: OpDelete}, {Offset: 5, OldLength: 1, Op: OpDelete}}, want: ErrPatchOutOfRange},
+		{name: "Copy out of range", chunks: []DiffChunk{{Offset: 0, Op: OpCopy, CopyFrom: 8, CopyLength: 5}}, want: ErrPatchOutOfRange},
+		{name: "Checksum", chunks: []DiffChunk{{Offset: 0, NewData: []byte("x"), Op: OpInsert, Checksum: 1}}, want: ErrChecksumMismatch},
+		{name: "Old data mismatch", chunks: []DiffChunk{{Offset: 0, OldData: []byte("jello"), OldLength: 5, NewData: []byte("x"), Op: OpReplace}}},
+		{name: "Unknown operation", chunks: []DiffChunk{{Offset: 0, NewData: []byte("x")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ApplyCanonical(original, tt.chunks)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("expected error %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package diff
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveManifestName is the name of the manifest, the first entry of a patch archive.
+const archiveManifestName = "manifest.json"
+
+// archiveChunksDir holds the encoded chunks of each file of a patch archive, by RelPath.
+const archiveChunksDir = "chunks"
+
+// archiveManifest describes the content of a patch archive. Its results hold no chunks,
+// which are stored in their own entries.
+type archiveManifest struct {
+	Metadata PatchMetadata
+	Summary  *DiffSummary `json:",omitempty"`
+	Results  []DiffResult
+}
+
+// WritePatchArchive writes the results of a comparison to w as a single tar stream which
+// ApplyPatchArchive applies: a JSON manifest with the summary and the results, followed by
+// the chunks of each result, in the EncodeChunks encoding. The stream may be compressed by
+// writing it through a gzip.Writer, which ApplyPatchArchive detects.
+func WritePatchArchive(w io.Writer, summary *DiffSummary, results []DiffResult) error {
+	manifest := archiveManifest{
+		Metadata: NewPatchMetadata(summary, ""),
+		Summary:  summary,
+		Results:  make([]DiffResult, len(results)),
+	}
+
+	for i, result := range results {
+		result.Chunks = nil
+		manifest.Results[i] = result
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	modTime := manifest.Metadata.CreatedAt
+
+	if err := writeArchiveEntry(tw, archiveManifestName, data, modTime); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if len(result.Chunks) == 0 {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeChunks(&buf, result.Chunks); err != nil {
+			return fmt.Errorf("%s: %w", result.RelPath, err)
+		}
+
+		if err := writeArchiveEntry(tw, path.Join(archiveChunksDir, result.RelPath), buf.Bytes(), modTime); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeArchiveEntry writes a regular file entry holding data to tw.
+func writeArchiveEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+	return err
+}
+
+// ApplyPatchArchive reads a patch archive written by WritePatchArchive, compressed with
+// gzip or not, and writes to outDir the new tree it describes: baseDir, the old tree of
+// the comparison, with the results applied. outDir may be baseDir, to update it in place.
+// Results whose chunks were transformed with a ChunkTransform can't be applied, nor those
+// of "typechange" results, which hold no content. The files written get the permissions
+// of their new version.
+func ApplyPatchArchive(baseDir, outDir string, r io.Reader) error {
+	manifest, chunks, err := readPatchArchive(r)
+	if err != nil {
+		return err
+	}
+
+	for i := range manifest.Results {
+		manifest.Results[i].Chunks = chunks[manifest.Results[i].RelPath]
+	}
+
+	return applyResults(baseDir, outDir, manifest.Results, true)
+}
+
+// ApplyResults writes to outDir the new tree of a comparison of baseDir, its old tree, with
+// the results applied, like ApplyPatchArchive. The permissions of the files written are
+// those of their new version with Configuration.RestorePermissions.
+func (e *DiffEngine) ApplyResults(baseDir, outDir string, results []DiffResult) error {
+	return applyResults(baseDir, outDir, results, e.config.RestorePermissions)
+}
+
+// applyResults applies the results to a copy of baseDir in outDir, setting the permissions
+// of the files written when restore is set.
+func applyResults(baseDir, outDir string, results []DiffResult, restore bool) error {
+	results, err := ResolveReferences(results)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Clean(baseDir) != filepath.Clean(outDir) {
+		if err := copyTree(baseDir, outDir); err != nil {
+			return err
+		}
+	}
+
+	for _, result := range results {
+		if err := applyResult(outDir, result, restore); err != nil {
+			return fmt.Errorf("%s: %w", result.RelPath, err)
+		}
+	}
+
+	return ApplyLinks(outDir, results)
+}
+
+// readPatchArchive reads the manifest and the chunks, by RelPath, of a patch archive.
+func readPatchArchive(r io.Reader) (*archiveManifest, map[string][]DiffChunk, error) {
+	reader := bufio.NewReader(r)
+
+	// Compressed archives start with the gzip magic number
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPatchFile, err)
+		}
+
+		defer zr.Close()
+		r = zr
+	} else {
+		r = reader
+	}
+
+	tr := tar.NewReader(r)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != archiveManifestName {
+		return nil, nil, fmt.Errorf("%w: missing manifest", ErrInvalidPatchFile)
+	}
+
+	var manifest archiveManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, nil, fmt.Errorf("%w: manifest: %v", ErrInvalidPatchFile, err)
+	}
+
+	chunks := make(map[string][]DiffChunk)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPatchFile, err)
+		}
+
+		relPath, ok := strings.CutPrefix(header.Name, archiveChunksDir+"/")
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidPatchFile, header.Name)
+		}
+
+		decoded, err := DecodeChunks(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", relPath, err)
+		}
+
+		chunks[relPath] = decoded
+	}
+
+	return &manifest, chunks, nil
+}
+
+// applyResult applies a result to the file it describes in dir, setting its permissions
+// when restore is set. Links and empty directories are left to ApplyLinks.
+func applyResult(dir string, result DiffResult, restore bool) error {
+	if !filepath.IsLocal(result.LocalPath()) {
+		return fmt.Errorf("%w: path outside the tree", ErrInvalidPatchFile)
+	}
+
+	target := filepath.Join(dir, result.LocalPath())
+
+	switch result.Operation {
+	case "deleted":
+		return os.RemoveAll(target)
+	case "added", "modified", "rewritten":
+		if result.IsDir {
+			return nil
+		}
+	case "typechange":
+		return fmt.Errorf("%w: type change content not recorded", ErrUnsupportedType)
+	case "chmod":
+		if restore {
+			return restorePermissions(target, result)
+		}
+
+		return nil
+	default:
+		return nil
+	}
+
+	if result.Transformed {
+		return errors.New("chunks transformed with a ChunkTransform")
+	}
+
+	var original []byte
+	if result.Operation != "added" {
+		data, err := os.ReadFile(target)
+		if err != nil {
+			return err
+		}
+
+		original = data
+	}
+
+	data, err := ApplyCanonical(original, result.Chunks)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if err := writeReplacing(target, data); err != nil {
+		return err
+	}
+
+	if restore {
+		return restorePermissions(target, result)
+	}
+
+	return nil
+}
+
+// writeReplacing writes data to a new file replacing path, so that the files hard linked
+// to path keep their content. An existing file keeps its permissions.
+func writeReplacing(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyTree copies the directories, regular files and symbolic links of src to dst,
+// with their permissions. Hard linked files of src are copied separately, ApplyLinks
+// recreates the links of the new tree.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, current)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, relPath)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(current)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+		case entry.Type().IsRegular():
+			if err := copyFile(current, target); err != nil {
+				return err
+			}
+
+			return os.Chmod(target, info.Mode().Perm())
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,183 @@
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPatchArchive_RoundTrip(t *testing.T) {
+	oldFiles := map[string]string{
+		"a.txt":         "one\ntwo\nthree\n",
+		"b.bin":         strings.Repeat("binary\x00data", 200),
+		"docs/gone.txt": "deleted\n",
+		"same.txt":      "unchanged\n",
+		"dup1.txt":      "duplicate\n",
+		"dup2.txt":      "duplicate\n",
+	}
+
+	newFiles := map[string]string{
+		"a.txt":          "one\n2\nthree\nfour\n",
+		"b.bin":          strings.Repeat("binary\x00data", 100) + "inserted" + strings.Repeat("binary\x00data", 100),
+		"same.txt":       "unchanged\n",
+		"dup1.txt":       "duplicate, changed\n",
+		"dup2.txt":       "duplicate, changed\n",
+		"new/nested.txt": "added\n",
+	}
+
+	tests := []struct {
+		name     string
+		compress bool
+		gzipped  bool
+	}{
+		{name: "plain"},
+		{name: "compressed chunks", compress: true},
+		{name: "gzipped", gzipped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldDir, newDir, outDir := t.TempDir(), t.TempDir(), t.TempDir()
+			writeTestTree(t, oldDir, oldFiles)
+			writeTestTree(t, newDir, newFiles)
+
+			config := DefaultConfig()
+			config.CompressPatches = tt.compress
+			config.OmitOldData = true
+			config.DedupContent = true
+
+			summary, results, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			var archive bytes.Buffer
+			var w io.Writer = &archive
+
+			var zw *gzip.Writer
+			if tt.gzipped {
+				zw = gzip.NewWriter(&archive)
+				w = zw
+			}
+
+			if err := WritePatchArchive(w, summary, results); err != nil {
+				t.Fatalf("WritePatchArchive returned an error: %v", err)
+			}
+
+			if zw != nil {
+				if err := zw.Close(); err != nil {
+					t.Fatalf("Failed to close gzip writer: %v", err)
+				}
+			}
+
+			if err := ApplyPatchArchive(oldDir, filepath.Join(outDir, "out"), &archive); err != nil {
+				t.Fatalf("ApplyPatchArchive returned an error: %v", err)
+			}
+
+			if diff := cmp.Diff(newFiles, readArchiveTestTree(t, filepath.Join(outDir, "out"))); diff != "" {
+				t.Errorf("applied tree mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPatchArchive_InPlace(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	writeTestTree(t, oldDir, map[string]string{"a.txt": "old\n", "gone.txt": "gone\n"})
+	writeTestTree(t, newDir, map[string]string{"a.txt": "new\n"})
+
+	summary, results, err := newTestEngine(t, DefaultConfig()).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err := WritePatchArchive(&archive, summary, results); err != nil {
+		t.Fatalf("WritePatchArchive returned an error: %v", err)
+	}
+
+	if err := ApplyPatchArchive(oldDir, oldDir, &archive); err != nil {
+		t.Fatalf("ApplyPatchArchive returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff(map[string]string{"a.txt": "new\n"}, readArchiveTestTree(t, oldDir)); diff != "" {
+		t.Errorf("applied tree mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPatchArchive_Invalid(t *testing.T) {
+	archiveOf := func(results ...DiffResult) []byte {
+		var buf bytes.Buffer
+		if err := WritePatchArchive(&buf, nil, results); err != nil {
+			t.Fatalf("WritePatchArchive returned an error: %v", err)
+		}
+
+		return buf.Bytes()
+	}
+
+	noManifest := func() []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "other.txt", Mode: 0644})
+		tw.Close()
+
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "not an archive", data: []byte("not a tar stream")},
+		{name: "missing manifest", data: noManifest()},
+		{name: "path outside the tree", data: archiveOf(DiffResult{RelPath: "../escape.txt", Operation: "added", Chunks: []DiffChunk{{NewData: []byte("x"), Op: OpInsert}}})},
+		{name: "link outside the tree", data: archiveOf(DiffResult{RelPath: "shadow", Operation: "linked", LinkTo: "../../etc/shadow"})},
+		{name: "absolute link", data: archiveOf(DiffResult{RelPath: "shadow", Operation: "linked", LinkTo: "/etc/shadow"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyPatchArchive(t.TempDir(), t.TempDir(), bytes.NewReader(tt.data))
+			if !errors.Is(err, ErrInvalidPatchFile) {
+				t.Errorf("expected ErrInvalidPatchFile, got %v", err)
+			}
+		})
+	}
+}
+
+// readArchiveTestTree returns the content of the regular files under root, by slash-separated path.
+func readArchiveTestTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		relPath, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(relPath)] = string(data)
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to read tree %s: %v", root, err)
+	}
+
+	return files
+}
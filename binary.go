@@ -1,8 +1,14 @@
 package diff
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"math"
+	"os"
+	"time"
 )
 
 // GenericBinaryHandler implements sophisticated binary file comparison
@@ -11,8 +17,42 @@ type GenericBinaryHandler struct {
 	MaxGapSize     int
 	ChunkSize      int64
 	Stats          *BinaryDiffStats
+
+	// MaxCandidatesPerBucket caps the number of old positions kept per hash, which bounds
+	// the match search on highly repetitive data. Zero means no limit.
+	MaxCandidatesPerBucket int
+
+	// ContextBytes is the number of unchanged bytes recorded on each side of a changed
+	// region, which Patch uses to locate the region when the original has shifted.
+	ContextBytes int
+
+	// DetectMoves copies the blocks of new found elsewhere in old, such as a block moved
+	// within the file, with OpCopy chunks instead of storing them again. The chunks then
+	// need the original to be patched, and can't be reversed.
+	DetectMoves bool
+
+	// IgnoreRanges are the offset ranges whose bytes are treated as equal in both inputs,
+	// such as embedded build timestamps or signatures. Differences within them produce no chunks.
+	IgnoreRanges []ByteRange
+
+	// SampleBytes caps the bytes the entropy of the data is computed from, such as by
+	// OptimizeBinaryDiff, to pieces of that many bytes in total spread evenly over larger
+	// data. Zero computes it from all the bytes.
+	SampleBytes int
+
+	// TimeBudget bounds the time Compare spends searching the matches of the data and
+	// building its chunks, for pathological inputs. Past it, Compare returns a single chunk
+	// replacing the whole file, with BudgetExceeded set. Zero means no limit.
+	TimeBudget time.Duration
+
+	// autoTuned is set by AutoTune, so Compare keeps the tuned parameters.
+	autoTuned bool
 }
 
+// maxContextDrift is the distance from its expected offset within which Patch
+// searches for the context of a chunk.
+const maxContextDrift = 64
+
 // BinaryDiffStats provides statistics about binary diff operation
 type BinaryDiffStats struct {
 	MatchCount        int
@@ -25,6 +65,12 @@ type BinaryDiffStats struct {
 	Entropy           float64
 }
 
+// ByteRange is the range of offsets [Start, End) of a file.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
 type binaryMatch struct {
 	OldOffset int64
 	NewOffset int64
@@ -41,69 +87,297 @@ func NewGenericBinaryHandler() *GenericBinaryHandler {
 }
 
 func (h *GenericBinaryHandler) Compare(old, new []byte) ([]DiffChunk, error) {
+	new = h.maskIgnored(old, new)
+
 	if bytes.Equal(old, new) {
 		return nil, nil
 	}
 
-	// Pre-optimization based on data characteristics
-	h.OptimizeBinaryDiff(new)
+	// Appended, truncated or otherwise contiguous changes need no match search
+	if chunks, stats, ok := h.compareContiguous(old, new); ok {
+		h.Stats = stats
+		return chunks, nil
+	}
+
+	// Pre-optimization based on data characteristics, unless tuned for the data already.
+	// The parameters are tuned on a copy, so they don't carry over to the next comparison.
+	tuned := *h
+	if !h.autoTuned {
+		tuned.OptimizeBinaryDiff(new)
+	}
+
+	chunks, stats, err := tuned.compareMatches(old, new)
+	if stats != nil {
+		h.Stats = stats
+	}
+
+	return chunks, err
+}
+
+// compareMatches compares old and new, with the differences the match search finds, and
+// returns their chunks along with the statistics of the comparison.
+func (h *GenericBinaryHandler) compareMatches(old, new []byte) ([]DiffChunk, *BinaryDiffStats, error) {
+	budget := newTimeBudget(h.TimeBudget)
+
+	// Merged matches may span differing bytes, so only exact matches delimit the chunks
+	scanned, ok := h.scanMatchesWithin(old, new, budget)
+	if !ok {
+		chunks, stats := h.budgetExceeded(old, new)
+		return chunks, stats, nil
+	}
+
+	matches := h.monotonicMatches(scanned)
+
+	// The changed regions are searched for blocks moved from anywhere in old
+	var moves map[uint32][]int64
+	if h.DetectMoves {
+		moves = h.hashBlocks(old)
+	}
 
-	matches := h.findMatches(old, new)
 	chunks := make([]DiffChunk, 0)
 	var lastOldEnd, lastNewEnd int64
 
 	for _, match := range matches {
-		if match.NewOffset > lastNewEnd {
-			chunks = append(chunks, DiffChunk{
-				Offset:    lastOldEnd,
-				OldData:   old[lastOldEnd:match.OldOffset],
-				NewData:   new[lastNewEnd:match.NewOffset],
-				ChunkType: "binary",
-			})
+		if budget.spent() {
+			chunks, stats := h.budgetExceeded(old, new)
+			return chunks, stats, nil
+		}
+
+		if match.NewOffset > lastNewEnd || match.OldOffset > lastOldEnd {
+			chunks = append(chunks, h.gapChunks(old, new, lastOldEnd, match.OldOffset, lastNewEnd, match.NewOffset, moves)...)
 		}
 
 		lastOldEnd = match.OldOffset + match.Length
 		lastNewEnd = match.NewOffset + match.Length
 	}
 
-	if lastNewEnd < int64(len(new)) {
-		chunks = append(chunks, DiffChunk{
-			Offset:    lastOldEnd,
-			OldData:   old[lastOldEnd:],
-			NewData:   new[lastNewEnd:],
-			ChunkType: "binary",
-		})
+	if lastNewEnd < int64(len(new)) || lastOldEnd < int64(len(old)) {
+		chunks = append(chunks, h.gapChunks(old, new, lastOldEnd, int64(len(old)), lastNewEnd, int64(len(new)), moves)...)
+	}
+
+	if h.ContextBytes > 0 {
+		for i := range chunks {
+			h.addContext(&chunks[i], old)
+		}
 	}
 
 	// Post-analysis of the diff operation
 	stats, err := h.AnalyzeBinaryDiff(old, new)
 	if err != nil {
-		return chunks, err
+		return chunks, nil, err
 	}
 
 	stats.ChunkCount = len(chunks)
 	stats.Entropy = h.calculateEntropy(new)
-	h.Stats = stats
 
-	return chunks, nil
+	return chunks, stats, nil
+}
+
+// budgetExceeded returns the chunks of a comparison of old and new which exceeded
+// TimeBudget, a single chunk replacing old whole, with their statistics.
+func (h *GenericBinaryHandler) budgetExceeded(old, new []byte) ([]DiffChunk, *BinaryDiffStats) {
+	stats := &BinaryDiffStats{
+		ChunkCount:       1,
+		CompressionRatio: 1.0,
+	}
+
+	return []DiffChunk{budgetChunk(old, new, "binary")}, stats
+}
+
+// gapChunks returns the chunks replacing old[oldStart:oldEnd], between two matches, with
+// new[newStart:newEnd]. When the blocks of old are hashed in moves, the blocks of the new
+// range found in old are copied from there, and the other bytes stored. The first chunk
+// replaces the whole old range, and the following ones are inserted after it, in order.
+func (h *GenericBinaryHandler) gapChunks(old, new []byte, oldStart, oldEnd, newStart, newEnd int64, moves map[uint32][]int64) []DiffChunk {
+	chunks := make([]DiffChunk, 0, 1)
+
+	add := func(newData []byte, move *binaryMatch) {
+		chunk := DiffChunk{
+			Offset:    oldEnd,
+			NewData:   newData,
+			ChunkType: "binary",
+		}
+
+		if len(chunks) == 0 {
+			chunk.Offset = oldStart
+			chunk.OldData = old[oldStart:oldEnd]
+			chunk.OldLength = oldEnd - oldStart
+		}
+
+		chunk.Op = chunkOp(chunk.OldData, newData)
+		if move != nil {
+			chunk.Op = OpCopy
+			chunk.CopyFrom = move.OldOffset
+			chunk.CopyLength = move.Length
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	pos := newStart
+
+	if moves != nil {
+		for _, move := range h.scanMoves(old, new, moves, newStart, newEnd) {
+			if move.NewOffset > pos {
+				add(new[pos:move.NewOffset], nil)
+			}
+
+			add(nil, &move)
+			pos = move.NewOffset + move.Length
+		}
+	}
+
+	if pos < newEnd || len(chunks) == 0 {
+		add(new[pos:newEnd], nil)
+	}
+
+	return chunks
+}
+
+// scanMoves finds the blocks of new[start:end] present anywhere in old, whose blocks are
+// hashed in table. Unlike scanMatches, every offset of the range is tried, so a block is
+// found whatever its alignment, and matches are extended backwards to the block's start.
+// Blocks not longer than the overhead of a chunk are not worth copying and are skipped.
+func (h *GenericBinaryHandler) scanMoves(old, new []byte, table map[uint32][]int64, start, end int64) []binaryMatch {
+	moves := make([]binaryMatch, 0)
+	window := int64(h.MinMatchLength)
+	lower := start
+
+	for i := start; i+window <= end; i++ {
+		for _, pos := range table[h.rollingHash(new[i:], h.MinMatchLength)] {
+			length := h.extendMatch(old[pos:], new[i:end])
+			if length < window {
+				continue
+			}
+
+			back := int64(0)
+			for i-back > lower && pos-back > 0 && old[pos-back-1] == new[i-back-1] {
+				back++
+			}
+
+			if length+back <= chunkOverhead {
+				continue
+			}
+
+			moves = append(moves, binaryMatch{OldOffset: pos - back, NewOffset: i - back, Length: length + back})
+
+			i += length - 1
+			lower = i + 1
+
+			break
+		}
+	}
+
+	return moves
+}
+
+// maskIgnored returns new with the bytes of the ignored ranges replaced by those of old,
+// so the match search sees them as equal. Ranges beyond the end of old are left as they are.
+func (h *GenericBinaryHandler) maskIgnored(old, new []byte) []byte {
+	if len(h.IgnoreRanges) == 0 {
+		return new
+	}
+
+	masked := bytes.Clone(new)
+	for _, r := range h.IgnoreRanges {
+		start := max(r.Start, 0)
+		end := min(r.End, int64(len(old)), int64(len(new)))
+
+		if start < end {
+			copy(masked[start:end], old[start:end])
+		}
+	}
+
+	return masked
+}
+
+// compareContiguous handles the changes confined to a single region between a common
+// prefix and suffix, in linear time. It applies when the region is empty on either side,
+// as for appended or truncated data, or too small to contain a match on either side, with
+// the configured MinMatchLength. It returns the chunk along with its statistics.
+func (h *GenericBinaryHandler) compareContiguous(old, new []byte) ([]DiffChunk, *BinaryDiffStats, bool) {
+	prefix := commonPrefixLen(old, new)
+	suffix := commonSuffixLen(old[prefix:], new[prefix:])
+
+	oldMid := old[prefix : len(old)-suffix]
+	newMid := new[prefix : len(new)-suffix]
+
+	if len(oldMid) > 0 && len(newMid) > 0 && min(len(oldMid), len(newMid)) >= h.MinMatchLength {
+		return nil, nil, false
+	}
+
+	chunk := DiffChunk{
+		Offset:    int64(prefix),
+		OldData:   oldMid,
+		OldLength: int64(len(oldMid)),
+		NewData:   newMid,
+		ChunkType: "binary",
+		Op:        chunkOp(oldMid, newMid),
+	}
+
+	if h.ContextBytes > 0 {
+		h.addContext(&chunk, old)
+	}
+
+	stats := &BinaryDiffStats{
+		SmallestMatch:    int64(h.MinMatchLength),
+		ChunkCount:       1,
+		CompressionRatio: 1.0,
+		Entropy:          h.calculateEntropy(new),
+	}
+
+	for _, length := range []int64{int64(prefix), int64(suffix)} {
+		if length == 0 {
+			continue
+		}
+
+		stats.MatchCount++
+		stats.TotalMatchedBytes += length
+		stats.LargestMatch = max(stats.LargestMatch, length)
+		stats.SmallestMatch = min(stats.SmallestMatch, length)
+	}
+
+	if stats.MatchCount > 0 {
+		stats.AverageMatchSize = float64(stats.TotalMatchedBytes) / float64(stats.MatchCount)
+		stats.CompressionRatio = float64(len(new)) / float64(stats.TotalMatchedBytes)
+	}
+
+	return []DiffChunk{chunk}, stats, true
 }
 
 func (h *GenericBinaryHandler) findMatches(old, new []byte) []binaryMatch {
+	return h.mergeAdjacentMatches(h.scanMatches(old, new))
+}
+
+// scanMatches finds exact matches between old and new, in increasing order of their new offsets.
+func (h *GenericBinaryHandler) scanMatches(old, new []byte) []binaryMatch {
+	matches, _ := h.scanMatchesWithin(old, new, nil)
+	return matches
+}
+
+// scanMatchesWithin is scanMatches within budget, reporting false when it is exceeded
+// before the scan ends.
+func (h *GenericBinaryHandler) scanMatchesWithin(old, new []byte, budget *timeBudget) ([]binaryMatch, bool) {
 	matches := make([]binaryMatch, 0)
 	if len(old) == 0 || len(new) == 0 {
-		return matches
+		return matches, true
 	}
 
-	hashTable := make(map[uint32][]int64)
-	for i := 0; i <= len(old)-h.MinMatchLength; i += h.MinMatchLength {
-		hash := h.rollingHash(old[i:], h.MinMatchLength)
-		hashTable[hash] = append(hashTable[hash], int64(i))
-	}
+	hashTable := h.hashBlocks(old)
 
 	for i := 0; i <= len(new)-h.MinMatchLength; i += h.MinMatchLength {
+		if budget.spent() {
+			return nil, false
+		}
+
 		hash := h.rollingHash(new[i:], h.MinMatchLength)
 		if positions, ok := hashTable[hash]; ok {
 			for _, pos := range positions {
+				// Repetitive data has many candidates, each extended as far as it matches
+				if budget.spent() {
+					return nil, false
+				}
+
 				matchLen := h.extendMatch(old[pos:], new[i:])
 				if matchLen >= int64(h.MinMatchLength) {
 					matches = append(matches, binaryMatch{
@@ -118,7 +392,44 @@ func (h *GenericBinaryHandler) findMatches(old, new []byte) []binaryMatch {
 		}
 	}
 
-	return h.mergeAdjacentMatches(matches)
+	return matches, true
+}
+
+// hashBlocks maps the hashes of the blocks of old at multiples of MinMatchLength to their offsets.
+func (h *GenericBinaryHandler) hashBlocks(old []byte) map[uint32][]int64 {
+	hashTable := make(map[uint32][]int64)
+	for i := 0; i <= len(old)-h.MinMatchLength; i += h.MinMatchLength {
+		hash := h.rollingHash(old[i:], h.MinMatchLength)
+		bucket := hashTable[hash]
+
+		// Keep only the most recent positions of a full bucket, bounding the candidates tried per hash
+		if h.MaxCandidatesPerBucket > 0 && len(bucket) >= h.MaxCandidatesPerBucket {
+			bucket = append(bucket[:0], bucket[len(bucket)-h.MaxCandidatesPerBucket+1:]...)
+		}
+
+		hashTable[hash] = append(bucket, int64(i))
+	}
+
+	return hashTable
+}
+
+// monotonicMatches drops the matches whose old range precedes the end of an earlier match,
+// so the remaining matches advance in both old and new.
+func (h *GenericBinaryHandler) monotonicMatches(matches []binaryMatch) []binaryMatch {
+	filtered := make([]binaryMatch, 0, len(matches))
+	var lastOldEnd, lastNewEnd int64
+
+	for _, match := range matches {
+		if match.OldOffset < lastOldEnd || match.NewOffset < lastNewEnd {
+			continue
+		}
+
+		filtered = append(filtered, match)
+		lastOldEnd = match.OldOffset + match.Length
+		lastNewEnd = match.NewOffset + match.Length
+	}
+
+	return filtered
 }
 
 func (h *GenericBinaryHandler) rollingHash(data []byte, window int) uint32 {
@@ -203,6 +514,77 @@ func (h *GenericBinaryHandler) OptimizeBinaryDiff(sampleData []byte) {
 	}
 }
 
+// autoTuneSampleSize is the size of the prefixes of the inputs on which AutoTune tries parameters.
+const autoTuneSampleSize = 64 * 1024
+
+// autoTuneMatchLengths are the minimum match lengths tried by AutoTune.
+var autoTuneMatchLengths = []int{4, 8, 16, 32, 64}
+
+// chunkOverhead is the estimated size of the metadata stored with each chunk of a patch.
+const chunkOverhead = 16
+
+// AutoTune picks the parameters producing the smallest patch of old into new, for the
+// following calls to Compare. The parameters chosen by OptimizeBinaryDiff are the baseline,
+// and each candidate minimum match length is tried on prefixes of the inputs, bounding the
+// cost to a few comparisons of autoTuneSampleSize bytes. The baseline is kept unless a
+// candidate produces a strictly smaller patch of the prefixes.
+func (h *GenericBinaryHandler) AutoTune(old, new []byte) error {
+	h.OptimizeBinaryDiff(new)
+	h.autoTuned = true
+
+	sampleOld := old[:min(len(old), autoTuneSampleSize)]
+	sampleNew := new[:min(len(new), autoTuneSampleSize)]
+
+	cost := func(minMatchLength int) (int, error) {
+		trial := &GenericBinaryHandler{
+			MinMatchLength:         minMatchLength,
+			MaxGapSize:             h.MaxGapSize,
+			ChunkSize:              h.ChunkSize,
+			MaxCandidatesPerBucket: h.MaxCandidatesPerBucket,
+			DetectMoves:            h.DetectMoves,
+			SampleBytes:            h.SampleBytes,
+			autoTuned:              true,
+		}
+
+		chunks, err := trial.Compare(sampleOld, sampleNew)
+		if err != nil {
+			return 0, err
+		}
+
+		size := 0
+		for _, chunk := range chunks {
+			size += len(chunk.NewData) + chunkOverhead
+		}
+
+		return size, nil
+	}
+
+	best := h.MinMatchLength
+	bestCost, err := cost(best)
+	if err != nil {
+		return err
+	}
+
+	for _, minMatchLength := range autoTuneMatchLengths {
+		if minMatchLength == h.MinMatchLength {
+			continue
+		}
+
+		trialCost, err := cost(minMatchLength)
+		if err != nil {
+			return err
+		}
+
+		if trialCost < bestCost {
+			best, bestCost = minMatchLength, trialCost
+		}
+	}
+
+	h.MinMatchLength = best
+
+	return nil
+}
+
 func (h *GenericBinaryHandler) AnalyzeBinaryDiff(old, new []byte) (*BinaryDiffStats, error) {
 	matches := h.findMatches(old, new)
 
@@ -236,39 +618,81 @@ func (h *GenericBinaryHandler) AnalyzeBinaryDiff(old, new []byte) (*BinaryDiffSt
 }
 
 func (h *GenericBinaryHandler) calculateEntropy(data []byte) float64 {
-	if len(data) == 0 {
-		return 0
+	var acc EntropyAccumulator
+	for _, piece := range samplePieces(data, h.SampleBytes) {
+		acc.Write(piece)
 	}
 
-	freq := make(map[byte]int)
-	for _, b := range data {
-		freq[b]++
+	return acc.Entropy()
+}
+
+// entropySamplePiece is the size of the pieces of the samples of samplePieces.
+const entropySamplePiece = 4096
+
+// samplePieces returns pieces of data of n bytes in total, evenly spread over it, or the
+// whole data when n is not positive or data is not larger.
+func samplePieces(data []byte, n int) [][]byte {
+	if n <= 0 || len(data) <= n {
+		return [][]byte{data}
 	}
 
-	entropy := 0.0
-	dataLen := float64(len(data))
-	for _, count := range freq {
-		p := float64(count) / dataLen
-		entropy -= p * math.Log2(p)
+	count := max(n/entropySamplePiece, 1)
+	size, stride := n/count, len(data)/count
+
+	pieces := make([][]byte, 0, count)
+	for i := 0; i < count-1; i++ {
+		pieces = append(pieces, data[i*stride:i*stride+size])
 	}
 
-	return entropy / 8.0
+	// The last piece ends the data and takes the remainder of n
+	return append(pieces, data[len(data)-(n-(count-1)*size):])
 }
 
 func (h *GenericBinaryHandler) Patch(original []byte, chunks []DiffChunk) ([]byte, error) {
+	return h.PatchSized(original, chunks, len(original))
+}
+
+// PatchSized is Patch with the result buffer pre-allocated for expectedSize bytes, which
+// avoids growing it repeatedly when the patched data is known to be much larger than original.
+func (h *GenericBinaryHandler) PatchSized(original []byte, chunks []DiffChunk, expectedSize int) ([]byte, error) {
 	if len(chunks) == 0 {
 		return original, nil
 	}
 
-	result := make([]byte, 0, len(original))
+	result := make([]byte, 0, max(expectedSize, 0))
 	lastOffset := int64(0)
 
-	for _, chunk := range chunks {
-		if chunk.Offset > lastOffset {
-			result = append(result, original[lastOffset:chunk.Offset]...)
+	// drift is the shift of the original relative to the offsets recorded in the chunks
+	var drift int64
+
+	for i, chunk := range chunks {
+		if err := chunk.verify(); err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
 		}
-		result = append(result, chunk.NewData...)
-		lastOffset = chunk.Offset + int64(len(chunk.OldData))
+
+		offset := chunk.Offset + drift
+		if len(chunk.ContextBefore) > 0 || len(chunk.ContextAfter) > 0 {
+			located, ok := locateChunk(original, chunk, offset)
+			if !ok {
+				return nil, fmt.Errorf("chunk %d at offset %d: context not found", i, chunk.Offset)
+			}
+
+			offset = located
+			drift = located - chunk.Offset
+		}
+
+		if offset < lastOffset || offset+chunk.oldSpan() > int64(len(original)) {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		data, err := chunk.replacementIn(original)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		result = append(result, original[lastOffset:offset]...)
+		result = append(result, data...)
+		lastOffset = offset + chunk.oldSpan()
 	}
 
 	if lastOffset < int64(len(original)) {
@@ -278,6 +702,226 @@ func (h *GenericBinaryHandler) Patch(original []byte, chunks []DiffChunk) ([]byt
 	return result, nil
 }
 
+// addContext records the unchanged bytes of old surrounding the chunk.
+func (h *GenericBinaryHandler) addContext(chunk *DiffChunk, old []byte) {
+	start := max(chunk.Offset-int64(h.ContextBytes), 0)
+	end := chunk.Offset + chunk.oldSpan()
+
+	chunk.ContextBefore = old[start:chunk.Offset]
+	chunk.ContextAfter = old[end:min(end+int64(h.ContextBytes), int64(len(old)))]
+}
+
+// locateChunk searches around the expected offset for the position where the chunk's
+// context and old data are found in original, preferring the closest position.
+func locateChunk(original []byte, chunk DiffChunk, expected int64) (int64, bool) {
+	matches := func(offset int64) bool {
+		before := offset - int64(len(chunk.ContextBefore))
+		end := offset + chunk.oldSpan()
+		after := end + int64(len(chunk.ContextAfter))
+
+		if before < 0 || after > int64(len(original)) {
+			return false
+		}
+
+		return bytes.Equal(original[before:offset], chunk.ContextBefore) &&
+			(chunk.OldData == nil || bytes.Equal(original[offset:end], chunk.OldData)) &&
+			bytes.Equal(original[end:after], chunk.ContextAfter)
+	}
+
+	for distance := int64(0); distance <= maxContextDrift; distance++ {
+		if matches(expected - distance) {
+			return expected - distance, true
+		}
+
+		if distance > 0 && matches(expected+distance) {
+			return expected + distance, true
+		}
+	}
+
+	return 0, false
+}
+
+// CompareFiles compares the files at oldPath and newPath like Compare, over memory maps of
+// their content instead of copies on the heap, so that comparing large files needs little
+// more memory than the chunks. Files which can't be mapped, on platforms or file systems
+// without mmap, are read instead. The files must not be truncated during the comparison.
+func (h *GenericBinaryHandler) CompareFiles(oldPath, newPath string) ([]DiffChunk, error) {
+	old, unmapOld, err := mapOrReadFile(oldPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unmapOld()
+
+	new, unmapNew, err := mapOrReadFile(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unmapNew()
+
+	chunks, err := h.Compare(old, new)
+	if err != nil {
+		return nil, err
+	}
+
+	// The chunks refer to the mapped data, which is unmapped on return
+	for i := range chunks {
+		chunk := &chunks[i]
+		chunk.OldData = bytes.Clone(chunk.OldData)
+		chunk.NewData = bytes.Clone(chunk.NewData)
+		chunk.ContextBefore = bytes.Clone(chunk.ContextBefore)
+		chunk.ContextAfter = bytes.Clone(chunk.ContextAfter)
+	}
+
+	return chunks, nil
+}
+
+// mapOrReadFile returns the content of the file at path, memory-mapped until unmap is
+// called when possible, or read otherwise.
+func mapOrReadFile(path string) (data []byte, unmap func() error, err error) {
+	if data, unmap, err := mmapFile(path); err == nil {
+		return data, unmap, nil
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return nil }, nil
+}
+
+// PatchFile applies the chunks to the file at originalPath and writes the result to outPath.
+// Unchanged ranges are copied from the original without buffering the whole file and
+// compressed chunk data is decompressed while it is written. Chunk offsets must be monotonic.
+func (h *GenericBinaryHandler) PatchFile(originalPath string, chunks []DiffChunk, outPath string) (err error) {
+	src, err := os.Open(originalPath)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			os.Remove(outPath)
+		}
+	}()
+
+	writer := bufio.NewWriter(dst)
+	var lastOffset int64
+
+	for i, chunk := range chunks {
+		if chunk.Offset < lastOffset {
+			return fmt.Errorf("chunk %d at offset %d: %w: offsets are not monotonic", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		if chunk.Offset+chunk.oldSpan() > info.Size() {
+			return fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		// Copy unchanged data
+		if _, err := io.CopyN(writer, src, chunk.Offset-lastOffset); err != nil {
+			return fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		if chunk.Op == OpCopy {
+			if chunk.CopyFrom < 0 || chunk.CopyLength < 0 || chunk.CopyFrom+chunk.CopyLength > info.Size() {
+				return fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+			}
+
+			if _, err := io.Copy(writer, io.NewSectionReader(src, chunk.CopyFrom, chunk.CopyLength)); err != nil {
+				return fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+			}
+		} else if chunk.Op == OpZero {
+			// Zero runs are left as holes of the output, which reads them as zeros
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+
+			if _, err := dst.Seek(chunk.ZeroLength, io.SeekCurrent); err != nil {
+				return fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+			}
+		} else if err := writeChunkData(writer, chunk); err != nil {
+			return fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		lastOffset = chunk.Offset + chunk.oldSpan()
+		if _, err := src.Seek(lastOffset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	// Copy remaining unchanged data
+	if _, err := io.Copy(writer, src); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	// A zero run ending the output extends it only once its size is set
+	size, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	return dst.Truncate(size)
+}
+
+// writeChunkData writes the replacement data of a chunk, decompressing it if needed,
+// and verifies its checksum when one was computed.
+func writeChunkData(w io.Writer, chunk DiffChunk) error {
+	var data io.Reader = bytes.NewReader(chunk.replacement())
+
+	if chunk.Compressed && len(chunk.replacement()) > 0 {
+		reader, err := decompressStream(data)
+		if err != nil {
+			return err
+		}
+
+		defer reader.Close()
+
+		data = reader
+	}
+
+	checksum := crc32.NewIEEE()
+	if _, err := io.Copy(w, io.TeeReader(data, checksum)); err != nil {
+		return err
+	}
+
+	if chunk.Checksum != 0 && chunk.Op != OpDelete && checksum.Sum32() != chunk.Checksum {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+// forComparison returns a copy of the handler with its own Stats, for the comparisons
+// run concurrently by the engine.
+func (h *GenericBinaryHandler) forComparison() FileHandler {
+	handler := *h
+	handler.Stats = &BinaryDiffStats{}
+
+	return &handler
+}
+
 func (h *GenericBinaryHandler) GetLatestStats() *BinaryDiffStats {
 	return h.Stats
 }
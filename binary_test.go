@@ -2,8 +2,14 @@ package diff
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -128,3 +134,850 @@ func TestAnalyzeBinaryDiff(t *testing.T) {
 		t.Errorf("expected entropy %.5f, got %.5f", stats.Entropy, expectedStats.Entropy)
 	}
 }
+
+func TestCompareOps(t *testing.T) {
+	base := bytes.Repeat([]byte("0123456789abcdef"), 8)
+
+	tests := []struct {
+		name   string
+		old    []byte
+		new    []byte
+		wantOp string
+	}{
+		{
+			name:   "Append bytes",
+			old:    base,
+			new:    append(append([]byte{}, base...), []byte("appended")...),
+			wantOp: OpInsert,
+		},
+		{
+			name:   "Truncate bytes",
+			old:    append(append([]byte{}, base...), []byte("appended")...),
+			new:    base,
+			wantOp: OpDelete,
+		},
+		{
+			name:   "Replace tail",
+			old:    append(append([]byte{}, base...), []byte("original")...),
+			new:    append(append([]byte{}, base...), []byte("replaced")...),
+			wantOp: OpReplace,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewGenericBinaryHandler()
+
+			chunks, err := handler.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if len(chunks) != 1 {
+				t.Fatalf("expected 1 chunk, got %d", len(chunks))
+			}
+
+			if chunks[0].Op != tt.wantOp {
+				t.Errorf("expected op %s, got %s", tt.wantOp, chunks[0].Op)
+			}
+
+			patched, err := handler.Patch(tt.old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match new data")
+			}
+		})
+	}
+}
+
+func TestCompare_Empty(t *testing.T) {
+	testCompareEmpty(t, NewGenericBinaryHandler())
+}
+
+func TestPatch_MultipleRegions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	old := make([]byte, 8192)
+	rng.Read(old)
+
+	// Three separate changed regions: a replacement, an insertion and a deletion.
+	// The region boundaries keep the match scan aligned, so every unchanged
+	// region between them is found as a separate match.
+	new := append([]byte{}, old[:993]...)
+	new = append(new, bytes.Repeat([]byte{0xAA}, 40)...)
+	new = append(new, old[1033:3025]...)
+	new = append(new, bytes.Repeat([]byte{0xBB}, 64)...)
+	new = append(new, old[3025:6001]...)
+	new = append(new, old[6097:]...)
+
+	handler := NewGenericBinaryHandler()
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 3 {
+		t.Errorf("expected 3 chunks, got %d", len(chunks))
+	}
+
+	var lastEnd int64
+	for i, chunk := range chunks {
+		if chunk.Offset < lastEnd {
+			t.Errorf("chunk %d: offset %d precedes the end of the previous chunk %d", i, chunk.Offset, lastEnd)
+		}
+
+		lastEnd = chunk.Offset + int64(len(chunk.OldData))
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched, new) {
+		t.Errorf("patched data does not match new data")
+	}
+}
+
+func TestPatchFile(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(2))
+
+	old := make([]byte, 8*1024*1024)
+	rng.Read(old)
+
+	new := append([]byte{}, old...)
+	copy(new[1024*1024:], bytes.Repeat([]byte{0xCC}, 4096))
+	new = append(new, []byte("appended tail")...)
+
+	originalPath := filepath.Join(dir, "original.bin")
+	if err := os.WriteFile(originalPath, old, 0644); err != nil {
+		t.Fatalf("failed to write original file: %v", err)
+	}
+
+	handler := NewGenericBinaryHandler()
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	// Store every other chunk compressed, as the engine does
+	for i := range chunks {
+		chunks[i].Checksum = crc32.ChecksumIEEE(chunks[i].NewData)
+
+		if i%2 == 0 {
+			chunks[i].NewData = compressData(chunks[i].NewData, true, gzip.BestSpeed)
+			chunks[i].Compressed = true
+		}
+	}
+
+	outPath := filepath.Join(dir, "patched.bin")
+	if err := handler.PatchFile(originalPath, chunks, outPath); err != nil {
+		t.Fatalf("PatchFile returned an error: %v", err)
+	}
+
+	if got, want := calculateHash(outPath), hashBytes(new); got != want {
+		t.Errorf("patched file hash = %s, want %s", got, want)
+	}
+}
+
+func TestPatchFile_NonMonotonic(t *testing.T) {
+	dir := t.TempDir()
+
+	originalPath := filepath.Join(dir, "original.bin")
+	if err := os.WriteFile(originalPath, []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("failed to write original file: %v", err)
+	}
+
+	chunks := []DiffChunk{
+		{Offset: 6, OldData: []byte("6"), NewData: []byte("x"), Op: OpReplace},
+		{Offset: 2, OldData: []byte("2"), NewData: []byte("y"), Op: OpReplace},
+	}
+
+	outPath := filepath.Join(dir, "patched.bin")
+
+	handler := NewGenericBinaryHandler()
+	if err := handler.PatchFile(originalPath, chunks, outPath); err == nil {
+		t.Fatal("expected an error for non-monotonic chunk offsets")
+	}
+
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("expected the output file to be removed, got %v", err)
+	}
+}
+
+func TestPatch_ContextBytes(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+
+	old := make([]byte, 4096)
+	rng.Read(old)
+
+	new := append([]byte{}, old...)
+	copy(new[2001:], bytes.Repeat([]byte{0xDD}, 32))
+
+	handler := NewGenericBinaryHandler()
+	handler.ContextBytes = 16
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk.ContextBefore) == 0 && chunk.Offset > 0 {
+			t.Errorf("chunk %d: expected context before the change", i)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		prefix    []byte
+		wantError bool
+	}{
+		{name: "Unshifted base", prefix: nil},
+		{name: "Base shifted forward", prefix: []byte("shift")},
+		{name: "Base shifted beyond the search window", prefix: make([]byte, maxContextDrift+1), wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := append(append([]byte{}, tt.prefix...), old...)
+			want := append(append([]byte{}, tt.prefix...), new...)
+
+			patched, err := handler.Patch(base, chunks)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Patch() error = %v, wantError %v", err, tt.wantError)
+			}
+
+			if !tt.wantError && !bytes.Equal(patched, want) {
+				t.Errorf("patched data does not match the shifted new data")
+			}
+		})
+	}
+}
+
+func TestCompare_MaxCandidatesPerBucket(t *testing.T) {
+	old := make([]byte, 64*1024)
+	new := append([]byte{}, old...)
+	copy(new[32*1024:], []byte("a change in the middle of zeros"))
+
+	handler := NewGenericBinaryHandler()
+	handler.MaxCandidatesPerBucket = 4
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	matches := handler.scanMatches(old, new)
+	if len(matches) == 0 {
+		t.Fatal("expected matches to be found with a bucket limit")
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched, new) {
+		t.Errorf("patched data does not match new data")
+	}
+}
+
+func BenchmarkFindMatches_Zeros(b *testing.B) {
+	old := make([]byte, 4*1024*1024)
+	new := append([]byte{}, old...)
+	for i := 0; i < len(new); i += 4096 {
+		new[i] = 1
+	}
+
+	for _, limit := range []int{0, 16} {
+		b.Run(fmt.Sprintf("MaxCandidatesPerBucket=%d", limit), func(b *testing.B) {
+			handler := NewGenericBinaryHandler()
+			handler.MaxCandidatesPerBucket = limit
+
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				handler.findMatches(old, new)
+			}
+		})
+	}
+}
+
+func TestCompare_Contiguous(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	base := make([]byte, 64*1024)
+	rng.Read(base)
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	replacement := make([]byte, 4096)
+	rng.Read(replacement)
+
+	tests := []struct {
+		name          string
+		old           []byte
+		new           []byte
+		wantShortCut  bool
+		wantOffset    int64
+		wantOp        string
+		wantUnchanged int64 // Bytes of the common prefix and suffix
+	}{
+		{
+			name:          "Pure append",
+			old:           base,
+			new:           join(base, []byte("appended log line\n")),
+			wantShortCut:  true,
+			wantOffset:    int64(len(base)),
+			wantOp:        OpInsert,
+			wantUnchanged: int64(len(base)),
+		},
+		{
+			name:          "Truncate",
+			old:           base,
+			new:           base[:1000],
+			wantShortCut:  true,
+			wantOffset:    1000,
+			wantOp:        OpDelete,
+			wantUnchanged: 1000,
+		},
+		{
+			name:          "Insert in the middle",
+			old:           base,
+			new:           join(base[:5000], []byte("inserted"), base[5000:]),
+			wantShortCut:  true,
+			wantOffset:    5000,
+			wantOp:        OpInsert,
+			wantUnchanged: int64(len(base)),
+		},
+		{
+			name:          "Delete in the middle",
+			old:           base,
+			new:           join(base[:5000], base[9000:]),
+			wantShortCut:  true,
+			wantOffset:    5000,
+			wantOp:        OpDelete,
+			wantUnchanged: int64(len(base)) - 4000,
+		},
+		{
+			name:          "Small change in the middle",
+			old:           base,
+			new:           join(base[:5000], []byte{^base[5000]}, base[5001:]),
+			wantShortCut:  true,
+			wantOffset:    5000,
+			wantOp:        OpReplace,
+			wantUnchanged: int64(len(base)) - 1,
+		},
+		{
+			name:         "Large change in the middle",
+			old:          base,
+			new:          join(base[:5000], replacement, base[9096:]),
+			wantShortCut: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewGenericBinaryHandler()
+			minMatchLength := handler.MinMatchLength
+
+			chunks, err := handler.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			patched, err := handler.Patch(tt.old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match new data")
+			}
+
+			// The parameters tuned by the general path don't carry over to the next comparison
+			if handler.MinMatchLength != minMatchLength {
+				t.Errorf("expected Compare to keep the minimum match length %d, got %d", minMatchLength, handler.MinMatchLength)
+			}
+
+			if _, _, shortCut := handler.compareContiguous(tt.old, tt.new); shortCut != tt.wantShortCut {
+				t.Fatalf("expected short-circuit %v, got %v", tt.wantShortCut, shortCut)
+			}
+
+			if !tt.wantShortCut {
+				return
+			}
+
+			if len(chunks) != 1 {
+				t.Fatalf("expected 1 chunk, got %d", len(chunks))
+			}
+
+			if chunks[0].Offset != tt.wantOffset || chunks[0].Op != tt.wantOp {
+				t.Errorf("expected %s chunk at offset %d, got %s at %d", tt.wantOp, tt.wantOffset, chunks[0].Op, chunks[0].Offset)
+			}
+
+			if stats := handler.GetLatestStats(); stats.TotalMatchedBytes != tt.wantUnchanged || stats.ChunkCount != 1 {
+				t.Errorf("expected 1 chunk and %d matched bytes, got %d and %d", tt.wantUnchanged, stats.ChunkCount, stats.TotalMatchedBytes)
+			}
+		})
+	}
+}
+
+func TestAutoTune(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	random := make([]byte, 32*1024)
+	rng.Read(random)
+
+	// Many small edits spread over the data
+	edited := append([]byte(nil), random...)
+	for i := 100; i < len(edited); i += 700 {
+		edited[i] ^= 0xff
+	}
+
+	// Low entropy data with shifted repetitions
+	text := bytes.Repeat([]byte("lorem ipsum dolor sit amet, "), 1000)
+	shifted := append([]byte("prefix "), text[:len(text)/2]...)
+	shifted = append(shifted, []byte(" middle ")...)
+	shifted = append(shifted, text[len(text)/2:]...)
+
+	patchSize := func(chunks []DiffChunk) int {
+		size := 0
+		for _, chunk := range chunks {
+			size += len(chunk.NewData) + chunkOverhead
+		}
+
+		return size
+	}
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+	}{
+		{name: "Scattered edits", old: random, new: edited},
+		{name: "Shifted text", old: text, new: shifted},
+		{name: "Unrelated data", old: random[:4096], new: text[:4096]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := NewGenericBinaryHandler()
+
+			defaultChunks, err := defaults.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			tuned := NewGenericBinaryHandler()
+			if err := tuned.AutoTune(tt.old, tt.new); err != nil {
+				t.Fatalf("AutoTune returned an error: %v", err)
+			}
+
+			minMatchLength := tuned.MinMatchLength
+
+			tunedChunks, err := tuned.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if tuned.MinMatchLength != minMatchLength {
+				t.Errorf("expected Compare to keep the tuned minimum match length %d, got %d", minMatchLength, tuned.MinMatchLength)
+			}
+
+			if tunedSize, defaultSize := patchSize(tunedChunks), patchSize(defaultChunks); tunedSize > defaultSize {
+				t.Errorf("expected the tuned patch to be at most %d bytes, got %d", defaultSize, tunedSize)
+			}
+
+			patched, err := tuned.Patch(tt.old, tunedChunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match new data")
+			}
+		})
+	}
+}
+
+func TestCompare_IgnoreRanges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	old := make([]byte, 8*1024)
+	rng.Read(old)
+
+	// A timestamp and a trailing signature differing between the builds
+	stamped := append([]byte(nil), old...)
+	copy(stamped[64:72], "20261017")
+	rng.Read(stamped[len(stamped)-32:])
+
+	ranges := []ByteRange{{Start: 64, End: 72}, {Start: int64(len(old) - 32), End: int64(len(old))}}
+
+	handler := NewGenericBinaryHandler()
+	handler.IgnoreRanges = ranges
+
+	chunks, err := handler.Compare(old, stamped)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 0 {
+		t.Errorf("expected no chunks, got %d", len(chunks))
+	}
+
+	// A change outside the ignored ranges is still reported, without the ignored bytes
+	edited := append([]byte(nil), stamped...)
+	copy(edited[4096:4100], "edit")
+
+	chunks, err = handler.Compare(old, edited)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 1 {
+		t.Fatalf("expected 1 chunk, got %d", len(chunks))
+	}
+
+	if chunks[0].Offset > 4096 || chunks[0].Offset+chunks[0].oldSpan() < 4100 || chunks[0].oldSpan() > 64 {
+		t.Errorf("expected a chunk covering only the edit, got offset %d length %d", chunks[0].Offset, chunks[0].oldSpan())
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched[4096:4100], []byte("edit")) {
+		t.Errorf("expected the edit to be patched, got %q", patched[4096:4100])
+	}
+
+	if !bytes.Equal(patched[64:72], old[64:72]) {
+		t.Errorf("expected the ignored range to keep the old bytes, got %q", patched[64:72])
+	}
+}
+
+// growingChunks returns chunks inserting n blocks of size bytes into original at regular offsets.
+func growingChunks(original []byte, n, size int) []DiffChunk {
+	chunks := make([]DiffChunk, 0, n)
+	for i := 0; i < n; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i%26)}, size)
+		chunks = append(chunks, DiffChunk{
+			Offset:    int64(i * len(original) / n),
+			NewData:   data,
+			ChunkType: "binary",
+			Op:        OpInsert,
+		})
+	}
+
+	return chunks
+}
+
+func TestPatchSized(t *testing.T) {
+	original := bytes.Repeat([]byte("original line\n"), 64)
+	chunks := growingChunks(original, 16, 1024)
+
+	handlers := []struct {
+		name    string
+		patch   func([]byte, []DiffChunk) ([]byte, error)
+		patchTo func([]byte, []DiffChunk, int) ([]byte, error)
+	}{
+		{name: "Binary", patch: NewGenericBinaryHandler().Patch, patchTo: NewGenericBinaryHandler().PatchSized},
+		{name: "Text", patch: (&TextFileHandler{}).Patch, patchTo: (&TextFileHandler{}).PatchSized},
+	}
+
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			want, err := h.patch(original, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			for _, size := range []int{-1, 0, len(want) / 2, len(want), 2 * len(want)} {
+				got, err := h.patchTo(original, chunks, size)
+				if err != nil {
+					t.Fatalf("PatchSized returned an error: %v", err)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Errorf("expected PatchSized with size %d to match Patch", size)
+				}
+
+				if size >= len(want) && cap(got) != size {
+					t.Errorf("expected a capacity of %d, got %d", size, cap(got))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPatchSized_Growing(b *testing.B) {
+	original := make([]byte, 4096)
+	chunks := growingChunks(original, 64, 16*1024)
+
+	handler := NewGenericBinaryHandler()
+	expected := len(original) + 64*16*1024
+
+	b.Run("Patch", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := handler.Patch(original, chunks); err != nil {
+				b.Fatalf("Patch returned an error: %v", err)
+			}
+		}
+	})
+
+	b.Run("PatchSized", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := handler.PatchSized(original, chunks, expected); err != nil {
+				b.Fatalf("PatchSized returned an error: %v", err)
+			}
+		}
+	})
+}
+
+func TestCompare_DetectMoves(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	old := make([]byte, 16*1024)
+	rng.Read(old)
+
+	// Swap two blocks of 2KB
+	a, b := old[4096:6144], old[10240:12288]
+
+	swapped := append([]byte(nil), old[:4096]...)
+	swapped = append(swapped, b...)
+	swapped = append(swapped, old[6144:10240]...)
+	swapped = append(swapped, a...)
+	swapped = append(swapped, old[12288:]...)
+
+	patchSize := func(chunks []DiffChunk) int {
+		size := 0
+		for _, chunk := range chunks {
+			size += len(chunk.NewData) + chunkOverhead
+		}
+
+		return size
+	}
+
+	linear := NewGenericBinaryHandler()
+
+	linearChunks, err := linear.Compare(old, swapped)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	handler := NewGenericBinaryHandler()
+	handler.DetectMoves = true
+
+	chunks, err := handler.Compare(old, swapped)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	copies := 0
+	for _, chunk := range chunks {
+		if chunk.Op == OpCopy {
+			copies++
+		}
+	}
+
+	if copies == 0 {
+		t.Errorf("expected the moved block to be copied, got %+v", chunks)
+	}
+
+	if size := patchSize(chunks); size > 256 || size >= patchSize(linearChunks) {
+		t.Errorf("expected a patch of at most 256 bytes, smaller than the %d bytes without moves, got %d", patchSize(linearChunks), size)
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched, swapped) {
+		t.Fatalf("patched data does not match")
+	}
+
+	// Streaming the patch copies the block from the original file
+	dir := t.TempDir()
+	originalPath, outPath := filepath.Join(dir, "original"), filepath.Join(dir, "patched")
+
+	if err := os.WriteFile(originalPath, old, 0644); err != nil {
+		t.Fatalf("Failed to write original: %v", err)
+	}
+
+	if err := handler.PatchFile(originalPath, chunks, outPath); err != nil {
+		t.Fatalf("PatchFile returned an error: %v", err)
+	}
+
+	if got, err := os.ReadFile(outPath); err != nil || !bytes.Equal(got, swapped) {
+		t.Errorf("PatchFile output does not match: %v", err)
+	}
+
+	if _, err := ReverseChunks(chunks); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported reversing copies, got %v", err)
+	}
+}
+
+func TestCalculateEntropy_SampleBytes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	uniform := make([]byte, 16*1024*1024)
+	rng.Read(uniform)
+
+	full := (&GenericBinaryHandler{}).calculateEntropy(uniform)
+
+	for _, sampleBytes := range []int{1000, 64 * 1024, 1024*1024 + 3} {
+		sampled := (&GenericBinaryHandler{SampleBytes: sampleBytes}).calculateEntropy(uniform)
+		if math.Abs(sampled-full) > 0.03 {
+			t.Errorf("SampleBytes %d: sampled entropy %f too far from the full entropy %f", sampleBytes, sampled, full)
+		}
+
+		// Only the sample of the large input is read
+		total := 0
+		for _, piece := range samplePieces(uniform, sampleBytes) {
+			total += len(piece)
+		}
+
+		if total != sampleBytes {
+			t.Errorf("SampleBytes %d: expected a sample of %d bytes, got %d", sampleBytes, sampleBytes, total)
+		}
+	}
+
+	// Data not larger than the sample is read whole
+	small := uniform[:100]
+	if got, want := (&GenericBinaryHandler{SampleBytes: 1000}).calculateEntropy(small), (&GenericBinaryHandler{}).calculateEntropy(small); got != want {
+		t.Errorf("expected the full entropy %f of small data, got %f", want, got)
+	}
+}
+
+func BenchmarkOptimizeBinaryDiff_SampleBytes(b *testing.B) {
+	data := make([]byte, 100*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, sampleBytes := range []int{0, 1024 * 1024} {
+		b.Run(fmt.Sprintf("SampleBytes=%d", sampleBytes), func(b *testing.B) {
+			handler := &GenericBinaryHandler{SampleBytes: sampleBytes}
+
+			for i := 0; i < b.N; i++ {
+				handler.OptimizeBinaryDiff(data)
+			}
+		})
+	}
+}
+
+func TestGenericBinaryHandler_CompareFiles(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(3))
+
+	old := make([]byte, 256*1024)
+	rng.Read(old)
+
+	new := append([]byte{}, old...)
+	copy(new[128*1024:], bytes.Repeat([]byte{0xCC}, 4096))
+	new = append(new, []byte("appended tail")...)
+
+	oldPath, newPath := filepath.Join(dir, "old.bin"), filepath.Join(dir, "new.bin")
+	for path, data := range map[string][]byte{oldPath: old, newPath: new} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	handler := NewGenericBinaryHandler()
+	handler.ContextBytes = 16
+
+	chunks, err := handler.CompareFiles(oldPath, newPath)
+	if err != nil {
+		t.Fatalf("CompareFiles returned an error: %v", err)
+	}
+
+	reference := NewGenericBinaryHandler()
+	reference.ContextBytes = 16
+
+	want, err := reference.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	// The chunks remain readable after the files are unmapped
+	if diff := cmp.Diff(want, chunks); diff != "" {
+		t.Errorf("CompareFiles() mismatch (-want +got):\n%s", diff)
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched, new) {
+		t.Error("patched data does not match the new file")
+	}
+
+	if _, err := handler.CompareFiles(filepath.Join(dir, "missing.bin"), newPath); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func BenchmarkGenericBinaryHandler_CompareFiles(b *testing.B) {
+	dir := b.TempDir()
+	rng := rand.New(rand.NewSource(4))
+
+	old := make([]byte, 64*1024*1024)
+	rng.Read(old)
+
+	new := append([]byte{}, old...)
+	copy(new[32*1024*1024:], bytes.Repeat([]byte{0xCC}, 4096))
+
+	oldPath, newPath := filepath.Join(dir, "old.bin"), filepath.Join(dir, "new.bin")
+	for path, data := range map[string][]byte{oldPath: old, newPath: new} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			b.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	old, new = nil, nil
+
+	b.Run("ReadFile", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			oldData, err := os.ReadFile(oldPath)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			newData, err := os.ReadFile(newPath)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if _, err := NewGenericBinaryHandler().Compare(oldData, newData); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Mmap", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := NewGenericBinaryHandler().CompareFiles(oldPath, newPath); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
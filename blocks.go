@@ -0,0 +1,141 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// BlockDiff is the diff of a fixed-size block of a file, independent of the other blocks.
+type BlockDiff struct {
+	Index  int64       // Index of the block, at offset Index times the block size
+	Chunks []DiffChunk // Chunks patching the old block into the new one, with offsets within the block
+}
+
+// CompareBlocks compares old and new as independent blocks of blockSize bytes, in parallel,
+// so that the diff of each block can be stored, deduplicated or applied on its own. The
+// blocks whose content is identical are omitted, and the others are returned in order of
+// their index. A block beyond the end of old is inserted whole, and one beyond the end of
+// new deleted whole. PatchBlocks applies the diffs.
+func (h *GenericBinaryHandler) CompareBlocks(old, new []byte, blockSize int64) ([]BlockDiff, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+
+	count := blockCount(max(len(old), len(new)), blockSize)
+	diffs := make([]BlockDiff, count)
+	errs := make([]error, count)
+
+	indexes := make(chan int64)
+	var wg sync.WaitGroup
+
+	for i := 0; i < min(runtime.GOMAXPROCS(0), int(count)); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				oldBlock, newBlock := block(old, index, blockSize), block(new, index, blockSize)
+				if bytes.Equal(oldBlock, newBlock) {
+					continue
+				}
+
+				// Each comparison tunes its own copy of the handler
+				handler := *h
+				handler.Stats = &BinaryDiffStats{}
+				handler.IgnoreRanges = blockRanges(h.IgnoreRanges, index*blockSize, blockSize)
+
+				chunks, err := handler.Compare(oldBlock, newBlock)
+				diffs[index] = BlockDiff{Index: index, Chunks: chunks}
+				errs[index] = err
+			}
+		}()
+	}
+
+	for index := int64(0); index < count; index++ {
+		indexes <- index
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	changed := make([]BlockDiff, 0)
+	for index, diff := range diffs {
+		if errs[index] != nil {
+			return nil, fmt.Errorf("block %d: %w", index, errs[index])
+		}
+
+		if len(diff.Chunks) > 0 {
+			changed = append(changed, diff)
+		}
+	}
+
+	return changed, nil
+}
+
+// PatchBlocks applies the block diffs returned by CompareBlocks with the same blockSize
+// to original, patching each block of original with its diff, if any.
+func (h *GenericBinaryHandler) PatchBlocks(original []byte, diffs []BlockDiff, blockSize int64) ([]byte, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+
+	count := blockCount(len(original), blockSize)
+	result := make([]byte, 0, len(original))
+
+	next := int64(0)
+	for i, diff := range diffs {
+		if diff.Index < next {
+			return nil, fmt.Errorf("block diff %d: %w", i, ErrPatchOutOfRange)
+		}
+
+		// Blocks without a diff are unchanged
+		for ; next < diff.Index; next++ {
+			result = append(result, block(original, next, blockSize)...)
+		}
+
+		patched, err := h.Patch(block(original, diff.Index, blockSize), diff.Chunks)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", diff.Index, err)
+		}
+
+		result = append(result, patched...)
+		next = diff.Index + 1
+	}
+
+	for ; next < count; next++ {
+		result = append(result, block(original, next, blockSize)...)
+	}
+
+	return result, nil
+}
+
+// blockCount returns the number of blocks of blockSize bytes covering size bytes.
+func blockCount(size int, blockSize int64) int64 {
+	return (int64(size) + blockSize - 1) / blockSize
+}
+
+// block returns the block of data at index, shorter at the end of data and empty beyond.
+func block(data []byte, index, blockSize int64) []byte {
+	start := min(index*blockSize, int64(len(data)))
+	end := min(start+blockSize, int64(len(data)))
+
+	return data[start:end]
+}
+
+// blockRanges returns the parts of ranges within the block at start, relative to it.
+func blockRanges(ranges []ByteRange, start, blockSize int64) []ByteRange {
+	var within []ByteRange
+	for _, r := range ranges {
+		r.Start = max(r.Start-start, 0)
+		r.End = min(r.End-start, blockSize)
+
+		if r.Start < r.End {
+			within = append(within, r)
+		}
+	}
+
+	return within
+}
@@ -0,0 +1,86 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestCompareBlocks(t *testing.T) {
+	const blockSize = 64 * 1024
+
+	rng := rand.New(rand.NewSource(1))
+	old := make([]byte, 8*blockSize)
+	rng.Read(old)
+
+	changedBlock := append([]byte{}, old...)
+	copy(changedBlock[5*blockSize+100:], "changed in the sixth block")
+
+	tests := []struct {
+		name        string
+		new         []byte
+		wantIndexes []int64
+	}{
+		{name: "one block changed", new: changedBlock, wantIndexes: []int64{5}},
+		{name: "unchanged", new: old, wantIndexes: []int64{}},
+		{name: "appended block", new: append(append([]byte{}, old...), "tail"...), wantIndexes: []int64{8}},
+		{name: "truncated", new: old[:6*blockSize+10], wantIndexes: []int64{6, 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewGenericBinaryHandler()
+
+			diffs, err := handler.CompareBlocks(old, tt.new, blockSize)
+			if err != nil {
+				t.Fatalf("CompareBlocks returned an error: %v", err)
+			}
+
+			indexes := make([]int64, 0, len(diffs))
+			for _, diff := range diffs {
+				indexes = append(indexes, diff.Index)
+
+				if len(diff.Chunks) == 0 {
+					t.Errorf("block %d: expected chunks", diff.Index)
+				}
+			}
+
+			if !slices.Equal(indexes, tt.wantIndexes) {
+				t.Errorf("expected changed blocks %v, got %v", tt.wantIndexes, indexes)
+			}
+
+			patched, err := handler.PatchBlocks(old, diffs, blockSize)
+			if err != nil {
+				t.Fatalf("PatchBlocks returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Error("patched data does not match the new data")
+			}
+		})
+	}
+}
+
+func TestCompareBlocks_IgnoreRanges(t *testing.T) {
+	old := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	new := append([]byte{}, old...)
+	copy(new[300:], "timestamp")
+
+	// The ignored range is in the second block of 256 bytes
+	handler := NewGenericBinaryHandler()
+	handler.IgnoreRanges = []ByteRange{{Start: 300, End: 309}}
+
+	diffs, err := handler.CompareBlocks(old, new, 256)
+	if err != nil {
+		t.Fatalf("CompareBlocks returned an error: %v", err)
+	}
+
+	if len(diffs) != 0 {
+		t.Errorf("expected no block diffs, got %+v", diffs)
+	}
+
+	if _, err := handler.CompareBlocks(old, new, 0); err == nil {
+		t.Error("expected an error for a zero block size")
+	}
+}
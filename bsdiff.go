@@ -0,0 +1,232 @@
+package diff
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+)
+
+// bsdiffMagic is the magic header of the BSDIFF40 patch format.
+const bsdiffMagic = "BSDIFF40"
+
+// bsdiffHeaderSize is the size of the BSDIFF40 header:
+// magic, control block length, diff block length and new file size.
+const bsdiffHeaderSize = 32
+
+// ErrInvalidBsdiff is returned when a patch is not a valid BSDIFF40 patch.
+var ErrInvalidBsdiff = errors.New("invalid bsdiff patch")
+
+// bsdiffControl is a single control tuple of a BSDIFF40 patch.
+// Add bytes are combined with the old data, Copy bytes are taken
+// from the extra block and Seek moves the old data position.
+type bsdiffControl struct {
+	Add  int64
+	Copy int64
+	Seek int64
+}
+
+// WriteBsdiff writes a patch transforming old into new in the BSDIFF40 format,
+// which can be applied by the standard bspatch tool.
+func (h *GenericBinaryHandler) WriteBsdiff(w io.Writer, old, new []byte) error {
+	controls, diffBlock, extraBlock := h.bsdiffStreams(old, new)
+
+	var ctrlBlock bytes.Buffer
+
+	for _, c := range controls {
+		ctrlBlock.Write(encodeBsdiffInt(c.Add))
+		ctrlBlock.Write(encodeBsdiffInt(c.Copy))
+		ctrlBlock.Write(encodeBsdiffInt(c.Seek))
+	}
+
+	ctrlData, err := bzip2Compress(ctrlBlock.Bytes())
+	if err != nil {
+		return err
+	}
+
+	diffData, err := bzip2Compress(diffBlock)
+	if err != nil {
+		return err
+	}
+
+	extraData, err := bzip2Compress(extraBlock)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, bsdiffHeaderSize)
+	header = append(header, bsdiffMagic...)
+	header = append(header, encodeBsdiffInt(int64(len(ctrlData)))...)
+	header = append(header, encodeBsdiffInt(int64(len(diffData)))...)
+	header = append(header, encodeBsdiffInt(int64(len(new)))...)
+
+	for _, block := range [][]byte{header, ctrlData, diffData, extraData} {
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bsdiffStreams derives the control tuples, diff and extra blocks from the matches
+// found between old and new.
+func (h *GenericBinaryHandler) bsdiffStreams(old, new []byte) ([]bsdiffControl, []byte, []byte) {
+	controls := make([]bsdiffControl, 0)
+	diffBlock := make([]byte, 0)
+	extraBlock := make([]byte, 0)
+
+	var oldPos, newPos int64
+	current := bsdiffControl{}
+
+	for _, match := range h.findMatches(old, new) {
+		if match.NewOffset < newPos {
+			continue
+		}
+
+		// Everything up to the match comes from the extra block
+		current.Copy = match.NewOffset - newPos
+		extraBlock = append(extraBlock, new[newPos:match.NewOffset]...)
+		current.Seek = match.OldOffset - oldPos
+		controls = append(controls, current)
+
+		length := match.Length
+		if remaining := int64(len(old)) - match.OldOffset; length > remaining {
+			length = remaining
+		}
+
+		for i := int64(0); i < length; i++ {
+			diffBlock = append(diffBlock, new[match.NewOffset+i]-old[match.OldOffset+i])
+		}
+
+		current = bsdiffControl{Add: length}
+		oldPos = match.OldOffset + length
+		newPos = match.NewOffset + length
+	}
+
+	current.Copy = int64(len(new)) - newPos
+	extraBlock = append(extraBlock, new[newPos:]...)
+	controls = append(controls, current)
+
+	return controls, diffBlock, extraBlock
+}
+
+// ApplyBsdiff applies a BSDIFF40 patch, such as one produced by the standard bsdiff tool,
+// to old and returns the reconstructed data. The result grows with the data the patch
+// holds, so a header claiming a larger new file does not allocate its size upfront.
+func (h *GenericBinaryHandler) ApplyBsdiff(old []byte, patch io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < bsdiffHeaderSize || string(data[:8]) != bsdiffMagic {
+		return nil, ErrInvalidBsdiff
+	}
+
+	ctrlLen := decodeBsdiffInt(data[8:16])
+	diffLen := decodeBsdiffInt(data[16:24])
+	newSize := decodeBsdiffInt(data[24:32])
+
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || bsdiffHeaderSize+ctrlLen+diffLen > int64(len(data)) {
+		return nil, ErrInvalidBsdiff
+	}
+
+	body := data[bsdiffHeaderSize:]
+	ctrlReader := bzip2.NewReader(bytes.NewReader(body[:ctrlLen]))
+	diffReader := bzip2.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+diffLen]))
+	extraReader := bzip2.NewReader(bytes.NewReader(body[ctrlLen+diffLen:]))
+
+	// newSize comes from the patch, so the result grows with the data actually read from
+	// its blocks rather than being allocated upfront, from a capacity bounded by the inputs
+	result := bytes.NewBuffer(make([]byte, 0, min(newSize, int64(len(old)+len(data)))))
+	var oldPos, newPos int64
+	ctrl := make([]byte, 24)
+
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrlReader, ctrl); err != nil {
+			return nil, fmt.Errorf("%w: reading control block: %v", ErrInvalidBsdiff, err)
+		}
+
+		add, cp, seek := decodeBsdiffInt(ctrl[0:8]), decodeBsdiffInt(ctrl[8:16]), decodeBsdiffInt(ctrl[16:24])
+		if add < 0 || cp < 0 || newPos+add > newSize {
+			return nil, ErrInvalidBsdiff
+		}
+
+		if _, err := io.CopyN(result, diffReader, add); err != nil {
+			return nil, fmt.Errorf("%w: reading diff block: %v", ErrInvalidBsdiff, err)
+		}
+
+		// Diff bytes are added to the old bytes, old positions outside the input count as zero
+		added := result.Bytes()[newPos:]
+		for i := int64(0); i < add; i++ {
+			if oldPos+i >= 0 && oldPos+i < int64(len(old)) {
+				added[i] += old[oldPos+i]
+			}
+		}
+
+		newPos += add
+		oldPos += add
+
+		if newPos+cp > newSize {
+			return nil, ErrInvalidBsdiff
+		}
+
+		if _, err := io.CopyN(result, extraReader, cp); err != nil {
+			return nil, fmt.Errorf("%w: reading extra block: %v", ErrInvalidBsdiff, err)
+		}
+
+		newPos += cp
+		oldPos += seek
+	}
+
+	return result.Bytes(), nil
+}
+
+// encodeBsdiffInt encodes an integer in the sign-magnitude little-endian form used by bsdiff.
+func encodeBsdiffInt(v int64) []byte {
+	buf := make([]byte, 8)
+
+	if v < 0 {
+		binary.LittleEndian.PutUint64(buf, uint64(-v))
+		buf[7] |= 0x80
+	} else {
+		binary.LittleEndian.PutUint64(buf, uint64(v))
+	}
+
+	return buf
+}
+
+// decodeBsdiffInt decodes an integer in the sign-magnitude little-endian form used by bsdiff.
+func decodeBsdiffInt(buf []byte) int64 {
+	v := int64(binary.LittleEndian.Uint64(buf) &^ (1 << 63))
+	if buf[7]&0x80 != 0 {
+		return -v
+	}
+
+	return v
+}
+
+// bzip2Compress compresses data using bzip2, as required by the BSDIFF40 format.
+func bzip2Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer, err := dsbzip2.NewWriter(&buf, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
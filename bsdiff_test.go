@@ -0,0 +1,93 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestBsdiffRoundTrip(t *testing.T) {
+	oldData, err := os.ReadFile("./testdata/bin1")
+	if err != nil {
+		t.Fatalf("failed to read old binary file: %v", err)
+	}
+
+	newData, err := os.ReadFile("./testdata/bin2")
+	if err != nil {
+		t.Fatalf("failed to read new binary file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+	}{
+		{name: "Binary fixtures", old: oldData, new: newData},
+		{name: "Reversed fixtures", old: newData, new: oldData},
+		{name: "Empty old", old: nil, new: newData},
+		{name: "Empty new", old: oldData, new: nil},
+		{name: "Identical", old: oldData, new: oldData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewGenericBinaryHandler()
+
+			var patch bytes.Buffer
+			if err := handler.WriteBsdiff(&patch, tt.old, tt.new); err != nil {
+				t.Fatalf("WriteBsdiff returned an error: %v", err)
+			}
+
+			if !bytes.HasPrefix(patch.Bytes(), []byte(bsdiffMagic)) {
+				t.Fatalf("expected patch to start with %q", bsdiffMagic)
+			}
+
+			got, err := handler.ApplyBsdiff(tt.old, &patch)
+			if err != nil {
+				t.Fatalf("ApplyBsdiff returned an error: %v", err)
+			}
+
+			if !bytes.Equal(got, tt.new) {
+				t.Errorf("patched data does not match new data")
+			}
+		})
+	}
+}
+
+func TestApplyBsdiff_Invalid(t *testing.T) {
+	handler := NewGenericBinaryHandler()
+
+	_, err := handler.ApplyBsdiff([]byte("old"), bytes.NewReader([]byte("NOTBSDIFF")))
+	if !errors.Is(err, ErrInvalidBsdiff) {
+		t.Errorf("expected ErrInvalidBsdiff, got %v", err)
+	}
+}
+
+func TestApplyBsdiff_OversizedHeader(t *testing.T) {
+	handler := NewGenericBinaryHandler()
+
+	var patch bytes.Buffer
+	if err := handler.WriteBsdiff(&patch, []byte("old data"), []byte("new data")); err != nil {
+		t.Fatalf("WriteBsdiff returned an error: %v", err)
+	}
+
+	// The header claims a terabyte of new data the blocks don't hold
+	data := patch.Bytes()
+	copy(data[24:32], encodeBsdiffInt(1<<40))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, err := handler.ApplyBsdiff([]byte("old data"), bytes.NewReader(data))
+	if !errors.Is(err, ErrInvalidBsdiff) {
+		t.Errorf("expected ErrInvalidBsdiff, got %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+		t.Errorf("expected the claimed size not to be allocated, allocated %d bytes", allocated)
+	}
+}
@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"errors"
+	"time"
+)
+
+// budgetCheckInterval is the number of steps of a comparison between two reads of the clock.
+const budgetCheckInterval = 256
+
+// errBudgetExceeded is returned internally by the steps of a comparison which exceeded its
+// time budget, for the comparison to fall back to budgetChunk.
+var errBudgetExceeded = errors.New("time budget exceeded")
+
+// timeBudget bounds the time a comparison takes, the TimeBudget of its handler. A nil
+// timeBudget is never exceeded.
+type timeBudget struct {
+	deadline time.Time
+	steps    int
+	exceeded bool
+}
+
+// newTimeBudget returns a budget ending after d, or nil when d is not positive.
+func newTimeBudget(d time.Duration) *timeBudget {
+	if d <= 0 {
+		return nil
+	}
+
+	return &timeBudget{deadline: time.Now().Add(d)}
+}
+
+// spent records a step of the comparison and reports whether the budget is exceeded.
+// The clock is read on the first step and every budgetCheckInterval steps after it.
+func (b *timeBudget) spent() bool {
+	if b == nil {
+		return false
+	}
+
+	if !b.exceeded && b.steps%budgetCheckInterval == 0 {
+		b.exceeded = !time.Now().Before(b.deadline)
+	}
+
+	b.steps++
+
+	return b.exceeded
+}
+
+// budgetChunk returns the chunk replacing the whole of old with new, returned by a
+// comparison which exceeded its time budget.
+func budgetChunk(old, new []byte, chunkType string) DiffChunk {
+	return DiffChunk{
+		Offset:         0,
+		OldData:        old,
+		OldLength:      int64(len(old)),
+		NewData:        new,
+		ChunkType:      chunkType,
+		Op:             chunkOp(old, new),
+		BudgetExceeded: true,
+	}
+}
@@ -0,0 +1,137 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTimeBudget(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Random data changed every few kilobytes, with no contiguous change to shortcut
+	binaryOld := make([]byte, 1<<20)
+	rng.Read(binaryOld)
+
+	binaryNew := bytes.Clone(binaryOld)
+	for i := 1000; i < len(binaryNew); i += 4096 {
+		binaryNew[i] ^= 0xff
+	}
+
+	// Lines changed every few lines, which MyersDiffer aligns one edit at a time
+	var textOld, textNew bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&textOld, "line %d\n", i)
+
+		if i%3 == 0 {
+			fmt.Fprintf(&textNew, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&textNew, "line %d\n", i)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(budget time.Duration) FileHandler
+		old, new []byte
+	}{
+		{
+			name: "binary",
+			handler: func(budget time.Duration) FileHandler {
+				handler := NewGenericBinaryHandler()
+				handler.TimeBudget = budget
+				return handler
+			},
+			old: binaryOld,
+			new: binaryNew,
+		},
+		{
+			name: "text",
+			handler: func(budget time.Duration) FileHandler {
+				return &TextFileHandler{Differ: MyersDiffer{}, TimeBudget: budget}
+			},
+			old: textOld.Bytes(),
+			new: textNew.Bytes(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler(time.Nanosecond)
+
+			chunks, err := handler.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if len(chunks) != 1 || !chunks[0].BudgetExceeded {
+				t.Fatalf("Compare returned %d chunks, want a single chunk with BudgetExceeded set", len(chunks))
+			}
+
+			patched, err := handler.Patch(tt.old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match")
+			}
+		})
+	}
+}
+
+func TestTimeBudget_NotExceeded(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	binaryOld := make([]byte, 64<<10)
+	rng.Read(binaryOld)
+
+	binaryNew := bytes.Clone(binaryOld)
+	binaryNew[1000] ^= 0xff
+	binaryNew[40000] ^= 0xff
+
+	tests := []struct {
+		name     string
+		handler  FileHandler
+		old, new []byte
+	}{
+		{
+			name:    "binary",
+			handler: &GenericBinaryHandler{MinMatchLength: 8, MaxGapSize: 1024, ChunkSize: 4096, TimeBudget: time.Hour},
+			old:     binaryOld,
+			new:     binaryNew,
+		},
+		{
+			name:    "text",
+			handler: &TextFileHandler{Differ: MyersDiffer{}, TimeBudget: time.Hour},
+			old:     []byte("one\ntwo\nthree\nfour\n"),
+			new:     []byte("one\n2\nthree\nfour\nfive\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := tt.handler.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			for _, chunk := range chunks {
+				if chunk.BudgetExceeded {
+					t.Errorf("chunk at %d has BudgetExceeded set within the budget", chunk.Offset)
+				}
+			}
+
+			patched, err := tt.handler.Patch(tt.old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match")
+			}
+		})
+	}
+}
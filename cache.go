@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// hashCacheKey identifies a cached file by the FileSystem it belongs to and its path.
+type hashCacheKey struct {
+	fsys FileSystem
+	path string
+}
+
+// hashCacheEntry is a cached file hash, valid as long as the file's size and
+// modification time are unchanged.
+type hashCacheEntry struct {
+	key     hashCacheKey
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// hashCache is a size-limited LRU cache of file hashes.
+type hashCache struct {
+	maxEntries int
+	entries    map[hashCacheKey]*list.Element
+	order      *list.List
+	mu         sync.Mutex
+}
+
+// newHashCache creates a new hashCache holding at most maxEntries hashes.
+func newHashCache(maxEntries int) *hashCache {
+	return &hashCache{
+		maxEntries: maxEntries,
+		entries:    make(map[hashCacheKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the cached hash of a file if its size and modification time match.
+func (c *hashCache) get(fsys FileSystem, path string, size int64, modTime time.Time) (string, bool) {
+	if c == nil || !cacheable(fsys) {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[hashCacheKey{fsys: fsys, path: path}]
+	if !ok {
+		return "", false
+	}
+
+	entry := elem.Value.(*hashCacheEntry)
+	if entry.size != size || !entry.modTime.Equal(modTime) {
+		return "", false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return entry.hash, true
+}
+
+// put stores the hash of a file, evicting the least recently used entry when full.
+func (c *hashCache) put(fsys FileSystem, path string, size int64, modTime time.Time, hash string) {
+	if c == nil || !cacheable(fsys) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := hashCacheKey{fsys: fsys, path: path}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*hashCacheEntry)
+		entry.size, entry.modTime, entry.hash = size, modTime, hash
+		c.order.MoveToFront(elem)
+
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&hashCacheEntry{
+		key:     key,
+		size:    size,
+		modTime: modTime,
+		hash:    hash,
+	})
+
+	if c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*hashCacheEntry).key)
+	}
+}
+
+// clear removes all cached hashes.
+func (c *hashCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[hashCacheKey]*list.Element)
+	c.order.Init()
+}
+
+// cacheable reports whether fsys can be part of a cache key.
+func cacheable(fsys FileSystem) bool {
+	return fsys != nil && reflect.TypeOf(fsys).Comparable()
+}
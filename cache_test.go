@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashCache_Eviction(t *testing.T) {
+	cache := newHashCache(2)
+	fsys := OSFileSystem{}
+	modTime := time.Now()
+
+	cache.put(fsys, "a", 1, modTime, "hash-a")
+	cache.put(fsys, "b", 1, modTime, "hash-b")
+
+	// Touch a so b becomes the least recently used entry
+	if _, ok := cache.get(fsys, "a", 1, modTime); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	cache.put(fsys, "c", 1, modTime, "hash-c")
+
+	if _, ok := cache.get(fsys, "b", 1, modTime); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	if hash, ok := cache.get(fsys, "a", 1, modTime); !ok || hash != "hash-a" {
+		t.Errorf("expected a to be cached with hash-a, got %q", hash)
+	}
+
+	if _, ok := cache.get(fsys, "a", 2, modTime); ok {
+		t.Error("expected a size change to invalidate the entry")
+	}
+
+	if _, ok := cache.get(fsys, "a", 1, modTime.Add(time.Second)); ok {
+		t.Error("expected a modification time change to invalidate the entry")
+	}
+
+	cache.clear()
+
+	if _, ok := cache.get(fsys, "c", 1, modTime); ok {
+		t.Error("expected the cache to be empty after clear")
+	}
+}
+
+func TestDiffEngine_HashCache(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	files := map[string]string{
+		"a.txt": "unchanged a\n",
+		"b.txt": "unchanged b\n",
+		"c.txt": "unchanged c\n",
+	}
+
+	writeTestTree(t, oldDir, files)
+	writeTestTree(t, newDir, files)
+	writeTestTree(t, oldDir, map[string]string{"changed.txt": "before\n"})
+	writeTestTree(t, newDir, map[string]string{"changed.txt": "after\n"})
+
+	var read atomic.Int64
+
+	engine := newTestEngine(t, DefaultConfig())
+	engine.SetFileSystem(&countingFileSystem{FileSystem: OSFileSystem{}, count: &read})
+	engine.EnableHashCache(100)
+
+	compare := func() int64 {
+		read.Store(0)
+
+		_, results, err := engine.CompareDirs(oldDir, newDir)
+		if err != nil {
+			t.Fatalf("CompareDirs returned an error: %v", err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		return read.Load()
+	}
+
+	first := compare()
+	second := compare()
+
+	if second >= first {
+		t.Errorf("expected the second comparison to read fewer bytes, got %d then %d", first, second)
+	}
+
+	engine.ClearHashCache()
+
+	if third := compare(); third != first {
+		t.Errorf("expected a cleared cache to read %d bytes, got %d", first, third)
+	}
+}
@@ -0,0 +1,125 @@
+package diff
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ChangeEntry is a changed file listed by ListChanges.
+type ChangeEntry struct {
+	Path      string // Slash-separated path relative to the compared directories
+	Operation string // "added", "modified", "deleted", "typechange", or "unchanged" with Configuration.ReportUnchanged
+	OldHash   string
+	NewHash   string
+}
+
+// ListChanges lists the files which differ between two directories, comparing their hashes
+// only. It honors the same configuration as CompareDirs, but never reads files for a
+// content diff, so it is much faster when the chunks are not needed. Modified files are
+// never reported as "rewritten". Entries are sorted by path.
+//
+// Files which could not be compared are left out, and reported joined in the returned
+// error along with the entries of the other files.
+func (e *DiffEngine) ListChanges(oldDir, newDir string) ([]ChangeEntry, error) {
+	fsys := e.getFileSystem()
+
+	summary, results, err := e.compareTreesWith(fsys, fsys, []string{oldDir}, newDir, e.compareHashes, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ChangeEntry, 0, len(results))
+	for _, result := range results {
+		entries = append(entries, ChangeEntry{
+			Path:      result.RelPath,
+			Operation: result.Operation,
+			OldHash:   result.OldHash,
+			NewHash:   result.NewHash,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	errs := make([]error, len(summary.Errors))
+	for i, fileErr := range summary.Errors {
+		errs[i] = fileErr
+	}
+
+	return entries, errors.Join(errs...)
+}
+
+// compareHashes compares two files by their hashes, without chunks. Files with a no-op
+// handler are compared from their metadata as by compareFiles.
+func (e *DiffEngine) compareHashes(oldFS, newFS FileSystem, oldPath, newPath, relPath string, newInfo os.FileInfo) (*DiffResult, error) {
+	if e.typeChanged(oldFS, newFS, oldPath, newPath, newInfo) {
+		return typeChangeResult(newPath, newInfo), nil
+	}
+
+	handler := e.getHandler(relPath)
+	if isNoOp(handler) {
+		return e.compareMetadata(oldFS, newFS, oldPath, newPath, relPath, newInfo)
+	}
+
+	newHash, err := e.cachedHash(newFS, newPath, newInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiffResult{
+		Path:        filepath.Base(newPath),
+		Operation:   "modified",
+		NewHash:     newHash,
+		FileType:    handler.GetFileType(),
+		Size:        newInfo.Size(),
+		ModTime:     newInfo.ModTime(),
+		Permissions: newInfo.Mode(),
+	}
+
+	oldInfo, err := oldFS.Stat(oldPath)
+	if notExist(err) {
+		result.Operation = "added"
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if oldInfo.IsDir() {
+		return typeChangeResult(newPath, newInfo), nil
+	}
+
+	if result.OldHash, err = e.cachedHash(oldFS, oldPath, oldInfo); err != nil {
+		return nil, err
+	}
+
+	if result.OldHash == newHash {
+		return e.unchangedResult(relPath, newInfo, result.OldHash, newHash), nil
+	}
+
+	return result, nil
+}
+
+// cachedHash returns the hash of a file from the hash cache when it is enabled, hashing
+// and caching it on a miss.
+func (e *DiffEngine) cachedHash(fsys FileSystem, path string, info os.FileInfo) (string, error) {
+	cache := e.getHashCache()
+	if cache != nil {
+		if hash, ok := cache.get(fsys, path, info.Size(), info.ModTime()); ok {
+			return hash, nil
+		}
+	}
+
+	hash, err := e.hashFile(fsys, path)
+	if err != nil {
+		return "", err
+	}
+
+	if cache != nil {
+		cache.put(fsys, path, info.Size(), info.ModTime(), hash)
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,59 @@
+package diff
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListChanges(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"same.txt":         "same\n",
+		"docs/changed.txt": "old\n",
+		"data.bin":         "\x00\x01\x02",
+		"deleted.txt":      "deleted\n",
+		"sub/deleted.md":   "deleted\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"same.txt":         "same\n",
+		"docs/changed.txt": "new\n",
+		"data.bin":         "\x00\x01\x03",
+		"sub/added.txt":    "added\n",
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	want := make([]ChangeEntry, 0, len(results))
+	for _, result := range results {
+		want = append(want, ChangeEntry{Path: result.RelPath, Operation: result.Operation, OldHash: result.OldHash, NewHash: result.NewHash})
+	}
+
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].Path < want[j].Path
+	})
+
+	// Comparisons through the text handler are counted to check that none happen
+	counter := &countingHandler{}
+	counter.FileHandler = engine.RegisterHandlerReturningPrev(".txt", counter)
+
+	got, err := engine.ListChanges(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("ListChanges returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected changes (-want +got):\n%s", diff)
+	}
+
+	if counter.compares != 0 {
+		t.Errorf("expected no content comparisons, got %d", counter.compares)
+	}
+}
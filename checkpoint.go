@@ -0,0 +1,196 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// checkpointVersion is the version of the checkpoint files written by CompareDirs.
+const checkpointVersion = 1
+
+// defaultCheckpointInterval is the number of files compared between checkpoints when
+// Configuration.CheckpointInterval is not set.
+const defaultCheckpointInterval = 100
+
+// checkpoint is the progress of an interrupted comparison of directories: the relative
+// paths of the new tree already processed, and the results and summary they produced.
+type checkpoint struct {
+	Version   int
+	Processed []string
+	Summary   DiffSummary
+	Results   []DiffResult
+}
+
+// loadCheckpoint reads the checkpoint file at path.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("invalid checkpoint %s: unsupported version %d", path, cp.Version)
+	}
+
+	if cp.Summary.FileTypes == nil {
+		cp.Summary.FileTypes = make(map[string]int)
+	}
+
+	return &cp, nil
+}
+
+// checkpointer records the files of a comparison as they are processed, and saves them
+// with the results and summary so far every interval files. A nil checkpointer records
+// nothing. Its done method is called with the mutex guarding the results held, and only
+// takes a snapshot of them; flush saves it once that mutex is released.
+type checkpointer struct {
+	logger    *Logger
+	path      string
+	interval  int
+	processed map[string]bool
+	bytes     int64 // Size of the processed files
+	pending   int   // Files processed since the last snapshot
+
+	dueMu   sync.Mutex  // Guards due
+	due     *checkpoint // Latest snapshot not saved yet
+	savesMu sync.Mutex  // Serializes the saves, so an older snapshot never replaces a newer one
+}
+
+// newCheckpointer returns the checkpointer saving to path, or nil when path is empty.
+// The files of a resumed checkpoint are already processed.
+func newCheckpointer(logger *Logger, path string, interval int, resumed *checkpoint) *checkpointer {
+	if path == "" {
+		return nil
+	}
+
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	c := &checkpointer{
+		logger:    logger,
+		path:      path,
+		interval:  interval,
+		processed: make(map[string]bool),
+	}
+
+	if resumed != nil {
+		for _, relPath := range resumed.Processed {
+			c.processed[relPath] = true
+		}
+
+		c.bytes = resumed.Summary.ProcessedBytes
+	}
+
+	return c
+}
+
+// done records that the file at relPath of the given size was processed, whose result,
+// if any, was added to results and summary, and takes a snapshot of them for flush when a
+// checkpoint is due. Errors and the files skipped by the walk are not saved, as they are
+// found again when resuming.
+func (c *checkpointer) done(relPath string, size int64, summary *DiffSummary, results []DiffResult) {
+	if c == nil {
+		return
+	}
+
+	c.processed[relPath] = true
+	c.bytes += size
+
+	if c.pending++; c.pending < c.interval {
+		return
+	}
+
+	c.pending = 0
+
+	// Results are only appended to, so the snapshot shares their elements
+	cp := &checkpoint{
+		Version:   checkpointVersion,
+		Processed: make([]string, 0, len(c.processed)),
+		Summary:   summary.snapshot(),
+		Results:   results[:len(results):len(results)],
+	}
+
+	for relPath := range c.processed {
+		cp.Processed = append(cp.Processed, relPath)
+	}
+
+	cp.Summary.Errors = nil
+	cp.Summary.SkippedOlderFiles = 0
+	cp.Summary.SkippedBinary = 0
+	cp.Summary.ProcessedBytes = c.bytes
+
+	c.dueMu.Lock()
+	c.due = cp
+	c.dueMu.Unlock()
+}
+
+// flush saves the latest snapshot taken by done, if any, without the mutex guarding the
+// results held. A checkpoint which cannot be saved is logged, and the comparison goes on.
+func (c *checkpointer) flush() {
+	if c == nil {
+		return
+	}
+
+	c.savesMu.Lock()
+	defer c.savesMu.Unlock()
+
+	c.dueMu.Lock()
+	cp := c.due
+	c.due = nil
+	c.dueMu.Unlock()
+
+	if cp == nil {
+		return
+	}
+
+	if err := c.save(cp); err != nil {
+		c.logger.Log("Failed to save checkpoint %s: %v", c.path, err)
+	}
+}
+
+// save writes the checkpoint, replacing the previous one atomically so an interruption
+// while saving leaves the previous checkpoint intact.
+func (c *checkpointer) save(cp *checkpoint) error {
+	sort.Strings(cp.Processed)
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// remove deletes the checkpoint once the comparison completed.
+func (c *checkpointer) remove() {
+	if c != nil {
+		os.Remove(c.path)
+	}
+}
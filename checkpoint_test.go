@@ -0,0 +1,175 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// interruptingFileSystem fails its walks after the given number of regular files,
+// like a comparison interrupted by a crash.
+type interruptingFileSystem struct {
+	FileSystem
+	after int
+}
+
+func (f *interruptingFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	files := 0
+
+	return f.FileSystem.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			if files++; files > f.after {
+				return errInterrupted
+			}
+		}
+
+		return fn(path, info, err)
+	})
+}
+
+func TestCompareDirs_Resume(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles := make(map[string]string)
+	newFiles := make(map[string]string)
+
+	for i := 0; i < 60; i++ {
+		name := fmt.Sprintf("dir%d/file%02d.txt", i%3, i)
+		oldFiles[name] = fmt.Sprintf("file %d\n", i)
+
+		switch i % 4 {
+		case 0:
+			newFiles[name] = fmt.Sprintf("file %d, modified\n", i)
+		case 1:
+			// Deleted
+		case 2:
+			newFiles[name] = oldFiles[name]
+		case 3:
+			newFiles[fmt.Sprintf("new/file%02d.txt", i)] = fmt.Sprintf("added %d\n", i)
+		}
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	wantSummary, wantResults, err := newTestEngine(t, DefaultConfig()).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	checkpointFile := filepath.Join(t.TempDir(), "compare.checkpoint")
+
+	config := DefaultConfig()
+	config.CheckpointFile = checkpointFile
+	config.CheckpointInterval = 5
+
+	interrupted := newTestEngine(t, config)
+	interrupted.SetFileSystem(&interruptingFileSystem{FileSystem: OSFileSystem{}, after: 23})
+
+	if _, _, err := interrupted.CompareDirs(oldDir, newDir); !errors.Is(err, errInterrupted) {
+		t.Fatalf("expected the comparison to be interrupted, got %v", err)
+	}
+
+	if _, err := os.Stat(checkpointFile); err != nil {
+		t.Fatalf("expected a checkpoint to be saved: %v", err)
+	}
+
+	metrics := &MemoryMetrics{}
+
+	config = DefaultConfig()
+	config.CheckpointFile = checkpointFile
+	config.ResumeFrom = checkpointFile
+	config.Metrics = metrics
+
+	gotSummary, gotResults, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	byPath := func(results []DiffResult) {
+		sort.Slice(results, func(i, j int) bool { return results[i].RelPath < results[j].RelPath })
+	}
+
+	byPath(wantResults)
+	byPath(gotResults)
+
+	if diff := cmp.Diff(wantResults, gotResults, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(wantSummary, gotSummary, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(DiffSummary{}, "StartTime", "EndTime")); diff != "" {
+		t.Errorf("summary mismatch (-want +got):\n%s", diff)
+	}
+
+	// Only the files after the checkpoint were compared again
+	resumedFiles := 0
+	for op, n := range metrics.Snapshot().Files {
+		if op != "deleted" {
+			resumedFiles += n
+		}
+	}
+
+	if compared := wantSummary.AddedFiles + wantSummary.ModifiedFiles; resumedFiles >= compared {
+		t.Errorf("expected fewer than %d files to be compared again, got %d", compared, resumedFiles)
+	}
+
+	if _, err := os.Stat(checkpointFile); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be removed once the comparison completed, got %v", err)
+	}
+}
+
+func TestCompareDirs_ResumeInvalid(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "compare.checkpoint")
+	if err := os.WriteFile(checkpointFile, []byte("not a checkpoint"), 0644); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.ResumeFrom = checkpointFile
+
+	if _, _, err := newTestEngine(t, config).CompareDirs(t.TempDir(), t.TempDir()); err == nil {
+		t.Error("expected an error for an invalid checkpoint")
+	}
+}
+
+func TestCheckpointer_Flush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	c := newCheckpointer(nil, path, 2, nil)
+
+	summary := &DiffSummary{FileTypes: map[string]int{"text": 1}}
+	results := []DiffResult{{RelPath: "a.txt", Operation: "added"}}
+
+	c.done("a.txt", 10, summary, results)
+	c.done("b.txt", 20, summary, results)
+
+	// The snapshot is taken by done, and written by flush only
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no checkpoint before flush, got %v", err)
+	}
+
+	// Later changes to the summary don't alter the snapshot
+	summary.FileTypes["text"]++
+
+	c.flush()
+
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"a.txt", "b.txt"}, cp.Processed); diff != "" {
+		t.Errorf("processed files mismatch (-want +got):\n%s", diff)
+	}
+
+	if cp.Summary.FileTypes["text"] != 1 || cp.Summary.ProcessedBytes != 30 || len(cp.Results) != 1 {
+		t.Errorf("unexpected checkpoint %+v", cp)
+	}
+}
@@ -0,0 +1,258 @@
+package diff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// chunkEncodingMagic starts the compact chunk encoding.
+const chunkEncodingMagic = "DCK1"
+
+// ErrInvalidChunkEncoding is returned when data is not a valid compact chunk encoding.
+var ErrInvalidChunkEncoding = errors.New("invalid chunk encoding")
+
+// chunkOps are the operations of the compact chunk encoding, by code. Code 0 is a chunk
+// without an operation.
+var chunkOps = []string{"", OpInsert, OpDelete, OpReplace, OpCopy, OpZero}
+
+// Flags of a chunk in the compact encoding
+const (
+	chunkFlagCompressed = 1 << iota
+	chunkFlagCopyRange  // CopyFrom and CopyLength follow OldLength
+	chunkFlagZeroLength // ZeroLength follows OldLength and the copy range
+	chunkFlagBudgetExceeded
+)
+
+// EncodeChunks writes the chunks in a compact binary encoding, much smaller than gob or
+// JSON for many small chunks. Integers are written as varints and byte fields are
+// prefixed with their length. Each chunk type is written once and referred to by its index
+// afterwards. DecodeChunks reads the chunks back.
+func EncodeChunks(w io.Writer, chunks []DiffChunk) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(chunkEncodingMagic); err != nil {
+		return err
+	}
+
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(len(chunks)))
+
+	types := make(map[string]int)
+
+	for i, chunk := range chunks {
+		op := -1
+		for code, name := range chunkOps {
+			if name == chunk.Op {
+				op = code
+			}
+		}
+
+		if op < 0 {
+			return fmt.Errorf("chunk %d: unknown operation %q", i, chunk.Op)
+		}
+
+		var flags uint64
+		if chunk.Compressed {
+			flags |= chunkFlagCompressed
+		}
+
+		if chunk.CopyFrom != 0 || chunk.CopyLength != 0 {
+			flags |= chunkFlagCopyRange
+		}
+
+		if chunk.ZeroLength != 0 {
+			flags |= chunkFlagZeroLength
+		}
+
+		if chunk.BudgetExceeded {
+			flags |= chunkFlagBudgetExceeded
+		}
+
+		buf = binary.AppendVarint(buf, chunk.Offset)
+		buf = binary.AppendUvarint(buf, uint64(op))
+		buf = binary.AppendUvarint(buf, flags)
+		buf = binary.AppendUvarint(buf, uint64(chunk.Checksum))
+		buf = binary.AppendVarint(buf, chunk.Page)
+		buf = binary.AppendVarint(buf, chunk.Record)
+		buf = binary.AppendVarint(buf, chunk.OldLength)
+
+		if flags&chunkFlagCopyRange != 0 {
+			buf = binary.AppendVarint(buf, chunk.CopyFrom)
+			buf = binary.AppendVarint(buf, chunk.CopyLength)
+		}
+
+		if flags&chunkFlagZeroLength != 0 {
+			buf = binary.AppendVarint(buf, chunk.ZeroLength)
+		}
+
+		// A new type is written after the next unused index
+		index, seen := types[chunk.ChunkType]
+		if !seen {
+			index = len(types)
+			types[chunk.ChunkType] = index
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(index))
+		if !seen {
+			buf = appendLengthPrefixed(buf, []byte(chunk.ChunkType))
+		}
+
+		buf = appendLengthPrefixed(buf, chunk.OldData)
+		buf = appendLengthPrefixed(buf, chunk.NewData)
+		buf = appendLengthPrefixed(buf, chunk.ContextBefore)
+		buf = appendLengthPrefixed(buf, chunk.ContextAfter)
+
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+
+		buf = buf[:0]
+	}
+
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// appendLengthPrefixed appends data to buf, prefixed with its length.
+func appendLengthPrefixed(buf, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// DecodeChunks reads chunks written by EncodeChunks. Empty byte fields are decoded as nil.
+// The reader is buffered, so it may be read past the end of the chunks.
+func DecodeChunks(r io.Reader) ([]DiffChunk, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		reader := bufio.NewReader(r)
+		r, br = reader, reader
+	}
+
+	magic := make([]byte, len(chunkEncodingMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != chunkEncodingMagic {
+		return nil, ErrInvalidChunkEncoding
+	}
+
+	d := chunkDecoder{r: r, br: br}
+
+	count := d.uvarint()
+	chunks := make([]DiffChunk, 0, min(count, 1024))
+
+	var types []string
+
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		var chunk DiffChunk
+
+		chunk.Offset = d.varint()
+
+		op := d.uvarint()
+		if op >= uint64(len(chunkOps)) {
+			d.fail()
+			break
+		}
+
+		chunk.Op = chunkOps[op]
+		flags := d.uvarint()
+		chunk.Compressed = flags&chunkFlagCompressed != 0
+		chunk.BudgetExceeded = flags&chunkFlagBudgetExceeded != 0
+		chunk.Checksum = uint32(d.uvarint())
+		chunk.Page = d.varint()
+		chunk.Record = d.varint()
+		chunk.OldLength = d.varint()
+
+		if flags&chunkFlagCopyRange != 0 {
+			chunk.CopyFrom = d.varint()
+			chunk.CopyLength = d.varint()
+		}
+
+		if flags&chunkFlagZeroLength != 0 {
+			chunk.ZeroLength = d.varint()
+		}
+
+		switch index := d.uvarint(); {
+		case index == uint64(len(types)):
+			types = append(types, string(d.bytes()))
+			fallthrough
+		case index < uint64(len(types)):
+			chunk.ChunkType = types[index]
+		default:
+			d.fail()
+		}
+
+		chunk.OldData = d.bytes()
+		chunk.NewData = d.bytes()
+		chunk.ContextBefore = d.bytes()
+		chunk.ContextAfter = d.bytes()
+
+		chunks = append(chunks, chunk)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return chunks, nil
+}
+
+// chunkDecoder reads the fields of encoded chunks, keeping the first error.
+type chunkDecoder struct {
+	r   io.Reader
+	br  io.ByteReader
+	err error
+}
+
+// fail records that the encoding is invalid.
+func (d *chunkDecoder) fail() {
+	if d.err == nil {
+		d.err = ErrInvalidChunkEncoding
+	}
+}
+
+func (d *chunkDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(d.br)
+	if err != nil {
+		d.fail()
+	}
+
+	return v
+}
+
+func (d *chunkDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadVarint(d.br)
+	if err != nil {
+		d.fail()
+	}
+
+	return v
+}
+
+// bytes reads a length-prefixed field. The data is read incrementally, so a corrupt
+// length cannot allocate more than the remaining input.
+func (d *chunkDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(d.r, int64(min(n, 1<<62))))
+	if err != nil || uint64(len(data)) != n {
+		d.fail()
+		return nil
+	}
+
+	return data
+}
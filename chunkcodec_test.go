@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEncodeChunks(t *testing.T) {
+	chunks := []DiffChunk{
+		{Offset: 0, NewData: []byte("inserted"), ChunkType: "text", Op: OpInsert, Checksum: 0xdeadbeef},
+		{Offset: 12, OldData: []byte("old"), NewData: []byte("new"), ChunkType: "binary", Op: OpReplace, ContextBefore: []byte("ab"), ContextAfter: []byte("cd")},
+		{Offset: 4096, OldLength: 1024, NewData: []byte{0x1f, 0x8b}, ChunkType: "sqlite", Op: OpReplace, Compressed: true, Page: 5},
+		{Offset: 96, OldData: []byte("record"), NewData: []byte("RECORD"), ChunkType: "record", Op: OpReplace, Record: 16},
+		{Offset: 1 << 40, OldData: []byte("deleted"), ChunkType: "binary", Op: OpDelete},
+		{Offset: 7, NewData: []byte("copied"), Op: OpCopy},
+		{Offset: 64, OldLength: 8, ChunkType: "binary", Op: OpCopy, CopyFrom: 1024, CopyLength: 512},
+		{Offset: 72, OldLength: 4, ChunkType: "binary", Op: OpZero, ZeroLength: 1 << 20},
+		{Offset: 0, OldData: []byte("whole"), OldLength: 5, NewData: []byte("file"), ChunkType: "text", Op: OpReplace, BudgetExceeded: true},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeChunks(&buf, chunks); err != nil {
+		t.Fatalf("EncodeChunks returned an error: %v", err)
+	}
+
+	got, err := DecodeChunks(&buf)
+	if err != nil {
+		t.Fatalf("DecodeChunks returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff(chunks, got); diff != "" {
+		t.Errorf("unexpected chunks (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncodeChunks_Size(t *testing.T) {
+	chunks := make([]DiffChunk, 1000)
+	for i := range chunks {
+		chunks[i] = DiffChunk{
+			Offset:    int64(i * 64),
+			OldData:   []byte(fmt.Sprintf("line %d", i)),
+			NewData:   []byte(fmt.Sprintf("LINE %d", i)),
+			ChunkType: "text",
+			Op:        OpReplace,
+		}
+	}
+
+	var compact, gobbed bytes.Buffer
+	if err := EncodeChunks(&compact, chunks); err != nil {
+		t.Fatalf("EncodeChunks returned an error: %v", err)
+	}
+
+	if err := gob.NewEncoder(&gobbed).Encode(chunks); err != nil {
+		t.Fatalf("gob encoding returned an error: %v", err)
+	}
+
+	t.Logf("%d chunks: %d bytes compact, %d bytes gob", len(chunks), compact.Len(), gobbed.Len())
+
+	if compact.Len() >= gobbed.Len() {
+		t.Errorf("expected the compact encoding to be smaller than gob, got %d >= %d bytes", compact.Len(), gobbed.Len())
+	}
+}
+
+func TestDecodeChunks_Invalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeChunks(&buf, []DiffChunk{{Offset: 3, NewData: []byte("data"), Op: OpInsert}}); err != nil {
+		t.Fatalf("EncodeChunks returned an error: %v", err)
+	}
+
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "Bad magic", data: []byte("XXXX\x00")},
+		{name: "Truncated", data: valid[:len(valid)-2]},
+		{name: "Unknown chunk type", data: []byte(chunkEncodingMagic + "\x01\x00\x01\x00\x00\x00\x00\x00\x05")},
+		{name: "Unknown operation", data: []byte(chunkEncodingMagic + "\x01\x00\x63")},
+		{name: "Huge length", data: []byte(chunkEncodingMagic + "\x01\x00\x01\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff\x0f")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeChunks(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidChunkEncoding) {
+				t.Errorf("expected ErrInvalidChunkEncoding, got %v", err)
+			}
+		})
+	}
+
+	if err := EncodeChunks(&bytes.Buffer{}, []DiffChunk{{Op: "move"}}); err == nil {
+		t.Error("expected an error encoding an unknown operation")
+	}
+}
@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+)
+
+// CommentSyntax describes the comments of a language, which TextFileHandler ignores when
+// comparing lines. Markers within quoted strings don't start comments.
+type CommentSyntax struct {
+	Line       []string // Markers starting a comment up to the end of the line, such as "//" or "#"
+	BlockStart string   // Marker starting a block comment, such as "/*"
+	BlockEnd   string   // Marker ending a block comment, such as "*/"
+	Quotes     string   // Characters quoting strings, such as `"'`; other characters never start a string
+}
+
+// Comment syntaxes of common languages
+var (
+	CStyleComments = &CommentSyntax{Line: []string{"//"}, BlockStart: "/*", BlockEnd: "*/", Quotes: "\"'`"} // C, Go, Java, JavaScript
+	HashComments   = &CommentSyntax{Line: []string{"#"}, Quotes: `"`}                                       // Shell, Python, YAML
+)
+
+// strip returns line without its comments and the blanks left before them, along with
+// whether a block comment is still open at its end. inBlock reports whether one is open
+// at its start, as returned for the previous line.
+func (c *CommentSyntax) strip(line []byte, inBlock bool) ([]byte, bool) {
+	stripped := make([]byte, 0, len(line))
+	var quote byte
+
+	i := 0
+	if inBlock {
+		end := bytes.Index(line, []byte(c.BlockEnd))
+		if end < 0 {
+			return stripped, true
+		}
+
+		i, inBlock = end+len(c.BlockEnd), false
+	}
+
+	for ; i < len(line); i++ {
+		ch := line[i]
+
+		if quote != 0 {
+			stripped = append(stripped, ch)
+
+			switch {
+			case ch == '\\' && i+1 < len(line):
+				i++
+				stripped = append(stripped, line[i])
+			case ch == quote:
+				quote = 0
+			}
+
+			continue
+		}
+
+		rest := line[i:]
+
+		if c.BlockStart != "" && bytes.HasPrefix(rest, []byte(c.BlockStart)) {
+			end := bytes.Index(rest[len(c.BlockStart):], []byte(c.BlockEnd))
+			if c.BlockEnd == "" || end < 0 {
+				// Without an end marker, block comments end with their line
+				inBlock = c.BlockEnd != ""
+				break
+			}
+
+			i += len(c.BlockStart) + end + len(c.BlockEnd) - 1
+			continue
+		}
+
+		if c.lineComment(rest) {
+			break
+		}
+
+		if strings.IndexByte(c.Quotes, ch) >= 0 {
+			quote = ch
+		}
+
+		stripped = append(stripped, ch)
+	}
+
+	return bytes.TrimRight(stripped, " \t\r"), inBlock
+}
+
+// lineComment reports whether data starts with a line comment marker.
+func (c *CommentSyntax) lineComment(data []byte) bool {
+	for _, marker := range c.Line {
+		if marker != "" && bytes.HasPrefix(data, []byte(marker)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCommentSyntax_strip(t *testing.T) {
+	tests := []struct {
+		name      string
+		syntax    *CommentSyntax
+		line      string
+		inBlock   bool // Block comment open before the line
+		want      string
+		wantBlock bool // Block comment open after the line
+	}{
+		{name: "trailing comment", syntax: CStyleComments, line: "x := 1 // one", want: "x := 1"},
+		{name: "comment line", syntax: CStyleComments, line: "\t// only a comment", want: ""},
+		{name: "inline block comment", syntax: CStyleComments, line: "f(a /* first */, b)", want: "f(a , b)"},
+		{name: "unterminated block comment", syntax: CStyleComments, line: "x := 1 /* starts here", want: "x := 1", wantBlock: true},
+		{name: "within block comment", syntax: CStyleComments, line: " * still a comment", inBlock: true, want: "", wantBlock: true},
+		{name: "end of block comment", syntax: CStyleComments, line: " ends */ x := 2 // two", inBlock: true, want: " x := 2"},
+		{name: "marker in string", syntax: CStyleComments, line: `url := "http://example.com" // site`, want: `url := "http://example.com"`},
+		{name: "escaped quote", syntax: CStyleComments, line: `s := "a\"//b" // c`, want: `s := "a\"//b"`},
+		{name: "hash comment", syntax: HashComments, line: "name: value  # note", want: "name: value"},
+		{name: "hash in string", syntax: HashComments, line: `color = "#fff"`, want: `color = "#fff"`},
+		{name: "apostrophe", syntax: HashComments, line: "msg: it's  # note", want: "msg: it's"},
+		{name: "no comment", syntax: HashComments, line: "plain text", want: "plain text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, inBlock := tt.syntax.strip([]byte(tt.line), tt.inBlock)
+			if string(got) != tt.want || inBlock != tt.wantBlock {
+				t.Errorf("strip(%q, %v) = %q, %v, want %q, %v", tt.line, tt.inBlock, got, inBlock, tt.want, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestTextFileHandler_CommentSyntax(t *testing.T) {
+	tests := []struct {
+		name     string
+		syntax   *CommentSyntax
+		old, new string
+		want     []DiffChunk
+		patched  string // Result of patching old, when there are chunks
+	}{
+		{
+			name:   "Slash comment changed",
+			syntax: CStyleComments,
+			old:    "package main\n\nvar x = 1 // the answer\n",
+			new:    "package main\n\nvar x = 1 // not the answer\n",
+		},
+		{
+			name:   "Comment line changed",
+			syntax: CStyleComments,
+			old:    "// Old doc\nfunc f() {}\n",
+			new:    "// New doc\nfunc f() {}\n",
+		},
+		{
+			name:   "Hash comment changed",
+			syntax: HashComments,
+			old:    "retries: 3 # default\ntimeout: 10\n",
+			new:    "retries: 3   # was the default\ntimeout: 10\n",
+		},
+		{
+			name:   "Block comment lines changed",
+			syntax: CStyleComments,
+			old:    "/*\n * Old doc\n * of f\n */\nfunc f() {}\n",
+			new:    "/*\n * New doc\n * of f()\n */\nfunc f() {}\n",
+		},
+		{
+			name:   "Code after block comment",
+			syntax: CStyleComments,
+			old:    "x := 1 /* first\nsecond */ y := 2\n",
+			new:    "x := 1 /* 1st\n2nd */ y := 2\n",
+		},
+		{
+			name:   "Apostrophe before hash comment",
+			syntax: HashComments,
+			old:    "msg: it's  # note\nnext: 1\n",
+			new:    "msg: it's  # changed note\nnext: 1\n",
+		},
+		{
+			name:   "Code after block comment changed",
+			syntax: CStyleComments,
+			old:    "/* doc\n */ y := 2\n",
+			new:    "/* doc\n */ y := 3\n",
+			want: []DiffChunk{{
+				Offset:    7,
+				OldData:   []byte(" */ y := 2"),
+				OldLength: 10,
+				NewData:   []byte(" */ y := 3"),
+				ChunkType: "text",
+				Op:        OpReplace,
+			}},
+			patched: "/* doc\n */ y := 3\n",
+		},
+		{
+			name:   "Code and comment changed",
+			syntax: CStyleComments,
+			old:    "a := 1 // first\nb := 2 // second\n",
+			new:    "a := 1 // 1st\nb := 3 // 2nd\n",
+			want: []DiffChunk{{
+				Offset:    16,
+				OldData:   []byte("b := 2 // second"),
+				OldLength: 16,
+				NewData:   []byte("b := 3 // 2nd"),
+				ChunkType: "text",
+				Op:        OpReplace,
+			}},
+			// The first line keeps its old comment
+			patched: "a := 1 // first\nb := 3 // 2nd\n",
+		},
+		{
+			name:   "Comment marker of another syntax",
+			syntax: HashComments,
+			old:    "a := 1 // first\n",
+			new:    "a := 1 // 1st\n",
+			want: []DiffChunk{{
+				Offset:    0,
+				OldData:   []byte("a := 1 // first"),
+				OldLength: 15,
+				NewData:   []byte("a := 1 // 1st"),
+				ChunkType: "text",
+				Op:        OpReplace,
+			}},
+			patched: "a := 1 // 1st\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, differ := range []LineDiffer{nil, MyersDiffer{}} {
+			t.Run(tt.name, func(t *testing.T) {
+				handler := &TextFileHandler{CommentSyntax: tt.syntax, Differ: differ}
+
+				chunks, err := handler.Compare([]byte(tt.old), []byte(tt.new))
+				if err != nil {
+					t.Fatalf("Compare returned an error: %v", err)
+				}
+
+				if diff := cmp.Diff(tt.want, chunks, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("Compare() mismatch (-want +got):\n%s", diff)
+				}
+
+				// The lines whose code changed are patched whole, with their new comments
+				if len(chunks) > 0 {
+					patched, err := handler.Patch([]byte(tt.old), chunks)
+					if err != nil {
+						t.Fatalf("Patch returned an error: %v", err)
+					}
+
+					if string(patched) != tt.patched {
+						t.Errorf("Patch() = %q, want %q", patched, tt.patched)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package diff
+
+import (
+	"compress/gzip"
+	"time"
+)
+
+// defaultAutoCompressionMBps is the throughput the level chosen by AutoCompressionLevel
+// must reach when Configuration.AutoCompressionMBps is not set.
+const defaultAutoCompressionMBps = 20
+
+// autoCompressionSampleSize is the largest sample the levels are measured on.
+const autoCompressionSampleSize = 1024 * 1024
+
+// autoCompressionLevels are the levels measured by AutoCompressionLevel, fastest first.
+var autoCompressionLevels = []int{gzip.BestSpeed, 3, gzip.DefaultCompression, gzip.BestCompression}
+
+// tuneCompressionLevel chooses the compression level of the run from the first chunks
+// with data compressed, when Configuration.AutoCompressionLevel is set. Each level of
+// autoCompressionLevels compresses a sample of the chunks, and the one producing the
+// smallest output among those reaching AutoCompressionMBps is kept, or the fastest when
+// none does.
+func (e *DiffEngine) tuneCompressionLevel(chunks []DiffChunk) {
+	if !e.config.AutoCompressionLevel {
+		return
+	}
+
+	e.autoLevelMu.Lock()
+	defer e.autoLevelMu.Unlock()
+
+	if e.autoLevelTuned {
+		return
+	}
+
+	sample := make([]byte, 0, autoCompressionSampleSize)
+	for _, chunk := range chunks {
+		if len(sample) == autoCompressionSampleSize {
+			break
+		}
+
+		n := min(len(chunk.NewData), autoCompressionSampleSize-len(sample))
+		sample = append(sample, chunk.NewData[:n]...)
+	}
+
+	// Chunks without data, such as deletions, leave the choice to the next ones
+	if len(sample) == 0 {
+		return
+	}
+
+	target := e.config.AutoCompressionMBps
+	if target <= 0 {
+		target = defaultAutoCompressionMBps
+	}
+
+	level, smallest := autoCompressionLevels[0], -1
+	for _, candidate := range autoCompressionLevels {
+		start := time.Now()
+		size := len(compressData(sample, true, candidate))
+		elapsed := time.Since(start)
+
+		mbps := float64(len(sample)) / (1024 * 1024) / max(elapsed.Seconds(), 1e-9)
+		e.logger.Log("Compression level %d: %d of %d bytes at %.1f MB/s", candidate, size, len(sample), mbps)
+
+		if mbps >= target && (smallest < 0 || size < smallest) {
+			level, smallest = candidate, size
+		}
+	}
+
+	e.logger.Log("Chose compression level %d", level)
+
+	e.autoLevel = level
+	e.autoLevelTuned = true
+}
+
+// tunedCompressionLevel returns the level chosen by tuneCompressionLevel, and false
+// before one is chosen.
+func (e *DiffEngine) tunedCompressionLevel() (int, bool) {
+	e.autoLevelMu.Lock()
+	defer e.autoLevelMu.Unlock()
+
+	return e.autoLevel, e.autoLevelTuned
+}
@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAutoCompressionLevel(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles := make(map[string]string)
+	newFiles := make(map[string]string)
+
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		oldFiles[name] = "old\n"
+		newFiles[name] = strings.Repeat(fmt.Sprintf("line %d of a compressible file\n", i), 2000)
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	tests := []struct {
+		name string
+		mbps float64
+	}{
+		{name: "Unreachable target", mbps: 1e12},
+		{name: "Any throughput", mbps: 1e-9},
+		{name: "Default target", mbps: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.AutoCompressionLevel = true
+			config.AutoCompressionMBps = tt.mbps
+			config.Concurrency = 1
+
+			engine := newTestEngine(t, config)
+
+			var mu sync.Mutex
+			used := make(map[int]bool)
+
+			engine.compressor = func(data []byte, compress bool, level int) []byte {
+				mu.Lock()
+				defer mu.Unlock()
+
+				used[level] = true
+				return compressData(data, compress, level)
+			}
+
+			if _, _, err := engine.CompareDirs(oldDir, newDir); err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			level, ok := engine.tunedCompressionLevel()
+			if !ok {
+				t.Fatal("expected a compression level to be chosen")
+			}
+
+			if !validCompressionLevel(level) {
+				t.Errorf("chose invalid compression level %d", level)
+			}
+
+			// Every file is compressed with the chosen level
+			if len(used) != 1 || !used[level] {
+				t.Errorf("expected only level %d to be used, got %v", level, used)
+			}
+
+			switch tt.mbps {
+			case 1e12:
+				if level != gzip.BestSpeed {
+					t.Errorf("expected the fastest level when no level reaches the target, got %d", level)
+				}
+			case 1e-9:
+				// The level compressing the first file the most is chosen
+				sample := []byte(newFiles["file0.txt"])
+
+				smallest := len(compressData(sample, true, level))
+				for _, candidate := range autoCompressionLevels {
+					if size := len(compressData(sample, true, candidate)); size < smallest {
+						t.Errorf("level %d compresses to %d bytes, less than the %d bytes of the chosen level %d", candidate, size, smallest, level)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compressionLevelNames maps the symbolic compression levels accepted by LoadConfig to
+// the gzip constants.
+var compressionLevelNames = map[string]int{
+	"none":         gzip.NoCompression,
+	"fastest":      gzip.BestSpeed,
+	"default":      gzip.DefaultCompression,
+	"best":         gzip.BestCompression,
+	"huffman-only": gzip.HuffmanOnly,
+}
+
+// compressionLevel is a compression level of a configuration file, either a number or
+// one of the names of compressionLevelNames.
+type compressionLevel int
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *compressionLevel) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var level int
+		if err := json.Unmarshal(data, &level); err != nil {
+			return fmt.Errorf("invalid compression level %s", data)
+		}
+
+		*l = compressionLevel(level)
+		return nil
+	}
+
+	level, ok := compressionLevelNames[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown compression level %q", name)
+	}
+
+	*l = compressionLevel(level)
+	return nil
+}
+
+// configFile is the layout of the configuration files read by LoadConfig, which is the
+// one of Configuration except for the compression levels.
+type configFile struct {
+	*Configuration
+	CompressionLevel  compressionLevel
+	CompressionLevels map[string]compressionLevel
+}
+
+// LoadConfig reads a configuration from the JSON file at path, such as for command line
+// tools. Its keys are the names of the fields of Configuration, matched regardless of
+// case, and the fields it does not set keep the values of DefaultConfig. Compression
+// levels are either numbers or one of "none", "fastest", "default", "best" and
+// "huffman-only". Durations are in nanoseconds, and the fields holding functions or
+// interfaces cannot be set from a file. The configuration is validated before being
+// returned.
+func LoadConfig(path string) (*Configuration, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("%s: YAML configuration files are not supported", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	file := configFile{Configuration: config, CompressionLevel: compressionLevel(config.CompressionLevel)}
+
+	// Unknown keys are rejected so that misspelled options are not silently ignored
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config.CompressionLevel = int(file.CompressionLevel)
+
+	if file.CompressionLevels != nil {
+		config.CompressionLevels = make(map[string]int, len(file.CompressionLevels))
+		for ext, level := range file.CompressionLevels {
+			config.CompressionLevels[ext] = int(level)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	data := `{
+		"Concurrency": 8,
+		"compressionLevel": "fastest",
+		"CompressionLevels": {".log": "none", ".txt": 6},
+		"IgnorePatterns": ["*.tmp"],
+		"BackupFiles": false
+	}`
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig returned an error: %v", err)
+	}
+
+	want := DefaultConfig()
+	want.Concurrency = 8
+	want.CompressionLevel = gzip.BestSpeed
+	want.CompressionLevels = map[string]int{".log": gzip.NoCompression, ".txt": 6}
+	want.IgnorePatterns = []string{"*.tmp"}
+	want.BackupFiles = false
+
+	if diff := cmp.Diff(want, config); diff != "" {
+		t.Errorf("LoadConfig() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{name: "Unknown level name", file: "config.json", data: `{"CompressionLevel": "fast"}`},
+		{name: "Invalid level", file: "config.json", data: `{"CompressionLevel": 42}`},
+		{name: "Unknown field", file: "config.json", data: `{"Concurency": 8}`},
+		{name: "Malformed", file: "config.json", data: `{"Concurrency": }`},
+		{name: "YAML", file: "config.yaml", data: "concurrency: 8\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := LoadConfig(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
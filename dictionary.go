@@ -0,0 +1,34 @@
+package diff
+
+import "fmt"
+
+// CompareWithDictionary expresses new as copies of blocks of dict, with OpCopy chunks,
+// and literal inserts of the bytes not found there, like a zstd dictionary shared by
+// many similar files. Patch(dict, chunks) reconstructs new from the same dictionary:
+// the chunks insert the content of new before the dictionary, then delete it.
+func (h *GenericBinaryHandler) CompareWithDictionary(dict, new []byte) ([]DiffChunk, error) {
+	if h.MinMatchLength <= 0 {
+		return nil, fmt.Errorf("invalid MinMatchLength %d", h.MinMatchLength)
+	}
+
+	var table map[uint32][]int64
+	if len(dict) >= h.MinMatchLength {
+		table = h.hashBlocks(dict)
+	}
+
+	chunks := make([]DiffChunk, 0)
+	if len(new) > 0 {
+		chunks = h.gapChunks(dict, new, 0, 0, 0, int64(len(new)), table)
+	}
+
+	if len(dict) > 0 {
+		chunks = append(chunks, DiffChunk{
+			Offset:    0,
+			OldLength: int64(len(dict)),
+			ChunkType: "binary",
+			Op:        OpDelete,
+		})
+	}
+
+	return chunks, nil
+}
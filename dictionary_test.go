@@ -0,0 +1,104 @@
+package diff
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCompareWithDictionary(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// The files share most of their records with the dictionary, in a different order
+	records := make([][]byte, 64)
+	for i := range records {
+		records[i] = make([]byte, 256)
+		rng.Read(records[i])
+	}
+
+	dict := bytes.Join(records, nil)
+
+	files := make([][]byte, 5)
+	for i := range files {
+		var buf bytes.Buffer
+		for _, j := range rng.Perm(len(records))[:48] {
+			buf.Write(records[j])
+			fmt.Fprintf(&buf, "file %d record %d\n", i, j)
+		}
+
+		files[i] = buf.Bytes()
+	}
+
+	handler := NewGenericBinaryHandler()
+
+	var withDict, independent int
+	for i, file := range files {
+		chunks, err := handler.CompareWithDictionary(dict, file)
+		if err != nil {
+			t.Fatalf("CompareWithDictionary returned an error: %v", err)
+		}
+
+		patched, err := handler.Patch(dict, chunks)
+		if err != nil {
+			t.Fatalf("Patch returned an error: %v", err)
+		}
+
+		if !bytes.Equal(patched, file) {
+			t.Fatalf("file %d: patched data does not match", i)
+		}
+
+		var encoded bytes.Buffer
+		if err := EncodeChunks(&encoded, chunks); err != nil {
+			t.Fatalf("EncodeChunks returned an error: %v", err)
+		}
+
+		withDict += encoded.Len()
+
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(file)
+		zw.Close()
+
+		independent += compressed.Len()
+	}
+
+	if withDict*4 > independent {
+		t.Errorf("expected dictionary diffs under a quarter of independent storage, got %d vs %d bytes", withDict, independent)
+	}
+}
+
+func TestCompareWithDictionary_Edges(t *testing.T) {
+	handler := NewGenericBinaryHandler()
+	dict := []byte("a dictionary of shared content")
+
+	tests := []struct {
+		name string
+		dict []byte
+		new  []byte
+	}{
+		{name: "empty dictionary", dict: nil, new: []byte("new content")},
+		{name: "empty new", dict: dict, new: nil},
+		{name: "equal", dict: dict, new: dict},
+		{name: "short dictionary", dict: []byte("abc"), new: []byte("abcdef")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := handler.CompareWithDictionary(tt.dict, tt.new)
+			if err != nil {
+				t.Fatalf("CompareWithDictionary returned an error: %v", err)
+			}
+
+			patched, err := handler.Patch(tt.dict, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("expected %q, got %q", tt.new, patched)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package diff
+
+// Edit operation types
+const (
+	EditEqual  = "equal"
+	EditInsert = "insert"
+	EditDelete = "delete"
+)
+
+// EditOp is an operation of an edit script.
+type EditOp struct {
+	Type  string // EditEqual, EditInsert or EditDelete
+	Bytes []byte
+}
+
+// EditScript returns the edit script transforming old into new, derived from the
+// matches of the binary handler. The operations cover both inputs in order:
+// concatenating the bytes of the equal and delete operations gives old, and
+// concatenating the bytes of the equal and insert operations gives new.
+func EditScript(old, new []byte) []EditOp {
+	h := NewGenericBinaryHandler()
+
+	ops := make([]EditOp, 0)
+	add := func(opType string, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		// Consecutive operations of the same type are reported as one
+		if n := len(ops); n > 0 && ops[n-1].Type == opType {
+			ops[n-1].Bytes = append(ops[n-1].Bytes, data...)
+			return
+		}
+
+		ops = append(ops, EditOp{Type: opType, Bytes: append([]byte(nil), data...)})
+	}
+
+	var lastOldEnd, lastNewEnd int64
+
+	for _, match := range h.monotonicMatches(h.scanMatches(old, new)) {
+		add(EditDelete, old[lastOldEnd:match.OldOffset])
+		add(EditInsert, new[lastNewEnd:match.NewOffset])
+		add(EditEqual, new[match.NewOffset:match.NewOffset+match.Length])
+
+		lastOldEnd = match.OldOffset + match.Length
+		lastNewEnd = match.NewOffset + match.Length
+	}
+
+	add(EditDelete, old[lastOldEnd:])
+	add(EditInsert, new[lastNewEnd:])
+
+	return ops
+}
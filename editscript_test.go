@@ -0,0 +1,70 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEditScript(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	base := make([]byte, 4096)
+	rng.Read(base)
+
+	edited := append([]byte(nil), base[:1000]...)
+	edited = append(edited, []byte("inserted bytes")...)
+	edited = append(edited, base[1000:2500]...)
+	edited = append(edited, base[2600:]...)
+	edited[3000] ^= 0xff
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+	}{
+		{name: "Both empty", old: nil, new: nil},
+		{name: "Old empty", old: nil, new: []byte("new content")},
+		{name: "New empty", old: []byte("old content"), new: nil},
+		{name: "Identical", old: base, new: base},
+		{name: "Completely different", old: []byte("abcdef"), new: []byte("uvwxyz")},
+		{name: "Insert, delete and replace", old: base, new: edited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := EditScript(tt.old, tt.new)
+
+			var gotOld, gotNew []byte
+			for _, op := range ops {
+				switch op.Type {
+				case EditEqual:
+					gotOld = append(gotOld, op.Bytes...)
+					gotNew = append(gotNew, op.Bytes...)
+				case EditDelete:
+					gotOld = append(gotOld, op.Bytes...)
+				case EditInsert:
+					gotNew = append(gotNew, op.Bytes...)
+				default:
+					t.Fatalf("unexpected operation type %q", op.Type)
+				}
+
+				if len(op.Bytes) == 0 {
+					t.Errorf("unexpected empty %s operation", op.Type)
+				}
+			}
+
+			if !bytes.Equal(gotOld, tt.old) {
+				t.Errorf("equal and delete operations do not reconstruct old")
+			}
+
+			if !bytes.Equal(gotNew, tt.new) {
+				t.Errorf("equal and insert operations do not reconstruct new")
+			}
+		})
+	}
+
+	if ops := EditScript(base, edited); ops[0].Type != EditEqual || len(ops[0].Bytes) != 1000 {
+		t.Errorf("expected the edit script to start with an equal operation of 1000 bytes, got %s of %d", ops[0].Type, len(ops[0].Bytes))
+	}
+}
@@ -2,19 +2,39 @@ package diff
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // DiffEnging is the entrypoint for the diff package.
 type DiffEngine struct {
 	handlers       map[string]FileHandler // File extension to handler mapping
+	overrides      []typeOverride         // Configuration.TypeOverrides in the order they are tried
 	defaultHandler FileHandler
 	config         *Configuration
 	logger         *Logger
+	fileSystem     FileSystem
+	hashCache      *hashCache
+	remoteVersions map[string]remoteVersion // Last version of each URL compared by CompareURL
+	readSlots      chan struct{}            // Bounds the files read at once to IOConcurrency, nil when unbounded
+	compareSlots   chan struct{}            // Bounds the comparisons run at once to Concurrency when reads are bounded
+	autoLevel      int                      // Compression level chosen with AutoCompressionLevel, once autoLevelTuned
+	autoLevelTuned bool
+	autoLevelMu    sync.Mutex
+	compressor     func(data []byte, compress bool, level int) []byte // Compresses chunk data, compressData unless replaced in tests
 	mu             sync.RWMutex
 }
 
@@ -24,21 +44,42 @@ func NewDiffEngine(config *Configuration) (*DiffEngine, error) {
 		config = DefaultConfig()
 	}
 
-	logger, err := NewLogger(config.DetailedLogging, "diff.log")
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	logger, err := NewBufferedLogger(config.DetailedLogging, "diff.log", config.LogBufferSize, config.LogFlushInterval)
 	if err != nil {
 		return nil, err
 	}
 
 	engine := &DiffEngine{
-		handlers: make(map[string]FileHandler),
-		config:   config,
-		logger:   logger,
+		handlers:   make(map[string]FileHandler),
+		config:     config,
+		logger:     logger,
+		fileSystem: OSFileSystem{},
+		compressor: compressData,
+		overrides:  sortedOverrides(config.TypeOverrides),
+	}
+
+	// Reads and comparisons are bounded separately only when IOConcurrency is set,
+	// otherwise the workers of CompareDirs bound both
+	if config.IOConcurrency > 0 {
+		engine.readSlots = make(chan struct{}, config.IOConcurrency)
+		engine.compareSlots = make(chan struct{}, max(config.Concurrency, 1))
 	}
 
 	engine.initializeHandlers()
 	return engine, nil
 }
 
+// Close flushes the messages buffered with Configuration.LogBufferSize to the log file and
+// closes it, stopping its periodic flushes. The engine should be closed once it is no
+// longer used; messages logged afterwards are lost.
+func (e *DiffEngine) Close() error {
+	return e.logger.Close()
+}
+
 // initializeHandlers initializes the default handlers.
 // Note: For now we only have a generic binary handler and a text file handler.
 // TODO: Add more handlers for different file types.
@@ -48,31 +89,266 @@ func (e *DiffEngine) initializeHandlers() {
 	e.RegisterHandler(".txt", &TextFileHandler{})
 	e.RegisterHandler(".log", &TextFileHandler{})
 	e.RegisterHandler(".md", &TextFileHandler{})
+	e.RegisterHandler(".db", &SQLiteHandler{})
+	e.RegisterHandler(".sqlite", &SQLiteHandler{})
 }
 
 // RegisterHandler registers a new file handler for a specific file extension.
 // This can be used to add custom handlers for different file types.
 func (e *DiffEngine) RegisterHandler(ext string, handler FileHandler) {
+	e.RegisterHandlerReturningPrev(ext, handler)
+}
+
+// RegisterHandlerExts registers a file handler for several file extensions at once, such
+// as .yaml and .yml, replacing the handlers registered for them.
+func (e *DiffEngine) RegisterHandlerExts(exts []string, handler FileHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ext := range exts {
+		e.handlers[ext] = handler
+	}
+}
+
+// RegisterDefaultHandler replaces the handler used for files without a registered handler,
+// the generic binary handler by default. The handler must not be nil.
+func (e *DiffEngine) RegisterDefaultHandler(handler FileHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.defaultHandler = handler
+}
+
+// RegisterHandlerReturningPrev registers a new file handler for a specific file extension
+// and returns the handler previously registered for it, or nil.
+// This allows wrapping an existing handler with one that delegates to it.
+func (e *DiffEngine) RegisterHandlerReturningPrev(ext string, handler FileHandler) FileHandler {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	prev := e.handlers[ext]
 	e.handlers[ext] = handler
+
+	return prev
+}
+
+// getHandler returns the file handler for a file from its slash-separated path relative
+// to the root of its tree, by its extension. Type overrides matching the path take
+// precedence over the extension.
+func (e *DiffEngine) getHandler(relPath string) FileHandler {
+	handler, _ := e.lookupHandler(relPath)
+	return handler
 }
 
-// getHandler returns the file handler for a specific file extension.
-func (e *DiffEngine) getHandler(filename string) FileHandler {
+// lookupHandler returns the handler of a file like getHandler, along with an error wrapping
+// ErrUnsupportedType when TypeOverrides assigns the file a type without a handler.
+func (e *DiffEngine) lookupHandler(relPath string) (FileHandler, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	ext := strings.ToLower(filepath.Ext(filename))
+	handler, err := e.overrideHandler(relPath)
+	if handler != nil {
+		return handler, nil
+	}
+
+	ext := strings.ToLower(path.Ext(relPath))
 	if handler, ok := e.handlers[ext]; ok {
-		return handler
+		return handler, err
+	}
+	return e.defaultHandler, err
+}
+
+// typeOverride is an entry of Configuration.TypeOverrides.
+type typeOverride struct {
+	pattern  string
+	fileType string
+}
+
+// sortedOverrides returns the entries of overrides sorted by pattern, the order in which
+// they are tried.
+func sortedOverrides(overrides map[string]string) []typeOverride {
+	sorted := make([]typeOverride, 0, len(overrides))
+	for pattern, fileType := range overrides {
+		sorted = append(sorted, typeOverride{pattern: pattern, fileType: fileType})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].pattern < sorted[j].pattern })
+
+	return sorted
+}
+
+// overrideHandler returns the handler of the type the configuration's TypeOverrides assign
+// to the file, or nil if there is none. Patterns are tried in sorted order. When only
+// types without a handler are assigned, it returns an error wrapping ErrUnsupportedType.
+// The caller must hold the read lock.
+func (e *DiffEngine) overrideHandler(relPath string) (FileHandler, error) {
+	var err error
+
+	for _, override := range e.overrides {
+		if !matchPathSuffix(override.pattern, relPath) {
+			continue
+		}
+
+		if handler := e.handlerOfType(override.fileType); handler != nil {
+			return handler, nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("%w: %s for override %s", ErrUnsupportedType, override.fileType, override.pattern)
+		}
+	}
+
+	return nil, err
+}
+
+// handlerOfType returns the default handler if it is of the given type, or else the
+// handler of the type registered for the smallest extension, or nil if there is none.
+// The caller must hold the read lock.
+func (e *DiffEngine) handlerOfType(fileType string) FileHandler {
+	if e.defaultHandler.GetFileType() == fileType {
+		return e.defaultHandler
+	}
+
+	var handler FileHandler
+	var handlerExt string
+
+	for ext, h := range e.handlers {
+		if h.GetFileType() == fileType && (handler == nil || ext < handlerExt) {
+			handler, handlerExt = h, ext
+		}
 	}
+
+	return handler
+}
+
+// getDefaultHandler returns the handler used for files without a registered handler.
+func (e *DiffEngine) getDefaultHandler() FileHandler {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	return e.defaultHandler
 }
 
+// SetFileSystem sets the FileSystem through which the engine reads files.
+// By default the engine reads from the local disk.
+func (e *DiffEngine) SetFileSystem(fsys FileSystem) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.fileSystem = fsys
+}
+
+// getFileSystem returns the FileSystem through which the engine reads files.
+func (e *DiffEngine) getFileSystem() FileSystem {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.fileSystem
+}
+
+// EnableHashCache enables caching of file hashes across comparisons, holding at most
+// maxEntries hashes. A cached hash is reused while the file's size and modification
+// time are unchanged, so unchanged files are not re-read. A maxEntries of zero or
+// less disables the cache.
+func (e *DiffEngine) EnableHashCache(maxEntries int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if maxEntries <= 0 {
+		e.hashCache = nil
+		return
+	}
+
+	e.hashCache = newHashCache(maxEntries)
+}
+
+// ClearHashCache removes all cached file hashes.
+func (e *DiffEngine) ClearHashCache() {
+	e.getHashCache().clear()
+}
+
+// getHashCache returns the hash cache, or nil when caching is disabled.
+func (e *DiffEngine) getHashCache() *hashCache {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.hashCache
+}
+
 // CompareDirs compares two directories and returns differences
 func (e *DiffEngine) CompareDirs(oldDir, newDir string) (*DiffSummary, []DiffResult, error) {
+	fsys := e.getFileSystem()
+
+	return e.compareTrees(fsys, fsys, []string{oldDir}, newDir)
+}
+
+// CompareGlob compares only the files of two directories whose slash-separated path
+// relative to the directory matches pattern, such as **/*.go, and reports the matching
+// files of oldDir missing from newDir as deleted. A ** element of the pattern matches
+// any number of directories.
+func (e *DiffEngine) CompareGlob(oldDir, newDir, pattern string) ([]DiffResult, error) {
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	match := func(relPath string) bool {
+		return matchGlob(pattern, relPath)
+	}
+
+	fsys := e.getFileSystem()
+
+	_, results, err := e.compareTreesWith(fsys, fsys, []string{oldDir}, newDir, e.compareFiles, match, nil)
+
+	return results, err
+}
+
+// CompareFS compares the whole trees of two fs.FS values, such as embed.FS build artifacts
+// or zip archives, without extracting them. Paths of the results are relative to the
+// roots of the trees. The engine's FileSystem is not used.
+func (e *DiffEngine) CompareFS(oldFS, newFS fs.FS) (*DiffSummary, []DiffResult, error) {
+	return e.compareTrees(FromFS(oldFS), FromFS(newFS), []string{"."}, ".")
+}
+
+// CompareDirsLayered compares newDir against several old directories layered on top of
+// each other, like the layers of a container image. Each new file is compared with its
+// counterpart in the first old directory containing one, and is added when none does.
+// Files of any old directory missing from newDir are deleted.
+func (e *DiffEngine) CompareDirsLayered(newDir string, oldDirs []string) (*DiffSummary, []DiffResult, error) {
+	if len(oldDirs) == 0 {
+		return nil, nil, errors.New("no old directories to compare with")
+	}
+
+	fsys := e.getFileSystem()
+
+	return e.compareTrees(fsys, fsys, oldDirs, newDir)
+}
+
+// compareTrees compares the directories oldDirs of oldFS, layered in order, with the directory newDir of newFS.
+func (e *DiffEngine) compareTrees(oldFS, newFS FileSystem, oldDirs []string, newDir string) (*DiffSummary, []DiffResult, error) {
+	return e.compareTreesWith(oldFS, newFS, oldDirs, newDir, e.compareFiles, nil, nil)
+}
+
+// compareJob is a file of the new tree queued for comparison by the workers of compareTreesWith.
+type compareJob struct {
+	path       string
+	relPath    string
+	oldRelPath string
+	info       os.FileInfo
+}
+
+// fileComparer compares a file of the new tree with its counterpart of the old tree,
+// returning nil when it is unchanged.
+type fileComparer func(oldFS, newFS FileSystem, oldPath, newPath, relPath string, newInfo os.FileInfo) (*DiffResult, error)
+
+// compareTreesWith walks the trees like compareTrees, comparing the files with compare.
+// When match is not nil, only the files whose slash-separated relative path it matches
+// are compared or reported as deleted. When emit is not nil, it is called with each
+// result as soon as it is found, one call at a time, before DedupContent applies.
+func (e *DiffEngine) compareTreesWith(oldFS, newFS FileSystem, oldDirs []string, newDir string, compare fileComparer, match func(relPath string) bool, emit func(result DiffResult)) (*DiffSummary, []DiffResult, error) {
+	if emit == nil {
+		emit = func(DiffResult) {}
+	}
+
 	summary := &DiffSummary{
 		FileTypes: make(map[string]int),
 		StartTime: time.Now(),
@@ -82,181 +358,1299 @@ func (e *DiffEngine) CompareDirs(oldDir, newDir string) (*DiffSummary, []DiffRes
 	var mutex sync.Mutex
 	var wg sync.WaitGroup
 
-	semaphore := make(chan struct{}, e.config.Concurrency)
+	// mapped holds the old tree paths claimed by PathMap, used for deletion detection.
+	mapped := make(map[string]bool)
 
-	// Process new and modified files
-	err := filepath.Walk(newDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	// present holds the relative paths found by the walk of the new tree, used for deletion
+	// detection instead of a stat per old file. It is complete unless the walk was cut
+	// short or could not read a directory.
+	present := make(map[string]bool)
+	presentComplete := true
+
+	// links maps the files of the new tree with several hard links to the relative path
+	// of the first of their links found by the walk, when PreserveLinks is set.
+	links := make(map[fileKey]string)
+
+	// dirs holds the directories found by the walk of the new tree, to report the files of
+	// the old tree they replaced as type changes.
+	dirs := make(map[string]os.FileInfo)
+
+	// oldPaths maps the lowercased relative paths of the old trees to their actual case,
+	// when CaseInsensitivePaths is set.
+	var oldPaths map[string]string
+	if e.config.CaseInsensitivePaths {
+		oldPaths = e.caseInsensitivePaths(oldFS, oldDirs)
+	}
+
+	// processedBytes is the size of the new files compared so far by the workers
+	var processedBytes atomic.Int64
+
+	// With FailFast, the first comparison error cancels ctx, which stops the walk and the
+	// comparison of the files already queued
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failure error
+
+	// resumed holds the relative paths processed by the interrupted comparison resumed, if any
+	var resumed map[string]bool
+	var cp *checkpoint
+
+	if e.config.ResumeFrom != "" {
+		var err error
+		if cp, err = loadCheckpoint(e.config.ResumeFrom); err != nil {
+			return nil, nil, err
 		}
 
-		if info.IsDir() {
-			return nil
+		resumed = make(map[string]bool, len(cp.Processed))
+		for _, relPath := range cp.Processed {
+			resumed[relPath] = true
 		}
 
-		// Check file size limit
-		if info.Size() > e.config.MaxFileSizeBytes {
-			e.logger.Log("Skipping large file: %s (size: %d bytes)", path, info.Size())
-			return nil
+		summary = &cp.Summary
+		results = cp.Results
+		processedBytes.Store(cp.Summary.ProcessedBytes)
+
+		for _, result := range results {
+			emit(result)
 		}
+	}
 
-		relPath, err := filepath.Rel(newDir, path)
-		if err != nil {
-			return err
+	stopPublishing := e.publishSummaries(summary, &mutex)
+	defer stopPublishing()
+
+	checkpoints := newCheckpointer(e.logger, e.config.CheckpointFile, e.config.CheckpointInterval, cp)
+
+	var totalBytes int64
+	if e.config.OnProgress != nil {
+		totalBytes = e.estimateTreeBytes(newFS, newDir)
+	}
+
+	// skipDenied reports an inaccessible file or directory found by a walk and skips it.
+	skipDenied := func(root, path string, info os.FileInfo, err error) error {
+		e.logger.Log("Permission denied: %s", path)
+
+		relPath, _ := filepath.Rel(root, path)
+
+		mutex.Lock()
+		summary.Errors = append(summary.Errors, newFileError(relPath, err))
+		mutex.Unlock()
+
+		e.observe(relPath, nil, err, time.Now())
+
+		if info != nil && info.IsDir() {
+			return filepath.SkipDir
 		}
 
-		// Check ignore patterns
-		for _, pattern := range e.config.IgnorePatterns {
-			if matched, _ := filepath.Match(pattern, relPath); matched {
-				return nil
+		return nil
+	}
+
+	// ignore holds the rules of the ignore files found in the new tree
+	var ignore *ignoreMatcher
+	if e.config.IgnoreFile != "" {
+		ignore = &ignoreMatcher{}
+	}
+
+	// process compares a file queued by the walk of the new tree
+	process := func(job compareJob) {
+		start := time.Now()
+
+		oldPath := resolveOldPath(oldFS, oldDirs, job.oldRelPath)
+		result, err := compare(oldFS, newFS, oldPath, job.path, filepath.ToSlash(job.relPath), job.info)
+
+		processed := processedBytes.Add(job.info.Size())
+		if e.config.OnProgress != nil {
+			e.config.OnProgress(Progress{ProcessedBytes: processed, TotalBytes: totalBytes})
+		}
+
+		if err != nil {
+			e.logger.Log("Error comparing files %s: %v", job.relPath, err)
+			e.observe(job.relPath, nil, err, start)
+
+			mutex.Lock()
+			summary.Errors = append(summary.Errors, newFileError(job.relPath, err))
+
+			if e.config.FailFast && failure == nil {
+				failure = newFileError(job.relPath, err)
+				cancel()
 			}
+
+			mutex.Unlock()
+
+			return
 		}
 
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire semaphore
+		if result != nil {
+			result.RelPath = filepath.ToSlash(job.relPath)
+			e.observe(job.relPath, result, nil, start)
+		}
 
-		go func(path, relPath string, info os.FileInfo) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // Release semaphore
+		mutex.Lock()
 
-			oldPath := filepath.Join(oldDir, relPath)
-			result, err := e.compareFiles(oldPath, path, info)
-			if err != nil {
-				e.logger.Log("Error comparing files %s: %v", relPath, err)
-				return
+		if result != nil {
+			results = append(results, *result)
+			emit(*result)
+			summary.TotalFiles++
+
+			switch result.Operation {
+			case "added":
+				summary.AddedFiles++
+			case "modified":
+				summary.ModifiedFiles++
+			case "rewritten":
+				summary.RewrittenFiles++
+			case "unchanged":
+				summary.UnchangedFiles++
+			case "typechange":
+				summary.TypeChangedFiles++
+			case "chmod":
+				summary.ChmodFiles++
 			}
 
-			if result != nil {
-				mutex.Lock()
-				results = append(results, *result)
-				summary.TotalFiles++
+			summary.TotalSizeBytes += job.info.Size()
 
-				switch result.Operation {
-				case "added":
-					summary.AddedFiles++
-				case "modified":
-					summary.ModifiedFiles++
+			for _, chunk := range result.Chunks {
+				if chunk.Compressed {
+					summary.CompressedBytes += int64(len(chunk.NewData))
 				}
+			}
 
-				summary.TotalSizeBytes += info.Size()
+			summary.FileTypes[result.FileType]++
 
-				if result.IsCompressed {
-					summary.CompressedBytes += int64(len(result.Chunks[0].NewData))
-				}
+			summary.PatchBytes += patchBytes(result.Chunks)
+			if e.config.MaxTotalPatchBytes > 0 && summary.PatchBytes > e.config.MaxTotalPatchBytes && !summary.Truncated {
+				e.logger.Log("Patch size limit of %d bytes exceeded, stopping comparison", e.config.MaxTotalPatchBytes)
+				summary.Truncated = true
+			}
+		}
 
-				summary.FileTypes[result.FileType]++
-				mutex.Unlock()
+		checkpoints.done(job.relPath, job.info.Size(), summary, results)
+		mutex.Unlock()
+
+		// The checkpoint is saved without blocking the other workers
+		checkpoints.flush()
+	}
+
+	// A fixed pool of workers consumes the files queued by the walk, enough for both the
+	// reads and the comparisons to run at their own concurrency
+	jobs := make(chan compareJob)
+	for i := 0; i < max(e.config.Concurrency, e.config.IOConcurrency, 1); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				// Queued files are drained without being compared once cancelled
+				if ctx.Err() == nil {
+					process(job)
+				}
 			}
-		}(path, relPath, info)
+		}()
+	}
 
-		return nil
-	})
+	// mapPath returns the path in the old trees of the file at relPath in the new tree
+	mapPath := func(relPath string) string {
+		oldRelPath := relPath
+		if e.config.PathMap != nil {
+			oldRelPath = e.config.PathMap(relPath)
+		}
 
-	if err != nil {
-		return nil, nil, err
+		if actual, ok := oldPaths[strings.ToLower(oldRelPath)]; ok {
+			oldRelPath = actual
+		}
+
+		return oldRelPath
 	}
 
-	wg.Wait()
+	// Process new and modified files
+	err := newFS.Walk(newDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsPermission(err) {
+			presentComplete = false
+			return skipDenied(newDir, path, info, err)
+		}
+
+		if err != nil {
+			return err
+		}
 
-	// Check for deleted files
-	err = filepath.Walk(oldDir, func(path string, info os.FileInfo, err error) error {
+		relPath, err := filepath.Rel(newDir, path)
 		if err != nil {
 			return err
 		}
 
+		present[e.pathKey(relPath)] = true
+
+		if e.config.SkipHidden && isHidden(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
 		if info.IsDir() {
+			if e.tooDeep(relPath) {
+				e.logger.Log("Skipping directory beyond the maximum depth: %s", path)
+				return filepath.SkipDir
+			}
+
+			if ignore != nil {
+				if relPath != "." && ignore.ignored(relPath, true) {
+					return filepath.SkipDir
+				}
+
+				e.loadIgnoreFile(newFS, path, relPath, ignore)
+			}
+
+			dirs[e.pathKey(relPath)] = info
+
+			if e.config.PreserveLinks && relPath != "." && !resumed[relPath] {
+				oldRelPath := relPath
+				if actual, ok := oldPaths[strings.ToLower(relPath)]; ok {
+					oldRelPath = actual
+				}
+
+				if result := e.emptyDirResult(oldFS, newFS, oldDirs, oldRelPath, path, relPath, info); result != nil {
+					e.observe(relPath, result, nil, time.Now())
+
+					mutex.Lock()
+					results = append(results, *result)
+					emit(*result)
+					summary.TotalFiles++
+					summary.AddedFiles++
+					checkpoints.done(relPath, 0, summary, results)
+					mutex.Unlock()
+
+					checkpoints.flush()
+				}
+			}
+
 			return nil
 		}
 
-		relPath, err := filepath.Rel(oldDir, path)
-		if err != nil {
-			return err
+		if match != nil && !match(filepath.ToSlash(relPath)) {
+			return nil
 		}
 
-		newPath := filepath.Join(newDir, relPath)
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
-			summary.DeletedFiles++
-			summary.TotalFiles++
-			results = append(results, DiffResult{
-				Path:      relPath,
-				Operation: "deleted",
-				OldHash:   calculateHash(path),
-				ModTime:   info.ModTime(),
-				Size:      info.Size(),
-			})
+		// Reading special files such as named pipes may block forever
+		if !isRegularFile(newFS, path, info) {
+			e.logger.Log("Skipping non-regular file: %s (mode: %s)", path, info.Mode())
+			return nil
 		}
 
-		return nil
-	})
+		// The mapping is recorded before any skip so a skipped file is not reported as deleted
+		oldRelPath := mapPath(relPath)
+		if e.config.PathMap != nil {
+			mapped[oldRelPath] = true
+		}
 
-	summary.EndTime = time.Now()
-	return summary, results, err
-}
+		// Stop queuing comparisons once the patch size limit is exceeded
+		mutex.Lock()
+		truncated := summary.Truncated
+		mutex.Unlock()
 
-// compareFiles compares two files and returns the difference
-func (e *DiffEngine) compareFiles(oldPath, newPath string, newInfo os.FileInfo) (*DiffResult, error) {
-	oldData, err := os.ReadFile(oldPath)
-	if os.IsNotExist(err) {
-		newData, err := os.ReadFile(newPath)
-		if err != nil {
-			return nil, err
+		if truncated {
+			presentComplete = false
+			return filepath.SkipAll
 		}
 
-		return &DiffResult{
-			Path:         filepath.Base(newPath),
-			Operation:    "added",
-			NewHash:      calculateHash(newPath),
-			FileType:     e.getHandler(newPath).GetFileType(),
-			Size:         newInfo.Size(),
-			ModTime:      newInfo.ModTime(),
-			Permissions:  newInfo.Mode(),
-			IsCompressed: e.config.CompressPatches,
-			Chunks: []DiffChunk{{
-				Offset:    0,
-				NewData:   compressData(newData, e.config.CompressPatches, e.config.CompressionLevel),
-				ChunkType: e.getHandler(newPath).GetFileType(),
-			}},
-		}, nil
-	} else if err != nil {
-		return nil, err
-	}
-
-	newData, err := os.ReadFile(newPath)
-	if err != nil {
-		return nil, err
-	}
+		if e.skipFile(path, relPath, info, ignore) {
+			return nil
+		}
 
-	if bytes.Equal(oldData, newData) {
-		return nil, nil
-	}
+		// Skip files not modified since the cutoff, they still exist so aren't deleted either
+		if !e.config.ModifiedSince.IsZero() && !info.ModTime().After(e.config.ModifiedSince) {
+			mutex.Lock()
+			summary.SkippedOlderFiles++
+			mutex.Unlock()
 
-	handler := e.getHandler(newPath)
-	chunks, err := handler.Compare(oldData, newData)
-	if err != nil {
-		return nil, err
-	}
+			return nil
+		}
 
-	if len(chunks) == 0 {
-		return nil, nil
-	}
+		if e.config.TextOnly && e.getHandler(filepath.ToSlash(relPath)).GetFileType() != "text" {
+			mutex.Lock()
+			summary.SkippedBinary++
+			mutex.Unlock()
 
-	// Compress chunks if enabled
-	if e.config.CompressPatches {
-		for i := range chunks {
-			chunks[i].NewData = compressData(chunks[i].NewData, true, e.config.CompressionLevel)
+			return nil
 		}
-	}
 
-	return &DiffResult{
-		Path:         filepath.Base(newPath),
-		Operation:    "modified",
-		OldHash:      calculateHash(oldPath),
-		NewHash:      calculateHash(newPath),
-		Chunks:       chunks,
-		FileType:     handler.GetFileType(),
+		// Further links of a hard linked file are recorded as links rather than compared
+		if e.config.PreserveLinks {
+			if key, ok := hardLinkKey(info); ok {
+				if target, found := links[key]; found {
+					if resumed[relPath] {
+						return nil
+					}
+
+					result := linkResult(target, relPath, info)
+					e.observe(relPath, result, nil, time.Now())
+
+					mutex.Lock()
+					results = append(results, *result)
+					emit(*result)
+					summary.TotalFiles++
+					checkpoints.done(relPath, 0, summary, results)
+					mutex.Unlock()
+
+					checkpoints.flush()
+
+					return nil
+				}
+
+				links[key] = relPath
+			}
+		}
+
+		// Files compared before the interruption of a resumed comparison are not compared again
+		if resumed[relPath] {
+			return nil
+		}
+
+		select {
+		case jobs <- compareJob{path: path, relPath: relPath, oldRelPath: oldRelPath, info: info}:
+		case <-ctx.Done():
+			presentComplete = false
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+
+	close(jobs)
+	wg.Wait()
+
+	if failure != nil {
+		return nil, nil, failure
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The summary is still read by the summary publisher, if any
+	mutex.Lock()
+	summary.ProcessedBytes = processedBytes.Load()
+
+	if e.config.DedupContent {
+		dedupResults(results, summary)
+	}
+	mutex.Unlock()
+
+	// The files of the new tree the walk did not reach still claim their old paths
+	if e.config.PathMap != nil && !presentComplete {
+		e.mapRemaining(newFS, newDir, mapped, mapPath)
+	}
+
+	// Check for deleted files, a file of several layers is deleted once
+	seen := make(map[string]bool)
+
+	for _, oldDir := range oldDirs {
+		err = oldFS.Walk(oldDir, func(path string, info os.FileInfo, err error) error {
+			if os.IsPermission(err) {
+				return skipDenied(oldDir, path, info, err)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(oldDir, path)
+			if err != nil {
+				return err
+			}
+
+			if e.config.SkipHidden && isHidden(relPath) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if info.IsDir() {
+				if e.tooDeep(relPath) {
+					return filepath.SkipDir
+				}
+
+				if relPath != "." && ignore.ignored(relPath, true) {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if ignore.ignored(relPath, false) {
+				return nil
+			}
+
+			if match != nil && !match(filepath.ToSlash(relPath)) {
+				return nil
+			}
+
+			if !isRegularFile(oldFS, path, info) {
+				e.logger.Log("Skipping non-regular file: %s (mode: %s)", path, info.Mode())
+				return nil
+			}
+
+			if len(oldDirs) > 1 {
+				if seen[e.pathKey(relPath)] {
+					return nil
+				}
+
+				seen[e.pathKey(relPath)] = true
+			}
+
+			if e.config.PathMap != nil {
+				if mapped[relPath] {
+					return nil
+				}
+
+				// Directories which could not be read may hold the file unchanged
+				if !presentComplete {
+					if _, err := newFS.Stat(filepath.Join(newDir, relPath)); !os.IsNotExist(err) {
+						return nil
+					}
+				}
+			} else if present[e.pathKey(relPath)] {
+				// The files of a directory which replaced this file were compared as added
+				if dirInfo, ok := dirs[e.pathKey(relPath)]; ok {
+					result := typeChangeResult(path, dirInfo)
+					result.RelPath = filepath.ToSlash(relPath)
+
+					mutex.Lock()
+					summary.TypeChangedFiles++
+					summary.TotalFiles++
+					results = append(results, *result)
+					mutex.Unlock()
+
+					emit(*result)
+
+					e.observe(relPath, result, nil, time.Now())
+				}
+
+				return nil
+			} else if !presentComplete {
+				// Paths the walk did not reach may still exist
+				if _, err := newFS.Stat(filepath.Join(newDir, relPath)); !os.IsNotExist(err) {
+					return nil
+				}
+			}
+
+			if e.config.TextOnly && e.getHandler(filepath.ToSlash(relPath)).GetFileType() != "text" {
+				mutex.Lock()
+				summary.SkippedBinary++
+				mutex.Unlock()
+
+				return nil
+			}
+
+			// The content of files with a no-op handler is never read
+			var oldHash string
+			if !isNoOp(e.getHandler(filepath.ToSlash(relPath))) {
+				oldHash = e.calculateHash(oldFS, path)
+			}
+
+			mutex.Lock()
+			summary.DeletedFiles++
+			summary.TotalFiles++
+			results = append(results, DiffResult{
+				Path:      filepath.ToSlash(relPath),
+				RelPath:   filepath.ToSlash(relPath),
+				Operation: "deleted",
+				OldHash:   oldHash,
+				ModTime:   info.ModTime(),
+				Size:      info.Size(),
+			})
+			mutex.Unlock()
+
+			emit(results[len(results)-1])
+			e.observe(relPath, &results[len(results)-1], nil, time.Now())
+
+			return nil
+		})
+
+		if err != nil {
+			break
+		}
+	}
+
+	stopPublishing()
+	summary.EndTime = time.Now()
+
+	if err == nil {
+		checkpoints.remove()
+	}
+
+	e.publishFinalSummary(summary)
+
+	if e.config.Metrics != nil {
+		e.config.Metrics.ObserveSummary(summary)
+	}
+
+	return summary, results, err
+}
+
+// dedupResults replaces the chunks of the results with the same old and new content as
+// another result by a reference to it, and deducts their bytes from the summary. Results
+// are referenced by the smallest RelPath of their content so that the choice does not
+// depend on the order in which files were compared.
+func dedupResults(results []DiffResult, summary *DiffSummary) {
+	type content struct{ oldHash, newHash string }
+
+	canonical := make(map[content]int)
+
+	for i, result := range results {
+		if len(result.Chunks) == 0 || result.NewHash == "" {
+			continue
+		}
+
+		key := content{result.OldHash, result.NewHash}
+		if j, ok := canonical[key]; !ok || result.RelPath < results[j].RelPath {
+			canonical[key] = i
+		}
+	}
+
+	for i := range results {
+		result := &results[i]
+		if len(result.Chunks) == 0 || result.NewHash == "" {
+			continue
+		}
+
+		j := canonical[content{result.OldHash, result.NewHash}]
+		if j == i {
+			continue
+		}
+
+		summary.PatchBytes -= patchBytes(result.Chunks)
+		for _, chunk := range result.Chunks {
+			if chunk.Compressed {
+				summary.CompressedBytes -= int64(len(chunk.NewData))
+			}
+		}
+
+		result.SameAs = results[j].RelPath
+		result.Chunks = nil
+		result.IsCompressed = false
+	}
+}
+
+// loadIgnoreFile adds the rules of the ignore file of a directory, if it has one.
+func (e *DiffEngine) loadIgnoreFile(fsys FileSystem, dir, relDir string, ignore *ignoreMatcher) {
+	file, err := fsys.Open(filepath.Join(dir, e.config.IgnoreFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.logger.Log("Error opening ignore file in %s: %v", dir, err)
+		}
+
+		return
+	}
+
+	defer file.Close()
+
+	base := filepath.ToSlash(relDir)
+	if base == "." {
+		base = ""
+	}
+
+	rules, err := parseIgnore(file, base)
+	if err != nil {
+		e.logger.Log("Error reading ignore file in %s: %v", dir, err)
+		return
+	}
+
+	ignore.add(rules)
+}
+
+// resolveOldPath returns the path of the counterpart of a file in the first of the layered
+// old directories containing it, or in the first directory when none does.
+func resolveOldPath(fsys FileSystem, oldDirs []string, relPath string) string {
+	if len(oldDirs) > 1 {
+		for _, oldDir := range oldDirs {
+			path := filepath.Join(oldDir, relPath)
+			if _, err := fsys.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+
+	return filepath.Join(oldDirs[0], relPath)
+}
+
+// pathKey returns the key identifying a relative path in the comparison of the trees,
+// lowercased when Configuration.CaseInsensitivePaths is set.
+func (e *DiffEngine) pathKey(relPath string) string {
+	if e.config.CaseInsensitivePaths {
+		return strings.ToLower(relPath)
+	}
+
+	return relPath
+}
+
+// caseInsensitivePaths maps the lowercased relative paths of the old trees to their actual
+// case. A path of several layers maps to the path of the first layer containing it.
+func (e *DiffEngine) caseInsensitivePaths(fsys FileSystem, oldDirs []string) map[string]string {
+	paths := make(map[string]string)
+
+	for _, oldDir := range oldDirs {
+		fsys.Walk(oldDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			relPath, err := filepath.Rel(oldDir, path)
+			if err != nil {
+				return nil
+			}
+
+			if info.IsDir() && e.tooDeep(relPath) {
+				return filepath.SkipDir
+			}
+
+			key := strings.ToLower(relPath)
+			if _, ok := paths[key]; !ok {
+				paths[key] = relPath
+			}
+
+			return nil
+		})
+	}
+
+	return paths
+}
+
+// mapRemaining records in mapped the old paths, from mapPath, of the regular files of the
+// new tree at newDir, for the deletion detection of a walk cut short. Directories which
+// can't be read are skipped.
+func (e *DiffEngine) mapRemaining(newFS FileSystem, newDir string, mapped map[string]bool, mapPath func(relPath string) string) {
+	newFS.Walk(newDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if relPath, err := filepath.Rel(newDir, path); err == nil {
+			mapped[mapPath(relPath)] = true
+		}
+
+		return nil
+	})
+}
+
+// skipFile reports whether the file at path, relPath in its tree, is excluded by the size
+// limit, the ignore patterns and files, or the walk filter of the configuration.
+func (e *DiffEngine) skipFile(path, relPath string, info os.FileInfo, ignore *ignoreMatcher) bool {
+	if info.Size() > e.config.MaxFileSizeBytes {
+		e.logger.Log("Skipping large file: %s (size: %d bytes)", path, info.Size())
+		return true
+	}
+
+	for _, pattern := range e.config.IgnorePatterns {
+		if matched, _ := filepath.Match(pattern, relPath); matched {
+			return true
+		}
+	}
+
+	if ignore.ignored(relPath, false) {
+		return true
+	}
+
+	return e.config.WalkFilter != nil && !e.config.WalkFilter(path, info)
+}
+
+// fileKey identifies a file of the local disk independently of its paths.
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// linkResult returns the "linked" result of a new file which is a hard link to the file
+// target of the new tree. Links are reported even when the old files were already linked,
+// as applying copies the files of the old tree separately.
+func linkResult(target, relPath string, info os.FileInfo) *DiffResult {
+	return &DiffResult{
+		Path:        filepath.Base(relPath),
+		RelPath:     filepath.ToSlash(relPath),
+		Operation:   "linked",
+		LinkTo:      filepath.ToSlash(target),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Permissions: info.Mode().Perm(),
+	}
+}
+
+// typeChanged reports whether oldPath is another kind of file than newPath, a directory or
+// a symbolic link rather than a regular file or the reverse, when both file systems
+// implement Lstat. Missing old files are not type changes.
+func (e *DiffEngine) typeChanged(oldFS, newFS FileSystem, oldPath, newPath string, newInfo os.FileInfo) bool {
+	oldInfo, ok := lstat(oldFS, oldPath)
+	if !ok {
+		return false
+	}
+
+	// The given info may follow symbolic links, as for CompareFiles
+	if info, ok := lstat(newFS, newPath); ok {
+		newInfo = info
+	}
+
+	return fileKind(oldInfo) != fileKind(newInfo)
+}
+
+// typeChangeResult returns the "typechange" result of a path which is a different kind of
+// file than in the old tree, described by info. Its content is not compared.
+func typeChangeResult(path string, info os.FileInfo) *DiffResult {
+	return &DiffResult{
+		Path:        filepath.Base(path),
+		Operation:   "typechange",
+		IsDir:       info.IsDir(),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Permissions: info.Mode().Perm(),
+	}
+}
+
+// emptyDirResult returns the "added" result of an empty directory of the new tree missing
+// from the old tree, or nil when the directory has entries or already exists.
+func (e *DiffEngine) emptyDirResult(oldFS, newFS FileSystem, oldDirs []string, oldRelPath, path, relPath string, info os.FileInfo) *DiffResult {
+	entries, err := newFS.ReadDir(path)
+	if err != nil || len(entries) > 0 {
+		return nil
+	}
+
+	if _, err := oldFS.Stat(resolveOldPath(oldFS, oldDirs, oldRelPath)); !os.IsNotExist(err) {
+		return nil
+	}
+
+	return &DiffResult{
+		Path:        filepath.Base(relPath),
+		RelPath:     filepath.ToSlash(relPath),
+		Operation:   "added",
+		IsDir:       true,
+		ModTime:     info.ModTime(),
+		Permissions: info.Mode().Perm(),
+	}
+}
+
+// tooDeep reports whether the files of a directory are deeper than Configuration.MaxDepth.
+func (e *DiffEngine) tooDeep(relDir string) bool {
+	return e.config.MaxDepth > 0 && pathDepth(relDir) > e.config.MaxDepth
+}
+
+// estimateTreeBytes returns the total size of the files of a tree which may be compared,
+// an upper bound of the bytes processed by a comparison used to compute its progress.
+func (e *DiffEngine) estimateTreeBytes(fsys FileSystem, root string) int64 {
+	var total int64
+
+	fsys.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(root, path)
+		if e.config.SkipHidden && isHidden(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() && e.tooDeep(relPath) {
+			return filepath.SkipDir
+		}
+
+		if isRegularFile(fsys, path, info) && info.Size() <= e.config.MaxFileSizeBytes {
+			total += info.Size()
+		}
+
+		return nil
+	})
+
+	return total
+}
+
+// compareFiles compares two files and returns the difference, including that of their
+// permissions with Configuration.ReportPermissionChanges.
+func (e *DiffEngine) compareFiles(oldFS, newFS FileSystem, oldPath, newPath, relPath string, newInfo os.FileInfo) (*DiffResult, error) {
+	result, err := e.compareContent(oldFS, newFS, oldPath, newPath, relPath, newInfo)
+	if err != nil || !e.config.ReportPermissionChanges {
+		return result, err
+	}
+
+	return e.comparePermissions(oldFS, oldPath, relPath, newInfo, result), nil
+}
+
+// compareContent compares the content of two files and returns the difference
+func (e *DiffEngine) compareContent(oldFS, newFS FileSystem, oldPath, newPath, relPath string, newInfo os.FileInfo) (*DiffResult, error) {
+	if newInfo.Size() > e.config.MaxFileSizeBytes {
+		return nil, fmt.Errorf("%s: %w: %d bytes", newPath, ErrFileTooLarge, newInfo.Size())
+	}
+
+	handler, err := e.lookupHandler(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.typeChanged(oldFS, newFS, oldPath, newPath, newInfo) {
+		return typeChangeResult(newPath, newInfo), nil
+	}
+
+	if isNoOp(handler) {
+		return e.compareMetadata(oldFS, newFS, oldPath, newPath, relPath, newInfo)
+	}
+
+	if e.config.SparseFiles {
+		if result, ok, err := e.compareSparse(oldFS, newFS, oldPath, newPath, relPath, newInfo); ok {
+			return result, err
+		}
+	}
+
+	compress := e.shouldCompress(newPath)
+
+	// Files whose cached hashes are equal are unchanged and need not be read
+	var oldInfo os.FileInfo
+
+	cache := e.getHashCache()
+	if cache != nil {
+		oldInfo, _ = oldFS.Stat(oldPath)
+	}
+
+	if oldInfo != nil {
+		oldHash, oldOk := cache.get(oldFS, oldPath, oldInfo.Size(), oldInfo.ModTime())
+		newHash, newOk := cache.get(newFS, newPath, newInfo.Size(), newInfo.ModTime())
+
+		if oldOk && newOk && oldHash == newHash {
+			return e.unchangedResult(relPath, newInfo, oldHash, newHash), nil
+		}
+	}
+
+	oldData, err := e.readFile(oldFS, oldPath)
+	if notExist(err) {
+		newData, err := e.readFile(newFS, newPath)
+		if err != nil {
+			return nil, err
+		}
+
+		chunks := splitChunks([]DiffChunk{{
+			Offset:    0,
+			NewData:   newData,
+			ChunkType: handler.GetFileType(),
+			Op:        OpInsert,
+		}}, e.config.ChunkSize)
+
+		e.checksumChunks(chunks)
+
+		if compress {
+			e.tuneCompressionLevel(chunks)
+			e.compressChunks(chunks, e.compressionLevel(newPath))
+		}
+
+		if e.config.ChunkTransform != nil {
+			if err := e.transformChunks(chunks); err != nil {
+				return nil, err
+			}
+		}
+
+		return &DiffResult{
+			Path:         filepath.Base(newPath),
+			Operation:    "added",
+			NewHash:      hashBytes(newData),
+			FileType:     handler.GetFileType(),
+			Size:         newInfo.Size(),
+			ModTime:      newInfo.ModTime(),
+			Permissions:  newInfo.Mode(),
+			IsCompressed: anyCompressed(chunks),
+			Transformed:  e.config.ChunkTransform != nil,
+			Chunks:       chunks,
+		}, nil
+	} else if err != nil {
+		// Without Lstat, a directory replaced by a file is only found by failing to read it
+		if info, statErr := oldFS.Stat(oldPath); statErr == nil && info.IsDir() {
+			return typeChangeResult(newPath, newInfo), nil
+		}
+
+		return nil, err
+	}
+
+	newData, err := e.readFile(newFS, newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldInfo != nil {
+		cache.put(oldFS, oldPath, oldInfo.Size(), oldInfo.ModTime(), hashBytes(oldData))
+		cache.put(newFS, newPath, newInfo.Size(), newInfo.ModTime(), hashBytes(newData))
+	}
+
+	if bytes.Equal(oldData, newData) {
+		hash := hashBytes(newData)
+		return e.unchangedResult(relPath, newInfo, hash, hash), nil
+	}
+
+	release := acquire(e.compareSlots)
+	defer release()
+
+	chunks, err := compareWith(handler, oldData, newData)
+	if err != nil {
+		// Binary content of a text file, or a file not in the format of its handler,
+		// is always delegated to the default handler
+		delegate := errors.Is(err, ErrNotText) || errors.Is(err, ErrNotSupported)
+
+		fallback := e.getDefaultHandler()
+		if !(e.config.FallbackOnError || delegate) || handler == fallback {
+			return nil, err
+		}
+
+		e.logger.Log("Handler %s failed for %s, falling back to %s: %v", handler.GetFileType(), newPath, fallback.GetFileType(), err)
+
+		handler = fallback
+		if chunks, err = compareWith(handler, oldData, newData); err != nil {
+			return nil, err
+		}
+	}
+
+	// The handler may consider different contents equal
+	if len(chunks) == 0 {
+		return e.unchangedResult(relPath, newInfo, hashBytes(oldData), hashBytes(newData)), nil
+	}
+
+	chunks = splitChunks(chunks, e.config.ChunkSize)
+	e.checksumChunks(chunks)
+
+	operation := "modified"
+	if e.config.RewriteThreshold > 0 && changeRatio(chunks, len(oldData), len(newData)) > e.config.RewriteThreshold {
+		operation = "rewritten"
+	}
+
+	// Compress chunks if enabled
+	if compress {
+		e.tuneCompressionLevel(chunks)
+		e.compressChunks(chunks, e.compressionLevel(newPath))
+	}
+
+	if e.config.VerifyPatches {
+		if err := verifyPatch(handler, oldData, newData, chunks); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.config.OmitOldData {
+		omitOldData(chunks)
+	}
+
+	if e.config.ChunkTransform != nil {
+		if err := e.transformChunks(chunks); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DiffResult{
+		Path:         filepath.Base(newPath),
+		Operation:    operation,
+		OldHash:      hashBytes(oldData),
+		NewHash:      hashBytes(newData),
+		Chunks:       chunks,
+		FileType:     handler.GetFileType(),
 		Size:         newInfo.Size(),
 		ModTime:      newInfo.ModTime(),
 		Permissions:  newInfo.Mode(),
-		IsCompressed: e.config.CompressPatches,
+		IsCompressed: anyCompressed(chunks),
+		Transformed:  e.config.ChunkTransform != nil,
 	}, nil
 }
+
+// splitChunks splits the chunks whose NewData is larger than size into sequential chunks
+// of at most size new bytes, which apply in order like the original chunk. The old span
+// is divided alongside, the last piece replacing what remains of it. The context of a
+// chunk goes to its first and last pieces. A size of 0 or less disables splitting.
+func splitChunks(chunks []DiffChunk, size int64) []DiffChunk {
+	if size <= 0 {
+		return chunks
+	}
+
+	split := make([]DiffChunk, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		newLen := int64(len(chunk.NewData))
+		if chunk.Op == OpDelete || newLen <= size {
+			split = append(split, chunk)
+			continue
+		}
+
+		oldData := chunk.OldData
+		if chunk.Op == OpInsert {
+			oldData = nil
+		}
+
+		oldLen := int64(len(oldData))
+
+		for start := int64(0); start < newLen; start += size {
+			end := min(start+size, newLen)
+
+			oldStart, oldEnd := min(start, oldLen), min(end, oldLen)
+			if end == newLen {
+				oldEnd = oldLen
+			}
+
+			piece := chunk
+			piece.Offset = chunk.Offset + oldStart
+			piece.OldData = oldData[oldStart:oldEnd]
+			piece.OldLength = oldEnd - oldStart
+			piece.NewData = chunk.NewData[start:end]
+			piece.Op = chunkOp(piece.OldData, piece.NewData)
+
+			if start > 0 {
+				piece.ContextBefore = nil
+			}
+
+			if end < newLen {
+				piece.ContextAfter = nil
+			}
+
+			split = append(split, piece)
+		}
+	}
+
+	return split
+}
+
+// compressChunks compresses at level the data of the chunks for which it pays off. The gain of
+// each chunk is estimated with a fast trial compression, and the chunk is stored raw
+// unless its size is reduced by at least MinCompressionGain.
+func (e *DiffEngine) compressChunks(chunks []DiffChunk, level int) {
+	for i := range chunks {
+		size := len(chunks[i].NewData)
+		if size == 0 {
+			continue
+		}
+
+		trial := compressData(chunks[i].NewData, true, gzip.BestSpeed)
+
+		gain := 1 - float64(len(trial))/float64(size)
+		if gain <= 0 || gain < e.config.MinCompressionGain {
+			continue
+		}
+
+		chunks[i].NewData = e.compressor(chunks[i].NewData, true, level)
+		chunks[i].Compressed = true
+	}
+}
+
+// omitOldData replaces the old data of the chunks with its length.
+func omitOldData(chunks []DiffChunk) {
+	for i := range chunks {
+		chunks[i].OldLength = chunks[i].oldSpan()
+		chunks[i].OldData = nil
+	}
+}
+
+// verifyPatch applies the possibly compressed chunks to oldData with the handler which
+// produced them, and checks that the result is newData.
+func verifyPatch(handler FileHandler, oldData, newData []byte, chunks []DiffChunk) error {
+	plain := make([]DiffChunk, len(chunks))
+	for i, chunk := range chunks {
+		data, err := chunkData(chunk)
+		if err != nil {
+			return fmt.Errorf("%w: chunk %d: %v", ErrPatchVerification, i, err)
+		}
+
+		chunk.NewData = data
+		chunk.Compressed = false
+		plain[i] = chunk
+	}
+
+	patched, err := handler.Patch(oldData, plain)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPatchVerification, err)
+	}
+
+	if !bytes.Equal(patched, newData) {
+		return fmt.Errorf("%w: patched data does not match the new file", ErrPatchVerification)
+	}
+
+	return nil
+}
+
+// compressionLevel returns the compression level of the chunks of a file,
+// taken from CompressionLevels for its extension, the level chosen with
+// AutoCompressionLevel or the global CompressionLevel.
+func (e *DiffEngine) compressionLevel(path string) int {
+	ext := strings.ToLower(filepath.Ext(path))
+	for levelExt, level := range e.config.CompressionLevels {
+		if strings.ToLower(levelExt) == ext {
+			return level
+		}
+	}
+
+	if level, ok := e.tunedCompressionLevel(); ok {
+		return level
+	}
+
+	return e.config.CompressionLevel
+}
+
+// shouldCompress reports whether the chunks of a file should be compressed.
+// Files with an extension listed in NoCompressExtensions are stored as-is.
+func (e *DiffEngine) shouldCompress(path string) bool {
+	if !e.config.CompressPatches {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, noCompress := range e.config.NoCompressExtensions {
+		if strings.ToLower(noCompress) == ext {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CompareFilesIfChanged compares the hashes of two files before diffing them.
+// The hashes are streamed, so identical files are detected without buffering
+// their content, in which case nil is returned. Only files whose hashes differ
+// are read fully and diffed.
+func (e *DiffEngine) CompareFilesIfChanged(oldPath, newPath string) (*DiffResult, error) {
+	start := time.Now()
+
+	result, err := e.compareFilesIfChanged(oldPath, newPath)
+	e.observe(newPath, result, err, start)
+
+	return result, err
+}
+
+func (e *DiffEngine) compareFilesIfChanged(oldPath, newPath string) (*DiffResult, error) {
+	fsys := e.getFileSystem()
+
+	newInfo, err := fsys.Stat(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	oldHash, err := e.hashFile(fsys, oldPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		newHash, err := e.hashFile(fsys, newPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if oldHash == newHash {
+			result := e.unchangedResult(filepath.Base(newPath), newInfo, oldHash, newHash)
+			if e.config.ReportPermissionChanges {
+				result = e.comparePermissions(fsys, oldPath, filepath.Base(newPath), newInfo, result)
+			}
+
+			return result, nil
+		}
+	}
+
+	return e.compareFiles(fsys, fsys, oldPath, newPath, filepath.Base(newPath), newInfo)
+}
+
+// checksumChunks sets the checksum of the NewData of each chunk with
+// Configuration.ChunkChecksums, before the chunks are compressed or transformed.
+func (e *DiffEngine) checksumChunks(chunks []DiffChunk) {
+	if !e.config.ChunkChecksums {
+		return
+	}
+
+	for i := range chunks {
+		chunks[i].Checksum = crc32.ChecksumIEEE(chunks[i].NewData)
+	}
+}
+
+// unchangedResult returns the "unchanged" result of a file with the given content hashes
+// of its old and new versions when Configuration.ReportUnchanged is set, and nil otherwise.
+// The hashes differ when the handler considers different contents equal.
+func (e *DiffEngine) unchangedResult(relPath string, newInfo os.FileInfo, oldHash, newHash string) *DiffResult {
+	if !e.config.ReportUnchanged {
+		return nil
+	}
+
+	return &DiffResult{
+		Path:        path.Base(relPath),
+		Operation:   "unchanged",
+		OldHash:     oldHash,
+		NewHash:     newHash,
+		FileType:    e.getHandler(relPath).GetFileType(),
+		Size:        newInfo.Size(),
+		ModTime:     newInfo.ModTime(),
+		Permissions: newInfo.Mode(),
+	}
+}
+
+// readFile reads the whole content of a file.
+func (e *DiffEngine) readFile(fsys FileSystem, path string) ([]byte, error) {
+	release := acquire(e.readSlots)
+	defer release()
+
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// hashFile calculates the SHA256 hash of a file without buffering its content.
+func (e *DiffEngine) hashFile(fsys FileSystem, path string) (string, error) {
+	release := acquire(e.readSlots)
+	defer release()
+
+	if _, ok := fsys.(OSFileSystem); ok && e.config.UseMmap {
+		hash, err := mmapHash(path)
+		if err == nil || os.IsNotExist(err) {
+			return hash, err
+		}
+
+		e.logger.Log("Memory-mapping %s failed, falling back to streaming: %v", path, err)
+	}
+
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	return hashReader(file, e.config.HashBufferSize)
+}
+
+// acquire takes one of slots, waiting for one to be released, and returns the function
+// releasing it. A nil slots is unbounded.
+func acquire(slots chan struct{}) func() {
+	if slots == nil {
+		return func() {}
+	}
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// calculateHash calculates the SHA256 hash of a file, returning an empty string on failure.
+func (e *DiffEngine) calculateHash(fsys FileSystem, path string) string {
+	hash, err := e.hashFile(fsys, path)
+	if err != nil {
+		return ""
+	}
+
+	return hash
+}
@@ -0,0 +1,2453 @@
+package diff
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testEngineLogFile = "diff.log"
+
+func newTestEngine(t *testing.T, config *Configuration) *DiffEngine {
+	t.Helper()
+
+	engine, err := NewDiffEngine(config)
+	if err != nil {
+		t.Fatalf("Failed to create diff engine: %v", err)
+	}
+
+	t.Cleanup(func() {
+		engine.Close()
+		os.Remove(testEngineLogFile)
+	})
+
+	return engine
+}
+
+func writeTestTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestCompareDirs_PathMap(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"srv/app/config.txt": "port=80\n",
+		"srv/app/legacy.txt": "legacy\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"opt/app/config.txt": "port=8080\n",
+		"opt/app/extra.txt":  "extra\n",
+	})
+
+	config := DefaultConfig()
+	config.PathMap = func(relPath string) string {
+		slashed := filepath.ToSlash(relPath)
+		if rest, ok := strings.CutPrefix(slashed, "opt/app/"); ok {
+			return filepath.FromSlash("srv/app/" + rest)
+		}
+
+		return relPath
+	}
+
+	engine := newTestEngine(t, config)
+
+	summary, _, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if summary.ModifiedFiles != 1 {
+		t.Errorf("expected 1 modified file, got %d", summary.ModifiedFiles)
+	}
+
+	if summary.AddedFiles != 1 {
+		t.Errorf("expected 1 added file, got %d", summary.AddedFiles)
+	}
+
+	if summary.DeletedFiles != 1 {
+		t.Errorf("expected 1 deleted file, got %d", summary.DeletedFiles)
+	}
+}
+
+func TestCompareDirs_PathMapTruncated(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles, newFiles := make(map[string]string), make(map[string]string)
+	for i := 0; i < 8; i++ {
+		name := string(rune('a'+i)) + ".txt"
+		oldFiles["srv/"+name] = strings.Repeat("old line\n", 10)
+		newFiles["opt/"+name] = strings.Repeat("new line\n", 10)
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	config := DefaultConfig()
+	config.CompressPatches = false
+	config.Concurrency = 1
+	config.MaxTotalPatchBytes = 100
+	config.PathMap = func(relPath string) string {
+		if rest, ok := strings.CutPrefix(filepath.ToSlash(relPath), "opt/"); ok {
+			return filepath.FromSlash("srv/" + rest)
+		}
+
+		return relPath
+	}
+
+	summary, results, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if !summary.Truncated {
+		t.Fatal("expected the comparison to be truncated")
+	}
+
+	// The old files of the new files not compared were moved, not deleted
+	if summary.DeletedFiles != 0 {
+		t.Errorf("expected no deleted files, got %d in %+v", summary.DeletedFiles, results)
+	}
+}
+
+// strictJSONHandler is a test handler which fails on malformed JSON documents.
+type strictJSONHandler struct {
+	TextFileHandler
+}
+
+func (h *strictJSONHandler) Compare(old, new []byte) ([]DiffChunk, error) {
+	if !json.Valid(old) || !json.Valid(new) {
+		return nil, errors.New("malformed json")
+	}
+
+	return h.TextFileHandler.Compare(old, new)
+}
+
+func (h *strictJSONHandler) GetFileType() string {
+	return "json"
+}
+
+func TestCompareDirs_FallbackOnError(t *testing.T) {
+	tests := []struct {
+		name            string
+		fallbackOnError bool
+		wantResults     int
+		wantFileType    string
+	}{
+		{
+			name:            "Fallback enabled",
+			fallbackOnError: true,
+			wantResults:     1,
+			wantFileType:    "binary",
+		},
+		{
+			name:            "Fallback disabled",
+			fallbackOnError: false,
+			wantResults:     0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldDir, newDir := t.TempDir(), t.TempDir()
+
+			writeTestTree(t, oldDir, map[string]string{"data.json": `{"key": "old"`})
+			writeTestTree(t, newDir, map[string]string{"data.json": `{"key": "new"`})
+
+			config := DefaultConfig()
+			config.FallbackOnError = tt.fallbackOnError
+
+			engine := newTestEngine(t, config)
+			engine.RegisterHandler(".json", &strictJSONHandler{})
+
+			_, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			if len(results) != tt.wantResults {
+				t.Fatalf("expected %d results, got %d", tt.wantResults, len(results))
+			}
+
+			if tt.wantResults > 0 && results[0].FileType != tt.wantFileType {
+				t.Errorf("expected file type %s, got %s", tt.wantFileType, results[0].FileType)
+			}
+		})
+	}
+}
+
+func TestCompareFiles_RewriteThreshold(t *testing.T) {
+	dir := t.TempDir()
+
+	// The second line changes 4 of 10 bytes, a change ratio of exactly 0.4
+	writeTestTree(t, dir, map[string]string{
+		"old/notes.txt": "aaaa\nbbbb\n",
+		"new/notes.txt": "aaaa\ncccc\n",
+	})
+
+	oldPath := filepath.Join(dir, "old", "notes.txt")
+	newPath := filepath.Join(dir, "new", "notes.txt")
+
+	info, err := os.Stat(newPath)
+	if err != nil {
+		t.Fatalf("Failed to stat new file: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		threshold     float64
+		wantOperation string
+	}{
+		{name: "Threshold disabled", threshold: 0, wantOperation: "modified"},
+		{name: "Ratio equal to threshold", threshold: 0.4, wantOperation: "modified"},
+		{name: "Ratio above threshold", threshold: 0.39, wantOperation: "rewritten"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.RewriteThreshold = tt.threshold
+
+			engine := newTestEngine(t, config)
+
+			result, err := engine.compareFiles(OSFileSystem{}, OSFileSystem{}, oldPath, newPath, filepath.Base(newPath), info)
+			if err != nil {
+				t.Fatalf("compareFiles returned an error: %v", err)
+			}
+
+			if result.Operation != tt.wantOperation {
+				t.Errorf("expected operation %s, got %s", tt.wantOperation, result.Operation)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_RewrittenFiles(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"small.txt":   "line one\nline two\nline three\n",
+		"rewrite.txt": "original\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"small.txt":   "line one\nline 2\nline three\n",
+		"rewrite.txt": "replaced\n",
+	})
+
+	config := DefaultConfig()
+	config.RewriteThreshold = 0.8
+
+	engine := newTestEngine(t, config)
+
+	summary, _, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if summary.ModifiedFiles != 1 || summary.RewrittenFiles != 1 {
+		t.Errorf("expected 1 modified and 1 rewritten file, got %d and %d", summary.ModifiedFiles, summary.RewrittenFiles)
+	}
+}
+
+func TestCompareDirs_WalkFilter(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"recent.txt": "old recent\n",
+		"stale.txt":  "old stale\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"recent.txt": "new recent\n",
+		"stale.txt":  "new stale\n",
+	})
+
+	stale := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(newDir, "stale.txt"), stale, stale); err != nil {
+		t.Fatalf("Failed to change file times: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.WalkFilter = func(path string, info os.FileInfo) bool {
+		return time.Since(info.ModTime()) < 24*time.Hour
+	}
+
+	engine := newTestEngine(t, config)
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Path != "recent.txt" {
+		t.Fatalf("expected only recent.txt in results, got %+v", results)
+	}
+
+	if summary.DeletedFiles != 0 {
+		t.Errorf("expected filtered file not to be reported as deleted, got %d deletions", summary.DeletedFiles)
+	}
+}
+
+func TestCompareFiles_ChunkChecksums(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			name: "Modified file",
+			files: map[string]string{
+				"old/notes.txt": "first\nsecond\nthird\n",
+				"new/notes.txt": "first\n2nd\nthird\n",
+			},
+		},
+		{
+			name:  "Added file",
+			files: map[string]string{"new/notes.txt": "first\nsecond\nthird\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestTree(t, dir, tt.files)
+
+			oldPath := filepath.Join(dir, "old", "notes.txt")
+			newPath := filepath.Join(dir, "new", "notes.txt")
+
+			info, err := os.Stat(newPath)
+			if err != nil {
+				t.Fatalf("Failed to stat new file: %v", err)
+			}
+
+			config := DefaultConfig()
+			config.CompressPatches = false
+			config.ChunkChecksums = true
+
+			engine := newTestEngine(t, config)
+
+			result, err := engine.compareFiles(OSFileSystem{}, OSFileSystem{}, oldPath, newPath, filepath.Base(newPath), info)
+			if err != nil {
+				t.Fatalf("compareFiles returned an error: %v", err)
+			}
+
+			for i, chunk := range result.Chunks {
+				if chunk.Checksum == 0 {
+					t.Errorf("chunk %d: expected a checksum to be set", i)
+				}
+			}
+
+			oldData := []byte(tt.files["old/notes.txt"])
+			handler := &TextFileHandler{}
+
+			if _, err := handler.Patch(oldData, result.Chunks); err != nil {
+				t.Fatalf("Patch returned an error for intact chunks: %v", err)
+			}
+
+			result.Chunks[0].NewData[0] ^= 0xFF
+
+			if _, err := handler.Patch(oldData, result.Chunks); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("expected ErrChecksumMismatch for a corrupted chunk, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompareFiles_OmitOldData(t *testing.T) {
+	dir := t.TempDir()
+
+	oldContent := "first\nsecond\nthird\nfourth\n"
+	newContent := "first\n2nd\nthird\nfourth\nfifth\n"
+
+	writeTestTree(t, dir, map[string]string{
+		"old/notes.txt": oldContent,
+		"new/notes.txt": newContent,
+		"old/data.bin":  "\x00\x01" + strings.Repeat("a", 64) + "\x02" + strings.Repeat("b", 64),
+		"new/data.bin":  "\x00\x01" + strings.Repeat("a", 64) + "\x03\x04" + strings.Repeat("b", 64),
+	})
+
+	config := DefaultConfig()
+	config.OmitOldData = true
+
+	engine := newTestEngine(t, config)
+
+	for _, name := range []string{"notes.txt", "data.bin"} {
+		t.Run(name, func(t *testing.T) {
+			oldPath := filepath.Join(dir, "old", name)
+			newPath := filepath.Join(dir, "new", name)
+
+			info, err := os.Stat(newPath)
+			if err != nil {
+				t.Fatalf("Failed to stat new file: %v", err)
+			}
+
+			result, err := engine.compareFiles(OSFileSystem{}, OSFileSystem{}, oldPath, newPath, filepath.Base(newPath), info)
+			if err != nil {
+				t.Fatalf("compareFiles returned an error: %v", err)
+			}
+
+			for i, chunk := range result.Chunks {
+				if chunk.OldData != nil {
+					t.Errorf("chunk %d: expected no old data, got %q", i, chunk.OldData)
+				}
+			}
+
+			oldData, err := os.ReadFile(oldPath)
+			if err != nil {
+				t.Fatalf("Failed to read old file: %v", err)
+			}
+
+			newData, err := os.ReadFile(newPath)
+			if err != nil {
+				t.Fatalf("Failed to read new file: %v", err)
+			}
+
+			patched, _, skipped, err := ApplyPatchReport(oldData, result.Chunks)
+			if err != nil {
+				t.Fatalf("ApplyPatchReport returned an error: %v", err)
+			}
+
+			if len(skipped) > 0 || !bytes.Equal(patched, newData) {
+				t.Errorf("forward patch = %q with skipped chunks %v, want %q", patched, skipped, newData)
+			}
+
+			if _, err := ReverseChunks(result.Chunks); !errors.Is(err, ErrOldDataOmitted) {
+				t.Errorf("expected ErrOldDataOmitted reversing the chunks, got %v", err)
+			}
+		})
+	}
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count.Add(int64(n))
+
+	return n, err
+}
+
+// countingFileSystem counts the bytes read from the files it opens.
+type countingFileSystem struct {
+	FileSystem
+	count *atomic.Int64
+}
+
+func (c *countingFileSystem) Open(name string) (io.ReadCloser, error) {
+	file, err := c.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingReadCloser{ReadCloser: file, count: c.count}, nil
+}
+
+func TestCompareFilesIfChanged(t *testing.T) {
+	dir := t.TempDir()
+
+	const size = 4 * 1024 * 1024
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	changed := append([]byte{}, large...)
+	changed[size/2] = 'X'
+
+	tests := []struct {
+		name      string
+		newData   []byte
+		wantNil   bool
+		wantBytes int64
+	}{
+		{
+			name:      "Identical files are only hashed",
+			newData:   large,
+			wantNil:   true,
+			wantBytes: 2 * size,
+		},
+		{
+			name:      "Changed files are hashed and diffed",
+			newData:   changed,
+			wantNil:   false,
+			wantBytes: 4 * size,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldPath := filepath.Join(dir, "old.bin")
+			newPath := filepath.Join(dir, "new.bin")
+
+			if err := os.WriteFile(oldPath, large, 0644); err != nil {
+				t.Fatalf("Failed to write old file: %v", err)
+			}
+
+			if err := os.WriteFile(newPath, tt.newData, 0644); err != nil {
+				t.Fatalf("Failed to write new file: %v", err)
+			}
+
+			engine := newTestEngine(t, DefaultConfig())
+
+			var read atomic.Int64
+			engine.SetFileSystem(&countingFileSystem{FileSystem: OSFileSystem{}, count: &read})
+
+			result, err := engine.CompareFilesIfChanged(oldPath, newPath)
+			if err != nil {
+				t.Fatalf("CompareFilesIfChanged returned an error: %v", err)
+			}
+
+			if (result == nil) != tt.wantNil {
+				t.Errorf("expected nil result %v, got %+v", tt.wantNil, result)
+			}
+
+			if read.Load() != tt.wantBytes {
+				t.Errorf("expected %d bytes read, got %d", tt.wantBytes, read.Load())
+			}
+		})
+	}
+}
+
+func TestCompareDirs_NoCompressExtensions(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	// The data is compressible, so only the extension decides whether it is compressed
+	writeTestTree(t, oldDir, map[string]string{
+		"image.png": strings.Repeat("old image data ", 20),
+		"notes.txt": strings.Repeat("old notes ", 20),
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"image.png": strings.Repeat("new image data ", 20),
+		"notes.txt": strings.Repeat("new notes ", 20),
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	wantCompressed := map[string]bool{
+		"image.png": false,
+		"notes.txt": true,
+	}
+
+	if len(results) != len(wantCompressed) {
+		t.Fatalf("expected %d results, got %d", len(wantCompressed), len(results))
+	}
+
+	for _, result := range results {
+		if result.IsCompressed != wantCompressed[result.Path] {
+			t.Errorf("%s: expected IsCompressed %v, got %v", result.Path, wantCompressed[result.Path], result.IsCompressed)
+		}
+
+		_, err := decompressData(result.Chunks[0].NewData)
+		if (err == nil) != wantCompressed[result.Path] {
+			t.Errorf("%s: expected chunk data compressed %v, decompression error %v", result.Path, wantCompressed[result.Path], err)
+		}
+	}
+}
+
+func TestDiffEngine_hashFile(t *testing.T) {
+	dir := t.TempDir()
+
+	writeTestTree(t, dir, map[string]string{
+		"content.txt": testFileContent,
+		"empty.txt":   "",
+	})
+
+	tests := []struct {
+		name           string
+		file           string
+		hashBufferSize int
+		useMmap        bool
+		want           string
+	}{
+		{name: "Default buffer", file: "content.txt", want: testFileSHA256},
+		{name: "Custom buffer", file: "content.txt", hashBufferSize: 4, want: testFileSHA256},
+		{name: "Memory-mapped", file: "content.txt", useMmap: true, want: testFileSHA256},
+		{name: "Memory-mapped empty file", file: "empty.txt", useMmap: true, want: hashBytes(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.HashBufferSize = tt.hashBufferSize
+			config.UseMmap = tt.useMmap
+
+			engine := newTestEngine(t, config)
+
+			got, err := engine.hashFile(OSFileSystem{}, filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatalf("hashFile returned an error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("hashFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkDiffEngine_hashFile(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "large.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("0123456789abcdef"), 4*1024*1024), 0644); err != nil {
+		b.Fatalf("Failed to write large file: %v", err)
+	}
+
+	benchmarks := []struct {
+		name           string
+		hashBufferSize int
+		useMmap        bool
+	}{
+		{name: "DefaultBuffer"},
+		{name: "1MBBuffer", hashBufferSize: 1024 * 1024},
+		{name: "Mmap", useMmap: true},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			config := DefaultConfig()
+			config.HashBufferSize = bm.hashBufferSize
+			config.UseMmap = bm.useMmap
+
+			engine, err := NewDiffEngine(config)
+			if err != nil {
+				b.Fatalf("Failed to create diff engine: %v", err)
+			}
+
+			defer os.Remove(testEngineLogFile)
+			defer engine.Close()
+
+			b.SetBytes(64 * 1024 * 1024)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := engine.hashFile(OSFileSystem{}, path); err != nil {
+					b.Fatalf("hashFile returned an error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareDirs_ModifiedSince(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"before.txt": "old before\n",
+		"after.txt":  "old after\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"before.txt": "new before\n",
+		"after.txt":  "new after\n",
+	})
+
+	cutoff := time.Now().Add(-time.Hour)
+	before := cutoff.Add(-time.Hour)
+
+	if err := os.Chtimes(filepath.Join(newDir, "before.txt"), before, before); err != nil {
+		t.Fatalf("Failed to change file times: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.ModifiedSince = cutoff
+
+	engine := newTestEngine(t, config)
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Path != "after.txt" {
+		t.Fatalf("expected only after.txt in results, got %+v", results)
+	}
+
+	if summary.SkippedOlderFiles != 1 {
+		t.Errorf("expected 1 skipped file, got %d", summary.SkippedOlderFiles)
+	}
+
+	if summary.DeletedFiles != 0 {
+		t.Errorf("expected skipped file not to be reported as deleted, got %d deletions", summary.DeletedFiles)
+	}
+}
+
+func TestCompareDirs_SkipHidden(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		".git/HEAD":     "ref: refs/heads/main\n",
+		".git/config":   "[core]\n",
+		".env":          "SECRET=old\n",
+		"src/.cache":    "cached\n",
+		"src/main.txt":  "old main\n",
+		"src/other.txt": "unchanged\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		".git/HEAD":     "ref: refs/heads/feature\n",
+		".git/ORIG":     "added\n",
+		".env":          "SECRET=new\n",
+		"src/main.txt":  "new main\n",
+		"src/other.txt": "unchanged\n",
+	})
+
+	config := DefaultConfig()
+	config.SkipHidden = true
+
+	engine := newTestEngine(t, config)
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Path != "main.txt" {
+		t.Fatalf("expected only main.txt in results, got %+v", results)
+	}
+
+	if summary.DeletedFiles != 0 {
+		t.Errorf("expected hidden files not to be reported as deleted, got %d deletions", summary.DeletedFiles)
+	}
+}
+
+func TestCompareDirs_TypeOverrides(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"data/records.dat": "first\nsecond\n",
+		"data/other.dat":   "first\nsecond\n",
+		"fake.txt":         "header\nbody\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"data/records.dat": "first\n2nd\n",
+		"data/other.dat":   "first\n2nd\n",
+		"fake.txt":         "header\nBODY\n",
+	})
+
+	config := DefaultConfig()
+	config.TypeOverrides = map[string]string{
+		"data/records.dat": "text",
+		"fake.*":           "binary",
+	}
+
+	engine := newTestEngine(t, config)
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	want := map[string]string{
+		"records.dat": "text",
+		"other.dat":   "binary",
+		"fake.txt":    "binary",
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+
+	for _, result := range results {
+		if result.FileType != want[result.Path] {
+			t.Errorf("%s: expected file type %s, got %s", result.Path, want[result.Path], result.FileType)
+		}
+	}
+}
+
+func TestCompareDirs_TypeOverridesRelative(t *testing.T) {
+	root := t.TempDir()
+	oldDir, newDir := filepath.Join(root, "old"), filepath.Join(root, "new")
+
+	writeTestTree(t, oldDir, map[string]string{
+		"records.dat":  "first\nsecond\n",
+		"settings.cfg": "a=1\nb=2\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"records.dat":  "first\n2nd\n",
+		"settings.cfg": "a=1\nb=3\n",
+	})
+
+	config := DefaultConfig()
+	config.TypeOverrides = map[string]string{
+		// Names the directory of the new tree, above its root
+		"new/*.dat": "text",
+		"*.cfg":     "text",
+	}
+
+	engine := newTestEngine(t, config)
+
+	first := &countingHandler{FileHandler: &TextFileHandler{}}
+	last := &countingHandler{FileHandler: &TextFileHandler{}}
+	engine.RegisterHandler(".a", first)
+	engine.RegisterHandler(".z", last)
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	want := map[string]string{
+		"records.dat":  "binary",
+		"settings.cfg": "text",
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+
+	for _, result := range results {
+		if result.FileType != want[result.Path] {
+			t.Errorf("%s: expected file type %s, got %s", result.Path, want[result.Path], result.FileType)
+		}
+	}
+
+	// The text handler registered for the smallest extension is used
+	if first.compares != 1 || last.compares != 0 {
+		t.Errorf("expected only the .a handler to compare settings.cfg, got %d and %d comparisons", first.compares, last.compares)
+	}
+}
+
+// countingHandler wraps another handler, counting the comparisons delegated to it.
+type countingHandler struct {
+	FileHandler
+	compares int32
+}
+
+func (h *countingHandler) Compare(old, new []byte) ([]DiffChunk, error) {
+	atomic.AddInt32(&h.compares, 1)
+
+	return h.FileHandler.Compare(old, new)
+}
+
+func TestRegisterHandlerReturningPrev(t *testing.T) {
+	engine := newTestEngine(t, DefaultConfig())
+
+	if prev := engine.RegisterHandlerReturningPrev(".csv", &TextFileHandler{}); prev != nil {
+		t.Errorf("expected no previous handler for .csv, got %T", prev)
+	}
+
+	wrapper := &countingHandler{}
+	wrapper.FileHandler = engine.RegisterHandlerReturningPrev(".txt", wrapper)
+
+	if _, ok := wrapper.FileHandler.(*TextFileHandler); !ok {
+		t.Fatalf("expected the previous .txt handler to be a TextFileHandler, got %T", wrapper.FileHandler)
+	}
+
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"notes.txt": "one\ntwo\n"})
+	writeTestTree(t, newDir, map[string]string{"notes.txt": "one\n2\n"})
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+
+	if results[0].FileType != "text" {
+		t.Errorf("expected file type text, got %s", results[0].FileType)
+	}
+
+	if n := atomic.LoadInt32(&wrapper.compares); n != 1 {
+		t.Errorf("expected 1 delegated comparison, got %d", n)
+	}
+}
+
+func TestRegisterHandlerExts(t *testing.T) {
+	engine := newTestEngine(t, DefaultConfig())
+
+	handler := &TextFileHandler{MaxGapLines: 3}
+	engine.RegisterHandlerExts([]string{".yaml", ".yml", ".toml"}, handler)
+
+	for _, name := range []string{"config.yaml", "ci.yml", "Cargo.toml"} {
+		if got := engine.getHandler(name); got != handler {
+			t.Errorf("%s: expected the registered handler, got %T", name, got)
+		}
+	}
+
+	// Other extensions keep their handlers
+	if _, ok := engine.getHandler("notes.txt").(*TextFileHandler); !ok || engine.getHandler("notes.txt") == handler {
+		t.Errorf("expected the default .txt handler, got %T", engine.getHandler("notes.txt"))
+	}
+
+	fallback := &countingHandler{FileHandler: NewGenericBinaryHandler()}
+	engine.RegisterDefaultHandler(fallback)
+
+	if got := engine.getHandler("image.raw"); got != fallback {
+		t.Errorf("expected the registered default handler, got %T", got)
+	}
+
+	if got := engine.getDefaultHandler(); got != fallback {
+		t.Errorf("expected getDefaultHandler to return the registered default handler, got %T", got)
+	}
+}
+
+func TestCompareDirs_MaxTotalPatchBytes(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles, newFiles := make(map[string]string), make(map[string]string)
+	for i := 0; i < 8; i++ {
+		name := filepath.Join("files", string(rune('a'+i))+".txt")
+		oldFiles[name] = strings.Repeat("old line\n", 10)
+		newFiles[name] = strings.Repeat("new line\n", 10)
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	tests := []struct {
+		name          string
+		limit         int64
+		wantTruncated bool
+	}{
+		{name: "Unlimited", limit: 0, wantTruncated: false},
+		{name: "Large limit", limit: 1 << 20, wantTruncated: false},
+		{name: "Small limit", limit: 100, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.CompressPatches = false
+			config.Concurrency = 1
+			config.MaxTotalPatchBytes = tt.limit
+
+			engine := newTestEngine(t, config)
+
+			summary, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			if summary.Truncated != tt.wantTruncated {
+				t.Errorf("expected truncated %v, got %v", tt.wantTruncated, summary.Truncated)
+			}
+
+			if tt.wantTruncated && len(results) >= len(newFiles) {
+				t.Errorf("expected fewer than %d results, got %d", len(newFiles), len(results))
+			}
+
+			if !tt.wantTruncated && len(results) != len(newFiles) {
+				t.Errorf("expected %d results, got %d", len(newFiles), len(results))
+			}
+
+			var total int64
+			for _, result := range results {
+				total += patchBytes(result.Chunks)
+			}
+
+			if summary.PatchBytes != total {
+				t.Errorf("expected %d patch bytes, got %d", total, summary.PatchBytes)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_VerifyPatches(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"notes.txt": "one\ntwo\nthree\n",
+		"data.bin":  strings.Repeat("0123456789abcdef", 64),
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"notes.txt": "one\n" + strings.Repeat("2", 200) + "\nthree\nfour\n",
+		"data.bin":  strings.Repeat("0123456789abcdef", 32) + strings.Repeat("changed", 100) + strings.Repeat("0123456789abcdef", 32),
+	})
+
+	// faultyCompressor corrupts the last byte of the data it compresses
+	faultyCompressor := func(data []byte, compress bool, level int) []byte {
+		compressed := compressData(data, compress, level)
+		if len(compressed) > 0 {
+			compressed[len(compressed)-1] ^= 0xff
+		}
+
+		return compressed
+	}
+
+	tests := []struct {
+		name          string
+		verify        bool
+		faulty        bool
+		wantResults   int
+		wantErrors    int
+		wantVerifyErr bool
+	}{
+		{name: "Verified", verify: true, faulty: false, wantResults: 2, wantErrors: 0},
+		{name: "Faulty compressor detected", verify: true, faulty: true, wantResults: 0, wantErrors: 2, wantVerifyErr: true},
+		{name: "Faulty compressor undetected", verify: false, faulty: true, wantResults: 2, wantErrors: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.VerifyPatches = tt.verify
+
+			engine := newTestEngine(t, config)
+			if tt.faulty {
+				engine.compressor = faultyCompressor
+			}
+
+			summary, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			if len(results) != tt.wantResults {
+				t.Errorf("expected %d results, got %d", tt.wantResults, len(results))
+			}
+
+			if len(summary.Errors) != tt.wantErrors {
+				t.Fatalf("expected %d errors, got %d: %v", tt.wantErrors, len(summary.Errors), summary.Errors)
+			}
+
+			for _, fileErr := range summary.Errors {
+				if errors.Is(fileErr, ErrPatchVerification) != tt.wantVerifyErr {
+					t.Errorf("unexpected error for %s: %v", fileErr.Path, fileErr)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffEngine_compressChunks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	random := make([]byte, 1024)
+	rng.Read(random)
+
+	repeated := bytes.Repeat([]byte("abcd"), 256)
+
+	// Mostly random data which compresses slightly
+	partly := append(append([]byte(nil), random[:900]...), bytes.Repeat([]byte{0}, 124)...)
+
+	tests := []struct {
+		name           string
+		minGain        float64
+		wantCompressed []bool
+	}{
+		{name: "Any gain", minGain: 0, wantCompressed: []bool{false, true, true, false}},
+		{name: "Minimum gain", minGain: 0.5, wantCompressed: []bool{false, true, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.MinCompressionGain = tt.minGain
+
+			engine := newTestEngine(t, config)
+
+			chunks := []DiffChunk{
+				{NewData: random, Op: OpReplace},
+				{NewData: repeated, Op: OpReplace},
+				{NewData: partly, Op: OpReplace},
+				{OldData: []byte("gone"), Op: OpDelete},
+			}
+			original := [][]byte{random, repeated, partly, nil}
+
+			engine.compressChunks(chunks, config.CompressionLevel)
+
+			for i, chunk := range chunks {
+				if chunk.Compressed != tt.wantCompressed[i] {
+					t.Errorf("chunk %d: expected compressed %v, got %v", i, tt.wantCompressed[i], chunk.Compressed)
+				}
+
+				data, err := chunkData(chunk)
+				if err != nil {
+					t.Fatalf("chunk %d: chunkData returned an error: %v", i, err)
+				}
+
+				if !bytes.Equal(data, original[i]) {
+					t.Errorf("chunk %d: data does not match the original", i)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareDirs_BinaryTextFile(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	binary := make([]byte, 512)
+	rand.New(rand.NewSource(1)).Read(binary)
+	binary[0] = 0
+
+	changed := append([]byte(nil), binary...)
+	changed[256] ^= 0xff
+
+	writeTestTree(t, oldDir, map[string]string{
+		"notes.txt": "one\ntwo\n",
+		"blob.txt":  string(binary),
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"notes.txt": "one\n2\n",
+		"blob.txt":  string(changed),
+	})
+
+	// Delegation to the binary handler does not depend on FallbackOnError
+	config := DefaultConfig()
+	config.FallbackOnError = false
+
+	engine := newTestEngine(t, config)
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	want := map[string]string{
+		"notes.txt": "text",
+		"blob.txt":  "binary",
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d: %v", len(want), len(results), summary.Errors)
+	}
+
+	for _, result := range results {
+		if result.FileType != want[result.Path] {
+			t.Errorf("%s: expected file type %s, got %s", result.Path, want[result.Path], result.FileType)
+		}
+	}
+}
+
+func TestCompareDirs_CompressionLevels(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"app.log":   strings.Repeat("old log entry ", 50),
+		"notes.txt": strings.Repeat("old notes ", 50),
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"app.log":   strings.Repeat("new log entry ", 50),
+		"notes.txt": strings.Repeat("new notes ", 50),
+	})
+
+	config := DefaultConfig()
+	config.CompressionLevels = map[string]int{".LOG": gzip.BestSpeed}
+
+	engine := newTestEngine(t, config)
+
+	var mu sync.Mutex
+	levels := make(map[string]int)
+
+	// The compressed data identifies the file it belongs to
+	engine.compressor = func(data []byte, compress bool, level int) []byte {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if bytes.Contains(data, []byte("log")) {
+			levels["app.log"] = level
+		} else {
+			levels["notes.txt"] = level
+		}
+
+		return compressData(data, compress, level)
+	}
+
+	if _, _, err := engine.CompareDirs(oldDir, newDir); err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	want := map[string]int{
+		"app.log":   gzip.BestSpeed,
+		"notes.txt": gzip.BestCompression,
+	}
+
+	for name, level := range want {
+		if got, ok := levels[name]; !ok || got != level {
+			t.Errorf("%s: expected compression level %d, got %d", name, level, got)
+		}
+	}
+}
+
+func TestNewDiffEngine_InvalidConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.CompressionLevels = map[string]int{".log": 42}
+
+	if _, err := NewDiffEngine(config); err == nil {
+		t.Error("expected NewDiffEngine to reject an invalid compression level")
+	}
+}
+
+func TestCompareDirs_Progress(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"same.txt":       "unchanged\n",
+		"changed.txt":    "old\n",
+		"removed.txt":    "removed\n",
+		"nested/bin.dat": strings.Repeat("x", 300),
+	})
+
+	newFiles := map[string]string{
+		"same.txt":       "unchanged\n",
+		"changed.txt":    "new content\n",
+		"added.txt":      strings.Repeat("added\n", 40),
+		"nested/bin.dat": strings.Repeat("y", 300),
+	}
+	writeTestTree(t, newDir, newFiles)
+
+	var wantBytes int64
+	for _, content := range newFiles {
+		wantBytes += int64(len(content))
+	}
+
+	var mu sync.Mutex
+	var updates []Progress
+
+	config := DefaultConfig()
+	config.OnProgress = func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		updates = append(updates, p)
+	}
+
+	engine := newTestEngine(t, config)
+
+	summary, _, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if summary.ProcessedBytes != wantBytes {
+		t.Errorf("expected %d processed bytes, got %d", wantBytes, summary.ProcessedBytes)
+	}
+
+	if len(updates) != len(newFiles) {
+		t.Fatalf("expected %d progress updates, got %d", len(newFiles), len(updates))
+	}
+
+	var maxProcessed int64
+	for _, p := range updates {
+		if p.TotalBytes != wantBytes {
+			t.Errorf("expected %d total bytes, got %d", wantBytes, p.TotalBytes)
+		}
+
+		maxProcessed = max(maxProcessed, p.ProcessedBytes)
+	}
+
+	if maxProcessed != wantBytes {
+		t.Errorf("expected the last progress update to report %d bytes, got %d", wantBytes, maxProcessed)
+	}
+}
+
+// deniedFileSystem denies access to the files of the given names.
+type deniedFileSystem struct {
+	FileSystem
+	denied map[string]bool
+}
+
+func (d *deniedFileSystem) Open(name string) (io.ReadCloser, error) {
+	if d.denied[filepath.Base(name)] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+
+	return d.FileSystem.Open(name)
+}
+
+func TestCompareDirs_ErrorCategories(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"file.txt": "old\n", "secret.txt": "old secret\n"})
+	writeTestTree(t, newDir, map[string]string{"file.txt": "new\n", "secret.txt": "new secret\n"})
+
+	engine := newTestEngine(t, DefaultConfig())
+	engine.SetFileSystem(&deniedFileSystem{FileSystem: OSFileSystem{}, denied: map[string]bool{"secret.txt": true}})
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(results))
+	}
+
+	if len(summary.Errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(summary.Errors), summary.Errors)
+	}
+
+	if fileErr := summary.Errors[0]; fileErr.Path != "secret.txt" || fileErr.Category != ErrorCategoryPermission {
+		t.Errorf("expected a permission error for secret.txt, got %s error %v", fileErr.Category, fileErr)
+	}
+}
+
+// slowFileSystem delays opening files and counts the files opened.
+type slowFileSystem struct {
+	FileSystem
+	opened atomic.Int64
+}
+
+func (s *slowFileSystem) Open(name string) (io.ReadCloser, error) {
+	s.opened.Add(1)
+	time.Sleep(time.Millisecond)
+
+	return s.FileSystem.Open(name)
+}
+
+func TestCompareDirs_FailFast(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles, newFiles := make(map[string]string), make(map[string]string)
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("file%03d.txt", i)
+		oldFiles[name] = "old\n"
+		newFiles[name] = "new\n"
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	for _, failFast := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FailFast=%t", failFast), func(t *testing.T) {
+			config := DefaultConfig()
+			config.FailFast = failFast
+
+			// The first file walked can't be read
+			fsys := &slowFileSystem{FileSystem: &deniedFileSystem{FileSystem: OSFileSystem{}, denied: map[string]bool{"file000.txt": true}}}
+
+			engine := newTestEngine(t, config)
+			engine.SetFileSystem(fsys)
+
+			summary, results, err := engine.CompareDirs(oldDir, newDir)
+
+			if !failFast {
+				if err != nil {
+					t.Fatalf("CompareDirs returned an error: %v", err)
+				}
+
+				if len(results) != 199 || len(summary.Errors) != 1 {
+					t.Errorf("expected 199 results and 1 error, got %d and %v", len(results), summary.Errors)
+				}
+
+				return
+			}
+
+			var fileErr FileError
+			if !errors.As(err, &fileErr) || fileErr.Path != "file000.txt" || !errors.Is(err, os.ErrPermission) {
+				t.Fatalf("expected the permission error of file000.txt, got %v", err)
+			}
+
+			if summary != nil || results != nil {
+				t.Errorf("expected no summary nor results, got %+v and %d results", summary, len(results))
+			}
+
+			// Only the files already being compared are opened after the failure
+			if opened := fsys.opened.Load(); opened > int64(4*config.Concurrency) {
+				t.Errorf("expected the comparison to stop promptly, %d files opened", opened)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_TextOnly(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"notes.txt":   "one\ntwo\n",
+		"image.png":   "old image",
+		"archive.bin": "old archive",
+		"removed.md":  "# Removed\n",
+		"removed.dat": "removed data",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"notes.txt":   "one\n2\n",
+		"image.png":   "new image",
+		"archive.bin": "new archive",
+		"added.log":   "started\n",
+	})
+
+	tests := []struct {
+		name              string
+		textOnly          bool
+		wantPaths         []string
+		wantSkippedBinary int
+	}{
+		{
+			name:              "Text only",
+			textOnly:          true,
+			wantPaths:         []string{"added.log", "notes.txt", "removed.md"},
+			wantSkippedBinary: 3,
+		},
+		{
+			name:      "All files",
+			textOnly:  false,
+			wantPaths: []string{"added.log", "archive.bin", "image.png", "notes.txt", "removed.dat", "removed.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.TextOnly = tt.textOnly
+
+			engine := newTestEngine(t, config)
+
+			summary, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			paths := make([]string, 0, len(results))
+			for _, result := range results {
+				paths = append(paths, result.Path)
+			}
+			sort.Strings(paths)
+
+			if diff := cmp.Diff(tt.wantPaths, paths); diff != "" {
+				t.Errorf("unexpected results (-want +got):\n%s", diff)
+			}
+
+			if summary.SkippedBinary != tt.wantSkippedBinary {
+				t.Errorf("expected %d skipped binary files, got %d", tt.wantSkippedBinary, summary.SkippedBinary)
+			}
+		})
+	}
+}
+
+func TestCompareDirsLayered(t *testing.T) {
+	upper, lower, newDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+	writeTestTree(t, upper, map[string]string{
+		"shared.txt":  "upper version\n",
+		"removed.txt": "upper removed\n",
+	})
+	writeTestTree(t, lower, map[string]string{
+		"shared.txt":  "lower version\n",
+		"lower.txt":   "lower only\n",
+		"removed.txt": "lower removed\n",
+		"gone.txt":    "gone\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"shared.txt": "upper version\n",
+		"lower.txt":  "lower only, changed\n",
+		"added.txt":  "added\n",
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	summary, results, err := engine.CompareDirsLayered(newDir, []string{upper, lower})
+	if err != nil {
+		t.Fatalf("CompareDirsLayered returned an error: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, result := range results {
+		got[result.Path] = result.Operation
+	}
+
+	// shared.txt matches the upper layer, so it is unchanged despite differing from the lower one
+	want := map[string]string{
+		"lower.txt":   "modified",
+		"added.txt":   "added",
+		"removed.txt": "deleted",
+		"gone.txt":    "deleted",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+
+	if summary.DeletedFiles != 2 || summary.TotalFiles != len(want) {
+		t.Errorf("expected 2 deleted of %d files, got %d of %d", len(want), summary.DeletedFiles, summary.TotalFiles)
+	}
+
+	if _, _, err := engine.CompareDirsLayered(newDir, nil); err == nil {
+		t.Error("expected an error without old directories")
+	}
+}
+
+func TestCompareDirs_RelPath(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"a/b/modified.txt": "old\n",
+		"a/deleted.txt":    "deleted\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"a/b/modified.txt": "new\n",
+		"c/d/e/added.txt":  "added\n",
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, result := range results {
+		if strings.Contains(result.RelPath, `\`) || strings.Contains(result.Path, `\`) {
+			t.Errorf("expected slash-separated paths, got %q and %q", result.Path, result.RelPath)
+		}
+
+		if want := filepath.Join(strings.Split(result.RelPath, "/")...); result.LocalPath() != want {
+			t.Errorf("expected local path %q, got %q", want, result.LocalPath())
+		}
+
+		got[result.RelPath] = result.Operation
+	}
+
+	want := map[string]string{
+		"a/b/modified.txt": "modified",
+		"a/deleted.txt":    "deleted",
+		"c/d/e/added.txt":  "added",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompareDirs_ReportUnchanged(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"same.txt":     "same\n",
+		"sub/same.bin": "\x00\x01\x02",
+		"changed.txt":  "old\n",
+		"deleted.txt":  "deleted\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"same.txt":     "same\n",
+		"sub/same.bin": "\x00\x01\x02",
+		"changed.txt":  "new\n",
+		"added.txt":    "added\n",
+	})
+
+	tests := []struct {
+		name            string
+		reportUnchanged bool
+		want            map[string]string
+	}{
+		{
+			name: "Changed files only",
+			want: map[string]string{"changed.txt": "modified", "added.txt": "added", "deleted.txt": "deleted"},
+		},
+		{
+			name:            "Unchanged files reported",
+			reportUnchanged: true,
+			want: map[string]string{
+				"changed.txt":  "modified",
+				"added.txt":    "added",
+				"deleted.txt":  "deleted",
+				"same.txt":     "unchanged",
+				"sub/same.bin": "unchanged",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.ReportUnchanged = tt.reportUnchanged
+
+			engine := newTestEngine(t, config)
+
+			summary, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			got := make(map[string]string)
+			for _, result := range results {
+				got[result.RelPath] = result.Operation
+
+				if result.Operation == "unchanged" && (len(result.Chunks) != 0 || result.NewHash == "") {
+					t.Errorf("%s: expected a hash and no chunks, got %d chunks", result.RelPath, len(result.Chunks))
+				}
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected results (-want +got):\n%s", diff)
+			}
+
+			wantUnchanged := len(FilterByOperation(results, "unchanged"))
+			if summary.UnchangedFiles != wantUnchanged || summary.TotalFiles != len(tt.want) {
+				t.Errorf("expected %d unchanged of %d files, got %d of %d", wantUnchanged, len(tt.want), summary.UnchangedFiles, summary.TotalFiles)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_UnchangedHashes(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles := map[string]string{"code.txt": "value = 1 // old note\n", "media.bin": "media"}
+	newFiles := map[string]string{"code.txt": "value = 1 // new note\n", "media.bin": "media"}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	// The media files are only compared from their metadata
+	modTime := time.Now().Add(-time.Hour)
+	for _, dir := range []string{oldDir, newDir} {
+		if err := os.Chtimes(filepath.Join(dir, "media.bin"), modTime, modTime); err != nil {
+			t.Fatalf("Failed to set the modification time: %v", err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.ReportUnchanged = true
+
+	engine := newTestEngine(t, config)
+	engine.RegisterHandler(".txt", &TextFileHandler{CommentSyntax: CStyleComments})
+	engine.RegisterHandler(".bin", &NoOpHandler{})
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+
+	for _, result := range results {
+		if result.Operation != "unchanged" {
+			t.Errorf("%s: expected unchanged, got %q", result.RelPath, result.Operation)
+		}
+
+		if want := hashBytes([]byte(oldFiles[result.RelPath])); result.OldHash != want {
+			t.Errorf("%s: OldHash = %q, want the hash of the old file %q", result.RelPath, result.OldHash, want)
+		}
+
+		if want := hashBytes([]byte(newFiles[result.RelPath])); result.NewHash != want {
+			t.Errorf("%s: NewHash = %q, want the hash of the new file %q", result.RelPath, result.NewHash, want)
+		}
+	}
+}
+
+func TestCompareDirs_DedupContent(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	asset := strings.Repeat("generated asset content\n", 200)
+	oldConfig := strings.Repeat("setting = old\n", 50)
+	newConfig := strings.Repeat("setting = new\n", 50)
+
+	writeTestTree(t, oldDir, map[string]string{
+		"x/app.conf": oldConfig,
+		"y/app.conf": oldConfig,
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"c/asset.txt": asset,
+		"a/asset.txt": asset,
+		"b/asset.txt": asset,
+		"unique.txt":  "unique\n",
+		"x/app.conf":  newConfig,
+		"y/app.conf":  newConfig,
+	})
+
+	config := DefaultConfig()
+	config.DedupContent = true
+
+	engine := newTestEngine(t, config)
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, result := range results {
+		got[result.RelPath] = result.SameAs
+
+		if result.SameAs != "" && len(result.Chunks) != 0 {
+			t.Errorf("%s: expected no chunks for a reference, got %d", result.RelPath, len(result.Chunks))
+		}
+	}
+
+	want := map[string]string{
+		"a/asset.txt": "",
+		"b/asset.txt": "a/asset.txt",
+		"c/asset.txt": "a/asset.txt",
+		"unique.txt":  "",
+		"x/app.conf":  "",
+		"y/app.conf":  "x/app.conf",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected references (-want +got):\n%s", diff)
+	}
+
+	resolved, err := ResolveReferences(results)
+	if err != nil {
+		t.Fatalf("ResolveReferences returned an error: %v", err)
+	}
+
+	var storedBytes int64
+	for _, result := range resolved {
+		storedBytes += patchBytes(result.Chunks)
+
+		if result.Operation != "added" {
+			continue
+		}
+
+		data, err := chunkData(result.Chunks[0])
+		if err != nil {
+			t.Fatalf("%s: failed to read chunk data: %v", result.RelPath, err)
+		}
+
+		want := asset
+		if result.RelPath == "unique.txt" {
+			want = "unique\n"
+		}
+
+		if string(data) != want {
+			t.Errorf("%s: resolved content does not match the file", result.RelPath)
+		}
+	}
+
+	if summary.PatchBytes >= storedBytes {
+		t.Errorf("expected fewer patch bytes than without deduplication, got %d >= %d", summary.PatchBytes, storedBytes)
+	}
+}
+
+// statCountingFileSystem counts the Stat calls made on a file system.
+type statCountingFileSystem struct {
+	FileSystem
+	stats atomic.Int64
+}
+
+func (s *statCountingFileSystem) Stat(name string) (os.FileInfo, error) {
+	s.stats.Add(1)
+	return s.FileSystem.Stat(name)
+}
+
+func TestCompareDirs_DeletionDetection(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldTree := map[string]string{"deleted.txt": "deleted\n", "dir/gone.txt": "gone\n"}
+	newTree := map[string]string{}
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("dir/file%02d.txt", i)
+		oldTree[name] = strings.Repeat("old\n", 100)
+		newTree[name] = strings.Repeat(fmt.Sprintf("new %d\n", i), 100)
+	}
+
+	writeTestTree(t, oldDir, oldTree)
+	writeTestTree(t, newDir, newTree)
+
+	tests := []struct {
+		name          string
+		maxPatchBytes int64
+	}{
+		{name: "Complete walk"},
+		{name: "Truncated walk", maxPatchBytes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.MaxTotalPatchBytes = tt.maxPatchBytes
+			config.Concurrency = 1
+
+			engine := newTestEngine(t, config)
+
+			fsys := &statCountingFileSystem{FileSystem: OSFileSystem{}}
+			engine.SetFileSystem(fsys)
+
+			summary, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			var deleted []string
+			for _, result := range FilterByOperation(results, "deleted") {
+				deleted = append(deleted, result.RelPath)
+			}
+
+			sort.Strings(deleted)
+
+			if diff := cmp.Diff([]string{"deleted.txt", "dir/gone.txt"}, deleted); diff != "" {
+				t.Errorf("unexpected deleted files (-want +got):\n%s", diff)
+			}
+
+			if !summary.Truncated && fsys.stats.Load() != 0 {
+				t.Errorf("expected no stat calls for deletion detection, got %d", fsys.stats.Load())
+			}
+		})
+	}
+}
+
+func BenchmarkCompareDirs_DeletionDetection(b *testing.B) {
+	oldDir, newDir := b.TempDir(), b.TempDir()
+
+	// A large old tree of which most files are unchanged, and a tenth deleted
+	for i := 0; i < 2000; i++ {
+		name := filepath.Join(fmt.Sprintf("dir%02d", i%20), fmt.Sprintf("file%04d.txt", i))
+
+		for _, dir := range []string{oldDir, newDir} {
+			if dir == newDir && i%10 == 0 {
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+				b.Fatalf("Failed to create directory: %v", err)
+			}
+
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+				b.Fatalf("Failed to write file: %v", err)
+			}
+		}
+	}
+
+	engine, err := NewDiffEngine(DefaultConfig())
+	if err != nil {
+		b.Fatalf("Failed to create diff engine: %v", err)
+	}
+
+	defer os.Remove(testEngineLogFile)
+	defer engine.Close()
+
+	fsys := &statCountingFileSystem{FileSystem: OSFileSystem{}}
+	engine.SetFileSystem(fsys)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := engine.CompareDirs(oldDir, newDir); err != nil {
+			b.Fatalf("CompareDirs returned an error: %v", err)
+		}
+	}
+
+	b.ReportMetric(float64(fsys.stats.Load())/float64(b.N), "stats/op")
+}
+
+func TestCompareDirs_MaxDepth(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	deep := strings.Repeat("d/", 40)
+
+	writeTestTree(t, oldDir, map[string]string{
+		"a/b/deleted.txt":    "deleted\n",
+		deep + "deleted.txt": "deleted\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"root.txt":        "root\n",
+		"a/one.txt":       "one\n",
+		"a/b/two.txt":     "two\n",
+		"a/b/c/three.txt": "three\n",
+		deep + "deep.txt": "deep\n",
+	})
+
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     []string
+	}{
+		{name: "Unlimited", want: []string{"a/b/c/three.txt", "a/b/deleted.txt", "a/b/two.txt", "a/one.txt", deep + "deep.txt", deep + "deleted.txt", "root.txt"}},
+		{name: "One level", maxDepth: 1, want: []string{"a/one.txt", "root.txt"}},
+		{name: "Two levels", maxDepth: 2, want: []string{"a/b/deleted.txt", "a/b/two.txt", "a/one.txt", "root.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.MaxDepth = tt.maxDepth
+
+			engine := newTestEngine(t, config)
+
+			_, results, err := engine.CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			var paths []string
+			for _, result := range results {
+				paths = append(paths, result.RelPath)
+			}
+
+			sort.Strings(paths)
+
+			if diff := cmp.Diff(tt.want, paths); diff != "" {
+				t.Errorf("unexpected results (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldMid  string
+		newMid  string
+		op      string
+		wantOps []string
+	}{
+		{name: "Insert", newMid: "0123456789abcdefghij", op: OpInsert, wantOps: []string{OpInsert, OpInsert, OpInsert}},
+		{name: "Longer replacement", oldMid: "abc", newMid: "0123456789abcdefgh", op: OpReplace, wantOps: []string{OpReplace, OpInsert, OpInsert}},
+		{name: "Shorter replacement", oldMid: "0123456789abcdefghijklmnopq", newMid: "ABCDEFGHIJKLMNOPQRST", op: OpReplace, wantOps: []string{OpReplace, OpReplace, OpReplace}},
+		{name: "Small replacement", oldMid: "abc", newMid: "ABCDEFGH", op: OpReplace, wantOps: []string{OpReplace}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := "prefix|" + tt.oldMid + "|suffix"
+			new := "prefix|" + tt.newMid + "|suffix"
+
+			chunks := []DiffChunk{{
+				Offset:        7,
+				OldData:       []byte(tt.oldMid),
+				NewData:       []byte(tt.newMid),
+				Op:            tt.op,
+				ContextBefore: []byte("fix|"),
+				ContextAfter:  []byte("|suf"),
+			}}
+
+			split := splitChunks(chunks, 8)
+
+			var ops []string
+			for i, chunk := range split {
+				ops = append(ops, chunk.Op)
+
+				if len(chunk.NewData) > 8 {
+					t.Errorf("chunk %d: expected at most 8 new bytes, got %d", i, len(chunk.NewData))
+				}
+			}
+
+			if diff := cmp.Diff(tt.wantOps, ops); diff != "" {
+				t.Errorf("unexpected ops (-want +got):\n%s", diff)
+			}
+
+			handler := NewGenericBinaryHandler()
+
+			// The context of the split chunk still locates it in a shifted original
+			for _, shift := range []string{"", "++"} {
+				patched, err := handler.Patch([]byte(shift+old), split)
+				if err != nil {
+					t.Fatalf("Patch returned an error: %v", err)
+				}
+
+				if string(patched) != shift+new {
+					t.Errorf("Patch() = %q, want %q", patched, shift+new)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareDirs_ChunkSize(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	random := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	changed := append([]byte(nil), random...)
+	rand.New(rand.NewSource(2)).Read(changed[5000:12000])
+
+	writeTestTree(t, oldDir, map[string]string{"data.bin": string(random)})
+	writeTestTree(t, newDir, map[string]string{"data.bin": string(changed), "added.bin": string(random[:3500])})
+
+	config := DefaultConfig()
+	config.ChunkSize = 1000
+	config.ChunkChecksums = true
+
+	engine := newTestEngine(t, config)
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	for _, result := range results {
+		if len(result.Chunks) < 4 {
+			t.Errorf("%s: expected the changes to be split, got %d chunks", result.RelPath, len(result.Chunks))
+		}
+
+		for i, chunk := range result.Chunks {
+			data, err := chunkData(chunk)
+			if err != nil {
+				t.Fatalf("%s: chunk %d: %v", result.RelPath, i, err)
+			}
+
+			if len(data) > 1000 {
+				t.Errorf("%s: chunk %d: expected at most 1000 bytes, got %d", result.RelPath, i, len(data))
+			}
+		}
+
+		oldData, _ := os.ReadFile(filepath.Join(oldDir, result.RelPath))
+
+		newData, err := os.ReadFile(filepath.Join(newDir, result.RelPath))
+		if err != nil {
+			t.Fatalf("Failed to read new file: %v", err)
+		}
+
+		patched, _, skipped, err := ApplyPatchReport(oldData, result.Chunks)
+		if err != nil {
+			t.Fatalf("ApplyPatchReport returned an error: %v", err)
+		}
+
+		if len(skipped) > 0 || !bytes.Equal(patched, newData) {
+			t.Errorf("%s: patched data does not match new data", result.RelPath)
+		}
+	}
+}
+
+func TestCompareDirs_WorkerPool(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles := make(map[string]string)
+	newFiles := make(map[string]string)
+
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("dir%d/file%03d.txt", i%7, i)
+		oldFiles[name] = fmt.Sprintf("file %d\n", i)
+
+		switch i % 4 {
+		case 0:
+			newFiles[name] = fmt.Sprintf("file %d, modified\n", i)
+		case 1:
+			// Deleted
+		default:
+			newFiles[name] = oldFiles[name]
+		}
+	}
+
+	newFiles["added.txt"] = "added\n"
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	var want []string
+	for _, concurrency := range []int{1, 3, 16} {
+		config := DefaultConfig()
+		config.Concurrency = concurrency
+
+		summary, results, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+		if err != nil {
+			t.Fatalf("CompareDirs returned an error: %v", err)
+		}
+
+		if summary.ModifiedFiles != 50 || summary.DeletedFiles != 50 || summary.AddedFiles != 1 {
+			t.Errorf("concurrency %d: expected 50 modified, 50 deleted and 1 added files, got %d, %d and %d",
+				concurrency, summary.ModifiedFiles, summary.DeletedFiles, summary.AddedFiles)
+		}
+
+		got := make([]string, 0, len(results))
+		for _, result := range results {
+			got = append(got, result.Operation+" "+result.RelPath)
+		}
+
+		sort.Strings(got)
+
+		if want == nil {
+			want = got
+		} else if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("concurrency %d: results mismatch (-want +got):\n%s", concurrency, diff)
+		}
+	}
+}
+
+func TestCompareDirs_TypeChange(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"x":            "file becoming a directory\n",
+		"y/inside.txt": "directory becoming a file\n",
+		"same.txt":     "same\n",
+	})
+
+	writeTestTree(t, newDir, map[string]string{
+		"x/inside.txt": "new file in the directory\n",
+		"y":            "file replacing the directory\n",
+		"same.txt":     "same\n",
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(summary.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", summary.Errors)
+	}
+
+	got := make(map[string]string)
+	for _, result := range results {
+		got[result.RelPath] = result.Operation
+
+		if result.Operation == "typechange" && (result.IsDir != (result.RelPath == "x") || len(result.Chunks) != 0) {
+			t.Errorf("%s: unexpected typechange result %+v", result.RelPath, result)
+		}
+	}
+
+	want := map[string]string{
+		"x":            "typechange",
+		"x/inside.txt": "added",
+		"y":            "typechange",
+		"y/inside.txt": "deleted",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+
+	if summary.TypeChangedFiles != 2 {
+		t.Errorf("expected 2 type changed files, got %d", summary.TypeChangedFiles)
+	}
+
+	// Without Lstat, the directory replaced by a file is found when reading it fails
+	engine.SetFileSystem(&statCountingFileSystem{FileSystem: OSFileSystem{}})
+
+	entries, err := engine.ListChanges(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("ListChanges returned an error: %v", err)
+	}
+
+	got = make(map[string]string)
+	for _, entry := range entries {
+		got[entry.Path] = entry.Operation
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ListChanges mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// openCountingFileSystem records the largest number of files open at once, keeping each
+// file open a little while so that concurrent reads overlap.
+type openCountingFileSystem struct {
+	FileSystem
+	open, maxOpen atomic.Int64
+}
+
+func (o *openCountingFileSystem) Open(name string) (io.ReadCloser, error) {
+	file, err := o.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	open := o.open.Add(1)
+	for {
+		max := o.maxOpen.Load()
+		if open <= max || o.maxOpen.CompareAndSwap(max, open) {
+			break
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+
+	return &countedFile{ReadCloser: file, open: &o.open}, nil
+}
+
+type countedFile struct {
+	io.ReadCloser
+	open *atomic.Int64
+}
+
+func (c *countedFile) Close() error {
+	c.open.Add(-1)
+	return c.ReadCloser.Close()
+}
+
+func TestCompareDirs_IOConcurrency(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles := make(map[string]string)
+	newFiles := make(map[string]string)
+
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("file%02d.txt", i)
+		oldFiles[name] = fmt.Sprintf("file %d\n", i)
+		newFiles[name] = fmt.Sprintf("file %d, modified\n", i)
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	for _, ioConcurrency := range []int{1, 3} {
+		config := DefaultConfig()
+		config.Concurrency = 8
+		config.IOConcurrency = ioConcurrency
+
+		fsys := &openCountingFileSystem{FileSystem: OSFileSystem{}}
+
+		engine := newTestEngine(t, config)
+		engine.SetFileSystem(fsys)
+
+		summary, _, err := engine.CompareDirs(oldDir, newDir)
+		if err != nil {
+			t.Fatalf("CompareDirs returned an error: %v", err)
+		}
+
+		if summary.ModifiedFiles != 40 {
+			t.Errorf("expected 40 modified files, got %d", summary.ModifiedFiles)
+		}
+
+		if got := fsys.maxOpen.Load(); got > int64(ioConcurrency) {
+			t.Errorf("IOConcurrency %d: expected at most %d files open at once, got %d", ioConcurrency, ioConcurrency, got)
+		}
+	}
+}
+
+// TestCompareDirs_ConcurrentBinary compares binary files concurrently with the shared
+// default handler, for the race detector to check that comparisons don't share its state.
+func TestCompareDirs_ConcurrentBinary(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	rng := rand.New(rand.NewSource(1))
+	oldFiles := make(map[string]string)
+	newFiles := make(map[string]string)
+
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("file%02d.bin", i)
+
+		// Random and repetitive data tune the handler differently
+		data := make([]byte, 16*1024)
+		if i%2 == 0 {
+			rng.Read(data)
+		} else {
+			for j := range data {
+				data[j] = byte(j / 64 % 4)
+			}
+		}
+
+		changed := append([]byte(nil), data...)
+		if i%4 == 0 {
+			// Appended data takes the contiguous fast path
+			changed = append(changed, "appended"...)
+		} else {
+			for j := 100; j < len(changed); j += 1500 {
+				changed[j] ^= 0xff
+			}
+		}
+
+		oldFiles[name], newFiles[name] = string(data), string(changed)
+	}
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	config := DefaultConfig()
+	config.Concurrency = 8
+
+	engine := newTestEngine(t, config)
+	handler := engine.getDefaultHandler().(*GenericBinaryHandler)
+	minMatchLength := handler.MinMatchLength
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if summary.ModifiedFiles != len(newFiles) {
+		t.Fatalf("expected %d modified files, got %d", len(newFiles), summary.ModifiedFiles)
+	}
+
+	if handler.MinMatchLength != minMatchLength {
+		t.Errorf("expected the shared handler to keep its minimum match length %d, got %d", minMatchLength, handler.MinMatchLength)
+	}
+
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := engine.ApplyResults(oldDir, outDir, results); err != nil {
+		t.Fatalf("ApplyResults returned an error: %v", err)
+	}
+
+	for name, want := range newFiles {
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+
+		if string(data) != want {
+			t.Errorf("%s: patched data does not match new data", name)
+		}
+	}
+}
+
+func BenchmarkCompareDirs_ManyFiles(b *testing.B) {
+	oldDir, newDir := b.TempDir(), b.TempDir()
+
+	// Many small files of which a tenth is modified
+	for i := 0; i < 5000; i++ {
+		name := filepath.Join(fmt.Sprintf("dir%02d", i%50), fmt.Sprintf("file%04d.txt", i))
+
+		for _, dir := range []string{oldDir, newDir} {
+			content := name
+			if dir == newDir && i%10 == 0 {
+				content += " modified"
+			}
+
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+				b.Fatalf("Failed to create directory: %v", err)
+			}
+
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				b.Fatalf("Failed to write file: %v", err)
+			}
+		}
+	}
+
+	engine, err := NewDiffEngine(DefaultConfig())
+	if err != nil {
+		b.Fatalf("Failed to create diff engine: %v", err)
+	}
+
+	defer os.Remove(testEngineLogFile)
+	defer engine.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := engine.CompareDirs(oldDir, newDir); err != nil {
+			b.Fatalf("CompareDirs returned an error: %v", err)
+		}
+	}
+}
+
+func TestCompareGlob(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"main.go":            "package main\n",
+		"pkg/util.go":        "package pkg\n",
+		"pkg/deep/gone.go":   "package deep\n",
+		"README.md":          "old readme\n",
+		"pkg/notes.txt":      "old notes\n",
+		"pkg/removed.txt":    "removed\n",
+		"pkg/deep/same.go":   "package deep\n",
+		"pkg/deep/data.json": "{}\n",
+	})
+
+	writeTestTree(t, newDir, map[string]string{
+		"main.go":            "package main\n\nfunc main() {}\n",
+		"pkg/util.go":        "package pkg\n\nfunc Util() {}\n",
+		"pkg/added.go":       "package pkg\n",
+		"README.md":          "new readme\n",
+		"pkg/notes.txt":      "new notes\n",
+		"pkg/deep/same.go":   "package deep\n",
+		"pkg/deep/data.json": "{\"changed\": true}\n",
+	})
+
+	results, err := newTestEngine(t, DefaultConfig()).CompareGlob(oldDir, newDir, "**/*.go")
+	if err != nil {
+		t.Fatalf("CompareGlob returned an error: %v", err)
+	}
+
+	got := make([]string, 0, len(results))
+	for _, result := range results {
+		got = append(got, result.Operation+" "+result.RelPath)
+	}
+
+	sort.Strings(got)
+
+	want := []string{
+		"added pkg/added.go",
+		"deleted pkg/deep/gone.go",
+		"modified main.go",
+		"modified pkg/util.go",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := newTestEngine(t, DefaultConfig()).CompareGlob(oldDir, newDir, "[*.go"); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestCompareDirs_CaseInsensitivePaths(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"a/File.txt":    "old content\n",
+		"Docs/same.txt": "same\n",
+		"removed.txt":   "removed\n",
+	})
+
+	writeTestTree(t, newDir, map[string]string{
+		"a/file.txt":    "new content\n",
+		"docs/SAME.txt": "same\n",
+	})
+
+	tests := []struct {
+		name            string
+		caseInsensitive bool
+		want            []string
+	}{
+		{
+			name: "Case sensitive",
+			want: []string{
+				"added a/file.txt",
+				"added docs/SAME.txt",
+				"deleted Docs/same.txt",
+				"deleted a/File.txt",
+				"deleted removed.txt",
+			},
+		},
+		{
+			name:            "Case insensitive",
+			caseInsensitive: true,
+			want: []string{
+				"deleted removed.txt",
+				"modified a/file.txt",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.CaseInsensitivePaths = tt.caseInsensitive
+
+			_, results, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+			if err != nil {
+				t.Fatalf("CompareDirs returned an error: %v", err)
+			}
+
+			got := make([]string, 0, len(results))
+			for _, result := range results {
+				got = append(got, result.Operation+" "+result.RelPath)
+			}
+
+			sort.Strings(got)
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("results mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+//go:build unix
+
+package diff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCompareDirs_SkipsNamedPipes(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"file.txt": "old\n"})
+	writeTestTree(t, newDir, map[string]string{"file.txt": "new\n"})
+
+	for _, path := range []string{
+		filepath.Join(oldDir, "pipe"),
+		filepath.Join(newDir, "pipe"),
+		filepath.Join(oldDir, "old-pipe"),
+		filepath.Join(newDir, "new-pipe"),
+	} {
+		if err := syscall.Mkfifo(path, 0644); err != nil {
+			t.Skipf("Failed to create named pipe: %v", err)
+		}
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	type compareResult struct {
+		results []DiffResult
+		err     error
+	}
+
+	done := make(chan compareResult, 1)
+	go func() {
+		_, results, err := engine.CompareDirs(oldDir, newDir)
+		done <- compareResult{results: results, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("CompareDirs returned an error: %v", res.err)
+		}
+
+		if len(res.results) != 1 || res.results[0].Path != "file.txt" {
+			t.Errorf("expected only file.txt to be reported, got %+v", res.results)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("CompareDirs blocked on a named pipe")
+	}
+}
+
+func TestCompareDirs_PermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("File permissions are not enforced for root")
+	}
+
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"file.txt": "old\n", "secret.txt": "old secret\n"})
+	writeTestTree(t, newDir, map[string]string{"file.txt": "new\n", "secret.txt": "new secret\n"})
+
+	secret := filepath.Join(newDir, "secret.txt")
+	if err := os.Chmod(secret, 0000); err != nil {
+		t.Fatalf("Failed to change permissions: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(secret, 0644) })
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Path != "file.txt" {
+		t.Errorf("expected only file.txt to be reported, got %+v", results)
+	}
+
+	if len(summary.Errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(summary.Errors), summary.Errors)
+	}
+
+	if fileErr := summary.Errors[0]; fileErr.Path != "secret.txt" || fileErr.Category != ErrorCategoryPermission {
+		t.Errorf("expected a permission error for secret.txt, got %s error %v", fileErr.Category, fileErr)
+	}
+}
+
+func TestCompareDirs_PreserveLinks(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles := map[string]string{
+		"a.txt":      "shared\n",
+		"b.txt":      "shared\n",
+		"same/x.txt": "linked\n",
+	}
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, map[string]string{"a.txt": "shared, modified\n", "same/x.txt": "linked\n"})
+
+	// Links already present in the old tree are reported again, as they are applied to
+	// separate copies of the old files
+	if err := os.Link(filepath.Join(oldDir, "same", "x.txt"), filepath.Join(oldDir, "same", "y.txt")); err != nil {
+		t.Skipf("Failed to create hard link: %v", err)
+	}
+
+	for _, link := range [][2]string{{"a.txt", "b.txt"}, {"same/x.txt", "same/y.txt"}} {
+		if err := os.Link(filepath.Join(newDir, link[0]), filepath.Join(newDir, link[1])); err != nil {
+			t.Fatalf("Failed to create hard link: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(newDir, "cache", "empty"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.PreserveLinks = true
+
+	_, results, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	got := make(map[string]DiffResult)
+	for _, result := range results {
+		got[result.RelPath] = result
+	}
+
+	if len(got) != 4 {
+		t.Errorf("expected 4 results, got %+v", results)
+	}
+
+	if got["a.txt"].Operation != "modified" {
+		t.Errorf("expected a.txt to be modified, got %q", got["a.txt"].Operation)
+	}
+
+	if link := got["b.txt"]; link.Operation != "linked" || link.LinkTo != "a.txt" || len(link.Chunks) != 0 {
+		t.Errorf("expected b.txt to be linked to a.txt without chunks, got %+v", link)
+	}
+
+	if link := got["same/y.txt"]; link.Operation != "linked" || link.LinkTo != "same/x.txt" {
+		t.Errorf("expected same/y.txt to be linked to same/x.txt, got %+v", link)
+	}
+
+	if dir := got["cache/empty"]; dir.Operation != "added" || !dir.IsDir {
+		t.Errorf("expected the empty directory to be added, got %+v", dir)
+	}
+
+	// Apply onto a copy of the old tree whose file content was already updated
+	outDir := t.TempDir()
+	writeTestTree(t, outDir, oldFiles)
+
+	if err := os.WriteFile(filepath.Join(outDir, "a.txt"), []byte("shared, modified\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := ApplyLinks(outDir, results); err != nil {
+		t.Fatalf("ApplyLinks returned an error: %v", err)
+	}
+
+	a, err := os.Stat(filepath.Join(outDir, "a.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat a.txt: %v", err)
+	}
+
+	b, err := os.Stat(filepath.Join(outDir, "b.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat b.txt: %v", err)
+	}
+
+	if !os.SameFile(a, b) {
+		t.Error("expected b.txt to be a hard link to a.txt")
+	}
+
+	if info, err := os.Stat(filepath.Join(outDir, "cache", "empty")); err != nil || !info.IsDir() {
+		t.Errorf("expected the empty directory to be created, got %v", err)
+	}
+}
+
+func TestApplyResults_KeepsLinks(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"a.txt": "old content\n"})
+	writeTestTree(t, newDir, map[string]string{"a.txt": "new content\n"})
+
+	// The files are linked in both trees, a.txt is modified and b.txt linked to it
+	for _, dir := range []string{oldDir, newDir} {
+		if err := os.Link(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")); err != nil {
+			t.Skipf("Failed to create hard link: %v", err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.PreserveLinks = true
+	engine := newTestEngine(t, config)
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := engine.ApplyResults(oldDir, outDir, results); err != nil {
+		t.Fatalf("ApplyResults returned an error: %v", err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+
+		if string(data) != "new content\n" {
+			t.Errorf("%s = %q, want %q", name, data, "new content\n")
+		}
+	}
+
+	a, errA := os.Stat(filepath.Join(outDir, "a.txt"))
+	b, errB := os.Stat(filepath.Join(outDir, "b.txt"))
+	if errA != nil || errB != nil || !os.SameFile(a, b) {
+		t.Errorf("expected b.txt to stay a hard link to a.txt")
+	}
+}
+
+func TestApplyPatchArchive_UnlinkedFile(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"a.bin": "old content"})
+	writeTestTree(t, newDir, map[string]string{"a.bin": "new content", "b.bin": "old content"})
+
+	// b.bin is a link to a.bin only in the old tree
+	if err := os.Link(filepath.Join(oldDir, "a.bin"), filepath.Join(oldDir, "b.bin")); err != nil {
+		t.Skipf("Failed to create hard link: %v", err)
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err := WritePatchArchive(&archive, summary, results); err != nil {
+		t.Fatalf("WritePatchArchive returned an error: %v", err)
+	}
+
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := ApplyPatchArchive(oldDir, outDir, &archive); err != nil {
+		t.Fatalf("ApplyPatchArchive returned an error: %v", err)
+	}
+
+	for name, want := range map[string]string{"a.bin": "new content", "b.bin": "old content"} {
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+
+	// The base tree is left untouched
+	if data, err := os.ReadFile(filepath.Join(oldDir, "b.bin")); err != nil || string(data) != "old content" {
+		t.Errorf("expected the base b.bin to keep its content, got %q, %v", data, err)
+	}
+}
+
+func TestCompareDirs_TypeChangeSymlink(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"target.txt": "target\n", "file.txt": "file\n"})
+	writeTestTree(t, newDir, map[string]string{"target.txt": "target\n", "link.txt": "file\n"})
+
+	// file.txt becomes a symbolic link, and link.txt stops being one
+	if err := os.Symlink("target.txt", filepath.Join(oldDir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("target.txt", filepath.Join(newDir, "file.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, results, err := newTestEngine(t, DefaultConfig()).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if summary.TypeChangedFiles != 2 || len(results) != 2 {
+		t.Fatalf("expected 2 type changes, got %d in %+v", summary.TypeChangedFiles, results)
+	}
+
+	for _, result := range results {
+		if result.Operation != "typechange" {
+			t.Errorf("%s: expected a typechange, got %s", result.RelPath, result.Operation)
+		}
+	}
+}
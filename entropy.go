@@ -0,0 +1,80 @@
+package diff
+
+import (
+	"io"
+	"math"
+)
+
+// EntropyAccumulator computes the byte entropy of data written to it in pieces,
+// so the entropy of a stream can be computed without buffering it.
+type EntropyAccumulator struct {
+	counts [256]int64
+	total  int64
+}
+
+// Makesure EntropyAccumulator implements the io.Writer interface
+var _ io.Writer = &EntropyAccumulator{}
+
+// Write adds the bytes of p to the accumulated data. It never fails.
+func (a *EntropyAccumulator) Write(p []byte) (int, error) {
+	for _, b := range p {
+		a.counts[b]++
+	}
+
+	a.total += int64(len(p))
+
+	return len(p), nil
+}
+
+// Entropy returns the Shannon entropy of the accumulated bytes, normalized to
+// between 0 for constant data and 1 for uniformly distributed bytes.
+func (a *EntropyAccumulator) Entropy() float64 {
+	if a.total == 0 {
+		return 0
+	}
+
+	entropy := 0.0
+	total := float64(a.total)
+	for _, count := range a.counts {
+		if count == 0 {
+			continue
+		}
+
+		p := float64(count) / total
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy / 8.0
+}
+
+// Compressibility returns the compressibility score of the accumulated bytes,
+// see EstimateCompressibility.
+func (a *EntropyAccumulator) Compressibility() float64 {
+	if a.total == 0 {
+		return 0
+	}
+
+	return 1 - a.Entropy()
+}
+
+// EstimateCompressibility returns a score between 0 and 1 of how well data compresses,
+// higher meaning more compressible, derived from its byte entropy. It is cheap compared
+// to compressing, but ignores repetitions of byte sequences which a compressor exploits.
+// Empty data scores 0.
+func EstimateCompressibility(data []byte) float64 {
+	var acc EntropyAccumulator
+	acc.Write(data)
+
+	return acc.Compressibility()
+}
+
+// EstimateCompressibilityReader returns the compressibility score of the data read from r,
+// see EstimateCompressibility.
+func EstimateCompressibilityReader(r io.Reader) (float64, error) {
+	var acc EntropyAccumulator
+	if _, err := io.Copy(&acc, r); err != nil {
+		return 0, err
+	}
+
+	return acc.Compressibility(), nil
+}
@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEstimateCompressibility(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+	constant := bytes.Repeat([]byte{'a'}, 4096)
+
+	scores := make([]float64, 0, 3)
+	for _, data := range [][]byte{random, text, constant} {
+		score := EstimateCompressibility(data)
+		if score < 0 || score > 1 {
+			t.Fatalf("expected a score between 0 and 1, got %f", score)
+		}
+
+		streamed, err := EstimateCompressibilityReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("EstimateCompressibilityReader returned an error: %v", err)
+		}
+
+		if math.Abs(streamed-score) > 1e-9 {
+			t.Errorf("expected the streamed score %f to equal %f", streamed, score)
+		}
+
+		scores = append(scores, score)
+	}
+
+	if !(scores[0] < scores[1] && scores[1] < scores[2]) {
+		t.Errorf("expected random < text < constant, got %v", scores)
+	}
+
+	if scores[0] > 0.01 {
+		t.Errorf("expected random data to be almost incompressible, got %f", scores[0])
+	}
+
+	if scores[2] != 1 {
+		t.Errorf("expected constant data to score 1, got %f", scores[2])
+	}
+
+	if score := EstimateCompressibility(nil); score != 0 {
+		t.Errorf("expected empty data to score 0, got %f", score)
+	}
+}
+
+func TestEntropyAccumulator(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 100)
+
+	var acc EntropyAccumulator
+	for i := 0; i < len(data); i += 7 {
+		acc.Write(data[i:min(i+7, len(data))])
+	}
+
+	// 16 equally frequent symbols carry 4 of 8 bits
+	if got := acc.Entropy(); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("expected entropy 0.5, got %f", got)
+	}
+
+	if got, want := acc.Entropy(), NewGenericBinaryHandler().calculateEntropy(data); got != want {
+		t.Errorf("expected the accumulated entropy %f to equal calculateEntropy %f", got, want)
+	}
+}
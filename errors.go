@@ -0,0 +1,30 @@
+package diff
+
+import "errors"
+
+// ErrChecksumMismatch is returned when the data of a chunk does not match its checksum.
+var ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+
+// ErrPatchVerification is returned when applying the chunks of a file to its old
+// version does not reproduce the new version.
+var ErrPatchVerification = errors.New("patch verification failed")
+
+// ErrFileTooLarge is returned when a file exceeds Configuration.MaxFileSizeBytes.
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrUnsupportedType is returned when Configuration.TypeOverrides assigns a file a type
+// without a registered handler.
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// ErrPatchOutOfRange is returned when a chunk lies outside the data it is applied to,
+// or before the end of the previous chunk.
+var ErrPatchOutOfRange = errors.New("patch chunk out of range")
+
+// ErrNotText is returned by the text handler when the data it compares is binary.
+var ErrNotText = errors.New("data is not text")
+
+// ErrNotSupported is returned by a handler when the data is not in the format it handles.
+var ErrNotSupported = errors.New("format not supported by handler")
+
+// ErrOldDataOmitted is returned when reversing a chunk whose original bytes were not stored.
+var ErrOldDataOmitted = errors.New("chunk old data omitted")
@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrors_Patch(t *testing.T) {
+	original := []byte("short original")
+
+	outOfRange := []DiffChunk{{Offset: 10, OldData: []byte("far beyond the end"), NewData: []byte("x"), Op: OpReplace}}
+	beyondEnd := []DiffChunk{{Offset: 100, NewData: []byte("x"), Op: OpInsert}}
+	overlapping := []DiffChunk{
+		{Offset: 6, OldData: []byte("original"), NewData: []byte("x"), Op: OpReplace},
+		{Offset: 2, NewData: []byte("y"), Op: OpInsert},
+	}
+	corrupt := []DiffChunk{{Offset: 0, OldData: []byte("short"), NewData: []byte("long"), Op: OpReplace, Checksum: 1}}
+
+	patchers := []struct {
+		name  string
+		patch func(chunks []DiffChunk) error
+	}{
+		{name: "Text", patch: func(chunks []DiffChunk) error {
+			_, err := (&TextFileHandler{}).Patch(original, chunks)
+			return err
+		}},
+		{name: "Binary", patch: func(chunks []DiffChunk) error {
+			_, err := NewGenericBinaryHandler().Patch(original, chunks)
+			return err
+		}},
+		{name: "SQLite", patch: func(chunks []DiffChunk) error {
+			_, err := (&SQLiteHandler{}).Patch(original, chunks)
+			return err
+		}},
+		{name: "ApplyChunks", patch: func(chunks []DiffChunk) error {
+			selected := make([]int, len(chunks))
+			for i := range selected {
+				selected[i] = i
+			}
+
+			_, err := ApplyChunks(original, chunks, selected)
+			return err
+		}},
+		{name: "PatchFile", patch: func(chunks []DiffChunk) error {
+			dir := t.TempDir()
+
+			path := filepath.Join(dir, "original")
+			if err := os.WriteFile(path, original, 0644); err != nil {
+				t.Fatalf("Failed to write original file: %v", err)
+			}
+
+			return NewGenericBinaryHandler().PatchFile(path, chunks, filepath.Join(dir, "patched"))
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		chunks []DiffChunk
+		want   error
+	}{
+		{name: "Old data beyond the end", chunks: outOfRange, want: ErrPatchOutOfRange},
+		{name: "Offset beyond the end", chunks: beyondEnd, want: ErrPatchOutOfRange},
+		{name: "Overlapping chunks", chunks: overlapping, want: ErrPatchOutOfRange},
+		{name: "Checksum mismatch", chunks: corrupt, want: ErrChecksumMismatch},
+	}
+
+	for _, patcher := range patchers {
+		for _, tt := range tests {
+			t.Run(patcher.name+"/"+tt.name, func(t *testing.T) {
+				if err := patcher.patch(tt.chunks); !errors.Is(err, tt.want) {
+					t.Errorf("expected %v, got %v", tt.want, err)
+				}
+			})
+		}
+	}
+}
+
+func TestErrors_Engine(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"records.dat": "old\n", "large.txt": "old\n"})
+	writeTestTree(t, newDir, map[string]string{"records.dat": "new\n", "large.txt": "a much larger new file\n"})
+
+	config := DefaultConfig()
+	config.TypeOverrides = map[string]string{"*.dat": "spreadsheet"}
+	config.MaxFileSizeBytes = 8
+
+	engine := newTestEngine(t, config)
+
+	_, err := engine.CompareFilesIfChanged(filepath.Join(oldDir, "large.txt"), filepath.Join(newDir, "large.txt"))
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	_, err = engine.CompareFilesIfChanged(filepath.Join(oldDir, "records.dat"), filepath.Join(newDir, "records.dat"))
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected ErrUnsupportedType, got %v", err)
+	}
+
+	summary, _, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(summary.Errors) != 1 || !errors.Is(summary.Errors[0], ErrUnsupportedType) {
+		t.Errorf("expected a single ErrUnsupportedType file error, got %v", summary.Errors)
+	}
+}
@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem is the interface through which the engine accesses files.
+// It allows comparing trees which are not stored on the local disk.
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	Walk(root string, fn filepath.WalkFunc) error
+}
+
+// OSFileSystem is a FileSystem backed by the local disk.
+type OSFileSystem struct{}
+
+// Makesure OSFileSystem implements the FileSystem interface
+var _ FileSystem = OSFileSystem{}
+
+// Open opens the named file for reading.
+func (OSFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// Stat returns the FileInfo of the named file.
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir returns the entries of the named directory.
+func (OSFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Walk walks the file tree rooted at root.
+func (OSFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+// Lstat returns the FileInfo of the named file, describing a symbolic link itself
+// rather than the file it points to.
+func (OSFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// lstatFileSystem is implemented by file systems which can describe symbolic links.
+type lstatFileSystem interface {
+	Lstat(name string) (os.FileInfo, error)
+}
+
+// lstat returns the FileInfo of a file without following symbolic links, and false when
+// the file system does not implement Lstat or the file cannot be described.
+func lstat(fsys FileSystem, name string) (os.FileInfo, bool) {
+	l, ok := fsys.(lstatFileSystem)
+	if !ok {
+		return nil, false
+	}
+
+	info, err := l.Lstat(name)
+	return info, err == nil
+}
+
+// ioFileSystem adapts an fs.FS to the FileSystem interface.
+type ioFileSystem struct {
+	fsys fs.FS
+}
+
+// FromFS returns a FileSystem reading from fsys, such as an embed.FS or fstest.MapFS.
+// Paths are converted to the slash-separated form expected by fs.FS.
+func FromFS(fsys fs.FS) FileSystem {
+	return &ioFileSystem{fsys: fsys}
+}
+
+// Open opens the named file for reading.
+func (f *ioFileSystem) Open(name string) (io.ReadCloser, error) {
+	return f.fsys.Open(fsPath(name))
+}
+
+// Stat returns the FileInfo of the named file.
+func (f *ioFileSystem) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, fsPath(name))
+}
+
+// ReadDir returns the entries of the named directory.
+func (f *ioFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return fs.ReadDir(f.fsys, fsPath(name))
+}
+
+// Walk walks the file tree rooted at root, calling fn with OS-style paths.
+func (f *ioFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.WalkDir(f.fsys, fsPath(root), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(filepath.FromSlash(name), nil, err)
+		}
+
+		info, err := d.Info()
+
+		return fn(filepath.FromSlash(name), info, err)
+	})
+}
+
+// fsPath converts an OS-style path to a path accepted by fs.FS.
+func fsPath(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+
+	return strings.TrimPrefix(name, "/")
+}
@@ -0,0 +1,102 @@
+package diff
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompareTrees_MapFS(t *testing.T) {
+	oldFS := fstest.MapFS{
+		"docs/readme.txt": {Data: []byte("hello\nworld\n")},
+		"docs/removed.md": {Data: []byte("gone\n")},
+		"bin/tool":        {Data: []byte("binary data")},
+	}
+	newFS := fstest.MapFS{
+		"docs/readme.txt": {Data: []byte("hello\nthere\n")},
+		"docs/added.md":   {Data: []byte("new\n")},
+		"bin/tool":        {Data: []byte("binary data")},
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	summary, results, err := engine.compareTrees(FromFS(oldFS), FromFS(newFS), []string{"."}, ".")
+	if err != nil {
+		t.Fatalf("compareTrees returned an error: %v", err)
+	}
+
+	if summary.AddedFiles != 1 || summary.ModifiedFiles != 1 || summary.DeletedFiles != 1 {
+		t.Errorf("expected 1 added, 1 modified and 1 deleted file, got %d, %d and %d",
+			summary.AddedFiles, summary.ModifiedFiles, summary.DeletedFiles)
+	}
+
+	if len(results) != 3 {
+		t.Errorf("expected 3 results, got %d", len(results))
+	}
+}
+
+func TestCompareDirs_SetFileSystem(t *testing.T) {
+	fsys := fstest.MapFS{
+		"old/notes.txt": {Data: []byte("one\ntwo\n")},
+		"new/notes.txt": {Data: []byte("one\n2\n")},
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+	engine.SetFileSystem(FromFS(fsys))
+
+	summary, results, err := engine.CompareDirs("old", "new")
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if summary.ModifiedFiles != 1 || len(results) != 1 {
+		t.Fatalf("expected a single modified file, got %+v", results)
+	}
+
+	if results[0].FileType != "text" {
+		t.Errorf("expected file type text, got %s", results[0].FileType)
+	}
+}
+
+func TestCompareFS(t *testing.T) {
+	oldFS := fstest.MapFS{
+		"assets/app.js":     {Data: []byte("console.log('v1');\n")},
+		"assets/removed.js": {Data: []byte("gone\n")},
+		"index.html":        {Data: []byte("<html></html>\n")},
+		"img/logo.bin":      {Data: []byte("\x00\x01\x02\x03")},
+	}
+	newFS := fstest.MapFS{
+		"assets/app.js":   {Data: []byte("console.log('v2');\n")},
+		"assets/added.js": {Data: []byte("added\n")},
+		"index.html":      {Data: []byte("<html></html>\n")},
+		"img/logo.bin":    {Data: []byte("\x00\x01\x02\x04")},
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	summary, results, err := engine.CompareFS(oldFS, newFS)
+	if err != nil {
+		t.Fatalf("CompareFS returned an error: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, result := range results {
+		got[result.RelPath] = result.Operation
+	}
+
+	want := map[string]string{
+		"assets/app.js":     "modified",
+		"assets/added.js":   "added",
+		"assets/removed.js": "deleted",
+		"img/logo.bin":      "modified",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+
+	if summary.TotalFiles != len(want) || summary.ProcessedBytes == 0 {
+		t.Errorf("expected %d files with processed bytes, got %d files and %d bytes", len(want), summary.TotalFiles, summary.ProcessedBytes)
+	}
+}
@@ -2,4 +2,8 @@ module github.com/achu-1612/diff
 
 go 1.23.2
 
-require github.com/google/go-cmp v0.6.0
+require (
+	github.com/dsnet/compress v0.0.1
+	github.com/google/go-cmp v0.6.0
+	golang.org/x/text v0.24.0
+)
@@ -1,8 +1,27 @@
 package diff
 
 // FileHandler is an interface that defines the methods which can be used to compare and patch files.
+// The engine compares several files at once with the same handler, so Compare must be safe
+// for concurrent use.
 type FileHandler interface {
 	Compare(old, new []byte) ([]DiffChunk, error)
 	Patch(original []byte, chunks []DiffChunk) ([]byte, error)
 	GetFileType() string
 }
+
+// statefulHandler is implemented by the handlers which record state while comparing,
+// such as the statistics of GenericBinaryHandler, and so can't compare several files at once.
+type statefulHandler interface {
+	// forComparison returns a handler with the same configuration and its own state.
+	forComparison() FileHandler
+}
+
+// compareWith compares old and new with handler, or with its own copy of handler when it
+// records state, so that concurrent comparisons don't share it.
+func compareWith(handler FileHandler, old, new []byte) ([]DiffChunk, error) {
+	if stateful, ok := handler.(statefulHandler); ok {
+		handler = stateful.forComparison()
+	}
+
+	return handler.Compare(old, new)
+}
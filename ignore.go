@@ -0,0 +1,151 @@
+package diff
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a gitignore-style pattern read from an ignore file.
+type ignoreRule struct {
+	pattern  string // Slash-separated pattern, without negation, anchoring or trailing slash
+	base     string // Slash-separated directory of the ignore file relative to the root, "" for the root
+	negate   bool   // The pattern re-includes paths excluded by earlier patterns
+	dirOnly  bool   // The pattern only matches directories
+	anchored bool   // The pattern is matched against the whole path relative to base
+}
+
+// ignoreMatcher decides whether paths are ignored according to the rules of ignore files.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// parseIgnore parses gitignore-style patterns: blank lines and lines starting with # are
+// skipped, ! negates a pattern, a trailing / matches directories only, and a pattern
+// containing a / other than a trailing one is matched relative to base rather than
+// against the name of the path at any depth. ** matches any number of directories.
+func parseIgnore(r io.Reader, base string) ([]ignoreRule, error) {
+	rules := make([]ignoreRule, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// Escaped leading # or !
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// add adds the rules of an ignore file, taking precedence over the earlier rules.
+func (m *ignoreMatcher) add(rules []ignoreRule) {
+	m.rules = append(m.rules, rules...)
+}
+
+// ignored reports whether the path relative to the root is ignored.
+// The last matching rule decides, so negated rules re-include paths.
+func (m *ignoreMatcher) ignored(relPath string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+
+	relPath = filepath.ToSlash(relPath)
+
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.match(relPath, isDir) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// match reports whether the rule matches the slash-separated path relative to the root.
+func (r ignoreRule) match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+
+		relPath = strings.TrimPrefix(relPath, r.base+"/")
+	}
+
+	if !r.anchored {
+		return matchGlob(r.pattern, path.Base(relPath))
+	}
+
+	return matchGlob(r.pattern, relPath)
+}
+
+// matchGlob matches a slash-separated path against a pattern of path.Match, in which a
+// ** element matches any number of path elements.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+
+	return matchElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchElements matches path elements against pattern elements, which may include **.
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,131 @@
+package diff
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	rules, err := parseIgnore(strings.NewReader(`
+# Build output
+*.log
+!important.log
+build/
+/root.txt
+docs/*.tmp
+**/cache/**
+\#hash.txt
+`), "")
+	if err != nil {
+		t.Fatalf("parseIgnore returned an error: %v", err)
+	}
+
+	nested, err := parseIgnore(strings.NewReader("*.bak\n!keep.log\n"), "sub")
+	if err != nil {
+		t.Fatalf("parseIgnore returned an error: %v", err)
+	}
+
+	matcher := &ignoreMatcher{}
+	matcher.add(rules)
+	matcher.add(nested)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "app.log", want: true},
+		{path: "deep/nested/app.log", want: true},
+		{path: "important.log", want: false},
+		{path: "deep/important.log", want: false},
+		{path: "build", isDir: true, want: true},
+		{path: "build", isDir: false, want: false},
+		{path: "root.txt", want: true},
+		{path: "sub/root.txt", want: false},
+		{path: "docs/a.tmp", want: true},
+		{path: "other/docs/a.tmp", want: false},
+		{path: "a/cache/b/c.txt", want: true},
+		{path: "#hash.txt", want: true},
+		{path: "# Build output", want: false},
+		{path: "sub/file.bak", want: true},
+		{path: "file.bak", want: false},
+		{path: "sub/keep.log", want: false},
+		{path: "other/keep.log", want: true},
+		{path: "main.go", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matcher.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	var nilMatcher *ignoreMatcher
+	if nilMatcher.ignored("app.log", false) {
+		t.Error("expected a nil matcher to ignore nothing")
+	}
+}
+
+func TestCompareDirs_IgnoreFile(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"main.txt":          "old\n",
+		"debug.log":         "old debug\n",
+		"important.log":     "old important\n",
+		"build/out.txt":     "old output\n",
+		"build/gone.txt":    "gone\n",
+		"sub/notes.bak":     "old backup\n",
+		"sub/notes.txt":     "old notes\n",
+		"sub/deleted.bak":   "deleted backup\n",
+		"other/notes.bak":   "old other backup\n",
+		"ignored-patterns":  "old\n",
+		"sub/ignored-again": "old\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		".diffignore":       "# Logs\n*.log\n!important.log\n\nbuild/\n",
+		"sub/.diffignore":   "*.bak\n",
+		"main.txt":          "new\n",
+		"debug.log":         "new debug\n",
+		"important.log":     "new important\n",
+		"build/out.txt":     "new output\n",
+		"sub/notes.bak":     "new backup\n",
+		"sub/notes.txt":     "new notes\n",
+		"other/notes.bak":   "new other backup\n",
+		"ignored-patterns":  "new\n",
+		"sub/ignored-again": "new\n",
+	})
+
+	config := DefaultConfig()
+	config.IgnoreFile = ".diffignore"
+	config.IgnorePatterns = []string{"ignored-*", "*/ignored-*"}
+
+	engine := newTestEngine(t, config)
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	paths := make([]string, 0, len(results))
+	for _, result := range results {
+		paths = append(paths, result.Operation+" "+result.Path)
+	}
+	sort.Strings(paths)
+
+	want := []string{
+		"added .diffignore",
+		"added .diffignore",
+		"modified important.log",
+		"modified main.txt",
+		"modified notes.bak",
+		"modified notes.txt",
+	}
+
+	if diff := cmp.Diff(want, paths); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+}
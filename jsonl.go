@@ -0,0 +1,40 @@
+package diff
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonlSummary is the last line written by CompareDirsJSONL.
+type jsonlSummary struct {
+	Summary *DiffSummary
+}
+
+// CompareDirsJSONL compares two directories like CompareDirs, writing each result to w as
+// a line of JSON as soon as it is found, such as for jq or log pipelines, and a last line
+// holding the summary in a Summary field. Results are written one at a time in the order
+// they are found, with their chunks, as DedupContent does not apply to them. After a write
+// fails the comparison completes without writing, and the write error is returned.
+func (e *DiffEngine) CompareDirsJSONL(oldDir, newDir string, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+
+	var writeErr error
+	emit := func(result DiffResult) {
+		if writeErr == nil {
+			writeErr = encoder.Encode(result)
+		}
+	}
+
+	fsys := e.getFileSystem()
+
+	summary, _, err := e.compareTreesWith(fsys, fsys, []string{oldDir}, newDir, e.compareFiles, nil, emit)
+	if err != nil {
+		return err
+	}
+
+	if writeErr != nil {
+		return writeErr
+	}
+
+	return encoder.Encode(jsonlSummary{Summary: summary})
+}
@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompareDirsJSONL(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"same.txt":     "same\n",
+		"changed.txt":  "old\n",
+		"removed.txt":  "removed\n",
+		"dir/deep.bin": "old binary",
+	})
+
+	writeTestTree(t, newDir, map[string]string{
+		"same.txt":     "same\n",
+		"changed.txt":  "new\n",
+		"added.txt":    "added\n",
+		"dir/deep.bin": "new binary",
+	})
+
+	config := DefaultConfig()
+	config.Concurrency = 4
+
+	var buf bytes.Buffer
+	if err := newTestEngine(t, config).CompareDirsJSONL(oldDir, newDir, &buf); err != nil {
+		t.Fatalf("CompareDirsJSONL returned an error: %v", err)
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	if len(lines) == 0 {
+		t.Fatal("expected output lines")
+	}
+
+	var got []string
+	for i, line := range lines[:len(lines)-1] {
+		var result DiffResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			t.Fatalf("line %d is not a valid result: %v", i, err)
+		}
+
+		got = append(got, result.Operation+" "+result.RelPath)
+	}
+
+	sort.Strings(got)
+
+	want := []string{"added added.txt", "deleted removed.txt", "modified changed.txt", "modified dir/deep.bin"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+
+	var last jsonlSummary
+	if err := json.Unmarshal(lines[len(lines)-1], &last); err != nil || last.Summary == nil {
+		t.Fatalf("expected a summary line, got %s (error %v)", lines[len(lines)-1], err)
+	}
+
+	if last.Summary.TotalFiles != len(want) {
+		t.Errorf("expected %d files in the summary, got %d", len(want), last.Summary.TotalFiles)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestCompareDirsJSONL_WriteError(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"file.txt": "old\n"})
+	writeTestTree(t, newDir, map[string]string{"file.txt": "new\n"})
+
+	if err := newTestEngine(t, DefaultConfig()).CompareDirsJSONL(oldDir, newDir, failingWriter{}); err == nil {
+		t.Error("expected the write error")
+	}
+}
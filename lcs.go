@@ -0,0 +1,16 @@
+package diff
+
+import "bytes"
+
+// splitLines splits data into lines, keeping the trailing newline of each line
+// so that joining the lines reproduces data exactly.
+func splitLines(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte{'\n'})
+
+	// A trailing newline leaves an empty last element
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
@@ -0,0 +1,369 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// LineOp is an operation of a line edit script, on a single line.
+type LineOp struct {
+	Type     string // EditEqual, EditInsert or EditDelete
+	OldIndex int    // Index of the line in old, for EditEqual and EditDelete
+	NewIndex int    // Index of the line in new, for EditEqual and EditInsert
+}
+
+// LineDiffer computes the edit script transforming the old lines into the new ones, for
+// TextFileHandler.Differ. The operations cover every line of both inputs in order, so
+// that the equal and delete operations list the old lines, and the equal and insert
+// operations the new lines.
+type LineDiffer interface {
+	Diff(old, new [][]byte) []LineOp
+}
+
+// MyersDiffer is a LineDiffer finding a shortest edit script with Myers' algorithm, in
+// time proportional to the number of lines times the number of changed lines. It uses
+// the linear space variant, so its memory grows with the number of lines only.
+type MyersDiffer struct{}
+
+// Makesure MyersDiffer implements the LineDiffer interface
+var _ LineDiffer = MyersDiffer{}
+
+// budgetedDiffer is a LineDiffer which stops once the time budget of the comparison,
+// TextFileHandler.TimeBudget, is exceeded.
+type budgetedDiffer interface {
+	diffWithin(old, new [][]byte, budget *timeBudget) ([]LineOp, bool)
+}
+
+// Diff returns a shortest edit script transforming old into new.
+func (d MyersDiffer) Diff(old, new [][]byte) []LineOp {
+	ops, _ := d.diffWithin(old, new, nil)
+	return ops
+}
+
+// diffWithin is Diff within budget, reporting false when it is exceeded before a
+// shortest edit script is found.
+func (MyersDiffer) diffWithin(old, new [][]byte, budget *timeBudget) ([]LineOp, bool) {
+	m := &myers{
+		old:    old,
+		new:    new,
+		budget: budget,
+		ops:    make([]LineOp, 0, max(len(old), len(new))),
+	}
+
+	if !m.compare(0, len(old), 0, len(new)) {
+		return nil, false
+	}
+
+	return m.ops, true
+}
+
+// myers is the state of a comparison of MyersDiffer. The old lines [oldStart, oldEnd)
+// and the new lines [newStart, newEnd) are split on a point of a shortest edit script,
+// found by searching it from both ends at once, and the halves compared in turn.
+type myers struct {
+	old, new [][]byte
+	budget   *timeBudget
+	ops      []LineOp
+
+	// forward[offset+k] and backward[offset+k] are the furthest old index reached on
+	// diagonal k from the start and from the end of the lines split, reused across splits
+	forward, backward []int
+}
+
+// compare appends the edit script of the old lines [oldStart, oldEnd) and the new lines
+// [newStart, newEnd) to ops, returning false when the budget is exceeded.
+func (m *myers) compare(oldStart, oldEnd, newStart, newEnd int) bool {
+	// The lines the ranges share at their start and end are equal
+	prefix := 0
+	for oldStart+prefix < oldEnd && newStart+prefix < newEnd && bytes.Equal(m.old[oldStart+prefix], m.new[newStart+prefix]) {
+		prefix++
+	}
+
+	m.equal(oldStart, newStart, prefix)
+	oldStart, newStart = oldStart+prefix, newStart+prefix
+
+	suffix := 0
+	for oldEnd-suffix > oldStart && newEnd-suffix > newStart && bytes.Equal(m.old[oldEnd-suffix-1], m.new[newEnd-suffix-1]) {
+		suffix++
+	}
+
+	oldEnd, newEnd = oldEnd-suffix, newEnd-suffix
+
+	switch {
+	case oldStart == oldEnd:
+		for y := newStart; y < newEnd; y++ {
+			m.ops = append(m.ops, LineOp{Type: EditInsert, OldIndex: oldStart, NewIndex: y})
+		}
+	case newStart == newEnd:
+		for x := oldStart; x < oldEnd; x++ {
+			m.ops = append(m.ops, LineOp{Type: EditDelete, OldIndex: x, NewIndex: newStart})
+		}
+	default:
+		x, y, ok := m.split(oldStart, oldEnd, newStart, newEnd)
+		if !ok || !m.compare(oldStart, x, newStart, y) || !m.compare(x, oldEnd, y, newEnd) {
+			return false
+		}
+	}
+
+	m.equal(oldEnd, newEnd, suffix)
+
+	return true
+}
+
+// equal appends the equal operations of n lines from the old line oldStart and the new
+// line newStart to ops.
+func (m *myers) equal(oldStart, newStart, n int) {
+	for i := 0; i < n; i++ {
+		m.ops = append(m.ops, LineOp{Type: EditEqual, OldIndex: oldStart + i, NewIndex: newStart + i})
+	}
+}
+
+// split returns a point of a shortest edit script of the old lines [oldStart, oldEnd)
+// and the new lines [newStart, newEnd), where the searches from their start and from their
+// end overlap, or false when the budget is exceeded. The lines differ at both ends.
+func (m *myers) split(oldStart, oldEnd, newStart, newEnd int) (int, int, bool) {
+	old, new := m.old[oldStart:oldEnd], m.new[newStart:newEnd]
+	n, l := len(old), len(new)
+
+	maxD := (n + l + 1) / 2
+	offset := maxD
+
+	if size := 2*maxD + 2; len(m.forward) < size {
+		m.forward, m.backward = make([]int, size), make([]int, size)
+	}
+
+	forward, backward := m.forward[:2*maxD+2], m.backward[:2*maxD+2]
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	// The searches meet on the forward pass when the diagonals of the ends differ by an
+	// odd number, and on the backward pass otherwise
+	delta := n - l
+	odd := delta%2 != 0
+
+	// Diagonals which left the lines are not searched further
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			if m.budget.spent() {
+				return 0, 0, false
+			}
+
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < l && bytes.Equal(old[x], new[y]) {
+				x++
+				y++
+			}
+
+			forward[offset+k] = x
+
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > l:
+				forwardStart += 2
+			case odd:
+				i := offset + delta - k
+				if i >= 0 && i < len(backward) && backward[i] != -1 && x >= n-backward[i] {
+					return oldStart + x, newStart + y, true
+				}
+			}
+		}
+
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			if m.budget.spent() {
+				return 0, 0, false
+			}
+
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < l && bytes.Equal(old[n-x-1], new[l-y-1]) {
+				x++
+				y++
+			}
+
+			backward[offset+k] = x
+
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > l:
+				backwardStart += 2
+			case !odd:
+				i := offset + delta - k
+				if i >= 0 && i < len(forward) && forward[i] != -1 && forward[i] >= n-x {
+					return oldStart + forward[i], newStart + forward[i] - (i - offset), true
+				}
+			}
+		}
+	}
+
+	// Not reached, as the searches overlap within maxD steps; every line is replaced
+	return oldEnd, newStart, true
+}
+
+// lineRun is a run of changed lines of an edit script, replacing the old lines
+// [oldStart, oldEnd) with the new lines [newStart, newEnd).
+type lineRun struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// diffLines compares the lines of old and new, neither empty, with Differ. The runs of
+// changed lines become chunks, merged when separated by fewer than MaxGapLines lines.
+// inBlock reports whether a block comment is open before their first lines. It returns
+// errBudgetExceeded when a budgetedDiffer exceeds budget.
+func (h *TextFileHandler) diffLines(old, new []byte, inBlock bool, budget *timeBudget) ([]DiffChunk, error) {
+	oldLines := bytes.Split(old, []byte{'\n'})
+	newLines := bytes.Split(new, []byte{'\n'})
+
+	// The differ compares the lines normalized and without their comments
+	oldCompared, newCompared := h.comparedLines(oldLines, inBlock), h.comparedLines(newLines, inBlock)
+
+	runs := make([]lineRun, 0)
+	i, j := 0, 0
+	changed := false
+
+	var ops []LineOp
+	if differ, ok := h.Differ.(budgetedDiffer); ok && budget != nil {
+		if ops, ok = differ.diffWithin(oldCompared, newCompared, budget); !ok {
+			return nil, errBudgetExceeded
+		}
+	} else {
+		ops = h.Differ.Diff(oldCompared, newCompared)
+	}
+
+	for _, op := range ops {
+		if op.Type != EditEqual {
+			if !changed {
+				runs = append(runs, lineRun{oldStart: i, newStart: j})
+				changed = true
+			}
+		} else {
+			changed = false
+		}
+
+		switch op.Type {
+		case EditEqual:
+			if op.OldIndex != i || op.NewIndex != j {
+				return nil, fmt.Errorf("invalid line diff: equal line %d, %d out of order", op.OldIndex, op.NewIndex)
+			}
+
+			i++
+			j++
+		case EditDelete:
+			if op.OldIndex != i {
+				return nil, fmt.Errorf("invalid line diff: deleted line %d out of order", op.OldIndex)
+			}
+
+			i++
+		case EditInsert:
+			if op.NewIndex != j {
+				return nil, fmt.Errorf("invalid line diff: inserted line %d out of order", op.NewIndex)
+			}
+
+			j++
+		default:
+			return nil, fmt.Errorf("invalid line diff: unknown operation %q", op.Type)
+		}
+
+		if changed {
+			runs[len(runs)-1].oldEnd, runs[len(runs)-1].newEnd = i, j
+		}
+	}
+
+	if i != len(oldLines) || j != len(newLines) {
+		return nil, fmt.Errorf("invalid line diff: %d of %d old and %d of %d new lines covered", i, len(oldLines), j, len(newLines))
+	}
+
+	// Runs separated by fewer than MaxGapLines unchanged lines are merged with them
+	merged := make([]lineRun, 0, len(runs))
+	for _, run := range runs {
+		if n := len(merged); n > 0 && run.oldStart-merged[n-1].oldEnd < h.MaxGapLines {
+			merged[n-1].oldEnd, merged[n-1].newEnd = run.oldEnd, run.newEnd
+			continue
+		}
+
+		merged = append(merged, run)
+	}
+
+	oldStarts, newStarts := lineStarts(oldLines), lineStarts(newLines)
+	chunks := make([]DiffChunk, 0, len(merged))
+
+	for _, run := range merged {
+		chunks = append(chunks, runChunk(old, new, oldLines, oldStarts, newStarts, run))
+	}
+
+	return chunks, nil
+}
+
+// lineStarts returns the offsets of the lines split on newlines, followed by the length
+// of the data plus one, as if it ended with a newline.
+func lineStarts(lines [][]byte) []int64 {
+	starts := make([]int64, len(lines)+1)
+	for i, line := range lines {
+		starts[i+1] = starts[i] + int64(len(line)) + 1
+	}
+
+	return starts
+}
+
+// runChunk returns the chunk replacing the old lines of a run with its new lines. Lines
+// inserted or deleted whole carry their newline, the one before them at the end of data.
+func runChunk(old, new []byte, oldLines [][]byte, oldStarts, newStarts []int64, run lineRun) DiffChunk {
+	// lineEnd is the offset of the end of a line, before its newline
+	lineEnd := func(starts []int64, i int) int64 {
+		return starts[i+1] - 1
+	}
+
+	chunk := DiffChunk{ChunkType: "text"}
+
+	switch {
+	case run.oldEnd > run.oldStart && run.newEnd > run.newStart:
+		chunk.Offset = oldStarts[run.oldStart]
+		chunk.OldData = old[oldStarts[run.oldStart]:lineEnd(oldStarts, run.oldEnd-1)]
+		chunk.NewData = new[newStarts[run.newStart]:lineEnd(newStarts, run.newEnd-1)]
+		chunk.Op = OpReplace
+	case run.newEnd == run.newStart:
+		if run.oldEnd < len(oldLines) {
+			chunk.Offset = oldStarts[run.oldStart]
+			chunk.OldData = old[oldStarts[run.oldStart]:oldStarts[run.oldEnd]]
+		} else {
+			chunk.Offset = lineEnd(oldStarts, run.oldStart-1)
+			chunk.OldData = old[chunk.Offset:]
+		}
+
+		chunk.Op = OpDelete
+	default:
+		if run.oldStart < len(oldLines) {
+			chunk.Offset = oldStarts[run.oldStart]
+			chunk.NewData = new[newStarts[run.newStart]:newStarts[run.newEnd]]
+		} else {
+			chunk.Offset = int64(len(old))
+			chunk.NewData = new[lineEnd(newStarts, run.newStart-1):]
+		}
+
+		chunk.Op = OpInsert
+	}
+
+	chunk.OldLength = int64(len(chunk.OldData))
+
+	return chunk
+}
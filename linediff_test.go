@@ -0,0 +1,295 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// splitTestLines splits s on newlines, with no lines for an empty s.
+func splitTestLines(s string) [][]byte {
+	if s == "" {
+		return nil
+	}
+
+	return bytes.Split([]byte(s), []byte{'\n'})
+}
+
+func TestMyersDiffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		old, new  string
+		wantEdits int
+	}{
+		{name: "equal", old: "a\nb\nc", new: "a\nb\nc", wantEdits: 0},
+		{name: "empty old", old: "", new: "a\nb", wantEdits: 2},
+		{name: "empty new", old: "a\nb", new: "", wantEdits: 2},
+		{name: "insert", old: "a\nc", new: "a\nb\nc", wantEdits: 1},
+		{name: "delete", old: "a\nb\nc", new: "a\nc", wantEdits: 1},
+		{name: "replace", old: "a\nb\nc", new: "a\nx\nc", wantEdits: 2},
+		{name: "classic", old: "a\nb\nc\na\nb\nb\na", new: "c\nb\na\nb\na\nc", wantEdits: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := splitTestLines(tt.old), splitTestLines(tt.new)
+			ops := MyersDiffer{}.Diff(old, new)
+
+			var gotOld, gotNew []string
+			edits := 0
+
+			for _, op := range ops {
+				switch op.Type {
+				case EditEqual:
+					if !bytes.Equal(old[op.OldIndex], new[op.NewIndex]) {
+						t.Errorf("equal operation on different lines %q and %q", old[op.OldIndex], new[op.NewIndex])
+					}
+
+					gotOld = append(gotOld, string(old[op.OldIndex]))
+					gotNew = append(gotNew, string(new[op.NewIndex]))
+				case EditDelete:
+					gotOld = append(gotOld, string(old[op.OldIndex]))
+					edits++
+				case EditInsert:
+					gotNew = append(gotNew, string(new[op.NewIndex]))
+					edits++
+				}
+			}
+
+			if got := strings.Join(gotOld, "\n"); got != tt.old {
+				t.Errorf("old lines of the script = %q, want %q", got, tt.old)
+			}
+
+			if got := strings.Join(gotNew, "\n"); got != tt.new {
+				t.Errorf("new lines of the script = %q, want %q", got, tt.new)
+			}
+
+			if edits != tt.wantEdits {
+				t.Errorf("expected %d edits, got %d", tt.wantEdits, edits)
+			}
+		})
+	}
+}
+
+func TestMyersDiffer_Shortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// lcs returns the length of the longest common subsequence of a and b
+	lcs := func(a, b [][]byte) int {
+		lengths := make([][]int, len(a)+1)
+		for i := range lengths {
+			lengths[i] = make([]int, len(b)+1)
+		}
+
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if bytes.Equal(a[i], b[j]) {
+					lengths[i][j] = lengths[i+1][j+1] + 1
+				} else {
+					lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+				}
+			}
+		}
+
+		return lengths[0][0]
+	}
+
+	for iteration := 0; iteration < 500; iteration++ {
+		old, new := make([][]byte, rng.Intn(30)), make([][]byte, rng.Intn(30))
+		for i := range old {
+			old[i] = []byte{byte('a' + rng.Intn(4))}
+		}
+
+		for i := range new {
+			new[i] = []byte{byte('a' + rng.Intn(4))}
+		}
+
+		edits := 0
+		for _, op := range (MyersDiffer{}).Diff(old, new) {
+			if op.Type != EditEqual {
+				edits++
+			}
+		}
+
+		if want := len(old) + len(new) - 2*lcs(old, new); edits != want {
+			t.Fatalf("Diff(%q, %q) has %d edits, want %d", old, new, edits, want)
+		}
+	}
+}
+
+func TestMyersDiffer_Memory(t *testing.T) {
+	// Every line of a large file rewritten, the longest edit script
+	old, new := make([][]byte, 4000), make([][]byte, 4000)
+	for i := range old {
+		old[i] = []byte(fmt.Sprintf("old line %d", i))
+		new[i] = []byte(fmt.Sprintf("new line %d", i))
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	ops := MyersDiffer{}.Diff(old, new)
+
+	runtime.ReadMemStats(&after)
+
+	if len(ops) != len(old)+len(new) {
+		t.Fatalf("Diff returned %d operations, want %d", len(ops), len(old)+len(new))
+	}
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+		t.Errorf("Diff allocated %d bytes, want the space of the lines only", allocated)
+	}
+}
+
+func TestTextFileHandler_MyersDiffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []DiffChunk
+	}{
+		{
+			name: "Insert lines",
+			old:  "one\ntwo\nthree\nfour",
+			new:  "one\n1.5\ntwo\nthree\n3.5\nfour",
+			want: []DiffChunk{
+				{Offset: 4, NewData: []byte("1.5\n"), ChunkType: "text", Op: OpInsert},
+				{Offset: 14, NewData: []byte("3.5\n"), ChunkType: "text", Op: OpInsert},
+			},
+		},
+		{
+			name: "Delete lines",
+			old:  "one\ntwo\nthree\nfour\nfive",
+			new:  "one\nthree\nfive",
+			want: []DiffChunk{
+				{Offset: 4, OldData: []byte("two\n"), OldLength: 4, ChunkType: "text", Op: OpDelete},
+				{Offset: 14, OldData: []byte("four\n"), OldLength: 5, ChunkType: "text", Op: OpDelete},
+			},
+		},
+		{
+			name: "Replace line",
+			old:  "one\ntwo\nthree",
+			new:  "one\n2\nthree",
+			want: []DiffChunk{
+				{Offset: 4, OldData: []byte("two"), OldLength: 3, NewData: []byte("2"), ChunkType: "text", Op: OpReplace},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &TextFileHandler{Differ: MyersDiffer{}}
+
+			chunks, err := handler.Compare([]byte(tt.old), []byte(tt.new))
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, chunks); diff != "" {
+				t.Errorf("Compare() mismatch (-want +got):\n%s", diff)
+			}
+
+			patched, err := handler.Patch([]byte(tt.old), chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if string(patched) != tt.new {
+				t.Errorf("Patch() = %q, want %q", patched, tt.new)
+			}
+		})
+	}
+}
+
+func TestTextFileHandler_MyersDifferRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for iteration := 0; iteration < 200; iteration++ {
+		lines := make([]string, rng.Intn(20)+1)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("line %d", rng.Intn(8))
+		}
+
+		edited := make([]string, 0, len(lines))
+		for _, line := range lines {
+			switch rng.Intn(5) {
+			case 0:
+				continue
+			case 1:
+				edited = append(edited, "inserted", line)
+			case 2:
+				edited = append(edited, "changed")
+			default:
+				edited = append(edited, line)
+			}
+		}
+
+		old := strings.Join(lines, "\n") + strings.Repeat("\n", rng.Intn(2))
+		new := strings.Join(edited, "\n") + strings.Repeat("\n", rng.Intn(2))
+
+		handler := &TextFileHandler{Differ: MyersDiffer{}, MaxGapLines: rng.Intn(3)}
+
+		chunks, err := handler.Compare([]byte(old), []byte(new))
+		if err != nil {
+			t.Fatalf("Compare(%q, %q) returned an error: %v", old, new, err)
+		}
+
+		patched, err := handler.Patch([]byte(old), chunks)
+		if err != nil {
+			t.Fatalf("Patch returned an error for %q to %q: %v", old, new, err)
+		}
+
+		if string(patched) != new {
+			t.Fatalf("Patch() = %q, want %q from %q", patched, new, old)
+		}
+	}
+}
+
+// replaceAllDiffer deletes every old line and inserts every new line.
+type replaceAllDiffer struct{}
+
+func (replaceAllDiffer) Diff(old, new [][]byte) []LineOp {
+	ops := make([]LineOp, 0, len(old)+len(new))
+	for i := range old {
+		ops = append(ops, LineOp{Type: EditDelete, OldIndex: i})
+	}
+
+	for j := range new {
+		ops = append(ops, LineOp{Type: EditInsert, OldIndex: len(old), NewIndex: j})
+	}
+
+	return ops
+}
+
+// emptyDiffer returns no operations, an invalid script for any lines.
+type emptyDiffer struct{}
+
+func (emptyDiffer) Diff(old, new [][]byte) []LineOp {
+	return nil
+}
+
+func TestTextFileHandler_Differ(t *testing.T) {
+	old, new := "head\none\ntwo\ntail", "head\n1\n2\n3\ntail"
+
+	handler := &TextFileHandler{Differ: replaceAllDiffer{}}
+
+	chunks, err := handler.Compare([]byte(old), []byte(new))
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	// The common lines are not passed to the differ, which replaces all the others
+	want := []DiffChunk{{Offset: 5, OldData: []byte("one\ntwo\n"), OldLength: 8, NewData: []byte("1\n2\n3\n"), ChunkType: "text", Op: OpReplace}}
+	if diff := cmp.Diff(want, chunks); diff != "" {
+		t.Errorf("Compare() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := (&TextFileHandler{Differ: emptyDiffer{}}).Compare([]byte(old), []byte(new)); err == nil {
+		t.Error("expected an error for a script not covering the lines")
+	}
+}
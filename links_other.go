@@ -0,0 +1,11 @@
+//go:build !unix
+
+package diff
+
+import "os"
+
+// hardLinkKey is not supported on this platform, hard linked files are compared as
+// independent files.
+func hardLinkKey(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
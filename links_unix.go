@@ -0,0 +1,19 @@
+//go:build unix
+
+package diff
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardLinkKey returns the device and inode identifying the file of info, when the file
+// has several hard links.
+func hardLinkKey(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
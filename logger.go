@@ -1,7 +1,9 @@
 package diff
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
@@ -12,24 +14,64 @@ type Logger struct {
 	detailed bool
 	logFile  *os.File
 	mu       sync.Mutex
+
+	// out is the destination of the messages for the log file, buffered when the
+	// logger was created with a buffer size
+	out    io.Writer
+	buffer *bufio.Writer
+	done   chan struct{} // Closed to stop the periodic flushes
 }
 
 // NewLogger creates a new Logger instance.
 func NewLogger(detailed bool, logPath string) (*Logger, error) {
-	var logFile *os.File
-	var err error
+	return NewBufferedLogger(detailed, logPath, 0, 0)
+}
+
+// NewBufferedLogger creates a new Logger which buffers up to bufferSize bytes of messages
+// before writing them to the log file, rather than writing each message. The buffer is
+// also flushed every flushInterval when it is positive, and on Close, so no message is
+// lost as long as the logger is closed. A bufferSize of 0 disables buffering.
+func NewBufferedLogger(detailed bool, logPath string, bufferSize int, flushInterval time.Duration) (*Logger, error) {
+	logger := &Logger{detailed: detailed}
+
+	if logPath == "" {
+		return logger, nil
+	}
 
-	if logPath != "" {
-		logFile, err = os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, err
+	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.logFile = logFile
+	logger.out = logFile
+
+	if bufferSize > 0 {
+		logger.buffer = bufio.NewWriterSize(logFile, bufferSize)
+		logger.out = logger.buffer
+
+		if flushInterval > 0 {
+			logger.done = make(chan struct{})
+			go logger.flushEvery(flushInterval, logger.done)
 		}
 	}
 
-	return &Logger{
-		detailed: detailed,
-		logFile:  logFile,
-	}, nil
+	return logger, nil
+}
+
+// flushEvery flushes the buffer periodically until the logger is closed.
+func (l *Logger) flushEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-done:
+			return
+		}
+	}
 }
 
 // Log writes a log message to the logger.
@@ -43,8 +85,8 @@ func (l *Logger) Log(format string, args ...interface{}) {
 
 	msg := fmt.Sprintf("[%s] %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
 
-	if l.logFile != nil {
-		l.logFile.WriteString(msg)
+	if l.out != nil {
+		io.WriteString(l.out, msg)
 	}
 
 	if l.detailed {
@@ -52,8 +94,46 @@ func (l *Logger) Log(format string, args ...interface{}) {
 	}
 }
 
-func (l *Logger) Close() {
+// Flush writes the buffered messages to the log file.
+func (l *Logger) Flush() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buffer == nil {
+		return nil
+	}
+
+	return l.buffer.Flush()
+}
+
+// Close stops the periodic flushes, flushes the buffered messages and closes the log file.
+// Messages logged afterwards are only printed, when detailed.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+
+	err := l.Flush()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.logFile != nil {
-		l.logFile.Close()
+		if closeErr := l.logFile.Close(); err == nil {
+			err = closeErr
+		}
+
+		l.logFile, l.out, l.buffer = nil, nil, nil
 	}
+
+	return err
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -155,3 +157,113 @@ func TestNewLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestBufferedLogger_Close(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), testLogFileName)
+
+	logger, err := NewBufferedLogger(false, logPath, 4096, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	const messages = 10000
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < messages/4; i++ {
+				logger.Log("worker %d message %d", w, i)
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	logger.Close()
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+
+	if lines := bytes.Count(content, []byte("\n")); lines != messages {
+		t.Errorf("expected %d messages in the log file, got %d", messages, lines)
+	}
+}
+
+func TestBufferedLogger_FlushInterval(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), testLogFileName)
+
+	logger, err := NewBufferedLogger(false, logPath, 4096, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	defer logger.Close()
+
+	logger.Log("flushed periodically")
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		content, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("Failed to read log file: %v", err)
+		}
+
+		if bytes.Contains(content, []byte("flushed periodically")) {
+			return
+		}
+	}
+
+	t.Error("expected the message to be flushed before the logger is closed")
+}
+
+func BenchmarkLogger_Log(b *testing.B) {
+	benchmarks := []struct {
+		name       string
+		bufferSize int
+	}{
+		{name: "Unbuffered"},
+		{name: "Buffered64KB", bufferSize: 64 * 1024},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			logger, err := NewBufferedLogger(false, filepath.Join(b.TempDir(), testLogFileName), bm.bufferSize, time.Second)
+			if err != nil {
+				b.Fatalf("Failed to create logger: %v", err)
+			}
+
+			defer logger.Close()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					logger.Log("Skipping large file: %s (size: %d bytes)", "some/path/file.bin", 1<<30)
+				}
+			})
+		})
+	}
+}
+
+func TestDiffEngine_Close(t *testing.T) {
+	config := DefaultConfig()
+	config.LogBufferSize = 1 << 20
+
+	engine := newTestEngine(t, config)
+	engine.logger.Log("buffered until the engine is closed")
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(testEngineLogFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+
+	if !bytes.Contains(content, []byte("buffered until the engine is closed")) {
+		t.Errorf("expected the buffered message in the log file, got %q", content)
+	}
+}
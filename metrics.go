@@ -0,0 +1,105 @@
+package diff
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements of the comparisons of an engine, to export them to a
+// metrics backend such as Prometheus or OpenTelemetry. Its methods may be called
+// concurrently, from the goroutines comparing the files.
+type Metrics interface {
+	// ObserveFile is called for each result, with the time taken to compare the file.
+	ObserveFile(result *DiffResult, dur time.Duration)
+
+	// ObserveError is called for each file which could not be compared or accessed.
+	ObserveError(path string, err error)
+
+	// ObserveSummary is called at the end of each comparison of directories.
+	ObserveSummary(summary *DiffSummary)
+}
+
+// observe reports the outcome of the comparison of a file started at start to the
+// configured Metrics, if any.
+func (e *DiffEngine) observe(path string, result *DiffResult, err error, start time.Time) {
+	if e.config.Metrics == nil {
+		return
+	}
+
+	if err != nil {
+		e.config.Metrics.ObserveError(path, err)
+	} else if result != nil {
+		e.config.Metrics.ObserveFile(result, time.Since(start))
+	}
+}
+
+// MemoryMetrics is a Metrics keeping the measurements in memory, such as for tests.
+type MemoryMetrics struct {
+	mu       sync.Mutex
+	snapshot MetricsSnapshot
+}
+
+// MetricsSnapshot holds the measurements collected by a MemoryMetrics.
+type MetricsSnapshot struct {
+	Files       map[string]int   // Number of results by operation
+	Bytes       int64            // Total size of the files of the results
+	Duration    time.Duration    // Total time taken to compare the files
+	Errors      map[string]error // Errors by path
+	Comparisons int              // Number of comparisons of directories
+}
+
+// Makesure MemoryMetrics implements the Metrics interface
+var _ Metrics = &MemoryMetrics{}
+
+// ObserveFile records a result.
+func (m *MemoryMetrics) ObserveFile(result *DiffResult, dur time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.snapshot.Files == nil {
+		m.snapshot.Files = make(map[string]int)
+	}
+
+	m.snapshot.Files[result.Operation]++
+	m.snapshot.Bytes += result.Size
+	m.snapshot.Duration += dur
+}
+
+// ObserveError records an error.
+func (m *MemoryMetrics) ObserveError(path string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.snapshot.Errors == nil {
+		m.snapshot.Errors = make(map[string]error)
+	}
+
+	m.snapshot.Errors[path] = err
+}
+
+// ObserveSummary records the end of a comparison of directories.
+func (m *MemoryMetrics) ObserveSummary(summary *DiffSummary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snapshot.Comparisons++
+}
+
+// Snapshot returns a copy of the measurements recorded so far.
+func (m *MemoryMetrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := m.snapshot
+	snapshot.Files = make(map[string]int, len(m.snapshot.Files))
+	for op, n := range m.snapshot.Files {
+		snapshot.Files[op] = n
+	}
+
+	snapshot.Errors = make(map[string]error, len(m.snapshot.Errors))
+	for path, err := range m.snapshot.Errors {
+		snapshot.Errors[path] = err
+	}
+
+	return snapshot
+}
@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetrics(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"same.txt":    "same\n",
+		"changed.txt": "old\n",
+		"removed.txt": "removed\n",
+		"secret.txt":  "old secret\n",
+	})
+
+	writeTestTree(t, newDir, map[string]string{
+		"same.txt":    "same\n",
+		"changed.txt": "new content\n",
+		"added.txt":   "added\n",
+		"secret.txt":  "new secret\n",
+	})
+
+	metrics := &MemoryMetrics{}
+
+	config := DefaultConfig()
+	config.Metrics = metrics
+
+	engine := newTestEngine(t, config)
+	engine.SetFileSystem(&deniedFileSystem{FileSystem: OSFileSystem{}, denied: map[string]bool{"secret.txt": true}})
+
+	if _, _, err := engine.CompareDirs(oldDir, newDir); err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	got := metrics.Snapshot()
+
+	if diff := cmp.Diff(map[string]int{"added": 1, "modified": 1, "deleted": 1}, got.Files); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+
+	if want := int64(len("new content\n") + len("added\n") + len("removed\n")); got.Bytes != want {
+		t.Errorf("expected %d bytes, got %d", want, got.Bytes)
+	}
+
+	if err := got.Errors["secret.txt"]; len(got.Errors) != 1 || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected a permission error for secret.txt, got %v", got.Errors)
+	}
+
+	if got.Comparisons != 1 {
+		t.Errorf("expected 1 comparison, got %d", got.Comparisons)
+	}
+
+	// Single file comparisons are observed too
+	if _, err := engine.CompareFilesIfChanged(filepath.Join(oldDir, "changed.txt"), filepath.Join(newDir, "changed.txt")); err != nil {
+		t.Fatalf("CompareFilesIfChanged returned an error: %v", err)
+	}
+
+	if n := metrics.Snapshot().Files["modified"]; n != 2 {
+		t.Errorf("expected 2 modified files, got %d", n)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package diff
+
+import "errors"
+
+// errMmapUnsupported is returned by the mmap functions on this platform.
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// mmapHash is not supported on this platform, callers fall back to streaming.
+func mmapHash(path string) (string, error) {
+	return "", errMmapUnsupported
+}
+
+// mmapFile is not supported on this platform, callers fall back to reading the file.
+func mmapFile(path string) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package diff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"syscall"
+)
+
+// mmapHash calculates the SHA256 hash of a file by memory-mapping it,
+// which avoids copying its content through a read buffer.
+func mmapHash(path string) (string, error) {
+	data, unmap, err := mmapFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer unmap()
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// mmapFile maps the content of a file read-only, until unmap is called. The data must
+// not be used afterwards.
+func mmapFile(path string) (data []byte, unmap func() error, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The mapping remains valid after the file is closed
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Empty files can't be mapped
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	data, err = syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
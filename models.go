@@ -2,7 +2,11 @@ package diff
 
 import (
 	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -10,61 +14,378 @@ const Version = "1.0.0"
 
 // Main types
 type DiffResult struct {
-	Path         string
-	Operation    string // "added", "modified", "deleted"
-	OldHash      string
-	NewHash      string
-	Chunks       []DiffChunk
-	FileType     string
-	Size         int64
-	ModTime      time.Time
-	Permissions  os.FileMode
-	IsCompressed bool
+	Path           string
+	RelPath        string // Slash-separated path relative to the compared directories, on every platform
+	Operation      string // "added", "modified", "rewritten", "deleted", "unchanged", "linked", "typechange", "chmod"
+	OldHash        string
+	NewHash        string
+	Chunks         []DiffChunk
+	FileType       string
+	Size           int64
+	ModTime        time.Time
+	Permissions    os.FileMode
+	OldPermissions os.FileMode // Permissions of the old version when they differ, with Configuration.ReportPermissionChanges
+	IsCompressed   bool        // At least one of the chunks is compressed
+	Transformed    bool        // The chunk data was transformed with Configuration.ChunkTransform, see ReverseTransform
+
+	// SameAs is the RelPath of a result with the same old and new content, whose chunks
+	// apply to this file too and are stored once, when Configuration.DedupContent is set.
+	// ResolveReferences restores the chunks.
+	SameAs string
+
+	// LinkTo is the RelPath of the file this "linked" file is a hard link to, and IsDir is
+	// set for the empty directories added, when Configuration.PreserveLinks is set.
+	// ApplyLinks recreates both. IsDir is also set when a file became a directory, in a
+	// "typechange" result, which holds no chunks.
+	LinkTo string
+	IsDir  bool
 }
 
+// LocalPath returns RelPath converted to the path separator of the current platform.
+func (r DiffResult) LocalPath() string {
+	return filepath.FromSlash(r.RelPath)
+}
+
+// DiffChunk is a change to the original version of a file. Whatever the handler which
+// produced it, a chunk replaces the OldLength bytes of the original at Offset with NewData,
+// with nothing for OpDelete, for OpCopy with the CopyLength bytes of the original at
+// CopyFrom, or for OpZero with ZeroLength zero bytes. The chunks of a file are in increasing Offset order and do not overlap, so
+// ApplyCanonical applies those of any handler. The other fields are optional or specific
+// to some handlers.
 type DiffChunk struct {
-	Offset    int64
-	OldData   []byte
-	NewData   []byte
-	ChunkType string // "binary", "text", "image"
+	Offset     int64
+	OldData    []byte
+	NewData    []byte
+	ChunkType  string // "binary", "text", "image"
+	Op         string // "insert", "delete", "replace", "copy"
+	Checksum   uint32 // CRC32 of the uncompressed NewData, 0 when not computed
+	Compressed bool   // NewData is gzip compressed
+	Page       int64  // 1-based page number of the chunk for paged formats such as SQLite, 0 otherwise
+	OldLength  int64  // Length of the original span replaced, used by Patch instead of the length of OldData, which may be omitted
+	Record     int64  // 0-based index of the first record of the chunk for fixed-record formats, see RecordBinaryHandler
+
+	// CopyFrom and CopyLength are the range of the original an OpCopy chunk writes in place
+	// of its span instead of NewData, for a block moved within the file.
+	CopyFrom   int64
+	CopyLength int64
+
+	// ZeroLength is the number of zero bytes an OpZero chunk writes in place of its span
+	// instead of NewData, such as for a hole of a sparse file.
+	ZeroLength int64
+
+	// BudgetExceeded is set on the single chunk replacing the whole file returned by a
+	// comparison which exceeded the TimeBudget of its handler.
+	BudgetExceeded bool
+
+	// Unchanged bytes surrounding the chunk in the original, used to locate it when patching
+	ContextBefore []byte
+	ContextAfter  []byte
+}
+
+// Chunk operations
+const (
+	OpInsert  = "insert"
+	OpDelete  = "delete"
+	OpReplace = "replace"
+	OpCopy    = "copy"
+	OpZero    = "zero"
+)
+
+// oldSpan returns the number of original bytes replaced by the chunk.
+func (c DiffChunk) oldSpan() int64 {
+	if c.Op == OpInsert {
+		return 0
+	}
+
+	// Chunks built without OldLength, such as by hand, span their OldData
+	if c.OldLength > 0 || c.OldData == nil {
+		return c.OldLength
+	}
+
+	return int64(len(c.OldData))
+}
+
+// replacement returns the bytes written in place of the original span.
+func (c DiffChunk) replacement() []byte {
+	switch c.Op {
+	case OpDelete:
+		return nil
+	case OpZero:
+		return make([]byte, c.ZeroLength)
+	}
+
+	return c.NewData
+}
+
+// replacementIn returns the bytes written in place of the original span, read from
+// original for an OpCopy chunk.
+func (c DiffChunk) replacementIn(original []byte) ([]byte, error) {
+	if c.Op != OpCopy {
+		return c.replacement(), nil
+	}
+
+	if c.CopyFrom < 0 || c.CopyLength < 0 || c.CopyFrom+c.CopyLength > int64(len(original)) {
+		return nil, fmt.Errorf("%w: copy of %d bytes from offset %d", ErrPatchOutOfRange, c.CopyLength, c.CopyFrom)
+	}
+
+	return original[c.CopyFrom : c.CopyFrom+c.CopyLength], nil
+}
+
+// verify checks the chunk's NewData against its checksum, if one was computed.
+func (c DiffChunk) verify() error {
+	if c.Checksum != 0 && crc32.ChecksumIEEE(c.NewData) != c.Checksum {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+// chunkOp infers the operation of a chunk from the old and new data it replaces.
+func chunkOp(oldData, newData []byte) string {
+	switch {
+	case len(oldData) == 0 && len(newData) > 0:
+		return OpInsert
+	case len(newData) == 0 && len(oldData) > 0:
+		return OpDelete
+	default:
+		return OpReplace
+	}
 }
 
 type DiffSummary struct {
-	TotalFiles      int
-	AddedFiles      int
-	ModifiedFiles   int
-	DeletedFiles    int
-	TotalSizeBytes  int64
-	CompressedBytes int64
-	FileTypes       map[string]int
-	StartTime       time.Time
-	EndTime         time.Time
+	TotalFiles        int
+	AddedFiles        int
+	ModifiedFiles     int
+	DeletedFiles      int
+	RewrittenFiles    int
+	TypeChangedFiles  int   // Paths which changed between file, directory and symbolic link
+	ChmodFiles        int   // Files whose permissions only changed, when Configuration.ReportPermissionChanges is set
+	UnchangedFiles    int   // Files reported unchanged, when Configuration.ReportUnchanged is set
+	SkippedOlderFiles int   // Files skipped as not modified since Configuration.ModifiedSince
+	SkippedBinary     int   // Files skipped as not text, when Configuration.TextOnly is set
+	PatchBytes        int64 // Bytes of chunk data of the results
+	ProcessedBytes    int64 // Bytes of the new files compared
+	Truncated         bool  // Comparison stopped early as Configuration.MaxTotalPatchBytes was exceeded
+	TotalSizeBytes    int64
+	CompressedBytes   int64
+	FileTypes         map[string]int
+	Errors            []FileError // Files which failed to compare
+	StartTime         time.Time
+	EndTime           time.Time
+}
+
+// Progress is the progress of a directory comparison.
+type Progress struct {
+	ProcessedBytes int64 // Bytes of the new files compared so far
+	TotalBytes     int64 // Estimated bytes of the new files to compare, from a walk before the comparison
+}
+
+// File error categories
+const (
+	ErrorCategoryPermission   = "permission"   // The file could not be accessed
+	ErrorCategoryVerification = "verification" // The patch of the file did not reproduce it
+	ErrorCategoryOther        = "other"
+)
+
+// FileError is an error which occurred comparing a file.
+type FileError struct {
+	Path     string
+	Category string // One of the ErrorCategory constants
+	Err      error
+}
+
+// newFileError returns the FileError of a path, categorized from err.
+func newFileError(path string, err error) FileError {
+	category := ErrorCategoryOther
+
+	switch {
+	case os.IsPermission(err) || errors.Is(err, os.ErrPermission):
+		category = ErrorCategoryPermission
+	case errors.Is(err, ErrPatchVerification):
+		category = ErrorCategoryVerification
+	}
+
+	return FileError{Path: path, Category: category, Err: err}
+}
+
+// Error returns the path of the file with the error message.
+func (e FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e FileError) Unwrap() error {
+	return e.Err
 }
 
 // Configuration
 type Configuration struct {
-	CompressPatches     bool
-	CompressionLevel    int
-	ChunkSize           int64
-	Concurrency         int
-	IgnorePatterns      []string
-	IncludePatterns     []string
-	PreservePermissions bool
-	MaxFileSizeBytes    int64
-	BackupFiles         bool
-	BackupDir           string
-	DetailedLogging     bool
+	CompressPatches      bool
+	CompressionLevel     int
+	ChunkSize            int64 // Largest NewData of a chunk, larger chunks are split into sequential ones, 0 disables splitting
+	Concurrency          int
+	IgnorePatterns       []string
+	IncludePatterns      []string
+	MaxFileSizeBytes     int64
+	BackupFiles          bool
+	BackupDir            string
+	DetailedLogging      bool
+	FallbackOnError      bool          // Retry with the default handler when a registered handler fails
+	RewriteThreshold     float64       // Changed-bytes ratio above which a modified file is "rewritten", 0 disables
+	ChunkChecksums       bool          // Compute a CRC32 checksum of each chunk's NewData
+	NoCompressExtensions []string      // Extensions of already compressed formats which are stored uncompressed
+	HashBufferSize       int           // Read buffer size used when hashing files, 0 uses the io.Copy default
+	UseMmap              bool          // Memory-map local files when hashing, falling back to streaming when unsupported
+	SkipHidden           bool          // Skip files and directories whose name starts with a dot
+	MaxTotalPatchBytes   int64         // Chunk bytes after which CompareDirs stops comparing more files, 0 is unlimited
+	VerifyPatches        bool          // Check that the compressed chunks of each file reproduce it from its old version
+	MinCompressionGain   float64       // Fraction of a chunk's size compression must save for the chunk to be stored compressed
+	TextOnly             bool          // Skip files whose handler is not the text handler
+	ReportUnchanged      bool          // Report identical files with the "unchanged" operation and no chunks
+	DedupContent         bool          // Store the chunks of results with the same old and new content once, see DiffResult.SameAs
+	MaxDepth             int           // Directory levels below the roots the walks descend into, files of the roots being at 0, 0 is unlimited
+	IgnoreFile           string        // Name of gitignore-style files of the new tree listing paths to skip, such as ".diffignore"
+	PreserveLinks        bool          // Report the hard links of the new tree and added empty directories, see DiffResult.LinkTo
+	CaseInsensitivePaths bool          // Match the paths of the old and new trees regardless of case, as on Windows and macOS
+	HTTPTimeout          time.Duration // Timeout of the requests of CompareURL, 0 uses 30 seconds
+
+	// FailFast stops the comparison at the first file which can't be compared, returning
+	// its FileError instead of a summary, rather than collecting the errors in
+	// DiffSummary.Errors. Files already being compared are waited for, the others are skipped.
+	FailFast bool
+
+	// IOConcurrency, when positive, bounds the number of files read at once independently
+	// of Concurrency, which then bounds the number of files compared by their handler at
+	// once, such as to read few files at a time from a spinning disk while comparing on all
+	// cores, or to keep many reads in flight on fast storage.
+	IOConcurrency int
+
+	// AutoCompressionLevel replaces CompressionLevel for the run by the gzip level which
+	// compresses the first data of the run the best while reaching AutoCompressionMBps
+	// megabytes per second, 20 when not set. The levels of CompressionLevels still apply.
+	AutoCompressionLevel bool
+	AutoCompressionMBps  float64
+
+	// ReportPermissionChanges compares the permissions of the old and new versions of each
+	// file, reporting a file whose content is unchanged but whose permissions differ with
+	// the "chmod" operation, and setting DiffResult.OldPermissions of the modified files
+	// whose permissions differ too.
+	ReportPermissionChanges bool
+
+	// RestorePermissions sets the permissions of the files written by ApplyResults to those
+	// of the new version, including those of "chmod" results. Otherwise files keep their
+	// permissions, and added files are created with the default ones.
+	RestorePermissions bool
+
+	// SparseFiles compares the files of the local disk with holes, such as virtual machine
+	// images, from the ranges holding data in either version, without reading the holes
+	// in memory, and stores the zero runs of the new version as OpZero chunks, which
+	// PatchFile writes as holes. Holes are only found on Linux, other platforms reading
+	// the files whole. VerifyPatches does not apply to these files.
+	SparseFiles bool
+
+	// LogBufferSize buffers up to this many bytes of log messages before writing them to
+	// the log file, 0 writes each message. LogFlushInterval additionally flushes the
+	// buffer periodically when positive. The buffer is always flushed when the engine is
+	// closed with DiffEngine.Close.
+	LogBufferSize    int
+	LogFlushInterval time.Duration
+
+	// OmitOldData drops the original bytes of each chunk from OldData, keeping only their
+	// length in OldLength, which halves the size of patches applied forward only, but the
+	// chunks can no longer be reversed with ReverseChunks nor checked against the original
+	// they are applied to.
+	OmitOldData bool
+
+	// CompressionLevels maps file extensions to the compression level of their chunks,
+	// overriding CompressionLevel.
+	CompressionLevels map[string]int
+
+	// ChunkTransform, when set, transforms the data of the chunks of each result after
+	// compression, such as to encrypt it. ReverseTransform undoes it before applying.
+	ChunkTransform ChunkTransform
+
+	// TypeOverrides maps relative paths or glob patterns to the file type of the handler
+	// used for the matching files, taking precedence over their extension. Patterns match
+	// the trailing components of the slash-separated path of a file relative to the root
+	// of its tree, and are tried in sorted order. They are read when the engine is created.
+	TypeOverrides map[string]string
+
+	// ModifiedSince skips files of the new tree whose modification time is not after it.
+	// Changes which preserve the modification time are missed when it is set.
+	ModifiedSince time.Time
+
+	// PathMap transforms a relative path from the new tree into the relative
+	// path of its counterpart in the old tree. Files whose mapped path does
+	// not exist in the old tree are reported as added.
+	PathMap func(relPath string) string
+
+	// CheckpointFile, when set, is where the comparison of directories saves its progress
+	// every CheckpointInterval compared files, 100 when not set, so that it can be resumed
+	// with ResumeFrom if interrupted. The file is removed once the comparison completes.
+	CheckpointFile     string
+	CheckpointInterval int
+
+	// ResumeFrom is the checkpoint file of an interrupted comparison of the same trees. The
+	// files it records are not compared again and its results are returned with the others.
+	ResumeFrom string
+
+	// Metrics, when set, receives measurements of the files compared and of the errors.
+	Metrics Metrics
+
+	// OnProgress is called after each file of the new tree is compared, from the
+	// goroutine which compared it, so it may be called concurrently.
+	OnProgress func(p Progress)
+
+	// SummaryUpdates, when set, receives copies of the summary of each comparison of
+	// directories while it runs, every SummaryInterval, 100ms when not set, such as to show
+	// running totals. Copies the channel is not ready to receive are dropped. The last one,
+	// sent when the comparison completes and equal to the summary it returns, is waited
+	// for up to SummaryInterval, so the channel should be read while the comparison runs
+	// to receive it; the comparison never blocks on a channel no longer read.
+	SummaryUpdates  chan<- DiffSummary
+	SummaryInterval time.Duration
+
+	// WalkFilter is called for each candidate file of the new tree, after the size
+	// check and before comparison. Files for which it returns false are skipped.
+	// Skipping a file does not by itself report it as deleted.
+	WalkFilter func(path string, info os.FileInfo) bool
+}
+
+// Validate checks that the configuration values are within their accepted ranges.
+func (c *Configuration) Validate() error {
+	if !validCompressionLevel(c.CompressionLevel) {
+		return fmt.Errorf("invalid compression level %d", c.CompressionLevel)
+	}
+
+	for ext, level := range c.CompressionLevels {
+		if !validCompressionLevel(level) {
+			return fmt.Errorf("invalid compression level %d for %s", level, ext)
+		}
+	}
+
+	return nil
+}
+
+// validCompressionLevel reports whether level is accepted by gzip.
+func validCompressionLevel(level int) bool {
+	return level >= gzip.HuffmanOnly && level <= gzip.BestCompression
 }
 
 func DefaultConfig() *Configuration {
 	return &Configuration{
-		CompressPatches:     true,
-		CompressionLevel:    gzip.BestCompression,
-		ChunkSize:           1024 * 1024, // 1MB chunks
-		Concurrency:         4,
-		PreservePermissions: true,
-		MaxFileSizeBytes:    1024 * 1024 * 100, // 100MB
-		BackupFiles:         true,
-		DetailedLogging:     false,
+		CompressPatches:    true,
+		CompressionLevel:   gzip.BestCompression,
+		ChunkSize:          1024 * 1024, // 1MB chunks
+		Concurrency:        4,
+		RestorePermissions: true,
+		MaxFileSizeBytes:   1024 * 1024 * 100, // 100MB
+		BackupFiles:        true,
+		DetailedLogging:    false,
+		NoCompressExtensions: []string{
+			".png", ".jpg", ".jpeg", ".gif", ".webp",
+			".gz", ".tgz", ".bz2", ".xz", ".zst", ".zip", ".7z", ".rar",
+			".mp3", ".mp4", ".mkv", ".webm",
+		},
 	}
 }
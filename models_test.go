@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestConfiguration_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Configuration)
+		wantErr bool
+	}{
+		{name: "Default", modify: func(c *Configuration) {}, wantErr: false},
+		{name: "Huffman only", modify: func(c *Configuration) { c.CompressionLevel = gzip.HuffmanOnly }, wantErr: false},
+		{name: "Level too high", modify: func(c *Configuration) { c.CompressionLevel = 10 }, wantErr: true},
+		{name: "Level too low", modify: func(c *Configuration) { c.CompressionLevel = -3 }, wantErr: true},
+		{
+			name: "Valid extension levels",
+			modify: func(c *Configuration) {
+				c.CompressionLevels = map[string]int{".log": gzip.BestSpeed, ".txt": gzip.NoCompression}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid extension level",
+			modify:  func(c *Configuration) { c.CompressionLevels = map[string]int{".log": 42} },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(config)
+
+			if err := config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newFileError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: &os.PathError{Op: "open", Path: "file", Err: os.ErrPermission}, want: ErrorCategoryPermission},
+		{err: fmt.Errorf("reading: %w", os.ErrPermission), want: ErrorCategoryPermission},
+		{err: fmt.Errorf("%w: mismatch", ErrPatchVerification), want: ErrorCategoryVerification},
+		{err: io.ErrUnexpectedEOF, want: ErrorCategoryOther},
+	}
+
+	for _, tt := range tests {
+		if got := newFileError("file", tt.err).Category; got != tt.want {
+			t.Errorf("newFileError(%v) category = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDiffChunk_OldLength(t *testing.T) {
+	old := []byte("first line\nsecond line\nthird line\nfourth line\n")
+	new := []byte("first line\nchanged line\nthird line\n")
+
+	handlers := []struct {
+		name    string
+		handler FileHandler
+	}{
+		{name: "Text", handler: &TextFileHandler{}},
+		{name: "Binary", handler: NewGenericBinaryHandler()},
+	}
+
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			chunks, err := h.handler.Compare(old, new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			// Patching relies on OldLength alone once OldData is dropped
+			stripped := make([]DiffChunk, len(chunks))
+			for i, chunk := range chunks {
+				if chunk.Op != OpInsert && chunk.OldLength != int64(len(chunk.OldData)) {
+					t.Errorf("chunk %d: expected OldLength %d, got %d", i, len(chunk.OldData), chunk.OldLength)
+				}
+
+				chunk.OldData = nil
+				stripped[i] = chunk
+			}
+
+			got, err := h.handler.Patch(old, stripped)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(got, new) {
+				t.Errorf("expected %q, got %q", new, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// NoOpHandler is a file handler for files whose content is never compared, such as
+// large media files. The engine reports them as added, modified or deleted from their
+// metadata alone, without reading them, and without content chunks. Only the files
+// reported unchanged with Configuration.ReportUnchanged are read, to hash them.
+type NoOpHandler struct{}
+
+// Makesure NoOpHandler implements the FileHandler interface
+var _ FileHandler = &NoOpHandler{}
+
+// Compare returns no chunks.
+func (h *NoOpHandler) Compare(old, new []byte) ([]DiffChunk, error) {
+	return nil, nil
+}
+
+// Patch returns the original data unchanged.
+func (h *NoOpHandler) Patch(original []byte, chunks []DiffChunk) ([]byte, error) {
+	return original, nil
+}
+
+// GetFileType returns the type of the file handler.
+func (h *NoOpHandler) GetFileType() string {
+	return "skipped"
+}
+
+// isNoOp reports whether handler never compares file contents.
+func isNoOp(handler FileHandler) bool {
+	_, ok := handler.(*NoOpHandler)
+	return ok
+}
+
+// compareMetadata compares two files handled by a NoOpHandler from their metadata only.
+// The new file is added when the old one does not exist, and modified when their size
+// or modification time differ.
+func (e *DiffEngine) compareMetadata(oldFS, newFS FileSystem, oldPath, newPath, relPath string, newInfo os.FileInfo) (*DiffResult, error) {
+	result := &DiffResult{
+		Path:        filepath.Base(newPath),
+		Operation:   "modified",
+		FileType:    e.getHandler(relPath).GetFileType(),
+		Size:        newInfo.Size(),
+		ModTime:     newInfo.ModTime(),
+		Permissions: newInfo.Mode(),
+	}
+
+	oldInfo, err := oldFS.Stat(oldPath)
+	if os.IsNotExist(err) {
+		result.Operation = "added"
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if oldInfo.Size() == newInfo.Size() && oldInfo.ModTime().Equal(newInfo.ModTime()) {
+		if !e.config.ReportUnchanged {
+			return nil, nil
+		}
+
+		// The hashes of the files reported unchanged are their actual ones
+		oldHash, err := e.cachedHash(oldFS, oldPath, oldInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		newHash, err := e.cachedHash(newFS, newPath, newInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		return e.unchangedResult(relPath, newInfo, oldHash, newHash), nil
+	}
+
+	return result, nil
+}
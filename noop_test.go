@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// mediaFileSystem fails reading the content of .mp4 files.
+type mediaFileSystem struct {
+	FileSystem
+}
+
+func (m *mediaFileSystem) Open(name string) (io.ReadCloser, error) {
+	if strings.HasSuffix(name, ".mp4") {
+		return nil, fmt.Errorf("unexpected read of %s", name)
+	}
+
+	return m.FileSystem.Open(name)
+}
+
+func TestCompareDirs_NoOpHandler(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"same.mp4":    "same video",
+		"changed.mp4": "old video",
+		"removed.mp4": "removed video",
+		"notes.txt":   "old notes\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"same.mp4":    "same video",
+		"changed.mp4": "new, longer video",
+		"added.mp4":   "added video",
+		"notes.txt":   "new notes\n",
+	})
+
+	// Unchanged files must keep their modification time to be recognized from metadata
+	modTime := time.Now().Add(-time.Hour)
+	for _, dir := range []string{oldDir, newDir} {
+		if err := os.Chtimes(filepath.Join(dir, "same.mp4"), modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+	engine.RegisterHandler(".mp4", &NoOpHandler{})
+	engine.SetFileSystem(&mediaFileSystem{FileSystem: OSFileSystem{}})
+
+	summary, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(summary.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", summary.Errors)
+	}
+
+	got := make(map[string]string)
+	for _, result := range results {
+		got[result.Path] = result.Operation
+
+		if result.FileType == "skipped" && len(result.Chunks) != 0 {
+			t.Errorf("%s: expected no chunks, got %d", result.Path, len(result.Chunks))
+		}
+	}
+
+	want := map[string]string{
+		"changed.mp4": "modified",
+		"added.mp4":   "added",
+		"removed.mp4": "deleted",
+		"notes.txt":   "modified",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+
+	if summary.FileTypes["skipped"] != 2 {
+		t.Errorf("expected 2 skipped files counted, got %d", summary.FileTypes["skipped"])
+	}
+}
@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"time"
+)
+
+// patchFileMagic is the first line of a patch file.
+const patchFileMagic = "DIFFPATCH1\n"
+
+// ErrInvalidPatchFile is returned when data is not a valid patch file.
+var ErrInvalidPatchFile = errors.New("invalid patch file")
+
+// PatchMetadata is the provenance of a patch file, stored in its header.
+type PatchMetadata struct {
+	Author      string
+	CreatedAt   time.Time
+	ToolVersion string // Version of the package which wrote the patch
+	Message     string // Optional description of the patch
+}
+
+// NewPatchMetadata returns the metadata of a patch built from a comparison,
+// created when the comparison ended by the current user with this version of the package.
+func NewPatchMetadata(summary *DiffSummary, message string) PatchMetadata {
+	meta := PatchMetadata{
+		CreatedAt:   time.Now(),
+		ToolVersion: Version,
+		Message:     message,
+	}
+
+	if summary != nil && !summary.EndTime.IsZero() {
+		meta.CreatedAt = summary.EndTime
+	}
+
+	if u, err := user.Current(); err == nil {
+		meta.Author = u.Username
+	}
+
+	return meta
+}
+
+// WritePatch writes the results with their metadata header in the patch file format.
+// A missing tool version or creation time is filled in.
+func WritePatch(w io.Writer, meta PatchMetadata, results []DiffResult) error {
+	if meta.ToolVersion == "" {
+		meta.ToolVersion = Version
+	}
+
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+
+	if _, err := io.WriteString(w, patchFileMagic); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(meta); err != nil {
+		return err
+	}
+
+	return encoder.Encode(results)
+}
+
+// ReadPatch reads the metadata and results of a patch written by WritePatch.
+func ReadPatch(r io.Reader) (*PatchMetadata, []DiffResult, error) {
+	reader := bufio.NewReader(r)
+
+	magic := make([]byte, len(patchFileMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != patchFileMagic {
+		return nil, nil, ErrInvalidPatchFile
+	}
+
+	decoder := json.NewDecoder(reader)
+
+	var meta PatchMetadata
+	if err := decoder.Decode(&meta); err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %v", ErrInvalidPatchFile, err)
+	}
+
+	var results []DiffResult
+	if err := decoder.Decode(&results); err != nil {
+		return nil, nil, fmt.Errorf("%w: results: %v", ErrInvalidPatchFile, err)
+	}
+
+	return &meta, results, nil
+}
+
+// WritePatchFile writes the results with their metadata to a patch file at path.
+func WritePatchFile(path string, meta PatchMetadata, results []DiffResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := WritePatch(file, meta, results); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ReadPatchFile reads the metadata and results of the patch file at path.
+func ReadPatchFile(path string) (*PatchMetadata, []DiffResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer file.Close()
+
+	return ReadPatch(file)
+}
@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPatchFile_RoundTrip(t *testing.T) {
+	meta := PatchMetadata{
+		Author:      "alice",
+		CreatedAt:   time.Date(2024, 5, 1, 12, 30, 45, 123, time.UTC),
+		ToolVersion: "0.9.0",
+		Message:     "Nightly backup",
+	}
+
+	results := []DiffResult{
+		{
+			Path:      "notes.txt",
+			Operation: "modified",
+			OldHash:   "old",
+			NewHash:   "new",
+			FileType:  "text",
+			Size:      12,
+			ModTime:   time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC),
+			Chunks: []DiffChunk{{
+				Offset:    4,
+				OldData:   []byte("two"),
+				NewData:   []byte("2"),
+				ChunkType: "text",
+				Op:        OpReplace,
+				Checksum:  42,
+			}},
+			Permissions: 0644,
+		},
+		{
+			Path:      "gone.bin",
+			Operation: "deleted",
+			OldHash:   "hash",
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.patch")
+
+	if err := WritePatchFile(path, meta, results); err != nil {
+		t.Fatalf("WritePatchFile returned an error: %v", err)
+	}
+
+	gotMeta, gotResults, err := ReadPatchFile(path)
+	if err != nil {
+		t.Fatalf("ReadPatchFile returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff(meta, *gotMeta); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(results, gotResults); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+}
+
+func TestPatchFile_Defaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.patch")
+
+	summary := &DiffSummary{EndTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+	meta := NewPatchMetadata(summary, "message")
+
+	if meta.CreatedAt != summary.EndTime {
+		t.Errorf("expected created at %v, got %v", summary.EndTime, meta.CreatedAt)
+	}
+
+	if err := WritePatchFile(path, PatchMetadata{}, nil); err != nil {
+		t.Fatalf("WritePatchFile returned an error: %v", err)
+	}
+
+	gotMeta, _, err := ReadPatchFile(path)
+	if err != nil {
+		t.Fatalf("ReadPatchFile returned an error: %v", err)
+	}
+
+	if gotMeta.ToolVersion != Version {
+		t.Errorf("expected tool version %s, got %s", Version, gotMeta.ToolVersion)
+	}
+
+	if gotMeta.CreatedAt.IsZero() {
+		t.Error("expected the creation time to be set")
+	}
+}
+
+func TestReadPatch_Invalid(t *testing.T) {
+	for _, data := range []string{"", "not a patch", patchFileMagic + "{", patchFileMagic + "{}\n[1]"} {
+		if _, _, err := ReadPatch(strings.NewReader(data)); !errors.Is(err, ErrInvalidPatchFile) {
+			t.Errorf("ReadPatch(%q): expected ErrInvalidPatchFile, got %v", data, err)
+		}
+	}
+}
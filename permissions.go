@@ -0,0 +1,47 @@
+package diff
+
+import (
+	"os"
+	"path"
+)
+
+// comparePermissions returns the result of a file compared by content, nil when it is
+// unchanged and not reported, with the change of its permissions: a "chmod" result in
+// place of an unchanged file, or OldPermissions set on a modified one.
+func (e *DiffEngine) comparePermissions(oldFS FileSystem, oldPath, relPath string, newInfo os.FileInfo, result *DiffResult) *DiffResult {
+	if result != nil && result.Operation != "unchanged" && result.Operation != "modified" && result.Operation != "rewritten" {
+		return result
+	}
+
+	oldInfo, err := oldFS.Stat(oldPath)
+	if err != nil || oldInfo.Mode().Perm() == newInfo.Mode().Perm() {
+		return result
+	}
+
+	if result == nil {
+		result = &DiffResult{
+			Path:     path.Base(relPath),
+			FileType: e.getHandler(relPath).GetFileType(),
+			Size:     newInfo.Size(),
+			ModTime:  newInfo.ModTime(),
+		}
+	}
+
+	if result.Operation == "unchanged" || result.Operation == "" {
+		result.Operation = "chmod"
+	}
+
+	result.Permissions = newInfo.Mode()
+	result.OldPermissions = oldInfo.Mode()
+
+	return result
+}
+
+// restorePermissions sets the permissions of path to those of the new version of its file.
+func restorePermissions(path string, result DiffResult) error {
+	if result.Permissions.Perm() == 0 {
+		return nil
+	}
+
+	return os.Chmod(path, result.Permissions.Perm())
+}
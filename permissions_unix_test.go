@@ -0,0 +1,90 @@
+//go:build unix
+
+package diff
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissionChanges(t *testing.T) {
+	for _, report := range []bool{false, true} {
+		for _, restore := range []bool{false, true} {
+			t.Run(fmt.Sprintf("report=%t,restore=%t", report, restore), func(t *testing.T) {
+				oldDir, newDir, outDir := t.TempDir(), t.TempDir(), t.TempDir()
+				writeTestTree(t, oldDir, map[string]string{"chmod.sh": "same\n", "both.txt": "old\n"})
+				writeTestTree(t, newDir, map[string]string{"chmod.sh": "same\n", "both.txt": "new\n"})
+
+				for name, mode := range map[string]os.FileMode{"chmod.sh": 0755, "both.txt": 0600} {
+					if err := os.Chmod(filepath.Join(newDir, name), mode); err != nil {
+						t.Fatalf("Failed to chmod %s: %v", name, err)
+					}
+				}
+
+				config := DefaultConfig()
+				config.ReportPermissionChanges = report
+				config.RestorePermissions = restore
+				engine := newTestEngine(t, config)
+
+				summary, results, err := engine.CompareDirs(oldDir, newDir)
+				if err != nil {
+					t.Fatalf("CompareDirs returned an error: %v", err)
+				}
+
+				got := make(map[string]DiffResult)
+				for _, result := range results {
+					got[result.RelPath] = result
+				}
+
+				chmod, reported := got["chmod.sh"]
+				if reported != report {
+					t.Errorf("expected chmod.sh reported to be %t, got %+v", report, results)
+				}
+
+				if report {
+					if chmod.Operation != "chmod" || chmod.OldPermissions.Perm() != 0644 || chmod.Permissions.Perm() != 0755 {
+						t.Errorf("expected a chmod from 0644 to 0755, got %+v", chmod)
+					}
+
+					if summary.ChmodFiles != 1 {
+						t.Errorf("expected 1 chmod file, got %d", summary.ChmodFiles)
+					}
+				}
+
+				wantOld := os.FileMode(0)
+				if report {
+					wantOld = 0644
+				}
+
+				if both := got["both.txt"]; both.Operation != "modified" || both.OldPermissions.Perm() != wantOld {
+					t.Errorf("expected both.txt modified with old permissions %v, got %+v", wantOld, both)
+				}
+
+				if err := engine.ApplyResults(oldDir, outDir, results); err != nil {
+					t.Fatalf("ApplyResults returned an error: %v", err)
+				}
+
+				want := map[string]os.FileMode{"chmod.sh": 0644, "both.txt": 0644}
+				if restore {
+					want["both.txt"] = 0600
+					if report {
+						want["chmod.sh"] = 0755
+					}
+				}
+
+				for name, mode := range want {
+					info, err := os.Stat(filepath.Join(outDir, name))
+					if err != nil {
+						t.Fatalf("Failed to stat %s: %v", name, err)
+					}
+
+					if info.Mode().Perm() != mode {
+						t.Errorf("expected %s to have permissions %v, got %v", name, mode, info.Mode().Perm())
+					}
+				}
+			})
+		}
+	}
+}
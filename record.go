@@ -0,0 +1,82 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RecordBinaryHandler is a file handler for binary formats made of fixed-size records,
+// such as database dumps, which compares them record by record. Each changed record is
+// a chunk aligned to its boundary, where a byte-level diff would shift bytes between
+// records. It is not registered by default; register it for the extensions of such
+// formats with DiffEngine.RegisterHandler. It implements the FileHandler interface.
+type RecordBinaryHandler struct {
+	RecordSize int // Size of each record in bytes
+}
+
+// Makesure RecordBinaryHandler implements the FileHandler interface
+var _ FileHandler = &RecordBinaryHandler{}
+
+// Compare compares two record streams and returns a chunk per changed record, plus a chunk
+// for the records added or removed at the end. The Record of each chunk is the index of
+// its first record. It returns ErrNotSupported when RecordSize is not positive, or either
+// file is not made of whole records.
+func (h *RecordBinaryHandler) Compare(old, new []byte) ([]DiffChunk, error) {
+	if bytes.Equal(old, new) {
+		return nil, nil
+	}
+
+	if h.RecordSize <= 0 {
+		return nil, fmt.Errorf("%w: invalid record size %d", ErrNotSupported, h.RecordSize)
+	}
+
+	size := int64(h.RecordSize)
+
+	if int64(len(old))%size != 0 || int64(len(new))%size != 0 {
+		return nil, fmt.Errorf("%w: sizes %d and %d are not multiples of the record size %d", ErrNotSupported, len(old), len(new), size)
+	}
+
+	common := int64(min(len(old), len(new)))
+	chunks := make([]DiffChunk, 0)
+
+	for offset := int64(0); offset < common; offset += size {
+		end := offset + size
+		if bytes.Equal(old[offset:end], new[offset:end]) {
+			continue
+		}
+
+		chunks = append(chunks, DiffChunk{
+			Offset:    offset,
+			OldData:   old[offset:end],
+			OldLength: end - offset,
+			NewData:   new[offset:end],
+			ChunkType: "record",
+			Op:        OpReplace,
+			Record:    offset / size,
+		})
+	}
+
+	if len(old) != len(new) {
+		chunks = append(chunks, DiffChunk{
+			Offset:    common,
+			OldData:   old[common:],
+			OldLength: int64(len(old)) - common,
+			NewData:   new[common:],
+			ChunkType: "record",
+			Op:        chunkOp(old[common:], new[common:]),
+			Record:    common / size,
+		})
+	}
+
+	return chunks, nil
+}
+
+// Patch applies the given DiffChunks to the original data and returns the patched data.
+func (h *RecordBinaryHandler) Patch(original []byte, chunks []DiffChunk) ([]byte, error) {
+	return patchAtOffsets(original, chunks)
+}
+
+// GetFileType returns the type of the file handler.
+func (h *RecordBinaryHandler) GetFileType() string {
+	return "record"
+}
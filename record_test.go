@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testRecords returns a stream of 16-byte records holding their index and a value.
+func testRecords(values ...uint64) []byte {
+	var buf bytes.Buffer
+	for i, value := range values {
+		binary.Write(&buf, binary.LittleEndian, uint64(i))
+		binary.Write(&buf, binary.LittleEndian, value)
+	}
+
+	return buf.Bytes()
+}
+
+func TestRecordBinaryHandler_Compare(t *testing.T) {
+	old := testRecords(10, 20, 30, 40, 50)
+
+	tests := []struct {
+		name        string
+		new         []byte
+		wantRecords []int64
+		wantOps     []string
+	}{
+		{name: "One changed record", new: testRecords(10, 20, 31, 40, 50), wantRecords: []int64{2}, wantOps: []string{OpReplace}},
+		{name: "Changed and appended records", new: testRecords(11, 20, 30, 40, 50, 60, 70), wantRecords: []int64{0, 5}, wantOps: []string{OpReplace, OpInsert}},
+		{name: "Removed records", new: testRecords(10, 20, 30), wantRecords: []int64{3}, wantOps: []string{OpDelete}},
+	}
+
+	handler := &RecordBinaryHandler{RecordSize: 16}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := handler.Compare(old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if len(chunks) != len(tt.wantRecords) {
+				t.Fatalf("expected %d chunks, got %d", len(tt.wantRecords), len(chunks))
+			}
+
+			for i, chunk := range chunks {
+				if chunk.Record != tt.wantRecords[i] || chunk.Op != tt.wantOps[i] {
+					t.Errorf("chunk %d: expected %s of record %d, got %s of record %d", i, tt.wantOps[i], tt.wantRecords[i], chunk.Op, chunk.Record)
+				}
+
+				if chunk.Offset != chunk.Record*16 {
+					t.Errorf("chunk %d: expected offset %d, got %d", i, chunk.Record*16, chunk.Offset)
+				}
+			}
+
+			if chunks[0].Op == OpReplace && len(chunks[0].NewData) != 16 {
+				t.Errorf("expected a chunk of a whole record, got %d bytes", len(chunks[0].NewData))
+			}
+
+			patched, err := handler.Patch(old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match new data")
+			}
+		})
+	}
+}
+
+func TestRecordBinaryHandler_NotSupported(t *testing.T) {
+	old := testRecords(1, 2)
+
+	tests := []struct {
+		name       string
+		recordSize int
+		new        []byte
+	}{
+		{name: "Partial record", recordSize: 16, new: append(testRecords(1, 2), 0xff)},
+		{name: "Invalid record size", recordSize: 0, new: testRecords(1, 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (&RecordBinaryHandler{RecordSize: tt.recordSize}).Compare(old, tt.new); !errors.Is(err, ErrNotSupported) {
+				t.Errorf("expected ErrNotSupported, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_RecordBinaryHandler(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"dump.rec": string(testRecords(1, 2, 3, 4))})
+	writeTestTree(t, newDir, map[string]string{"dump.rec": string(testRecords(1, 2, 7, 4))})
+
+	config := DefaultConfig()
+	config.CompressPatches = false
+
+	engine := newTestEngine(t, config)
+	engine.RegisterHandler(".rec", &RecordBinaryHandler{RecordSize: 16})
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 1 || results[0].FileType != "record" {
+		t.Fatalf("expected a single record result, got %+v", results)
+	}
+
+	if chunks := results[0].Chunks; len(chunks) != 1 || chunks[0].Record != 2 {
+		t.Errorf("expected a single chunk of record 2, got %+v", chunks)
+	}
+}
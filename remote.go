@@ -0,0 +1,126 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// defaultHTTPTimeout is the timeout of the requests of CompareURL when
+// Configuration.HTTPTimeout is not set.
+const defaultHTTPTimeout = 30 * time.Second
+
+// remoteVersion is the version of a remote file compared by CompareURL, identified by
+// the ETag the server returned, with the result of its comparison.
+type remoteVersion struct {
+	etag      string
+	localHash string
+	result    *DiffResult
+}
+
+// CompareURL compares the remote file at url, such as a published artifact, as the old
+// version with the local file at localPath as the new one, using the handler of the local
+// file. The remote file is fetched within Configuration.HTTPTimeout and may be at most
+// MaxFileSizeBytes. When the server returned an ETag for the previous comparison of url,
+// it is sent in If-None-Match, and if the remote file was not modified while the local
+// file is unchanged, the previous result is returned without downloading the file again.
+// Like CompareFiles, nil is returned for identical files.
+func (e *DiffEngine) CompareURL(localPath, url string) (*DiffResult, error) {
+	fsys := e.getFileSystem()
+
+	newInfo, err := fsys.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	localHash, err := e.hashFile(fsys, localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	previous, cached := e.remoteVersions[url]
+	e.mu.RUnlock()
+
+	cached = cached && previous.localHash == localHash
+	if cached {
+		req.Header.Set("If-None-Match", previous.etag)
+	}
+
+	timeout := e.config.HTTPTimeout
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		e.logger.Log("Remote file not modified: %s", url)
+
+		if previous.result == nil {
+			return nil, nil
+		}
+
+		result := *previous.result
+		return &result, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	// The body is read up to one byte past the limit to detect larger files
+	data, err := io.ReadAll(io.LimitReader(resp.Body, e.config.MaxFileSizeBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+
+	if int64(len(data)) > e.config.MaxFileSizeBytes {
+		return nil, fmt.Errorf("%s: %w: more than %d bytes", url, ErrFileTooLarge, e.config.MaxFileSizeBytes)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	name := path.Base(req.URL.Path)
+	if name == "/" || name == "." {
+		name = "remote"
+	}
+
+	remote := newSnapshotFS()
+	remote.add(name, &snapshotFile{data: data, mode: 0644, modTime: modTime})
+
+	result, err := e.compareFiles(FromFS(remote), fsys, name, localPath, filepath.Base(localPath), newInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		version := remoteVersion{etag: etag, localHash: localHash}
+		if result != nil {
+			copied := *result
+			version.result = &copied
+		}
+
+		e.mu.Lock()
+		if e.remoteVersions == nil {
+			e.remoteVersions = make(map[string]remoteVersion)
+		}
+
+		e.remoteVersions[url] = version
+		e.mu.Unlock()
+	}
+
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package diff
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompareURL(t *testing.T) {
+	versions := map[string]string{
+		`"v1"`: "first line\nsecond line\n",
+		`"v2"`: "first line\nsecond line changed\n",
+	}
+
+	current := `"v1"`
+	var downloads atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", current)
+		if r.Header.Get("If-None-Match") == current {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		downloads.Add(1)
+		w.Write([]byte(versions[current]))
+	}))
+	defer server.Close()
+
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(localPath, []byte("first line\nsecond line edited locally\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := newTestEngine(t, DefaultConfig())
+	url := server.URL + "/file.txt"
+
+	result, err := engine.CompareURL(localPath, url)
+	if err != nil {
+		t.Fatalf("CompareURL returned an error: %v", err)
+	}
+
+	if result == nil || result.Operation != "modified" || len(result.Chunks) == 0 {
+		t.Fatalf("expected a modified result with chunks, got %+v", result)
+	}
+
+	// An unmodified remote file is not downloaded again
+	cached, err := engine.CompareURL(localPath, url)
+	if err != nil {
+		t.Fatalf("CompareURL returned an error: %v", err)
+	}
+
+	if got := downloads.Load(); got != 1 {
+		t.Errorf("expected 1 download, got %d", got)
+	}
+
+	if cached == nil || cached.NewHash != result.NewHash || len(cached.Chunks) != len(result.Chunks) {
+		t.Errorf("expected the cached result %+v, got %+v", result, cached)
+	}
+
+	// A new remote version is downloaded and compared
+	current = `"v2"`
+
+	updated, err := engine.CompareURL(localPath, url)
+	if err != nil {
+		t.Fatalf("CompareURL returned an error: %v", err)
+	}
+
+	if got := downloads.Load(); got != 2 {
+		t.Errorf("expected 2 downloads, got %d", got)
+	}
+
+	if updated == nil || updated.OldHash == result.OldHash {
+		t.Errorf("expected a result against the new remote version, got %+v", updated)
+	}
+
+	// The local file matching the remote one is reported as identical
+	if err := os.WriteFile(localPath, []byte(versions[current]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	same, err := engine.CompareURL(localPath, url)
+	if err != nil {
+		t.Fatalf("CompareURL returned an error: %v", err)
+	}
+
+	if same != nil {
+		t.Errorf("expected no result for identical files, got %+v", same)
+	}
+}
+
+func TestCompareURL_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(make([]byte, 2048))
+	}))
+	defer server.Close()
+
+	localPath := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(localPath, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.MaxFileSizeBytes = 1024
+
+	engine := newTestEngine(t, config)
+
+	if _, err := engine.CompareURL(localPath, server.URL+"/large"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+
+	if _, err := engine.CompareURL(localPath, server.URL+"/missing"); err == nil {
+		t.Error("expected an error for a missing remote file")
+	}
+}
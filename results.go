@@ -0,0 +1,62 @@
+package diff
+
+import "fmt"
+
+// FilterByOperation returns the results whose operation is one of ops.
+// The original order of the results is preserved.
+func FilterByOperation(results []DiffResult, ops ...string) []DiffResult {
+	wanted := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		wanted[op] = true
+	}
+
+	filtered := make([]DiffResult, 0)
+	for _, result := range results {
+		if wanted[result.Operation] {
+			filtered = append(filtered, result)
+		}
+	}
+
+	return filtered
+}
+
+// GroupByFileType groups the results by their file type.
+// Within each group the original order of the results is preserved.
+func GroupByFileType(results []DiffResult) map[string][]DiffResult {
+	groups := make(map[string][]DiffResult)
+	for _, result := range results {
+		groups[result.FileType] = append(groups[result.FileType], result)
+	}
+
+	return groups
+}
+
+// ResolveReferences returns a copy of the results in which the results deduplicated with
+// Configuration.DedupContent hold the chunks of the result they refer to again.
+func ResolveReferences(results []DiffResult) ([]DiffResult, error) {
+	byPath := make(map[string]int, len(results))
+	for i, result := range results {
+		byPath[result.RelPath] = i
+	}
+
+	resolved := make([]DiffResult, len(results))
+	copy(resolved, results)
+
+	for i := range resolved {
+		result := &resolved[i]
+		if result.SameAs == "" {
+			continue
+		}
+
+		j, ok := byPath[result.SameAs]
+		if !ok || results[j].SameAs != "" {
+			return nil, fmt.Errorf("%s: referenced result %s not found", result.RelPath, result.SameAs)
+		}
+
+		result.Chunks = results[j].Chunks
+		result.IsCompressed = results[j].IsCompressed
+		result.SameAs = ""
+	}
+
+	return resolved, nil
+}
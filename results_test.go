@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testResults = []DiffResult{
+	{Path: "a.txt", Operation: "added", FileType: "text"},
+	{Path: "b.bin", Operation: "modified", FileType: "binary"},
+	{Path: "c.txt", Operation: "deleted"},
+	{Path: "d.md", Operation: "modified", FileType: "text"},
+	{Path: "e.bin", Operation: "added", FileType: "binary"},
+}
+
+func TestFilterByOperation(t *testing.T) {
+	tests := []struct {
+		name  string
+		ops   []string
+		paths []string
+	}{
+		{name: "Added only", ops: []string{"added"}, paths: []string{"a.txt", "e.bin"}},
+		{name: "Added and deleted", ops: []string{"deleted", "added"}, paths: []string{"a.txt", "c.txt", "e.bin"}},
+		{name: "Unknown operation", ops: []string{"renamed"}, paths: []string{}},
+		{name: "No operations", ops: nil, paths: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := make([]string, 0)
+			for _, result := range FilterByOperation(testResults, tt.ops...) {
+				paths = append(paths, result.Path)
+			}
+
+			if diff := cmp.Diff(tt.paths, paths); diff != "" {
+				t.Errorf("unexpected paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGroupByFileType(t *testing.T) {
+	groups := GroupByFileType(testResults)
+
+	want := map[string][]string{
+		"text":   {"a.txt", "d.md"},
+		"binary": {"b.bin", "e.bin"},
+		"":       {"c.txt"},
+	}
+
+	got := make(map[string][]string)
+	for fileType, results := range groups {
+		for _, result := range results {
+			got[fileType] = append(got[fileType], result.Path)
+		}
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected groups (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	chunks := []DiffChunk{{NewData: []byte("shared"), Op: OpInsert}}
+
+	results := []DiffResult{
+		{RelPath: "a.txt", Operation: "added", Chunks: chunks},
+		{RelPath: "b.txt", Operation: "added", SameAs: "a.txt"},
+	}
+
+	resolved, err := ResolveReferences(results)
+	if err != nil {
+		t.Fatalf("ResolveReferences returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff(chunks, resolved[1].Chunks); diff != "" || resolved[1].SameAs != "" {
+		t.Errorf("unexpected resolved chunks (-want +got):\n%s", diff)
+	}
+
+	if results[1].Chunks != nil {
+		t.Error("expected the original results to be left unchanged")
+	}
+
+	if _, err := ResolveReferences(results[1:]); err == nil {
+		t.Error("expected an error for a missing referenced result")
+	}
+}
@@ -0,0 +1,213 @@
+package diff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidDelta is returned when a delta does not apply to the data it was computed for.
+var ErrInvalidDelta = errors.New("invalid rsync delta")
+
+// Signature holds the checksums of the fixed size blocks of a file, from which
+// a delta to another version can be computed without access to the file itself.
+type Signature struct {
+	BlockSize int
+	Length    int64 // Length of the file, the last block may be shorter than BlockSize
+	Blocks    []BlockChecksum
+}
+
+// BlockChecksum holds the weak rolling checksum and the strong checksum of a block.
+type BlockChecksum struct {
+	Weak   uint32
+	Strong [sha256.Size]byte
+}
+
+// blockLength returns the length of the block at index i.
+func (s *Signature) blockLength(i int) int {
+	return int(min(int64(s.BlockSize), s.Length-int64(i)*int64(s.BlockSize)))
+}
+
+// RsyncSignature computes the signature of old, split into blocks of blockSize bytes.
+// A blockSize of zero or less uses the handler's ChunkSize.
+func (h *GenericBinaryHandler) RsyncSignature(old []byte, blockSize int) *Signature {
+	if blockSize <= 0 {
+		blockSize = int(h.ChunkSize)
+	}
+
+	sig := &Signature{
+		BlockSize: blockSize,
+		Length:    int64(len(old)),
+		Blocks:    make([]BlockChecksum, 0, (len(old)+blockSize-1)/blockSize),
+	}
+
+	for offset := 0; offset < len(old); offset += blockSize {
+		block := old[offset:min(offset+blockSize, len(old))]
+
+		sig.Blocks = append(sig.Blocks, BlockChecksum{
+			Weak:   newRollingChecksum(block).sum(),
+			Strong: sha256.Sum256(block),
+		})
+	}
+
+	return sig
+}
+
+// RsyncDelta computes the delta transforming the file described by sig into new, like rsync.
+// The weak checksum is rolled over new to find blocks of the old file, confirmed by their
+// strong checksum. The delta is in the order of new: OpCopy chunks copy the old block
+// at their Offset, also set in CopyFrom with its length in CopyLength, and OpInsert
+// chunks hold literal data.
+func (h *GenericBinaryHandler) RsyncDelta(sig *Signature, new []byte) []DiffChunk {
+	delta := make([]DiffChunk, 0)
+
+	literal := func(data []byte) {
+		if len(data) > 0 {
+			delta = append(delta, DiffChunk{NewData: data, ChunkType: "binary", Op: OpInsert})
+		}
+	}
+
+	if sig == nil || len(sig.Blocks) == 0 {
+		literal(new)
+		return delta
+	}
+
+	blocks := make(map[uint32][]int, len(sig.Blocks))
+	for i, block := range sig.Blocks {
+		blocks[block.Weak] = append(blocks[block.Weak], i)
+	}
+
+	// match returns the index of the block with the same contents as data, or -1.
+	match := func(weak uint32, data []byte) int {
+		candidates, ok := blocks[weak]
+		if !ok {
+			return -1
+		}
+
+		strong := sha256.Sum256(data)
+		for _, i := range candidates {
+			if sig.blockLength(i) == len(data) && sig.Blocks[i].Strong == strong {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	copyBlock := func(i int) {
+		offset := int64(i) * int64(sig.BlockSize)
+		delta = append(delta, DiffChunk{
+			Offset:     offset,
+			ChunkType:  "binary",
+			Op:         OpCopy,
+			CopyFrom:   offset,
+			CopyLength: int64(sig.blockLength(i)),
+		})
+	}
+
+	blockSize := sig.BlockSize
+	literalStart, pos := 0, 0
+
+	var rolling *rollingChecksum
+	for pos+blockSize <= len(new) {
+		if rolling == nil {
+			rolling = newRollingChecksum(new[pos : pos+blockSize])
+		}
+
+		if i := match(rolling.sum(), new[pos:pos+blockSize]); i >= 0 {
+			literal(new[literalStart:pos])
+			copyBlock(i)
+
+			pos += blockSize
+			literalStart = pos
+			rolling = nil
+
+			continue
+		}
+
+		if pos+blockSize < len(new) {
+			rolling.roll(new[pos], new[pos+blockSize])
+		}
+
+		pos++
+	}
+
+	// The last block of the old file may be shorter, and can only match the end of new
+	if tail := sig.blockLength(len(sig.Blocks) - 1); tail < blockSize && len(new)-literalStart >= tail {
+		end := new[len(new)-tail:]
+		if i := match(newRollingChecksum(end).sum(), end); i >= 0 {
+			literal(new[literalStart : len(new)-tail])
+			copyBlock(i)
+
+			return delta
+		}
+	}
+
+	literal(new[literalStart:])
+
+	return delta
+}
+
+// ApplyRsyncDelta reconstructs the new file from old and the delta computed against its signature.
+func (h *GenericBinaryHandler) ApplyRsyncDelta(old []byte, sig *Signature, delta []DiffChunk) ([]byte, error) {
+	if sig == nil || sig.Length != int64(len(old)) {
+		return nil, fmt.Errorf("%w: signature does not describe the old data", ErrInvalidDelta)
+	}
+
+	var buf bytes.Buffer
+
+	for i, chunk := range delta {
+		switch chunk.Op {
+		case OpInsert:
+			buf.Write(chunk.NewData)
+		case OpCopy:
+			if sig.BlockSize <= 0 || chunk.Offset < 0 || chunk.Offset%int64(sig.BlockSize) != 0 || chunk.Offset >= sig.Length {
+				return nil, fmt.Errorf("%w: chunk %d copies invalid offset %d", ErrInvalidDelta, i, chunk.Offset)
+			}
+
+			block := int(chunk.Offset / int64(sig.BlockSize))
+			buf.Write(old[chunk.Offset : chunk.Offset+int64(sig.blockLength(block))])
+		default:
+			return nil, fmt.Errorf("%w: chunk %d has unexpected operation %q", ErrInvalidDelta, i, chunk.Op)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// rollingChecksumMod is the modulus of the sums of the rolling checksum.
+const rollingChecksumMod = 1 << 16
+
+// rollingChecksum is the Adler-32 style weak checksum used by rsync, which can be
+// updated in constant time as its window slides by one byte.
+type rollingChecksum struct {
+	a, b   uint32
+	window uint32
+}
+
+// newRollingChecksum computes the checksum of the window data.
+func newRollingChecksum(data []byte) *rollingChecksum {
+	r := &rollingChecksum{window: uint32(len(data))}
+
+	for i, c := range data {
+		r.a += uint32(c)
+		r.b += uint32(len(data)-i) * uint32(c)
+	}
+
+	r.a %= rollingChecksumMod
+	r.b %= rollingChecksumMod
+
+	return r
+}
+
+// roll slides the window by one byte, removing out and adding in.
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a = (r.a - uint32(out) + uint32(in)) % rollingChecksumMod
+	r.b = (r.b - r.window*uint32(out) + r.a) % rollingChecksumMod
+}
+
+// sum returns the checksum of the window.
+func (r *rollingChecksum) sum() uint32 {
+	return r.a | r.b<<16
+}
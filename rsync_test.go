@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRsyncDelta_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	old := make([]byte, 10000)
+	rng.Read(old)
+
+	modified := append([]byte(nil), old[:3000]...)
+	modified = append(modified, []byte("inserted in the middle")...)
+	modified = append(modified, old[3000:7000]...)
+	modified = append(modified, old[7500:]...)
+	modified[100] ^= 0xff
+
+	tests := []struct {
+		name       string
+		old        []byte
+		new        []byte
+		blockSize  int
+		wantCopies int // Minimum number of copied blocks
+	}{
+		{name: "Identical", old: old, new: old, blockSize: 512, wantCopies: 20},
+		{name: "Insert, delete and replace", old: old, new: modified, blockSize: 512, wantCopies: 15},
+		{name: "Unaligned length", old: old[:9999], new: modified[:len(modified)-1], blockSize: 700, wantCopies: 10},
+		{name: "Empty old", old: nil, new: []byte("all new"), blockSize: 16, wantCopies: 0},
+		{name: "Empty new", old: old, new: nil, blockSize: 16, wantCopies: 0},
+		{name: "Default block size", old: old, new: modified, blockSize: 0, wantCopies: 1},
+	}
+
+	h := NewGenericBinaryHandler()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := h.RsyncSignature(tt.old, tt.blockSize)
+			delta := h.RsyncDelta(sig, tt.new)
+
+			got, err := h.ApplyRsyncDelta(tt.old, sig, delta)
+			if err != nil {
+				t.Fatalf("ApplyRsyncDelta returned an error: %v", err)
+			}
+
+			if !bytes.Equal(got, tt.new) {
+				t.Fatalf("round trip does not reproduce new data")
+			}
+
+			var copies, literal int
+			for _, chunk := range delta {
+				if chunk.Op == OpCopy {
+					copies++
+				} else {
+					literal += len(chunk.NewData)
+				}
+			}
+
+			if copies < tt.wantCopies {
+				t.Errorf("expected at least %d copied blocks, got %d", tt.wantCopies, copies)
+			}
+
+			if copies > 0 && literal >= len(tt.new) {
+				t.Errorf("expected less than %d literal bytes, got %d", len(tt.new), literal)
+			}
+		})
+	}
+}
+
+func TestRollingChecksum_Roll(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	window := 8
+
+	rolling := newRollingChecksum(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		rolling.roll(data[i-1], data[i+window-1])
+
+		if want := newRollingChecksum(data[i : i+window]).sum(); rolling.sum() != want {
+			t.Fatalf("window %d: expected checksum %08x, got %08x", i, want, rolling.sum())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package diff
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// signatureMagic starts the wire format of a signature, followed by its version.
+const signatureMagic = "DSIG"
+
+// signatureVersion is the version of the wire format written by ExportSignature.
+const signatureVersion = 1
+
+// ErrInvalidSignature is returned when data is not a valid signature in the wire format.
+var ErrInvalidSignature = errors.New("invalid signature encoding")
+
+// ExportSignature writes the signature of data, split into blocks of blockSize bytes, in
+// the wire format read by ImportSignature, for a client to compute a delta with RsyncDelta.
+// The format is the magic "DSIG" and a version byte, the block size and the data length as
+// uvarints, then for each block its weak checksum as 4 big-endian bytes and its SHA256.
+// The blocks are written as they are computed. A blockSize of zero or less uses the
+// ChunkSize of the default binary handler.
+func ExportSignature(w io.Writer, data []byte, blockSize int) error {
+	if blockSize <= 0 {
+		blockSize = int(NewGenericBinaryHandler().ChunkSize)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	header := append([]byte(signatureMagic), signatureVersion)
+	header = binary.AppendUvarint(header, uint64(blockSize))
+	header = binary.AppendUvarint(header, uint64(len(data)))
+
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	var entry [4 + sha256.Size]byte
+	for offset := 0; offset < len(data); offset += blockSize {
+		block := data[offset:min(offset+blockSize, len(data))]
+
+		strong := sha256.Sum256(block)
+		binary.BigEndian.PutUint32(entry[:4], newRollingChecksum(block).sum())
+		copy(entry[4:], strong[:])
+
+		if _, err := bw.Write(entry[:]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ImportSignature reads a signature written by ExportSignature. It returns an error
+// wrapping ErrInvalidSignature when the data is malformed or of an unsupported version.
+func ImportSignature(r io.Reader) (*Signature, error) {
+	br := bufio.NewReader(r)
+
+	var head [len(signatureMagic) + 1]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil || string(head[:len(signatureMagic)]) != signatureMagic {
+		return nil, ErrInvalidSignature
+	}
+
+	if version := head[len(signatureMagic)]; version != signatureVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSignature, version)
+	}
+
+	blockSize, err := binary.ReadUvarint(br)
+	if err != nil || blockSize == 0 || blockSize > 1<<31-1 {
+		return nil, ErrInvalidSignature
+	}
+
+	length, err := binary.ReadUvarint(br)
+	if err != nil || length > 1<<62 {
+		return nil, ErrInvalidSignature
+	}
+
+	sig := &Signature{
+		BlockSize: int(blockSize),
+		Length:    int64(length),
+	}
+
+	// The blocks are read incrementally, so a corrupt length cannot allocate more than the input
+	count := (length + blockSize - 1) / blockSize
+	for i := uint64(0); i < count; i++ {
+		var entry [4 + sha256.Size]byte
+		if _, err := io.ReadFull(br, entry[:]); err != nil {
+			return nil, fmt.Errorf("%w: block %d: %v", ErrInvalidSignature, i, err)
+		}
+
+		block := BlockChecksum{Weak: binary.BigEndian.Uint32(entry[:4])}
+		copy(block.Strong[:], entry[4:])
+
+		sig.Blocks = append(sig.Blocks, block)
+	}
+
+	return sig, nil
+}
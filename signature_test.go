@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestExportSignature_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	old := make([]byte, 10000)
+	rng.Read(old)
+
+	modified := append([]byte(nil), old[:3000]...)
+	modified = append(modified, []byte("inserted in the middle")...)
+	modified = append(modified, old[3000:]...)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		blockSize int
+	}{
+		{name: "Aligned length", data: old, blockSize: 500},
+		{name: "Unaligned length", data: old[:9999], blockSize: 700},
+		{name: "Empty", data: nil, blockSize: 16},
+		{name: "Default block size", data: old, blockSize: 0},
+	}
+
+	h := NewGenericBinaryHandler()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ExportSignature(&buf, tt.data, tt.blockSize); err != nil {
+				t.Fatalf("ExportSignature returned an error: %v", err)
+			}
+
+			sig, err := ImportSignature(&buf)
+			if err != nil {
+				t.Fatalf("ImportSignature returned an error: %v", err)
+			}
+
+			if diff := cmp.Diff(h.RsyncSignature(tt.data, tt.blockSize), sig, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("signature mismatch (-want +got):\n%s", diff)
+			}
+
+			// The imported signature is usable for a delta
+			got, err := h.ApplyRsyncDelta(tt.data, sig, h.RsyncDelta(sig, modified))
+			if err != nil {
+				t.Fatalf("ApplyRsyncDelta returned an error: %v", err)
+			}
+
+			if !bytes.Equal(got, modified) {
+				t.Errorf("delta from the imported signature does not reproduce new data")
+			}
+		})
+	}
+}
+
+func TestImportSignature_Invalid(t *testing.T) {
+	var valid bytes.Buffer
+	if err := ExportSignature(&valid, bytes.Repeat([]byte("data"), 100), 64); err != nil {
+		t.Fatalf("ExportSignature returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "Bad magic", data: []byte("XSIG\x01\x40\x10")},
+		{name: "Unsupported version", data: []byte("DSIG\x02\x40\x10")},
+		{name: "Zero block size", data: []byte("DSIG\x01\x00\x10")},
+		{name: "Truncated header", data: []byte("DSIG\x01\x40")},
+		{name: "Truncated blocks", data: valid.Bytes()[:valid.Len()-1]},
+		{name: "Huge length", data: []byte("DSIG\x01\x01\xff\xff\xff\xff\x0f")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ImportSignature(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+}
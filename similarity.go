@@ -0,0 +1,207 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Similarity returns the fraction of the bytes of the larger of a and b left unchanged by
+// their binary diff, from 0 for unrelated data to 1 for equal data.
+func Similarity(a, b []byte) float64 {
+	if bytes.Equal(a, b) {
+		return 1
+	}
+
+	chunks, err := NewGenericBinaryHandler().Compare(a, b)
+	if err != nil {
+		return 0
+	}
+
+	return 1 - changeRatio(chunks, len(a), len(b))
+}
+
+// ClusterSimilar groups the files of dir whose Similarity is at least threshold, directly
+// or through other files of their group, for deduplication analysis. Each cluster holds
+// the sorted slash-separated paths relative to dir of at least two files, and clusters are
+// sorted by their first path. Only the regular files are grouped, filtered by the ignore
+// rules, size limit and walk filter of the configuration; its other options, which tune
+// comparisons, don't apply. Only the files whose sizes are close enough for their
+// similarity to reach threshold are compared, and files with the same content are grouped
+// from their hashes.
+func (e *DiffEngine) ClusterSimilar(dir string, threshold float64) ([][]string, error) {
+	if threshold < 0 || threshold > 1 {
+		return nil, fmt.Errorf("invalid similarity threshold %v", threshold)
+	}
+
+	fsys := e.getFileSystem()
+
+	files, errs, err := e.hashTree(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The similarity of two files is at most the ratio of their sizes, so each file is
+	// compared with the larger ones only until that ratio falls below threshold
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size < files[j].Size
+		}
+
+		return files[i].RelPath < files[j].RelPath
+	})
+
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+
+		return parent[i]
+	}
+
+	read := func(file DiffResult) ([]byte, error) {
+		return e.readFile(fsys, filepath.Join(dir, file.LocalPath()))
+	}
+
+	for i := range files {
+		// The content of the file is read once, when it is first compared
+		var a []byte
+
+		for j := i + 1; j < len(files); j++ {
+			if float64(files[i].Size) < threshold*float64(files[j].Size) {
+				break
+			}
+
+			if find(i) == find(j) {
+				continue
+			}
+
+			if files[i].NewHash != files[j].NewHash {
+				if a == nil {
+					if a, err = read(files[i]); err != nil {
+						errs = append(errs, newFileError(files[i].RelPath, err))
+						break
+					}
+				}
+
+				b, err := read(files[j])
+				if err != nil {
+					errs = append(errs, newFileError(files[j].RelPath, err))
+					continue
+				}
+
+				if Similarity(a, b) < threshold {
+					continue
+				}
+			}
+
+			parent[find(j)] = find(i)
+		}
+	}
+
+	groups := make(map[int][]string)
+	for i, file := range files {
+		root := find(i)
+		groups[root] = append(groups[root], file.RelPath)
+	}
+
+	clusters := make([][]string, 0)
+	for _, paths := range groups {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Strings(paths)
+		clusters = append(clusters, paths)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0] < clusters[j][0]
+	})
+
+	return clusters, errors.Join(errs...)
+}
+
+// hashTree returns the regular files of dir filtered as by ClusterSimilar, as results
+// holding their RelPath, size and hash, along with the errors of the files which could not
+// be read.
+func (e *DiffEngine) hashTree(fsys FileSystem, dir string) ([]DiffResult, []error, error) {
+	files := make([]DiffResult, 0)
+	var errs []error
+
+	var ignore *ignoreMatcher
+	if e.config.IgnoreFile != "" {
+		ignore = &ignoreMatcher{}
+	}
+
+	err := fsys.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		relPath, relErr := filepath.Rel(dir, path)
+
+		if os.IsPermission(err) {
+			errs = append(errs, newFileError(relPath, err))
+
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if relErr != nil {
+			return relErr
+		}
+
+		if e.config.SkipHidden && isHidden(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() {
+			if e.tooDeep(relPath) {
+				return filepath.SkipDir
+			}
+
+			if ignore != nil {
+				if relPath != "." && ignore.ignored(relPath, true) {
+					return filepath.SkipDir
+				}
+
+				e.loadIgnoreFile(fsys, path, relPath, ignore)
+			}
+
+			return nil
+		}
+
+		if !isRegularFile(fsys, path, info) || e.skipFile(path, relPath, info, ignore) {
+			return nil
+		}
+
+		hash, err := e.cachedHash(fsys, path, info)
+		if err != nil {
+			errs = append(errs, newFileError(relPath, err))
+			return nil
+		}
+
+		files = append(files, DiffResult{RelPath: filepath.ToSlash(relPath), NewHash: hash, Size: info.Size()})
+
+		return nil
+	})
+
+	return files, errs, err
+}
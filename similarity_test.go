@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSimilarity(t *testing.T) {
+	base := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 100)
+
+	tests := []struct {
+		name    string
+		a, b    string
+		atLeast float64
+		atMost  float64
+	}{
+		{name: "equal", a: base, b: base, atLeast: 1, atMost: 1},
+		{name: "small edit", a: base, b: strings.Replace(base, "lazy", "busy", 1), atLeast: 0.9, atMost: 1},
+		{name: "unrelated", a: base, b: strings.Repeat("0123456789", 440), atLeast: 0, atMost: 0.1},
+		{name: "empty", a: "", b: base, atLeast: 0, atMost: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Similarity([]byte(tt.a), []byte(tt.b))
+			if got < tt.atLeast || got > tt.atMost {
+				t.Errorf("Similarity() = %f, want within [%f, %f]", got, tt.atLeast, tt.atMost)
+			}
+		})
+	}
+}
+
+func TestClusterSimilar(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomText := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "line %d: %x\n", i, rng.Int63())
+		}
+
+		return b.String()
+	}
+
+	report, config := randomText(200), randomText(100)
+
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{
+		"reports/q1.txt":     report,
+		"reports/q2.txt":     strings.Replace(report, "line 50:", "line fifty:", 1),
+		"archive/q1copy.txt": report,
+		"config/a.conf":      config,
+		"config/b.conf":      config + "extra = true\n",
+		"unique/one.txt":     randomText(200),
+		"unique/two.txt":     randomText(100),
+		"unique/small.txt":   "tiny\n",
+	})
+
+	clusters, err := newTestEngine(t, DefaultConfig()).ClusterSimilar(dir, 0.9)
+	if err != nil {
+		t.Fatalf("ClusterSimilar returned an error: %v", err)
+	}
+
+	want := [][]string{
+		{"archive/q1copy.txt", "reports/q1.txt", "reports/q2.txt"},
+		{"config/a.conf", "config/b.conf"},
+	}
+
+	if diff := cmp.Diff(want, clusters); diff != "" {
+		t.Errorf("ClusterSimilar() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := newTestEngine(t, DefaultConfig()).ClusterSimilar(dir, 1.5); err == nil {
+		t.Error("expected an error for a threshold above 1")
+	}
+}
+
+func TestClusterSimilar_IgnoresCompareOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"a.txt": "same\n", "b.txt": "same\n", "ignored.log": "same\n"})
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	config := DefaultConfig()
+	config.IgnorePatterns = []string{"*.log"}
+	config.PathMap = func(relPath string) string { return "renamed/" + relPath }
+	config.ResumeFrom = filepath.Join(t.TempDir(), "missing.json")
+	config.CheckpointFile = checkpoint
+	config.CheckpointInterval = 1
+
+	clusters, err := newTestEngine(t, config).ClusterSimilar(dir, 0.9)
+	if err != nil {
+		t.Fatalf("ClusterSimilar returned an error: %v", err)
+	}
+
+	if diff := cmp.Diff([][]string{{"a.txt", "b.txt"}}, clusters); diff != "" {
+		t.Errorf("ClusterSimilar() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("expected no checkpoint to be written, got %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package diff
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// sparseZeroRun is the smallest run of changed zero bytes of a sparse file stored as an
+// OpZero chunk rather than as data.
+const sparseZeroRun = 4096
+
+// compareSparse compares two files of the local disk of which one has holes, such as a
+// virtual machine image, when Configuration.SparseFiles is set. Only the ranges holding
+// data in either file are read and compared, with the default handler, and the zero runs
+// of the new file are stored as OpZero chunks, so holes are not read in memory. It returns
+// false when neither file is sparse or the platform does not report holes, for the files
+// to be compared whole.
+func (e *DiffEngine) compareSparse(oldFS, newFS FileSystem, oldPath, newPath, relPath string, newInfo os.FileInfo) (*DiffResult, bool, error) {
+	_, oldLocal := oldFS.(OSFileSystem)
+	_, newLocal := newFS.(OSFileSystem)
+	if !oldLocal || !newLocal {
+		return nil, false, nil
+	}
+
+	newStat, err := os.Stat(newPath)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	oldStat, err := os.Stat(oldPath)
+	added := notExist(err)
+	if err != nil && !added {
+		return nil, false, nil
+	}
+
+	if !sparseFile(newStat) && (added || !sparseFile(oldStat)) {
+		return nil, false, nil
+	}
+
+	newFile, err := os.Open(newPath)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	defer newFile.Close()
+
+	newExtents, err := dataExtents(newFile, newStat.Size())
+	if err != nil {
+		e.logger.Log("Finding the holes of %s failed, reading it whole: %v", newPath, err)
+		return nil, false, nil
+	}
+
+	// A missing old file is empty
+	var oldFile *os.File
+	var oldExtents []ByteRange
+	var oldSize int64
+
+	if !added {
+		if oldFile, err = os.Open(oldPath); err != nil {
+			return nil, false, nil
+		}
+
+		defer oldFile.Close()
+
+		oldSize = oldStat.Size()
+		if oldExtents, err = dataExtents(oldFile, oldSize); err != nil {
+			e.logger.Log("Finding the holes of %s failed, reading it whole: %v", oldPath, err)
+			return nil, false, nil
+		}
+	}
+
+	chunks, err := e.sparseChunks(oldFile, newFile, oldSize, newStat.Size(), oldExtents, newExtents)
+	if err != nil {
+		return nil, true, err
+	}
+
+	newHash, err := e.hashFile(newFS, newPath)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if len(chunks) == 0 {
+		// The extents compared equal byte for byte
+		return e.unchangedResult(relPath, newInfo, newHash, newHash), true, nil
+	}
+
+	operation := "added"
+
+	var oldHash string
+	if !added {
+		if oldHash, err = e.hashFile(oldFS, oldPath); err != nil {
+			return nil, true, err
+		}
+
+		operation = "modified"
+		if e.config.RewriteThreshold > 0 && changeRatio(chunks, int(oldSize), int(newStat.Size())) > e.config.RewriteThreshold {
+			operation = "rewritten"
+		}
+	}
+
+	chunks = splitChunks(chunks, e.config.ChunkSize)
+	e.checksumChunks(chunks)
+
+	if e.shouldCompress(newPath) {
+		e.tuneCompressionLevel(chunks)
+		e.compressChunks(chunks, e.compressionLevel(newPath))
+	}
+
+	if e.config.OmitOldData {
+		omitOldData(chunks)
+	}
+
+	if e.config.ChunkTransform != nil {
+		if err := e.transformChunks(chunks); err != nil {
+			return nil, true, err
+		}
+	}
+
+	return &DiffResult{
+		Path:         filepath.Base(newPath),
+		Operation:    operation,
+		OldHash:      oldHash,
+		NewHash:      newHash,
+		Chunks:       chunks,
+		FileType:     e.getDefaultHandler().GetFileType(),
+		Size:         newInfo.Size(),
+		ModTime:      newInfo.ModTime(),
+		Permissions:  newInfo.Mode(),
+		IsCompressed: anyCompressed(chunks),
+		Transformed:  e.config.ChunkTransform != nil,
+	}, true, nil
+}
+
+// sparseChunks returns the chunks patching the old file into the new one, reading only
+// the ranges holding data in either file. A nil oldFile is an empty old file.
+func (e *DiffEngine) sparseChunks(oldFile, newFile *os.File, oldSize, newSize int64, oldExtents, newExtents []ByteRange) ([]DiffChunk, error) {
+	common := min(oldSize, newSize)
+	handler := e.getDefaultHandler()
+
+	var chunks []DiffChunk
+
+	// The ranges which are holes in both files are equal
+	for _, r := range clipRanges(append(append([]ByteRange(nil), oldExtents...), newExtents...), 0, common) {
+		oldData, err := e.readRange(oldFile, r)
+		if err != nil {
+			return nil, err
+		}
+
+		newData, err := e.readRange(newFile, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(oldData, newData) {
+			continue
+		}
+
+		release := acquire(e.compareSlots)
+		rangeChunks, err := compareWith(handler, oldData, newData)
+		release()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range rangeChunks {
+			chunk := &rangeChunks[i]
+			chunk.Offset += r.Start
+			if chunk.Op == OpCopy {
+				chunk.CopyFrom += r.Start
+			}
+
+			compactZeros(chunk)
+		}
+
+		chunks = append(chunks, rangeChunks...)
+	}
+
+	// The data and holes of the new file beyond the old one are inserted at its end
+	offset := common
+	for _, r := range clipRanges(newExtents, common, newSize) {
+		if r.Start > offset {
+			chunks = append(chunks, DiffChunk{Offset: common, ZeroLength: r.Start - offset, ChunkType: "binary", Op: OpZero})
+		}
+
+		data, err := e.readRange(newFile, r)
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, DiffChunk{Offset: common, NewData: data, ChunkType: "binary", Op: OpInsert})
+		offset = r.End
+	}
+
+	if newSize > offset {
+		chunks = append(chunks, DiffChunk{Offset: common, ZeroLength: newSize - offset, ChunkType: "binary", Op: OpZero})
+	}
+
+	// The old bytes beyond the new file are deleted without reading them
+	if oldSize > common {
+		chunks = append(chunks, DiffChunk{Offset: common, OldLength: oldSize - common, ChunkType: "binary", Op: OpDelete})
+	}
+
+	return chunks, nil
+}
+
+// readRange reads the range r of file.
+func (e *DiffEngine) readRange(file *os.File, r ByteRange) ([]byte, error) {
+	release := acquire(e.readSlots)
+	defer release()
+
+	data := make([]byte, r.End-r.Start)
+	if _, err := io.ReadFull(io.NewSectionReader(file, r.Start, r.End-r.Start), data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// compactZeros turns a chunk writing a long run of zero bytes into an OpZero chunk.
+func compactZeros(chunk *DiffChunk) {
+	if (chunk.Op != OpInsert && chunk.Op != OpReplace) || len(chunk.NewData) < sparseZeroRun {
+		return
+	}
+
+	if len(bytes.TrimLeft(chunk.NewData, "\x00")) > 0 {
+		return
+	}
+
+	chunk.ZeroLength = int64(len(chunk.NewData))
+	chunk.NewData = nil
+	chunk.Op = OpZero
+}
+
+// clipRanges returns the parts of ranges within [start, end), sorted and with the
+// overlapping or adjacent ones merged.
+func clipRanges(ranges []ByteRange, start, end int64) []ByteRange {
+	clipped := make([]ByteRange, 0, len(ranges))
+	for _, r := range ranges {
+		r.Start, r.End = max(r.Start, start), min(r.End, end)
+		if r.Start < r.End {
+			clipped = append(clipped, r)
+		}
+	}
+
+	sort.Slice(clipped, func(i, j int) bool {
+		return clipped[i].Start < clipped[j].Start
+	})
+
+	merged := clipped[:0]
+	for _, r := range clipped {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged
+}
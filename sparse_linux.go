@@ -0,0 +1,51 @@
+//go:build linux
+
+package diff
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Whence values of lseek seeking to the next data or hole of a sparse file
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// sparseFile reports whether the file of info has fewer bytes allocated than its size,
+// that is has holes.
+func sparseFile(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int64(stat.Blocks)*512 < info.Size()
+}
+
+// dataExtents returns the ranges of the first size bytes of file which hold data, the
+// other bytes being holes. File systems without holes report the whole file.
+func dataExtents(file *os.File, size int64) ([]ByteRange, error) {
+	var extents []ByteRange
+
+	for offset := int64(0); offset < size; {
+		start, err := file.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // Only a hole follows
+		} else if err != nil {
+			return nil, err
+		}
+
+		end, err := file.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+
+		if start >= size {
+			break
+		}
+
+		extents = append(extents, ByteRange{Start: start, End: min(end, size)})
+		offset = end
+	}
+
+	return extents, nil
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package diff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeSparseFile writes a file of the given size holding data at the given offsets,
+// and holes elsewhere.
+func writeSparseFile(t *testing.T, path string, size int64, data map[int64][]byte) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	for offset, block := range data {
+		if _, err := file.WriteAt(block, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareFiles_SparseFiles(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.img"), filepath.Join(dir, "new.img")
+
+	const size = 32 << 20
+
+	block := bytes.Repeat([]byte("data"), 1024)
+	changed := bytes.Repeat([]byte("DATA"), 1024)
+
+	// The new file changes a block, zeroes one, and grows by a hole, a block and a hole
+	writeSparseFile(t, oldPath, size, map[int64][]byte{0: block, 8 << 20: block, 16 << 20: block})
+	writeSparseFile(t, newPath, size+16<<20, map[int64][]byte{0: block, 8 << 20: changed, size + 4<<20: block})
+
+	if info, err := os.Stat(newPath); err != nil || !sparseFile(info) {
+		t.Skip("the file system does not support sparse files")
+	}
+
+	config := DefaultConfig()
+	config.SparseFiles = true
+
+	engine := newTestEngine(t, config)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	result, err := engine.CompareFilesIfChanged(oldPath, newPath)
+	if err != nil {
+		t.Fatalf("CompareFilesIfChanged returned an error: %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	// Reading the files whole would allocate more than their 80MB
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+		t.Errorf("expected the holes not to be read in memory, allocated %d bytes", allocated)
+	}
+
+	if result == nil || result.Operation != "modified" {
+		t.Fatalf("expected a modified result, got %+v", result)
+	}
+
+	zeros := int64(0)
+	for _, chunk := range result.Chunks {
+		if chunk.Op == OpZero {
+			zeros += chunk.ZeroLength
+		}
+	}
+
+	if zeros < 12<<20 {
+		t.Errorf("expected the new holes to be stored as zero runs, got %d zero bytes", zeros)
+	}
+
+	patched := filepath.Join(dir, "patched.img")
+	if err := (&GenericBinaryHandler{}).PatchFile(oldPath, result.Chunks, patched); err != nil {
+		t.Fatalf("PatchFile returned an error: %v", err)
+	}
+
+	if got, want := calculateHash(patched), calculateHash(newPath); got != want {
+		t.Errorf("patched file hash %s, want %s", got, want)
+	}
+
+	if info, err := os.Stat(patched); err != nil || !sparseFile(info) {
+		t.Errorf("expected the zero runs to be written as holes")
+	}
+}
+
+func Test_dataExtents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse.img")
+
+	block := bytes.Repeat([]byte{1}, 4096)
+	writeSparseFile(t, path, 4<<20, map[int64][]byte{1 << 20: block})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	extents, err := dataExtents(file, 4<<20)
+	if err != nil {
+		t.Fatalf("dataExtents returned an error: %v", err)
+	}
+
+	// File systems may report larger extents than the data written, but never miss it
+	covered := false
+	for _, extent := range extents {
+		if extent.Start <= 1<<20 && extent.End >= 1<<20+4096 {
+			covered = true
+		}
+	}
+
+	if !covered {
+		t.Errorf("expected an extent covering the data, got %v", extents)
+	}
+}
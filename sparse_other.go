@@ -0,0 +1,18 @@
+//go:build !linux
+
+package diff
+
+import (
+	"errors"
+	"os"
+)
+
+// sparseFile is not supported on this platform, sparse files are read whole.
+func sparseFile(info os.FileInfo) bool {
+	return false
+}
+
+// dataExtents is not supported on this platform.
+func dataExtents(file *os.File, size int64) ([]ByteRange, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// sqliteMagic is the header string of SQLite database files.
+const sqliteMagic = "SQLite format 3\x00"
+
+// sqliteHeaderSize is the size of the SQLite database header.
+const sqliteHeaderSize = 100
+
+// SQLiteHandler is a file handler for SQLite databases, which compares them page by page.
+// Databases change by whole pages, so page-level chunks stay aligned where a byte-level
+// diff would not. It implements the FileHandler interface.
+type SQLiteHandler struct{}
+
+// Makesure SQLiteHandler implements the FileHandler interface
+var _ FileHandler = &SQLiteHandler{}
+
+// Compare compares two SQLite databases and returns a chunk per changed page, plus a chunk
+// for the pages added or removed at the end. It returns ErrNotSupported when either file
+// is not a SQLite database, or their page sizes differ.
+func (h *SQLiteHandler) Compare(old, new []byte) ([]DiffChunk, error) {
+	if bytes.Equal(old, new) {
+		return nil, nil
+	}
+
+	oldPageSize, err := sqlitePageSize(old)
+	if err != nil {
+		return nil, err
+	}
+
+	newPageSize, err := sqlitePageSize(new)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldPageSize != newPageSize {
+		return nil, fmt.Errorf("%w: page size changed from %d to %d", ErrNotSupported, oldPageSize, newPageSize)
+	}
+
+	pageSize := int64(oldPageSize)
+	common := int64(min(len(old), len(new)))
+
+	chunks := make([]DiffChunk, 0)
+
+	for offset := int64(0); offset < common; offset += pageSize {
+		end := min(offset+pageSize, common)
+		if bytes.Equal(old[offset:end], new[offset:end]) {
+			continue
+		}
+
+		chunks = append(chunks, DiffChunk{
+			Offset:    offset,
+			OldData:   old[offset:end],
+			OldLength: end - offset,
+			NewData:   new[offset:end],
+			ChunkType: "sqlite",
+			Op:        OpReplace,
+			Page:      offset/pageSize + 1,
+		})
+	}
+
+	if int64(len(old)) != int64(len(new)) {
+		chunks = append(chunks, DiffChunk{
+			Offset:    common,
+			OldData:   old[common:],
+			OldLength: int64(len(old)) - common,
+			NewData:   new[common:],
+			ChunkType: "sqlite",
+			Op:        chunkOp(old[common:], new[common:]),
+			Page:      common/pageSize + 1,
+		})
+	}
+
+	return chunks, nil
+}
+
+// Patch applies the given DiffChunks to the original data and returns the patched data.
+func (h *SQLiteHandler) Patch(original []byte, chunks []DiffChunk) ([]byte, error) {
+	return patchAtOffsets(original, chunks)
+}
+
+// GetFileType returns the type of the file handler.
+func (h *SQLiteHandler) GetFileType() string {
+	return "sqlite"
+}
+
+// sqlitePageSize returns the page size of a SQLite database from its header.
+func sqlitePageSize(data []byte) (int, error) {
+	if len(data) < sqliteHeaderSize || string(data[:len(sqliteMagic)]) != sqliteMagic {
+		return 0, fmt.Errorf("%w: not a SQLite database", ErrNotSupported)
+	}
+
+	// The page size is a big-endian power of two from 512 to 32768, with 1 meaning 65536
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return 0, fmt.Errorf("%w: invalid SQLite page size %d", ErrNotSupported, pageSize)
+	}
+
+	return pageSize, nil
+}
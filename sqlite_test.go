@@ -0,0 +1,151 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// testSQLiteDB returns a fake SQLite database of the given pages, with a valid header.
+func testSQLiteDB(pageSize, pages int, seed int64) []byte {
+	db := make([]byte, pageSize*pages)
+	rand.New(rand.NewSource(seed)).Read(db)
+
+	copy(db, sqliteMagic)
+
+	size := uint16(pageSize)
+	if pageSize == 65536 {
+		size = 1
+	}
+	binary.BigEndian.PutUint16(db[16:18], size)
+
+	return db
+}
+
+func TestSQLiteHandler_Compare(t *testing.T) {
+	old := testSQLiteDB(1024, 8, 1)
+
+	changed := append([]byte(nil), old...)
+	changed[3*1024+100] ^= 0xff
+
+	grown := append(append([]byte(nil), changed...), bytes.Repeat([]byte{7}, 2048)...)
+
+	tests := []struct {
+		name      string
+		new       []byte
+		wantPages []int64
+		wantOps   []string
+	}{
+		{name: "One changed page", new: changed, wantPages: []int64{4}, wantOps: []string{OpReplace}},
+		{name: "Changed and added pages", new: grown, wantPages: []int64{4, 9}, wantOps: []string{OpReplace, OpInsert}},
+		{name: "Removed pages", new: old[:6*1024], wantPages: []int64{7}, wantOps: []string{OpDelete}},
+	}
+
+	handler := &SQLiteHandler{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := handler.Compare(old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if len(chunks) != len(tt.wantPages) {
+				t.Fatalf("expected %d chunks, got %d", len(tt.wantPages), len(chunks))
+			}
+
+			for i, chunk := range chunks {
+				if chunk.Page != tt.wantPages[i] || chunk.Op != tt.wantOps[i] {
+					t.Errorf("chunk %d: expected %s of page %d, got %s of page %d", i, tt.wantOps[i], tt.wantPages[i], chunk.Op, chunk.Page)
+				}
+
+				if chunk.Offset != (chunk.Page-1)*1024 {
+					t.Errorf("chunk %d: expected offset %d, got %d", i, (chunk.Page-1)*1024, chunk.Offset)
+				}
+			}
+
+			if chunks[0].Op == OpReplace && len(chunks[0].NewData) != 1024 {
+				t.Errorf("expected a chunk of a whole page, got %d bytes", len(chunks[0].NewData))
+			}
+
+			patched, err := handler.Patch(old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("patched data does not match new data")
+			}
+		})
+	}
+}
+
+func TestSQLiteHandler_NotSupported(t *testing.T) {
+	db := testSQLiteDB(4096, 2, 1)
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+	}{
+		{name: "Not a database", old: db, new: []byte("plain data")},
+		{name: "Page size changed", old: db, new: testSQLiteDB(1024, 8, 2)},
+		{name: "Invalid page size", old: db, new: append([]byte(sqliteMagic+"\x03\x00"), make([]byte, 200)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (&SQLiteHandler{}).Compare(tt.old, tt.new); !errors.Is(err, ErrNotSupported) {
+				t.Errorf("expected ErrNotSupported, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_SQLite(t *testing.T) {
+	oldDB := testSQLiteDB(65536, 2, 1)
+
+	newDB := append([]byte(nil), oldDB...)
+	newDB[65536+10] ^= 0xff
+
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"app.db":    string(oldDB),
+		"other.db":  "not a database",
+		"cache.bin": "unrelated",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"app.db":    string(newDB),
+		"other.db":  "not a database either",
+		"cache.bin": "unrelated",
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	want := map[string]string{
+		"app.db":   "sqlite",
+		"other.db": "binary",
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+
+	for _, result := range results {
+		if result.FileType != want[result.Path] {
+			t.Errorf("%s: expected file type %s, got %s", result.Path, want[result.Path], result.FileType)
+		}
+
+		if result.Path == "app.db" && (len(result.Chunks) != 1 || result.Chunks[0].Page != 2) {
+			t.Errorf("expected a single chunk of page 2, got %+v", result.Chunks)
+		}
+	}
+}
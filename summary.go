@@ -0,0 +1,177 @@
+package diff
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duration returns the time taken by the comparison.
+func (s *DiffSummary) Duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// BytesPerSecond returns the comparison throughput in bytes, or 0 when no time elapsed.
+func (s *DiffSummary) BytesPerSecond() float64 {
+	return perSecond(float64(s.TotalSizeBytes), s.Duration())
+}
+
+// FilesPerSecond returns the comparison throughput in files, or 0 when no time elapsed.
+func (s *DiffSummary) FilesPerSecond() float64 {
+	return perSecond(float64(s.TotalFiles), s.Duration())
+}
+
+// perSecond returns the rate of count over the duration d.
+func perSecond(count float64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return count / d.Seconds()
+}
+
+// FormatSummary renders a human-readable summary of a comparison.
+// File types are sorted so the output is deterministic.
+func FormatSummary(s *DiffSummary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Files: %d total, %d added, %d modified, %d deleted", s.TotalFiles, s.AddedFiles, s.ModifiedFiles, s.DeletedFiles)
+	if s.RewrittenFiles > 0 {
+		fmt.Fprintf(&b, ", %d rewritten", s.RewrittenFiles)
+	}
+
+	if s.UnchangedFiles > 0 {
+		fmt.Fprintf(&b, ", %d unchanged", s.UnchangedFiles)
+	}
+
+	if s.TypeChangedFiles > 0 {
+		fmt.Fprintf(&b, ", %d type changed", s.TypeChangedFiles)
+	}
+
+	if s.ChmodFiles > 0 {
+		fmt.Fprintf(&b, ", %d permissions changed", s.ChmodFiles)
+	}
+
+	fmt.Fprintf(&b, "\nSize: %s total, %s compressed\n", formatBytes(s.TotalSizeBytes), formatBytes(s.CompressedBytes))
+	fmt.Fprintf(&b, "Duration: %s\n", s.Duration().Round(time.Millisecond))
+
+	if len(s.FileTypes) > 0 {
+		fileTypes := make([]string, 0, len(s.FileTypes))
+		for fileType := range s.FileTypes {
+			fileTypes = append(fileTypes, fileType)
+		}
+
+		sort.Strings(fileTypes)
+
+		b.WriteString("File types:\n")
+		for _, fileType := range fileTypes {
+			fmt.Fprintf(&b, "  %s: %d\n", fileType, s.FileTypes[fileType])
+		}
+	}
+
+	return b.String()
+}
+
+// formatBytes formats a byte count using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// snapshot returns a copy of the summary sharing no map or slice with it.
+func (s *DiffSummary) snapshot() DiffSummary {
+	snapshot := *s
+	snapshot.FileTypes = maps.Clone(s.FileTypes)
+	snapshot.Errors = slices.Clone(s.Errors)
+
+	return snapshot
+}
+
+// defaultSummaryInterval is the interval between the summaries sent to
+// Configuration.SummaryUpdates when SummaryInterval is not set.
+const defaultSummaryInterval = 100 * time.Millisecond
+
+// publishSummaries sends a snapshot of the summary, read under mu, to
+// Configuration.SummaryUpdates every SummaryInterval until the returned function is
+// called. Snapshots the channel is not ready to receive are dropped.
+func (e *DiffEngine) publishSummaries(summary *DiffSummary, mu *sync.Mutex) (stop func()) {
+	if e.config.SummaryUpdates == nil {
+		return func() {}
+	}
+
+	interval := e.config.SummaryInterval
+	if interval <= 0 {
+		interval = defaultSummaryInterval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				mu.Lock()
+				snapshot := summary.snapshot()
+				mu.Unlock()
+
+				select {
+				case e.config.SummaryUpdates <- snapshot:
+				default:
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// publishFinalSummary sends a snapshot of the summary of a completed comparison to
+// Configuration.SummaryUpdates, waiting up to SummaryInterval for the channel to be ready.
+func (e *DiffEngine) publishFinalSummary(summary *DiffSummary) {
+	if e.config.SummaryUpdates == nil {
+		return
+	}
+
+	interval := e.config.SummaryInterval
+	if interval <= 0 {
+		interval = defaultSummaryInterval
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case e.config.SummaryUpdates <- summary.snapshot():
+	case <-timer.C:
+	}
+}
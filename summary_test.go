@@ -0,0 +1,211 @@
+package diff
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffSummary_Throughput(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name               string
+		summary            *DiffSummary
+		wantDuration       time.Duration
+		wantBytesPerSecond float64
+		wantFilesPerSecond float64
+	}{
+		{
+			name: "Two seconds",
+			summary: &DiffSummary{
+				TotalFiles:     10,
+				TotalSizeBytes: 4096,
+				StartTime:      start,
+				EndTime:        start.Add(2 * time.Second),
+			},
+			wantDuration:       2 * time.Second,
+			wantBytesPerSecond: 2048,
+			wantFilesPerSecond: 5,
+		},
+		{
+			name: "Zero duration",
+			summary: &DiffSummary{
+				TotalFiles:     10,
+				TotalSizeBytes: 4096,
+				StartTime:      start,
+				EndTime:        start,
+			},
+		},
+		{
+			name: "Unfinished comparison",
+			summary: &DiffSummary{
+				TotalFiles: 10,
+				StartTime:  start,
+			},
+			wantDuration: time.Time{}.Sub(start),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.summary.Duration(); got != tt.wantDuration {
+				t.Errorf("Duration() = %v, want %v", got, tt.wantDuration)
+			}
+
+			if got := tt.summary.BytesPerSecond(); got != tt.wantBytesPerSecond {
+				t.Errorf("BytesPerSecond() = %v, want %v", got, tt.wantBytesPerSecond)
+			}
+
+			if got := tt.summary.FilesPerSecond(); got != tt.wantFilesPerSecond {
+				t.Errorf("FilesPerSecond() = %v, want %v", got, tt.wantFilesPerSecond)
+			}
+		})
+	}
+}
+
+func TestFormatSummary(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	summary := &DiffSummary{
+		TotalFiles:      6,
+		AddedFiles:      2,
+		ModifiedFiles:   2,
+		DeletedFiles:    1,
+		RewrittenFiles:  1,
+		TotalSizeBytes:  3 * 1024 * 1024 / 2,
+		CompressedBytes: 512 * 1024,
+		FileTypes:       map[string]int{"text": 3, "binary": 2},
+		StartTime:       start,
+		EndTime:         start.Add(1500 * time.Millisecond),
+	}
+
+	want := "Files: 6 total, 2 added, 2 modified, 1 deleted, 1 rewritten\n" +
+		"Size: 1.5 MB total, 512.0 KB compressed\n" +
+		"Duration: 1.5s\n" +
+		"File types:\n" +
+		"  binary: 2\n" +
+		"  text: 3\n"
+
+	if got := FormatSummary(summary); got != want {
+		t.Errorf("FormatSummary() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_formatBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{bytes: 0, want: "0 B"},
+		{bytes: 1023, want: "1023 B"},
+		{bytes: 1024, want: "1.0 KB"},
+		{bytes: 5 * 1024 * 1024, want: "5.0 MB"},
+		{bytes: 3 * 1024 * 1024 * 1024, want: "3.0 GB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatBytes(tt.bytes); got != tt.want {
+				t.Errorf("formatBytes(%d) = %s, want %s", tt.bytes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareDirs_SummaryUpdates(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	oldFiles, newFiles := make(map[string]string), make(map[string]string)
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("file%03d.txt", i)
+		oldFiles[name] = "old\n"
+		newFiles[name] = "new\n"
+	}
+
+	oldFiles["deleted.txt"] = "deleted\n"
+
+	writeTestTree(t, oldDir, oldFiles)
+	writeTestTree(t, newDir, newFiles)
+
+	updates := make(chan DiffSummary, 1000)
+
+	config := DefaultConfig()
+	config.Concurrency = 1
+	config.SummaryUpdates = updates
+	config.SummaryInterval = 5 * time.Millisecond
+
+	engine := newTestEngine(t, config)
+	engine.SetFileSystem(&slowFileSystem{FileSystem: OSFileSystem{}})
+
+	summary, _, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	close(updates)
+
+	var snapshots []DiffSummary
+	for snapshot := range updates {
+		snapshots = append(snapshots, snapshot)
+	}
+
+	if len(snapshots) < 2 {
+		t.Fatalf("expected several snapshots, got %d", len(snapshots))
+	}
+
+	for i := 1; i < len(snapshots); i++ {
+		if snapshots[i].TotalFiles < snapshots[i-1].TotalFiles {
+			t.Errorf("snapshot %d: total files decreased from %d to %d", i, snapshots[i-1].TotalFiles, snapshots[i].TotalFiles)
+		}
+	}
+
+	if diff := cmp.Diff(*summary, snapshots[len(snapshots)-1]); diff != "" {
+		t.Errorf("last snapshot mismatch (-want +got):\n%s", diff)
+	}
+
+	// Snapshots are copies, which later changes to the summary don't affect
+	summary.FileTypes["text"] = -1
+	if got := snapshots[len(snapshots)-1].FileTypes["text"]; got != 100 {
+		t.Errorf("expected 100 text files in the last snapshot, got %d", got)
+	}
+}
+
+func TestCompareDirs_SummaryUpdatesNotRead(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{"a.txt": "old\n"})
+	writeTestTree(t, newDir, map[string]string{"a.txt": "new\n"})
+
+	// The channel is read only once the comparison returned
+	updates := make(chan DiffSummary)
+
+	config := DefaultConfig()
+	config.SummaryUpdates = updates
+	config.SummaryInterval = 5 * time.Millisecond
+
+	engine := newTestEngine(t, config)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := engine.CompareDirs(oldDir, newDir)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CompareDirs returned an error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CompareDirs blocked on a channel not read")
+	}
+
+	select {
+	case snapshot := <-updates:
+		t.Errorf("expected no snapshot after the comparison returned, got %+v", snapshot)
+	default:
+	}
+}
@@ -1,60 +1,315 @@
 package diff
 
-import "bytes"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
 
 // TextFileHandler is a file handler for text files.
 // It implements the FileHandler interface.
-type TextFileHandler struct{}
+type TextFileHandler struct {
+	// EqualFunc reports whether an old and a new line are equal, defaulting to bytes.Equal.
+	// It allows ignoring differences such as case; patches still hold the actual new lines.
+	EqualFunc func(a, b []byte) bool
+
+	// MaxGapLines merges changed lines separated by fewer than MaxGapLines unchanged lines
+	// into a single chunk including the lines between them, like the hunks of a unified diff.
+	// Zero produces a chunk per changed line.
+	MaxGapLines int
+
+	// WindowLines is the number of lines of each text CompareReaders aligns at once,
+	// defaulting to 1024.
+	WindowLines int
+
+	// Normalization is the Unicode normalization form lines are converted to before
+	// comparing them, so that canonically equivalent text is not reported as changed.
+	// Patches still hold the actual new lines.
+	Normalization Normalization
+
+	// Differ aligns the old and new lines, such as MyersDiffer, so that inserted and
+	// deleted lines don't shift the lines after them. It compares the lines itself, after
+	// Normalization and CommentSyntax but without EqualFunc. Nil compares the lines at the
+	// same positions.
+	Differ LineDiffer
+
+	// CommentSyntax, such as CStyleComments, ignores the comments of the lines when comparing
+	// them, so that lines differing only in their comments are not reported as changed, and
+	// patching keeps their old comments. Lines whose code changed are patched whole.
+	CommentSyntax *CommentSyntax
+
+	// TimeBudget bounds the time Compare spends aligning the lines with MyersDiffer, which
+	// grows with the number of lines times the number of changed lines. Past it, Compare
+	// returns a single chunk replacing the whole file, with BudgetExceeded set. Other
+	// differs aren't bounded. Zero means no limit.
+	TimeBudget time.Duration
+}
+
+// Normalization is a Unicode normalization form applied by TextFileHandler.
+type Normalization int
+
+const (
+	NormalizeNone Normalization = iota // Compare lines as they are
+	NormalizeNFC                       // Canonical composition
+	NormalizeNFD                       // Canonical decomposition
+	NormalizeNFKC                      // Compatibility composition
+	NormalizeNFKD                      // Compatibility decomposition
+)
+
+// form returns the norm.Form of n, and false for NormalizeNone.
+func (n Normalization) form() (norm.Form, bool) {
+	switch n {
+	case NormalizeNFC:
+		return norm.NFC, true
+	case NormalizeNFD:
+		return norm.NFD, true
+	case NormalizeNFKC:
+		return norm.NFKC, true
+	case NormalizeNFKD:
+		return norm.NFKD, true
+	}
+
+	return 0, false
+}
 
 // Makesure TextFileHandler implements the FileHandler interface
 var _ FileHandler = &TextFileHandler{}
 
 // Compare compares two text files and returns the differences as a slice of DiffChunk.
+// It returns ErrNotText when either file looks like binary data. The lines the files
+// share at their start and end are skipped, so large files with a small change are
+// compared in little more than the time it takes to scan them.
 func (h *TextFileHandler) Compare(old, new []byte) ([]DiffChunk, error) {
 	if bytes.Equal(old, new) {
 		return nil, nil
 	}
 
+	if looksBinary(old) || looksBinary(new) {
+		return nil, ErrNotText
+	}
+
+	// Only the lines between the common leading and trailing lines are compared
+	prefix, suffix := commonLines(old, new)
+
+	oldMid, newMid := old[prefix:len(old)-suffix], new[prefix:len(new)-suffix]
+
+	// The compared lines may start within a block comment opened by the common ones
+	inBlock := h.inBlockComment(old[:prefix])
+
+	var chunks []DiffChunk
+	if h.Differ != nil && len(oldMid) > 0 && len(newMid) > 0 {
+		var err error
+		chunks, err = h.diffLines(oldMid, newMid, inBlock, newTimeBudget(h.TimeBudget))
+		if errors.Is(err, errBudgetExceeded) {
+			return []DiffChunk{budgetChunk(old, new, "text")}, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		chunks = h.compareLines(oldMid, newMid, inBlock)
+	}
+
+	for i := range chunks {
+		chunks[i].Offset += int64(prefix)
+	}
+
+	return chunks, nil
+}
+
+// commonLines returns the lengths of the common prefix and suffix of old and new made
+// of whole lines, which don't overlap.
+func commonLines(old, new []byte) (prefix, suffix int) {
+	prefix = commonPrefixLen(old, new)
+	if prefix < len(old) || prefix < len(new) {
+		prefix = bytes.LastIndexByte(old[:prefix], '\n') + 1
+	}
+
+	oldRest, newRest := old[prefix:], new[prefix:]
+	suffix = commonSuffixLen(oldRest, newRest)
+
+	// The suffix starts after the first newline within it, unless it starts a line of both
+	lineStart := func(data []byte) bool {
+		start := len(data) - suffix
+		return start == 0 || data[start-1] == '\n'
+	}
+
+	if suffix > 0 && !(lineStart(oldRest) && lineStart(newRest)) {
+		suffix -= bytes.IndexByte(oldRest[len(oldRest)-suffix:], '\n') + 1
+	}
+
+	return prefix, suffix
+}
+
+// compareLines compares the lines of old and new in order. inBlock reports whether a
+// block comment is open before their first lines.
+func (h *TextFileHandler) compareLines(old, new []byte, inBlock bool) []DiffChunk {
+	// An empty file has no lines, while splitting it yields a single empty one, so
+	// the other file is inserted or deleted whole
+	if len(old) == 0 || len(new) == 0 {
+		return []DiffChunk{{
+			Offset:    0,
+			OldData:   old,
+			OldLength: int64(len(old)),
+			NewData:   new,
+			ChunkType: "text",
+			Op:        chunkOp(old, new),
+		}}
+	}
+
 	chunks := []DiffChunk{}
 	oldLines := bytes.Split(old, []byte{'\n'})
 	newLines := bytes.Split(new, []byte{'\n'})
+	oldCompared, newCompared := h.comparedLines(oldLines, inBlock), h.comparedLines(newLines, inBlock)
 
 	// Simple line-by-line comparison
-	offset := int64(0)
+	offset, newOffset := int64(0), int64(0)
+
+	// Line and new offset of the last changed line, and new offset of its chunk
+	lastChanged, chunkNewOffset := -1, int64(0)
 
 	for i := 0; i < len(oldLines) && i < len(newLines); i++ {
-		if !bytes.Equal(oldLines[i], newLines[i]) {
-			chunks = append(chunks, DiffChunk{
-				Offset:    offset,
-				OldData:   oldLines[i],
-				NewData:   newLines[i],
-				ChunkType: "text",
-			})
+		if !h.equal(oldCompared[i], newCompared[i]) {
+			if n := len(chunks); n > 0 && i-lastChanged-1 < h.MaxGapLines {
+				// Extend the previous chunk over the unchanged lines up to this one
+				chunks[n-1].OldData = old[chunks[n-1].Offset : offset+int64(len(oldLines[i]))]
+				chunks[n-1].OldLength = int64(len(chunks[n-1].OldData))
+				chunks[n-1].NewData = new[chunkNewOffset : newOffset+int64(len(newLines[i]))]
+			} else {
+				chunks = append(chunks, DiffChunk{
+					Offset:    offset,
+					OldData:   oldLines[i],
+					OldLength: int64(len(oldLines[i])),
+					NewData:   newLines[i],
+					ChunkType: "text",
+					Op:        OpReplace,
+				})
+				chunkNewOffset = newOffset
+			}
+
+			lastChanged = i
 		}
 
 		// +1 for newline
 		offset += int64(len(oldLines[i])) + 1
+		newOffset += int64(len(newLines[i])) + 1
 	}
 
-	return chunks, nil
+	// Lines beyond the common length are inserted or deleted after the last common line,
+	// together with the newline which separates them from it.
+	common := min(len(oldLines), len(newLines))
+
+	switch {
+	case len(newLines) > common:
+		chunks = append(chunks, DiffChunk{
+			Offset:    offset - 1,
+			NewData:   append([]byte{'\n'}, bytes.Join(newLines[common:], []byte{'\n'})...),
+			ChunkType: "text",
+			Op:        OpInsert,
+		})
+	case len(oldLines) > common:
+		deleted := append([]byte{'\n'}, bytes.Join(oldLines[common:], []byte{'\n'})...)
+		chunks = append(chunks, DiffChunk{
+			Offset:    offset - 1,
+			OldData:   deleted,
+			OldLength: int64(len(deleted)),
+			ChunkType: "text",
+			Op:        OpDelete,
+		})
+	}
+
+	return chunks
+}
+
+// equal compares two lines as they are compared, with EqualFunc, or bytes.Equal when it
+// is not set.
+func (h *TextFileHandler) equal(a, b []byte) bool {
+	if h.EqualFunc != nil {
+		return h.EqualFunc(a, b)
+	}
+
+	return bytes.Equal(a, b)
+}
+
+// comparedLines returns lines as they are compared, normalized to the Normalization form
+// and without the comments of CommentSyntax. inBlock reports whether a block comment is
+// open before the first line.
+func (h *TextFileHandler) comparedLines(lines [][]byte, inBlock bool) [][]byte {
+	form, normalize := h.Normalization.form()
+	if !normalize && h.CommentSyntax == nil {
+		return lines
+	}
+
+	compared := make([][]byte, len(lines))
+	for i, line := range lines {
+		if normalize {
+			line = form.Bytes(line)
+		}
+
+		if h.CommentSyntax != nil {
+			line, inBlock = h.CommentSyntax.strip(line, inBlock)
+		}
+
+		compared[i] = line
+	}
+
+	return compared
+}
+
+// inBlockComment reports whether a block comment of CommentSyntax is open at the end of
+// data, made of whole lines.
+func (h *TextFileHandler) inBlockComment(data []byte) bool {
+	if h.CommentSyntax == nil || h.CommentSyntax.BlockStart == "" || h.CommentSyntax.BlockEnd == "" {
+		return false
+	}
+
+	inBlock := false
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		_, inBlock = h.CommentSyntax.strip(line, inBlock)
+	}
+
+	return inBlock
 }
 
 // Patch applies the given DiffChunks to the original data and returns the patched data.
 func (h *TextFileHandler) Patch(original []byte, chunks []DiffChunk) ([]byte, error) {
+	return h.PatchSized(original, chunks, len(original))
+}
+
+// PatchSized is Patch with the result buffer pre-allocated for expectedSize bytes, which
+// avoids growing it repeatedly when the patched data is known to be much larger than original.
+func (h *TextFileHandler) PatchSized(original []byte, chunks []DiffChunk, expectedSize int) ([]byte, error) {
 	if len(chunks) == 0 {
 		return original, nil
 	}
 
-	result := make([]byte, 0, len(original))
+	result := make([]byte, 0, max(expectedSize, 0))
 	lastOffset := int64(0)
 
-	for _, chunk := range chunks {
+	for i, chunk := range chunks {
+		if err := chunk.verify(); err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
+		if chunk.Offset < lastOffset || chunk.Offset+chunk.oldSpan() > int64(len(original)) {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, ErrPatchOutOfRange)
+		}
+
+		data, err := chunk.replacementIn(original)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d at offset %d: %w", i, chunk.Offset, err)
+		}
+
 		// Copy unchanged data
 		result = append(result, original[lastOffset:chunk.Offset]...)
 		// Apply the change
-		result = append(result, chunk.NewData...)
+		result = append(result, data...)
 
-		lastOffset = chunk.Offset + int64(len(chunk.OldData))
+		lastOffset = chunk.Offset + chunk.oldSpan()
 	}
 
 	// Copy remaining unchanged data
@@ -0,0 +1,415 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTextFileHandler_CompareOps(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		wantOps []string
+	}{
+		{
+			name:    "Replace line",
+			old:     "one\ntwo\nthree",
+			new:     "one\n2\nthree",
+			wantOps: []string{OpReplace},
+		},
+		{
+			name:    "Replace line with empty line",
+			old:     "one\ntwo\nthree",
+			new:     "one\n\nthree",
+			wantOps: []string{OpReplace},
+		},
+		{
+			name:    "Insert trailing lines",
+			old:     "one\ntwo",
+			new:     "one\ntwo\nthree\nfour",
+			wantOps: []string{OpInsert},
+		},
+		{
+			name:    "Delete trailing lines",
+			old:     "one\ntwo\nthree\nfour",
+			new:     "one\ntwo",
+			wantOps: []string{OpDelete},
+		},
+		{
+			name:    "Replace and insert",
+			old:     "one\ntwo",
+			new:     "one\n2\nthree",
+			wantOps: []string{OpReplace, OpInsert},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &TextFileHandler{}
+
+			chunks, err := handler.Compare([]byte(tt.old), []byte(tt.new))
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if len(chunks) != len(tt.wantOps) {
+				t.Fatalf("expected %d chunks, got %d", len(tt.wantOps), len(chunks))
+			}
+
+			for i, chunk := range chunks {
+				if chunk.Op != tt.wantOps[i] {
+					t.Errorf("chunk %d: expected op %s, got %s", i, tt.wantOps[i], chunk.Op)
+				}
+			}
+
+			patched, err := handler.Patch([]byte(tt.old), chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, []byte(tt.new)) {
+				t.Errorf("Patch() = %q, want %q", patched, tt.new)
+			}
+		})
+	}
+}
+
+func TestTextFileHandler_CompareEmpty(t *testing.T) {
+	testCompareEmpty(t, &TextFileHandler{})
+}
+
+// emptyFileCases are the comparisons of an empty file with a nonempty one.
+var emptyFileCases = []struct {
+	name   string
+	old    []byte
+	new    []byte
+	wantOp string
+}{
+	{name: "Empty to nonempty", old: nil, new: []byte("one\ntwo\n"), wantOp: OpInsert},
+	{name: "Empty to newline", old: []byte{}, new: []byte("\n"), wantOp: OpInsert},
+	{name: "Nonempty to empty", old: []byte("one\ntwo"), new: []byte{}, wantOp: OpDelete},
+}
+
+// testCompareEmpty checks that the handler compares empty files with a single chunk
+// inserting or deleting the whole other file.
+func testCompareEmpty(t *testing.T, handler FileHandler) {
+	t.Helper()
+
+	for _, tt := range emptyFileCases {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := handler.Compare(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			if len(chunks) != 1 {
+				t.Fatalf("expected 1 chunk, got %d", len(chunks))
+			}
+
+			chunk := chunks[0]
+			if chunk.Op != tt.wantOp || chunk.Offset != 0 {
+				t.Errorf("expected %s at offset 0, got %s at offset %d", tt.wantOp, chunk.Op, chunk.Offset)
+			}
+
+			if !bytes.Equal(chunk.OldData, tt.old) || !bytes.Equal(chunk.NewData, tt.new) {
+				t.Errorf("expected the whole files, got old %q and new %q", chunk.OldData, chunk.NewData)
+			}
+
+			patched, err := handler.Patch(tt.old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, tt.new) {
+				t.Errorf("Patch() = %q, want %q", patched, tt.new)
+			}
+		})
+	}
+}
+
+func TestTextFileHandler_CompareBinary(t *testing.T) {
+	handler := &TextFileHandler{}
+
+	if _, err := handler.Compare([]byte("plain text\n"), []byte("binary\x00data\n")); !errors.Is(err, ErrNotText) {
+		t.Errorf("expected ErrNotText, got %v", err)
+	}
+
+	if _, err := handler.Compare([]byte("plain text\n"), []byte("other text\n")); err != nil {
+		t.Errorf("Compare returned an error: %v", err)
+	}
+}
+
+func TestTextFileHandler_EqualFunc(t *testing.T) {
+	old := []byte("Hello\nWorld\nfoo")
+	new := []byte("hello\nWORLD\nbar")
+
+	handler := &TextFileHandler{EqualFunc: bytes.EqualFold}
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 1 {
+		t.Fatalf("expected 1 chunk, got %d", len(chunks))
+	}
+
+	if string(chunks[0].OldData) != "foo" || string(chunks[0].NewData) != "bar" {
+		t.Errorf("expected chunk replacing foo with bar, got %q with %q", chunks[0].OldData, chunks[0].NewData)
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if want := "Hello\nWorld\nbar"; string(patched) != want {
+		t.Errorf("Patch() = %q, want %q", patched, want)
+	}
+
+	if chunks, err := handler.Compare([]byte("A\nB"), []byte("a\nb")); err != nil || len(chunks) != 0 {
+		t.Errorf("expected no chunks for lines differing only in case, got %d (error %v)", len(chunks), err)
+	}
+}
+
+func TestTextFileHandler_Normalization(t *testing.T) {
+	// "é" precomposed (NFC) in the old text and as "e" with a combining acute accent (NFD)
+	// in the new one
+	old := []byte("caf\u00e9\nsame\nold")
+	new := []byte("cafe\u0301\nsame\nnew")
+
+	chunks, err := (&TextFileHandler{}).Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 2 {
+		t.Errorf("expected 2 chunks without normalization, got %d", len(chunks))
+	}
+
+	for _, form := range []Normalization{NormalizeNFC, NormalizeNFD, NormalizeNFKC, NormalizeNFKD} {
+		handler := &TextFileHandler{Normalization: form}
+
+		chunks, err := handler.Compare(old, new)
+		if err != nil {
+			t.Fatalf("Compare returned an error: %v", err)
+		}
+
+		if len(chunks) != 1 || string(chunks[0].OldData) != "old" || string(chunks[0].NewData) != "new" {
+			t.Fatalf("form %d: expected a single chunk replacing old with new, got %+v", form, chunks)
+		}
+
+		patched, err := handler.Patch(old, chunks)
+		if err != nil {
+			t.Fatalf("Patch returned an error: %v", err)
+		}
+
+		// The equivalent line keeps its old encoding, the changed one takes the new bytes
+		if want := "caf\u00e9\nsame\nnew"; string(patched) != want {
+			t.Errorf("Patch() = %q, want %q", patched, want)
+		}
+	}
+}
+
+func TestTextFileHandler_MaxGapLines(t *testing.T) {
+	old := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj")
+	new := []byte("A\nb\nC\nd\ne\nf\nG\nH\ni\nj\nk")
+
+	tests := []struct {
+		name        string
+		maxGapLines int
+		wantOld     []string
+	}{
+		{name: "No merging", maxGapLines: 0, wantOld: []string{"a", "c", "g", "h"}},
+		{name: "Adjacent lines", maxGapLines: 1, wantOld: []string{"a", "c", "g\nh"}},
+		{name: "One line gap", maxGapLines: 2, wantOld: []string{"a\nb\nc", "g\nh"}},
+		{name: "Three line gap", maxGapLines: 4, wantOld: []string{"a\nb\nc\nd\ne\nf\ng\nh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &TextFileHandler{MaxGapLines: tt.maxGapLines}
+
+			chunks, err := handler.Compare(old, new)
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			// The trailing insertion of k is never merged
+			if len(chunks) != len(tt.wantOld)+1 {
+				t.Fatalf("expected %d chunks, got %d", len(tt.wantOld)+1, len(chunks))
+			}
+
+			for i, want := range tt.wantOld {
+				if string(chunks[i].OldData) != want {
+					t.Errorf("chunk %d: expected old data %q, got %q", i, want, chunks[i].OldData)
+				}
+			}
+
+			patched, err := handler.Patch(old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, new) {
+				t.Errorf("Patch() = %q, want %q", patched, new)
+			}
+		})
+	}
+}
+
+func TestTextFileHandler_MaxGapLinesEqualFunc(t *testing.T) {
+	// The lines between merged changes keep their new bytes even when they compare equal
+	old := []byte("a\nb\nc")
+	new := []byte("x\nB\ny")
+
+	handler := &TextFileHandler{EqualFunc: bytes.EqualFold, MaxGapLines: 2}
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	if len(chunks) != 1 {
+		t.Fatalf("expected 1 chunk, got %d", len(chunks))
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched, new) {
+		t.Errorf("Patch() = %q, want %q", patched, new)
+	}
+}
+
+func TestTextFileHandler_CommonLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		wantOps []string
+	}{
+		{
+			name:    "Insert middle line",
+			old:     "one\ntwo\nthree\n",
+			new:     "one\ntwo\n2.5\nthree\n",
+			wantOps: []string{OpInsert},
+		},
+		{
+			name:    "Delete middle line",
+			old:     "one\ntwo\nthree\n",
+			new:     "one\nthree\n",
+			wantOps: []string{OpDelete},
+		},
+		{
+			name:    "Common end of changed line",
+			old:     "one\nxtwo\nthree",
+			new:     "one\nytwo\nthree",
+			wantOps: []string{OpReplace},
+		},
+		{
+			name:    "Changed first and last lines",
+			old:     "one\ntwo\nthree",
+			new:     "1\ntwo\n3",
+			wantOps: []string{OpReplace, OpReplace},
+		},
+		{
+			name:    "Change within the last line",
+			old:     "one\ntwo",
+			new:     "one\ntwo!",
+			wantOps: []string{OpReplace},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &TextFileHandler{}
+
+			chunks, err := handler.Compare([]byte(tt.old), []byte(tt.new))
+			if err != nil {
+				t.Fatalf("Compare returned an error: %v", err)
+			}
+
+			ops := make([]string, len(chunks))
+			for i, chunk := range chunks {
+				ops[i] = chunk.Op
+			}
+
+			if diff := cmp.Diff(tt.wantOps, ops); diff != "" {
+				t.Errorf("Compare() ops mismatch (-want +got):\n%s", diff)
+			}
+
+			patched, err := handler.Patch([]byte(tt.old), chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, []byte(tt.new)) {
+				t.Errorf("Patch() = %q, want %q", patched, tt.new)
+			}
+		})
+	}
+}
+
+func TestTextFileHandler_CommonLinesLarge(t *testing.T) {
+	old, new := largeTextWithChange(10000)
+	handler := &TextFileHandler{}
+
+	chunks, err := handler.Compare(old, new)
+	if err != nil {
+		t.Fatalf("Compare returned an error: %v", err)
+	}
+
+	offset := int64(bytes.Index(old, []byte("middle")))
+	want := []DiffChunk{{
+		Offset:    offset,
+		OldData:   []byte("middle"),
+		OldLength: 6,
+		NewData:   []byte("changed middle"),
+		ChunkType: "text",
+		Op:        OpReplace,
+	}}
+
+	if diff := cmp.Diff(want, chunks); diff != "" {
+		t.Errorf("Compare() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// largeTextWithChange returns two texts sharing lines lines before and after a changed middle line.
+func largeTextWithChange(lines int) ([]byte, []byte) {
+	var head, tail bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&head, "head line %d\n", i)
+		fmt.Fprintf(&tail, "tail line %d\n", i)
+	}
+
+	old := slices.Concat(head.Bytes(), []byte("middle\n"), tail.Bytes())
+	new := slices.Concat(head.Bytes(), []byte("changed middle\n"), tail.Bytes())
+
+	return old, new
+}
+
+func BenchmarkTextFileHandler_CommonLines(b *testing.B) {
+	old, new := largeTextWithChange(100000)
+	handler := &TextFileHandler{}
+
+	b.Run("Trimmed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			handler.Compare(old, new)
+		}
+	})
+
+	b.Run("Untrimmed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			handler.compareLines(old, new, false)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// defaultWindowLines is the number of lines of each input CompareReaders aligns at once
+// when TextFileHandler.WindowLines is not set.
+const defaultWindowLines = 1024
+
+// maxStreamLineSize is the longest line CompareReaders accepts.
+const maxStreamLineSize = 16 * 1024 * 1024
+
+// lineWindow buffers the next lines of a reader.
+type lineWindow struct {
+	scanner *bufio.Scanner
+	lines   [][]byte // Lines of the window, with their trailing newline
+	start   int64    // Offset of the first line of the window
+	offset  int64    // Offset of the next line to be scanned
+	eof     bool
+}
+
+func newLineWindow(r io.Reader) *lineWindow {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+	scanner.Split(scanLinesWithNewline)
+
+	return &lineWindow{scanner: scanner}
+}
+
+// fill reads lines until the window holds size lines or the reader is exhausted.
+func (w *lineWindow) fill(size int) error {
+	for !w.eof && len(w.lines) < size {
+		if !w.scanner.Scan() {
+			w.eof = true
+			return w.scanner.Err()
+		}
+
+		// The scanner reuses its buffer, so the line is copied
+		data := append([]byte(nil), w.scanner.Bytes()...)
+		w.lines = append(w.lines, data)
+		w.offset += int64(len(data))
+	}
+
+	return nil
+}
+
+// take removes the first n lines of the window and returns their joined data.
+func (w *lineWindow) take(n int) []byte {
+	var data []byte
+	for _, line := range w.lines[:n] {
+		data = append(data, line...)
+	}
+
+	w.skip(n)
+
+	return data
+}
+
+// skip removes the first n lines of the window.
+func (w *lineWindow) skip(n int) {
+	for _, line := range w.lines[:n] {
+		w.start += int64(len(line))
+	}
+
+	w.lines = w.lines[n:]
+}
+
+// scanLinesWithNewline is a bufio.SplitFunc like bufio.ScanLines which keeps the newline
+// of each line, so that the lines of a reader join back into its exact contents.
+func scanLinesWithNewline(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// CompareReaders compares two texts line by line as they are read, without loading either
+// whole, and returns the differences as chunks which Patch applies to the old text. Memory
+// is proportional to WindowLines lines of each text plus the changed data.
+//
+// Lines are aligned with a longest common subsequence of the next WindowLines lines of each
+// text, so changes must be localized: where no line of the old window reappears in the new
+// window, such as when more than WindowLines lines are inserted at once, both windows are
+// reported as replaced, even if the texts realign later. Chunks hold whole lines including
+// their newline, and EqualFunc, MaxGapLines and Normalization are not applied.
+func (h *TextFileHandler) CompareReaders(old, new io.Reader) ([]DiffChunk, error) {
+	window := h.WindowLines
+	if window <= 0 {
+		window = defaultWindowLines
+	}
+
+	oldWindow, newWindow := newLineWindow(old), newLineWindow(new)
+	chunks := []DiffChunk{}
+
+	for {
+		if err := oldWindow.fill(window); err != nil {
+			return nil, err
+		}
+
+		if err := newWindow.fill(window); err != nil {
+			return nil, err
+		}
+
+		if len(oldWindow.lines) == 0 && len(newWindow.lines) == 0 {
+			return chunks, nil
+		}
+
+		// Skip equal lines, refilling the windows before looking for the next change
+		if len(oldWindow.lines) > 0 && len(newWindow.lines) > 0 && bytes.Equal(oldWindow.lines[0], newWindow.lines[0]) {
+			oldWindow.skip(1)
+			newWindow.skip(1)
+
+			continue
+		}
+
+		// The change extends up to the first line a shortest edit script of the windows
+		// keeps, or over both windows when they have none
+		oldCount, newCount := len(oldWindow.lines), len(newWindow.lines)
+		for _, op := range (MyersDiffer{}).Diff(oldWindow.lines, newWindow.lines) {
+			if op.Type == EditEqual {
+				oldCount, newCount = op.OldIndex, op.NewIndex
+				break
+			}
+		}
+
+		offset := oldWindow.start
+
+		oldData, newData := oldWindow.take(oldCount), newWindow.take(newCount)
+
+		// A change continuing the previous one, such as lines appended beyond a window,
+		// extends its chunk
+		if n := len(chunks); n > 0 && chunks[n-1].Offset+chunks[n-1].oldSpan() == offset {
+			last := &chunks[n-1]
+			last.OldData = append(last.OldData, oldData...)
+			last.OldLength = int64(len(last.OldData))
+			last.NewData = append(last.NewData, newData...)
+			last.Op = chunkOp(last.OldData, last.NewData)
+
+			continue
+		}
+
+		chunks = append(chunks, DiffChunk{
+			Offset:    offset,
+			OldData:   oldData,
+			OldLength: int64(len(oldData)),
+			NewData:   newData,
+			ChunkType: "text",
+			Op:        chunkOp(oldData, newData),
+		})
+	}
+}
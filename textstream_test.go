@@ -0,0 +1,134 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testLog returns a synthetic log of the given number of lines.
+func testLog(lines int) []string {
+	log := make([]string, lines)
+	for i := range log {
+		log[i] = fmt.Sprintf("2024-01-01T00:00:%05d INFO request %d served in %dms\n", i, i*7, i%250)
+	}
+
+	return log
+}
+
+func TestTextFileHandler_CompareReaders(t *testing.T) {
+	oldLog := testLog(200000)
+
+	newLog := append([]string(nil), oldLog[:1000]...)
+	newLog = append(newLog, "2024-01-01T00:00:01000 ERROR request failed\n")
+	newLog = append(newLog, oldLog[1001:50000]...)
+	newLog = append(newLog, oldLog[50010:120000]...)
+	newLog = append(newLog, "inserted 1\n", "inserted 2\n", "inserted 3\n")
+	newLog = append(newLog, oldLog[120000:]...)
+	newLog = append(newLog, testLog(5000)...)
+	newLog = append(newLog, "no trailing newline")
+
+	old := []byte(strings.Join(oldLog, ""))
+	new := []byte(strings.Join(newLog, ""))
+
+	handler := &TextFileHandler{}
+
+	chunks, err := handler.CompareReaders(bytes.NewReader(old), bytes.NewReader(new))
+	if err != nil {
+		t.Fatalf("CompareReaders returned an error: %v", err)
+	}
+
+	wantOps := []string{OpReplace, OpDelete, OpInsert, OpInsert}
+	if len(chunks) != len(wantOps) {
+		t.Fatalf("expected %d chunks, got %d", len(wantOps), len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if chunk.Op != wantOps[i] {
+			t.Errorf("chunk %d: expected op %s, got %s", i, wantOps[i], chunk.Op)
+		}
+	}
+
+	patched, err := handler.Patch(old, chunks)
+	if err != nil {
+		t.Fatalf("Patch returned an error: %v", err)
+	}
+
+	if !bytes.Equal(patched, new) {
+		t.Errorf("patched data does not match new data")
+	}
+}
+
+func TestTextFileHandler_CompareReaders_Window(t *testing.T) {
+	oldLog := testLog(100)
+
+	inserted := make([]string, 50)
+	for i := range inserted {
+		inserted[i] = fmt.Sprintf("inserted %d\n", i)
+	}
+
+	newLog := append(append(append([]string(nil), oldLog[:10]...), inserted...), oldLog[10:]...)
+
+	old := []byte(strings.Join(oldLog, ""))
+	new := []byte(strings.Join(newLog, ""))
+
+	tests := []struct {
+		name    string
+		window  int
+		wantOps []string
+	}{
+		{name: "Change within the window", window: 64, wantOps: []string{OpInsert}},
+		{name: "Change beyond the window", window: 16, wantOps: []string{OpReplace}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &TextFileHandler{WindowLines: tt.window}
+
+			chunks, err := handler.CompareReaders(bytes.NewReader(old), bytes.NewReader(new))
+			if err != nil {
+				t.Fatalf("CompareReaders returned an error: %v", err)
+			}
+
+			if len(chunks) == 0 || len(chunks) > len(tt.wantOps) {
+				t.Fatalf("expected %d chunks, got %d", len(tt.wantOps), len(chunks))
+			}
+
+			for i, chunk := range chunks {
+				if chunk.Op != tt.wantOps[i] {
+					t.Errorf("chunk %d: expected op %s, got %s", i, tt.wantOps[i], chunk.Op)
+				}
+			}
+
+			patched, err := handler.Patch(old, chunks)
+			if err != nil {
+				t.Fatalf("Patch returned an error: %v", err)
+			}
+
+			if !bytes.Equal(patched, new) {
+				t.Errorf("patched data does not match new data")
+			}
+		})
+	}
+}
+
+func BenchmarkCompareReaders_ScatteredChanges(b *testing.B) {
+	// A large log with a line changed every 100 lines
+	log := testLog(50000)
+	changed := append([]string(nil), log...)
+	for i := 50; i < len(changed); i += 100 {
+		changed[i] = fmt.Sprintf("changed line %d\n", i)
+	}
+
+	old, new := []byte(strings.Join(log, "")), []byte(strings.Join(changed, ""))
+	handler := &TextFileHandler{}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := handler.CompareReaders(bytes.NewReader(old), bytes.NewReader(new)); err != nil {
+			b.Fatalf("CompareReaders returned an error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package diff
+
+import (
+	"bytes"
+	"path/filepath"
+)
+
+// Conflict markers used in merged output
+const (
+	conflictStart     = "<<<<<<< mine\n"
+	conflictSeparator = "=======\n"
+	conflictEnd       = ">>>>>>> theirs\n"
+)
+
+// ThreeWayResult is the result of a three-way comparison.
+type ThreeWayResult struct {
+	FileType      string
+	MineChanged   bool // Mine differs from base
+	TheirsChanged bool // Theirs differs from base
+	Regions       []MergeRegion
+	Merged        []byte // Merged content with conflict markers, nil for non-text files
+	Conflicts     int
+}
+
+// MergeRegion is a region of a three-way text comparison.
+type MergeRegion struct {
+	Type      string // "unchanged", "mine", "theirs", "both", "conflict"
+	BaseStart int    // First base line of the region
+	BaseEnd   int    // Line after the last base line of the region
+	Base      []byte
+	Mine      []byte
+	Theirs    []byte
+}
+
+// CompareThreeWay compares mine and theirs against their common base.
+// Text files are merged line by line, identifying regions changed only in mine,
+// only in theirs, identically in both, and conflicting regions changed in both
+// differently. Conflicts are marked in the merged output like git does.
+// For non-text files only which sides differ from base is reported.
+func (e *DiffEngine) CompareThreeWay(base, mine, theirs string) (*ThreeWayResult, error) {
+	fsys := e.getFileSystem()
+
+	baseData, err := e.readFile(fsys, base)
+	if err != nil {
+		return nil, err
+	}
+
+	mineData, err := e.readFile(fsys, mine)
+	if err != nil {
+		return nil, err
+	}
+
+	theirsData, err := e.readFile(fsys, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ThreeWayResult{
+		FileType:      e.getHandler(filepath.Base(mine)).GetFileType(),
+		MineChanged:   !bytes.Equal(baseData, mineData),
+		TheirsChanged: !bytes.Equal(baseData, theirsData),
+	}
+
+	if result.FileType != "text" {
+		e.logger.Log("Three-way merge of %s is not supported for %s files", filepath.Base(mine), result.FileType)
+		return result, nil
+	}
+
+	result.Regions = mergeLines(splitLines(baseData), splitLines(mineData), splitLines(theirsData))
+
+	var merged bytes.Buffer
+	for _, region := range result.Regions {
+		switch region.Type {
+		case "theirs":
+			merged.Write(region.Theirs)
+		case "conflict":
+			result.Conflicts++
+
+			merged.WriteString(conflictStart)
+			writeLines(&merged, region.Mine)
+			merged.WriteString(conflictSeparator)
+			writeLines(&merged, region.Theirs)
+			merged.WriteString(conflictEnd)
+		default:
+			merged.Write(region.Mine)
+		}
+	}
+
+	result.Merged = merged.Bytes()
+
+	return result, nil
+}
+
+// mergeLines splits the lines into regions delimited by the base lines kept in both mine and theirs.
+func mergeLines(base, mine, theirs [][]byte) []MergeRegion {
+	mineMatch := matchedLines(base, mine)
+	theirsMatch := matchedLines(base, theirs)
+
+	regions := make([]MergeRegion, 0)
+	var i, j, k int
+
+	addRegion := func(baseEnd, mineEnd, theirsEnd int) {
+		region := MergeRegion{
+			BaseStart: i,
+			BaseEnd:   baseEnd,
+			Base:      bytes.Join(base[i:baseEnd], nil),
+			Mine:      bytes.Join(mine[j:mineEnd], nil),
+			Theirs:    bytes.Join(theirs[k:theirsEnd], nil),
+		}
+
+		mineChanged := !bytes.Equal(region.Base, region.Mine)
+		theirsChanged := !bytes.Equal(region.Base, region.Theirs)
+
+		switch {
+		case !mineChanged && !theirsChanged:
+			region.Type = "unchanged"
+		case !theirsChanged:
+			region.Type = "mine"
+		case !mineChanged:
+			region.Type = "theirs"
+		case bytes.Equal(region.Mine, region.Theirs):
+			region.Type = "both"
+		default:
+			region.Type = "conflict"
+		}
+
+		if region.BaseStart == region.BaseEnd && len(region.Mine) == 0 && len(region.Theirs) == 0 {
+			return
+		}
+
+		// Consecutive regions of the same type are reported as one
+		if n := len(regions); n > 0 && regions[n-1].Type == region.Type && region.Type != "conflict" {
+			regions[n-1].BaseEnd = region.BaseEnd
+			regions[n-1].Base = append(regions[n-1].Base, region.Base...)
+			regions[n-1].Mine = append(regions[n-1].Mine, region.Mine...)
+			regions[n-1].Theirs = append(regions[n-1].Theirs, region.Theirs...)
+
+			return
+		}
+
+		regions = append(regions, region)
+	}
+
+	for b := range base {
+		if mineMatch[b] < 0 || theirsMatch[b] < 0 {
+			continue
+		}
+
+		// Changes before the stable line, then the stable line itself
+		addRegion(b, mineMatch[b], theirsMatch[b])
+		i, j, k = b, mineMatch[b], theirsMatch[b]
+		addRegion(b+1, j+1, k+1)
+		i, j, k = b+1, j+1, k+1
+	}
+
+	addRegion(len(base), len(mine), len(theirs))
+
+	return regions
+}
+
+// matchedLines returns, for each line of base, the index of the matching line of other or -1.
+// The lines are matched by a shortest edit script, in memory linear in the number of lines.
+func matchedLines(base, other [][]byte) []int {
+	matches := make([]int, len(base))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	for _, op := range (MyersDiffer{}).Diff(base, other) {
+		if op.Type == EditEqual {
+			matches[op.OldIndex] = op.NewIndex
+		}
+	}
+
+	return matches
+}
+
+// writeLines writes data, terminating it with a newline so a conflict marker can follow.
+func writeLines(buf *bytes.Buffer, data []byte) {
+	buf.Write(data)
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+}
@@ -0,0 +1,174 @@
+package diff
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCompareThreeWay(t *testing.T) {
+	const base = "one\ntwo\nthree\nfour\nfive\n"
+
+	tests := []struct {
+		name          string
+		file          string
+		mine          string
+		theirs        string
+		wantMerged    string
+		wantConflicts int
+		wantTypes     []string
+	}{
+		{
+			name:       "Clean merge of separate changes",
+			file:       "notes.txt",
+			mine:       "ONE\ntwo\nthree\nfour\nfive\n",
+			theirs:     "one\ntwo\nthree\nfour\nFIVE\n",
+			wantMerged: "ONE\ntwo\nthree\nfour\nFIVE\n",
+			wantTypes:  []string{"mine", "unchanged", "theirs"},
+		},
+		{
+			name:       "Insertion and deletion",
+			file:       "notes.txt",
+			mine:       "one\ntwo\ntwo and a half\nthree\nfour\nfive\n",
+			theirs:     "one\ntwo\nthree\nfive\n",
+			wantMerged: "one\ntwo\ntwo and a half\nthree\nfive\n",
+			wantTypes:  []string{"unchanged", "mine", "unchanged", "theirs", "unchanged"},
+		},
+		{
+			name:       "Identical change on both sides",
+			file:       "notes.txt",
+			mine:       "one\n2\nthree\nfour\nfive\n",
+			theirs:     "one\n2\nthree\nfour\nfive\n",
+			wantMerged: "one\n2\nthree\nfour\nfive\n",
+			wantTypes:  []string{"unchanged", "both", "unchanged"},
+		},
+		{
+			name:          "Conflicting changes",
+			file:          "notes.txt",
+			mine:          "one\nmine\nthree\nfour\nfive\n",
+			theirs:        "one\ntheirs\nthree\nfour\nfive\n",
+			wantMerged:    "one\n<<<<<<< mine\nmine\n=======\ntheirs\n>>>>>>> theirs\nthree\nfour\nfive\n",
+			wantConflicts: 1,
+			wantTypes:     []string{"unchanged", "conflict", "unchanged"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestTree(t, dir, map[string]string{
+				"base/" + tt.file:   base,
+				"mine/" + tt.file:   tt.mine,
+				"theirs/" + tt.file: tt.theirs,
+			})
+
+			engine := newTestEngine(t, DefaultConfig())
+
+			result, err := engine.CompareThreeWay(
+				filepath.Join(dir, "base", tt.file),
+				filepath.Join(dir, "mine", tt.file),
+				filepath.Join(dir, "theirs", tt.file),
+			)
+			if err != nil {
+				t.Fatalf("CompareThreeWay returned an error: %v", err)
+			}
+
+			if string(result.Merged) != tt.wantMerged {
+				t.Errorf("merged = %q, want %q", result.Merged, tt.wantMerged)
+			}
+
+			if result.Conflicts != tt.wantConflicts {
+				t.Errorf("expected %d conflicts, got %d", tt.wantConflicts, result.Conflicts)
+			}
+
+			types := make([]string, 0, len(result.Regions))
+			for _, region := range result.Regions {
+				types = append(types, region.Type)
+			}
+
+			if len(types) != len(tt.wantTypes) {
+				t.Fatalf("region types = %v, want %v", types, tt.wantTypes)
+			}
+
+			for i := range types {
+				if types[i] != tt.wantTypes[i] {
+					t.Errorf("region types = %v, want %v", types, tt.wantTypes)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCompareThreeWay_Binary(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{
+		"base.bin":   "\x00\x01\x02",
+		"mine.bin":   "\x00\x01\x02",
+		"theirs.bin": "\x00\xFF\x02",
+	})
+
+	engine := newTestEngine(t, DefaultConfig())
+
+	result, err := engine.CompareThreeWay(
+		filepath.Join(dir, "base.bin"),
+		filepath.Join(dir, "mine.bin"),
+		filepath.Join(dir, "theirs.bin"),
+	)
+	if err != nil {
+		t.Fatalf("CompareThreeWay returned an error: %v", err)
+	}
+
+	if result.MineChanged || !result.TheirsChanged {
+		t.Errorf("expected only theirs to be changed, got mine %v theirs %v", result.MineChanged, result.TheirsChanged)
+	}
+
+	if result.Merged != nil || result.Regions != nil {
+		t.Errorf("expected no merge for binary files")
+	}
+}
+
+func TestMergeLines_Memory(t *testing.T) {
+	// Large regions changed on both sides, every tenth line of mine and theirs
+	base := make([][]byte, 20000)
+	mine := make([][]byte, len(base))
+	theirs := make([][]byte, len(base))
+
+	for i := range base {
+		base[i] = []byte(fmt.Sprintf("line %d\n", i))
+		mine[i], theirs[i] = base[i], base[i]
+
+		if i%10 == 0 {
+			mine[i] = []byte(fmt.Sprintf("mine %d\n", i))
+		} else if i%10 == 5 {
+			theirs[i] = []byte(fmt.Sprintf("theirs %d\n", i))
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	regions := mergeLines(base, mine, theirs)
+
+	runtime.ReadMemStats(&after)
+
+	var merged int
+	for _, region := range regions {
+		if region.Type == "conflict" {
+			t.Fatalf("expected no conflict, got one at base line %d", region.BaseStart)
+		}
+
+		merged += region.BaseEnd - region.BaseStart
+	}
+
+	if merged != len(base) {
+		t.Errorf("expected the regions to cover %d base lines, got %d", len(base), merged)
+	}
+
+	// A table of the line pairs would take 1.6 GB
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("mergeLines allocated %d bytes, want the space of the lines only", allocated)
+	}
+}
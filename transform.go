@@ -0,0 +1,59 @@
+package diff
+
+import "fmt"
+
+// ChunkTransform transforms the data of chunks as they are stored, such as to encrypt
+// them at rest. Forward is applied to the NewData of each chunk after compression, and
+// Reverse must undo it before the chunks are decompressed and applied.
+type ChunkTransform interface {
+	Forward(data []byte) ([]byte, error)
+	Reverse(data []byte) ([]byte, error)
+}
+
+// transformChunks applies the configured transform to the data of the chunks.
+func (e *DiffEngine) transformChunks(chunks []DiffChunk) error {
+	for i := range chunks {
+		if len(chunks[i].NewData) == 0 {
+			continue
+		}
+
+		data, err := e.config.ChunkTransform.Forward(chunks[i].NewData)
+		if err != nil {
+			return fmt.Errorf("transforming chunk %d: %w", i, err)
+		}
+
+		chunks[i].NewData = data
+	}
+
+	return nil
+}
+
+// ReverseTransform returns a copy of a result whose chunks were transformed with a
+// ChunkTransform, with the transform reversed so that the chunks can be applied.
+// Results which were not transformed are returned unchanged.
+func ReverseTransform(result DiffResult, transform ChunkTransform) (DiffResult, error) {
+	if !result.Transformed {
+		return result, nil
+	}
+
+	chunks := make([]DiffChunk, len(result.Chunks))
+	copy(chunks, result.Chunks)
+
+	for i := range chunks {
+		if len(chunks[i].NewData) == 0 {
+			continue
+		}
+
+		data, err := transform.Reverse(chunks[i].NewData)
+		if err != nil {
+			return DiffResult{}, fmt.Errorf("%s: reversing chunk %d: %w", result.RelPath, i, err)
+		}
+
+		chunks[i].NewData = data
+	}
+
+	result.Chunks = chunks
+	result.Transformed = false
+
+	return result, nil
+}
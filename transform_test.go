@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// xorTransform XORs data with a key, which is its own inverse.
+type xorTransform struct {
+	key byte
+}
+
+func (x xorTransform) Forward(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ x.key
+	}
+
+	return out, nil
+}
+
+func (x xorTransform) Reverse(data []byte) ([]byte, error) {
+	return x.Forward(data)
+}
+
+// failingTransform fails to transform any data.
+type failingTransform struct{}
+
+func (failingTransform) Forward(data []byte) ([]byte, error) { return nil, errors.New("no key") }
+func (failingTransform) Reverse(data []byte) ([]byte, error) { return nil, errors.New("no key") }
+
+func TestCompareDirs_ChunkTransform(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	writeTestTree(t, oldDir, map[string]string{
+		"notes.txt": "first\nsecond\nthird\n",
+	})
+	writeTestTree(t, newDir, map[string]string{
+		"notes.txt": "first\n" + strings.Repeat("2", 200) + "\nthird\n",
+		"added.txt": strings.Repeat("added line\n", 100),
+	})
+
+	transform := xorTransform{key: 0x5a}
+
+	config := DefaultConfig()
+	config.ChunkTransform = transform
+
+	engine := newTestEngine(t, config)
+
+	_, results, err := engine.CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	for _, result := range results {
+		if !result.Transformed || !result.IsCompressed {
+			t.Errorf("%s: expected compressed and transformed chunks", result.RelPath)
+		}
+
+		if _, err := chunkData(result.Chunks[0]); err == nil {
+			t.Errorf("%s: expected transformed chunk data not to decompress", result.RelPath)
+		}
+
+		restored, err := ReverseTransform(result, transform)
+		if err != nil {
+			t.Fatalf("ReverseTransform returned an error: %v", err)
+		}
+
+		if restored.Transformed {
+			t.Errorf("%s: expected the reversed result not to be marked transformed", result.RelPath)
+		}
+
+		oldData, err := os.ReadFile(filepath.Join(oldDir, result.RelPath))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Failed to read old file: %v", err)
+		}
+
+		newData, err := os.ReadFile(filepath.Join(newDir, result.RelPath))
+		if err != nil {
+			t.Fatalf("Failed to read new file: %v", err)
+		}
+
+		patched, _, skipped, err := ApplyPatchReport(oldData, restored.Chunks)
+		if err != nil {
+			t.Fatalf("ApplyPatchReport returned an error: %v", err)
+		}
+
+		if len(skipped) > 0 || !bytes.Equal(patched, newData) {
+			t.Errorf("%s: patched data does not match new data", result.RelPath)
+		}
+	}
+
+	config.ChunkTransform = failingTransform{}
+
+	summary, _, err := newTestEngine(t, config).CompareDirs(oldDir, newDir)
+	if err != nil {
+		t.Fatalf("CompareDirs returned an error: %v", err)
+	}
+
+	if len(summary.Errors) != 2 {
+		t.Errorf("expected 2 file errors from a failing transform, got %d", len(summary.Errors))
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
 )
 
 // calculateHash calculates the SHA256 hash of a file.
@@ -18,13 +23,37 @@ func calculateHash(path string) string {
 
 	defer file.Close()
 
+	hash, err := hashReader(file, 0)
+	if err != nil {
+		return ""
+	}
+
+	return hash
+}
+
+// hashReader calculates the SHA256 hash of the data read from r.
+// A positive bufferSize sets the size of the read buffer, otherwise the io.Copy default is used.
+func hashReader(r io.Reader, bufferSize int) (string, error) {
 	hash := sha256.New()
 
-	if _, err := io.Copy(hash, file); err != nil {
-		return ""
+	var buf []byte
+	if bufferSize > 0 {
+		buf = make([]byte, bufferSize)
+	}
+
+	// Hide any WriterTo implementation of r so the buffer is honored
+	if _, err := io.CopyBuffer(hash, struct{ io.Reader }{r}, buf); err != nil {
+		return "", err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashBytes calculates the SHA256 hash of data.
+func hashBytes(data []byte) string {
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:])
 }
 
 // compressData compresses data using gzip.
@@ -45,7 +74,7 @@ func compressData(data []byte, compress bool, level int) []byte {
 
 // decompressData decompresses data using gzip.
 func decompressData(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
+	reader, err := decompressStream(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +84,12 @@ func decompressData(data []byte) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
+// decompressStream returns a reader which decompresses the gzip stream read from r.
+// It allows callers to copy decompressed data without buffering it entirely in memory.
+func decompressStream(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
 // copyFile copies a file from src to dst.
 func copyFile(src, dst string) error {
 	source, err := os.Open(src)
@@ -74,3 +109,161 @@ func copyFile(src, dst string) error {
 	_, err = io.Copy(destination, source)
 	return err
 }
+
+// changeRatio returns the ratio of changed bytes in the chunks to the size of the larger input.
+func changeRatio(chunks []DiffChunk, oldSize, newSize int) float64 {
+	total := max(oldSize, newSize)
+	if total == 0 {
+		return 0
+	}
+
+	var changed int
+	for _, chunk := range chunks {
+		changed += max(len(chunk.OldData), len(chunk.NewData))
+	}
+
+	return min(float64(changed)/float64(total), 1)
+}
+
+// patchBytes returns the total size of the data held by the chunks.
+func patchBytes(chunks []DiffChunk) int64 {
+	var total int64
+	for _, chunk := range chunks {
+		total += int64(len(chunk.OldData) + len(chunk.NewData))
+	}
+
+	return total
+}
+
+// binarySampleSize is the number of leading bytes inspected by looksBinary.
+const binarySampleSize = 8000
+
+// maxInvalidUTF8Ratio is the ratio of invalid UTF-8 bytes above which data is considered binary.
+const maxInvalidUTF8Ratio = 0.3
+
+// looksBinary reports whether data appears to be binary rather than text, that is
+// whether its leading bytes contain a NUL byte or are mostly invalid UTF-8.
+func looksBinary(data []byte) bool {
+	sample := data[:min(len(data), binarySampleSize)]
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+
+	invalid := 0
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+
+		// A rune cut off by the end of the sample is not invalid
+		if r == utf8.RuneError && size == 1 && (len(sample) == len(data) || len(sample)-i >= utf8.UTFMax) {
+			invalid++
+		}
+
+		i += size
+	}
+
+	return len(sample) > 0 && float64(invalid)/float64(len(sample)) > maxInvalidUTF8Ratio
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
+
+// commonSuffixLen returns the length of the common suffix of a and b.
+func commonSuffixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+
+	i := 0
+	for i < n && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+
+	return i
+}
+
+// anyCompressed reports whether the data of any of the chunks is compressed.
+func anyCompressed(chunks []DiffChunk) bool {
+	for _, chunk := range chunks {
+		if chunk.Compressed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRegularFile reports whether info describes a regular file, or a symbolic link to one.
+func isRegularFile(fsys FileSystem, path string, info os.FileInfo) bool {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := fsys.Stat(path)
+		if err != nil {
+			return false
+		}
+
+		info = target
+	}
+
+	return info.Mode().IsRegular()
+}
+
+// fileKind returns the kind of a file, "directory", "symlink" or "file".
+func fileKind(info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "file"
+	}
+}
+
+// notExist reports whether err means a file does not exist, including when one of the
+// directories of its path is a file, as for the files of a directory which replaced one.
+func notExist(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// isHidden reports whether the last element of a relative path is hidden, that is starts with a dot.
+// The walk root itself, ".", is never hidden.
+func isHidden(relPath string) bool {
+	name := filepath.Base(relPath)
+
+	return relPath != "." && strings.HasPrefix(name, ".")
+}
+
+// pathDepth returns the number of elements of a relative path, 0 for ".".
+func pathDepth(relPath string) int {
+	if relPath == "." {
+		return 0
+	}
+
+	return strings.Count(filepath.ToSlash(filepath.Clean(relPath)), "/") + 1
+}
+
+// matchPathSuffix reports whether the trailing elements of path match the relative
+// path or glob pattern, which has as many elements as it matches.
+func matchPathSuffix(pattern, path string) bool {
+	patternParts := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+	pathParts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+
+	if len(pathParts) < len(patternParts) {
+		return false
+	}
+
+	pathParts = pathParts[len(pathParts)-len(patternParts):]
+	for i, part := range patternParts {
+		if matched, _ := filepath.Match(part, pathParts[i]); !matched {
+			return false
+		}
+	}
+
+	return true
+}
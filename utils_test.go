@@ -3,6 +3,7 @@ package diff
 import (
 	"bytes"
 	"compress/gzip"
+	"io"
 	"os"
 	"testing"
 )
@@ -30,8 +31,9 @@ func creatTestFile(t *testing.T) {
 func cleanTestDir(t *testing.T) {
 	t.Helper()
 
-	if err := os.RemoveAll(testDatadir); err != nil {
-		t.Fatalf("Failed to remove test data directory: %v", err)
+	// testdata also holds committed fixtures, so only the generated file is removed
+	if err := os.Remove(testDatadir + "/" + testFileName); err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Failed to remove test file: %v", err)
 	}
 }
 
@@ -226,3 +228,98 @@ func Test_copyFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_decompressStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		want      []byte
+		wantError bool
+	}{
+		{
+			name:      "Valid compressed data",
+			data:      compressData([]byte(testStringData), true, gzip.BestCompression),
+			want:      []byte(testStringData),
+			wantError: false,
+		},
+		{
+			name:      "Invalid compressed data",
+			data:      []byte("invalid compressed data"),
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := decompressStream(bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("decompressStream() error = %v, wantError %v", err, tt.wantError)
+			}
+
+			if tt.wantError {
+				return
+			}
+
+			defer reader.Close()
+
+			var got bytes.Buffer
+			if _, err := io.CopyN(&got, reader, 16); err != nil {
+				t.Fatalf("Failed to copy first part of the stream: %v", err)
+			}
+
+			if _, err := io.Copy(&got, reader); err != nil {
+				t.Fatalf("Failed to copy rest of the stream: %v", err)
+			}
+
+			if !bytes.Equal(got.Bytes(), tt.want) {
+				t.Errorf("decompressStream() = %v, want %v", got.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchPathSuffix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "records.dat", path: "/data/records.dat", want: true},
+		{pattern: "data/records.dat", path: "/srv/data/records.dat", want: true},
+		{pattern: "data/*.dat", path: "/srv/data/records.dat", want: true},
+		{pattern: "other/records.dat", path: "/srv/data/records.dat", want: false},
+		{pattern: "srv/data/records.dat", path: "data/records.dat", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := matchPathSuffix(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("matchPathSuffix(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_looksBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{name: "Empty", data: nil, want: false},
+		{name: "ASCII text", data: []byte("hello\nworld\n"), want: false},
+		{name: "UTF-8 text", data: []byte("héllo wörld, こんにちは\n"), want: false},
+		{name: "NUL byte", data: []byte("hello\x00world"), want: true},
+		{name: "Mostly invalid UTF-8", data: []byte{0xff, 0xfe, 0x80, 'a', 0x81, 0xc3}, want: true},
+		{name: "Some invalid UTF-8", data: []byte("latin-1 caf\xe9 text is still mostly text"), want: false},
+		{name: "NUL beyond the sample", data: append(bytes.Repeat([]byte("a"), binarySampleSize), 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksBinary(tt.data); got != tt.want {
+				t.Errorf("looksBinary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
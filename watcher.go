@@ -0,0 +1,207 @@
+package diff
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Watcher compares a directory with its state at the previous scan, for daemons which
+// rescan a tree periodically. It holds an in-memory snapshot of the files of the last
+// scan, so its memory use is proportional to the size of the tree.
+type Watcher struct {
+	engine *DiffEngine
+
+	mu       sync.Mutex
+	snapshot snapshotFS // Files of the last scan, empty before the first one
+}
+
+// NewWatcher returns a Watcher comparing directories with the engine.
+func NewWatcher(engine *DiffEngine) *Watcher {
+	return &Watcher{engine: engine, snapshot: newSnapshotFS()}
+}
+
+// Scan compares dir with the snapshot of the previous scan, returning the files added,
+// modified and deleted since then, and replaces the snapshot with the current state of
+// dir. All files are added on the first scan. The snapshot is kept when the comparison
+// fails. Files whose size and modification time are unchanged since the previous scan
+// are not read again, so changes which preserve both are missed.
+func (w *Watcher) Scan(dir string) (*DiffSummary, []DiffResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current, err := w.takeSnapshot(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	summary, results, err := w.engine.compareTrees(FromFS(w.snapshot), FromFS(current), []string{"."}, ".")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.snapshot = current
+
+	return summary, results, nil
+}
+
+// takeSnapshot reads the regular files of dir which the engine may compare into a
+// snapshot, reusing the data of the previous snapshot for files which look unchanged.
+func (w *Watcher) takeSnapshot(dir string) (snapshotFS, error) {
+	fsys := w.engine.getFileSystem()
+	snapshot := newSnapshotFS()
+
+	err := fsys.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(relPath)
+
+		if info.IsDir() {
+			snapshot.add(name, &snapshotFile{mode: info.Mode(), modTime: info.ModTime()})
+			return nil
+		}
+
+		if !isRegularFile(fsys, filePath, info) || info.Size() > w.engine.config.MaxFileSizeBytes {
+			return nil
+		}
+
+		if prev, ok := w.snapshot[name]; ok && prev.size() == info.Size() && prev.modTime.Equal(info.ModTime()) {
+			snapshot.add(name, prev)
+			return nil
+		}
+
+		data, err := w.engine.readFile(fsys, filePath)
+		if os.IsNotExist(err) {
+			// Removed since it was listed
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		snapshot.add(name, &snapshotFile{data: data, mode: info.Mode(), modTime: info.ModTime()})
+
+		return nil
+	})
+
+	return snapshot, err
+}
+
+// snapshotFS is an in-memory fs.FS holding a copy of a tree, keyed by slash-separated path.
+type snapshotFS map[string]*snapshotFile
+
+// snapshotFile is a file or directory of a snapshotFS.
+type snapshotFile struct {
+	data     []byte
+	mode     fs.FileMode
+	modTime  time.Time
+	children []string // Names of the entries of a directory, sorted
+}
+
+// Makesure snapshotFS implements the interfaces used by fs.WalkDir and fs.Stat
+var (
+	_ fs.ReadDirFS = snapshotFS(nil)
+	_ fs.StatFS    = snapshotFS(nil)
+)
+
+// newSnapshotFS returns a snapshot holding an empty root directory.
+func newSnapshotFS() snapshotFS {
+	return snapshotFS{".": {mode: fs.ModeDir | 0755}}
+}
+
+// add adds a file or directory, whose parent directory must have been added before.
+func (s snapshotFS) add(name string, file *snapshotFile) {
+	if name == "." {
+		s[name].mode, s[name].modTime = file.mode, file.modTime
+		return
+	}
+
+	s[name] = file
+
+	parent := s[path.Dir(name)]
+	parent.children = append(parent.children, path.Base(name))
+}
+
+func (f *snapshotFile) size() int64 {
+	return int64(len(f.data))
+}
+
+// Open opens the named file or directory for reading.
+func (s snapshotFS) Open(name string) (fs.File, error) {
+	file, ok := s[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &snapshotReader{Reader: bytes.NewReader(file.data), info: file.info(name)}, nil
+}
+
+// Stat returns the FileInfo of the named file or directory.
+func (s snapshotFS) Stat(name string) (fs.FileInfo, error) {
+	file, ok := s[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return file.info(name), nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (s snapshotFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, ok := s[name]
+	if !ok || !dir.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	children := append([]string(nil), dir.children...)
+	sort.Strings(children)
+
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		childPath := path.Join(name, child)
+		entries[i] = fs.FileInfoToDirEntry(s[childPath].info(childPath))
+	}
+
+	return entries, nil
+}
+
+// info returns the FileInfo of the file at name.
+func (f *snapshotFile) info(name string) fs.FileInfo {
+	return snapshotInfo{name: path.Base(name), file: f}
+}
+
+// snapshotInfo is the FileInfo of a snapshotFile.
+type snapshotInfo struct {
+	name string
+	file *snapshotFile
+}
+
+func (i snapshotInfo) Name() string       { return i.name }
+func (i snapshotInfo) Size() int64        { return i.file.size() }
+func (i snapshotInfo) Mode() fs.FileMode  { return i.file.mode }
+func (i snapshotInfo) ModTime() time.Time { return i.file.modTime }
+func (i snapshotInfo) IsDir() bool        { return i.file.mode.IsDir() }
+func (i snapshotInfo) Sys() any           { return nil }
+
+// snapshotReader is an open file of a snapshotFS.
+type snapshotReader struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (r *snapshotReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *snapshotReader) Close() error               { return nil }
+
+// Makesure snapshotReader implements fs.File
+var _ fs.File = &snapshotReader{}
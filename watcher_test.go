@@ -0,0 +1,61 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatcher_Scan(t *testing.T) {
+	dir := t.TempDir()
+
+	writeTestTree(t, dir, map[string]string{
+		"notes.txt":     "first\nsecond\n",
+		"docs/keep.md":  "kept\n",
+		"docs/gone.txt": "removed later\n",
+	})
+
+	watcher := NewWatcher(newTestEngine(t, DefaultConfig()))
+
+	scan := func() map[string]string {
+		t.Helper()
+
+		_, results, err := watcher.Scan(dir)
+		if err != nil {
+			t.Fatalf("Scan returned an error: %v", err)
+		}
+
+		got := make(map[string]string)
+		for _, result := range results {
+			got[result.RelPath] = result.Operation
+		}
+
+		return got
+	}
+
+	want := map[string]string{"notes.txt": "added", "docs/keep.md": "added", "docs/gone.txt": "added"}
+	if diff := cmp.Diff(want, scan()); diff != "" {
+		t.Errorf("unexpected first scan (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("first\nsecond, edited\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "docs", "gone.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	writeTestTree(t, dir, map[string]string{"new/added.txt": "added\n"})
+
+	want = map[string]string{"notes.txt": "modified", "docs/gone.txt": "deleted", "new/added.txt": "added"}
+	if diff := cmp.Diff(want, scan()); diff != "" {
+		t.Errorf("unexpected second scan (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]string{}, scan()); diff != "" {
+		t.Errorf("unexpected changes without modifications (-want +got):\n%s", diff)
+	}
+}